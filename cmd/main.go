@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -11,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 	defender "github.com/zapstore/defender/pkg/client"
@@ -20,6 +20,7 @@ import (
 	"github.com/zapstore/relay/pkg/config"
 	"github.com/zapstore/relay/pkg/dashboard"
 	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/gc"
 	"github.com/zapstore/relay/pkg/indexing"
 	"github.com/zapstore/relay/pkg/rate"
 	"github.com/zapstore/relay/pkg/relay"
@@ -31,9 +32,12 @@ Usage:
   relay <command>
 
 Commands:
-  run      Start the relay and blossom server
-  version  Print the relay version
-  config   Print the active configuration
+  run         Start the relay and blossom server
+  sync <url>  Download the missing app events from the relay at <url>, using NIP-77 negentropy
+  gc          List the blobs no longer referenced by any event, without deleting them
+  gc --delete Delete the blobs no longer referenced by any event from Bunny and blossom.db
+  version     Print the relay version
+  config      Print the active configuration
 `, config.Version)
 }
 
@@ -62,6 +66,25 @@ func main() {
 		fmt.Println(config)
 		os.Exit(0)
 
+	case "sync":
+		if len(os.Args) < 3 {
+			printHelp()
+			os.Exit(1)
+		}
+		if err := syncFrom(config, os.Args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "gc":
+		dryRun := len(os.Args) < 3 || os.Args[2] != "--delete"
+		if err := collectGarbage(config, dryRun); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+
 	case "run":
 		// continues below
 
@@ -112,17 +135,10 @@ func main() {
 	// Initialize rate limiter and connect to the defender
 	limiter := rate.NewLimiter(config.Limiter)
 
-	defenderURL := strings.TrimSpace(os.Getenv("DEFENDER_URL"))
-	if defenderURL == "" {
-		defenderURL = "localhost:8080"
-	}
-	defender, err := defender.Default(defenderURL)
+	defender, err := connectDefender(ctx)
 	if err != nil {
 		panic(err)
 	}
-	if _, err := defender.Health(ctx); err != nil {
-		slog.Error("defender health check failed", "error", err)
-	}
 
 	// Step 3.
 	// Initialize indexing engine
@@ -150,14 +166,16 @@ func main() {
 	defer analytics.Close()
 
 	// Step 5.
-	// Setup relay and blossom server
+	// Setup relay and blossom server, and the collector of their unreferenced blobs
+	bunny := bunny.NewClient(config.Blossom.Bunny)
+
 	relay, err := relay.Setup(
 		config.Relay,
 		limiter,
 		defender,
 		relayDB,
 		blossomDB,
-		bunny.NewClient(config.Blossom.Bunny),
+		bunny,
 		analytics,
 		indexingEngine,
 	)
@@ -177,6 +195,8 @@ func main() {
 		panic(err)
 	}
 
+	collector := gc.NewCollector(config.GC, relayDB, blossomDB, bunny)
+
 	// Step 6.
 	// Initialize dashboard
 	dashboard, err := dashboard.New(
@@ -184,8 +204,10 @@ func main() {
 		limiter,
 		defender,
 		relayDB,
+		relay,
 		blossomDB,
 		analyticsDB,
+		collector,
 	)
 	if err != nil {
 		panic(err)
@@ -195,7 +217,7 @@ func main() {
 	// Run everything
 	exit := make(chan error, 4)
 	wg := sync.WaitGroup{}
-	wg.Add(4)
+	wg.Add(5)
 
 	go func() {
 		defer wg.Done()
@@ -225,6 +247,11 @@ func main() {
 		}
 	}()
 
+	go func() {
+		defer wg.Done()
+		collector.Run(ctx)
+	}()
+
 	select {
 	case <-ctx.Done():
 		wg.Wait()
@@ -235,6 +262,99 @@ func main() {
 	}
 }
 
+// connectDefender connects to the defender at DEFENDER_URL, logging if it's not healthy.
+func connectDefender(ctx context.Context) (defender.T, error) {
+	url := strings.TrimSpace(os.Getenv("DEFENDER_URL"))
+	if url == "" {
+		url = "localhost:8080"
+	}
+	defender, err := defender.Default(url)
+	if err != nil {
+		return defender, err
+	}
+	if _, err := defender.Health(ctx); err != nil {
+		slog.Error("defender health check failed", "error", err)
+	}
+	return defender, nil
+}
+
+// syncFrom downloads the app events that the relay at the provided url has and the local relay.db doesn't.
+// The events go through the same checks of the published ones, so the relay is set up without serving it.
+func syncFrom(config config.Config, url string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dataDir := filepath.Join(config.Sys.Dir, "data")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+	relayDB, err := relay.NewDB(filepath.Join(dataDir, "relay.db"))
+	if err != nil {
+		return err
+	}
+	defer relayDB.Close()
+
+	blossomDB, err := blossom.NewDB(filepath.Join(dataDir, "blossom.db"))
+	if err != nil {
+		return err
+	}
+	defer blossomDB.Close()
+
+	defender, err := connectDefender(ctx)
+	if err != nil {
+		return err
+	}
+
+	limiter := rate.NewLimiter(config.Limiter)
+	relay, err := relay.Setup(config.Relay, limiter, defender, relayDB, blossomDB, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	saved, err := relay.Sync(ctx, url, nostr.Filter{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("synced %d events from %s\n", saved, url)
+	return nil
+}
+
+// collectGarbage deletes the blobs that are no longer referenced by any event, or only prints them in a dry run.
+func collectGarbage(config config.Config, dryRun bool) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dataDir := filepath.Join(config.Sys.Dir, "data")
+	relayDB, err := relay.NewDB(filepath.Join(dataDir, "relay.db"))
+	if err != nil {
+		return err
+	}
+	defer relayDB.Close()
+
+	blossomDB, err := blossom.NewDB(filepath.Join(dataDir, "blossom.db"))
+	if err != nil {
+		return err
+	}
+	defer blossomDB.Close()
+
+	collector := gc.NewCollector(config.GC, relayDB, blossomDB, bunny.NewClient(config.Blossom.Bunny))
+	if dryRun {
+		report, err := collector.Reclaimable(ctx)
+		if err != nil {
+			return err
+		}
+		for _, blob := range report.Blobs {
+			fmt.Printf("%s\t%s\t%d\t%s\n", blob.Hash, blob.Type, blob.Size, blob.CreatedAt.Format(time.DateOnly))
+		}
+		fmt.Printf("%d reclaimable blobs, %d bytes (dry run, use --delete to delete them)\n", len(report.Blobs), report.Bytes)
+		return nil
+	}
+
+	deleted, err := collector.Collect(ctx)
+	fmt.Printf("deleted %d blobs, %d bytes\n", len(deleted.Blobs), deleted.Bytes)
+	return err
+}
+
 // Resolver implements [analytics.Resolver]
 type resolver struct {
 	db relay.DB
@@ -254,51 +374,5 @@ func (r resolver) AssetsReferencing(ctx context.Context, hash blossom.Hash) ([]n
 }
 
 func (r resolver) LatestVersion(ctx context.Context, appID, pubkey string) (string, error) {
-	filter := nostr.Filter{
-		Kinds:   []int{events.KindAsset},
-		Authors: []string{pubkey},
-		Tags:    nostr.TagMap{"i": []string{appID}},
-		Limit:   10,
-	}
-	assets, err := r.db.Query(ctx, filter)
-	if err != nil {
-		return "", fmt.Errorf("failed to query: %w", err)
-	}
-
-	if len(assets) > 0 {
-		version, found := events.Find(assets[0].Tags, "version")
-		if !found {
-			return "", fmt.Errorf("no version tag found for app %s/%s", appID, pubkey)
-		}
-		return version, nil
-
-	} else {
-		// this is most likely caused by the app being on the old format with kind 1063,
-		// so we fall back to the version in the release kind
-		aTag := fmt.Sprintf("32267:%s:%s", pubkey, appID)
-		filter = nostr.Filter{
-			Kinds:   []int{events.KindRelease},
-			Authors: []string{pubkey},
-			Tags:    nostr.TagMap{"a": []string{aTag}},
-			Limit:   1,
-		}
-
-		assets, err = r.db.Query(ctx, filter)
-		if err != nil {
-			return "", fmt.Errorf("failed to query: %w", err)
-		}
-		if len(assets) == 0 {
-			return "", errors.New("no assets found")
-		}
-
-		dTag, found := events.Find(assets[0].Tags, "d")
-		if !found {
-			return "", fmt.Errorf("no d tag found for app %s/%s", appID, pubkey)
-		}
-		_, version, ok := strings.Cut(dTag, "@")
-		if !ok {
-			return "", fmt.Errorf("invalid d tag: %s", dTag)
-		}
-		return version, nil
-	}
+	return r.db.LatestVersion(ctx, appID, pubkey)
 }
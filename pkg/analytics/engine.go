@@ -62,7 +62,7 @@ type Resolver interface {
 	// same blob in the case of a fork (different app_id, same binary).
 	AssetsReferencing(ctx context.Context, hash blossom.Hash) ([]nostr.Event, error)
 
-	// LatestVersion returns the version string of the most recent kind 3063 asset published
+	// LatestVersion returns the version string of the most recent release (or kind 3063 asset) published
 	// for the given app (identified by app_id and pubkey). It is used to annotate impressions
 	// with the version that was live at the time of the impression.
 	LatestVersion(ctx context.Context, appID, pubkey string) (string, error)
@@ -72,6 +72,8 @@ type relayMetrics struct {
 	reqs    atomic.Int64
 	filters atomic.Int64
 	events  atomic.Int64
+	counts  atomic.Int64
+	expired atomic.Int64
 }
 
 type blossomMetrics struct {
@@ -210,11 +212,26 @@ func (e *Engine) RecordReq(client rely.Client, id string, filters nostr.Filters,
 	}
 }
 
+// RecordCount records the NIP-45 COUNT.
+func (e *Engine) RecordCount(_ rely.Client, _ string, _ nostr.Filters) {
+	e.relay.counts.Add(1)
+}
+
 // RecordEvent records the event.
 func (e *Engine) RecordEvent(_ rely.Client, _ *nostr.Event) {
 	e.relay.events.Add(1)
 }
 
+// RecordExpired records the deletion of n expired events.
+func (e *Engine) RecordExpired(n int) {
+	e.relay.expired.Add(int64(n))
+}
+
+// Popularity returns the downloads and impressions of every app since the given time.
+func (e *Engine) Popularity(ctx context.Context, since time.Time) ([]store.AppPopularity, error) {
+	return e.store.QueryPopularity(ctx, since.UTC().Format("2006-01-02"))
+}
+
 // RecordCheck records the check.
 func (e *Engine) RecordCheck(_ blossy.Request, _ blossom.Hash) {
 	e.blossom.checks.Add(1)
@@ -464,6 +481,8 @@ func (e *Engine) flushRelayMetrics() error {
 		Reqs:    e.relay.reqs.Swap(0),
 		Filters: e.relay.filters.Swap(0),
 		Events:  e.relay.events.Swap(0),
+		Counts:  e.relay.counts.Swap(0),
+		Expired: e.relay.expired.Swap(0),
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), e.config.FlushTimeout)
@@ -42,6 +42,8 @@ type relayMetricsResponse struct {
 	Reqs    int64  `json:"reqs"`
 	Filters int64  `json:"filters"`
 	Events  int64  `json:"events"`
+	Counts  int64  `json:"counts"`
+	Expired int64  `json:"expired"`
 }
 
 type blossomMetricsResponse struct {
@@ -257,6 +259,8 @@ func (e *Engine) relayMetrics(w http.ResponseWriter, r *http.Request) {
 			Reqs:    r.Reqs,
 			Filters: r.Filters,
 			Events:  r.Events,
+			Counts:  r.Counts,
+			Expired: r.Expired,
 		}
 	}
 	writeJSON(w, resp)
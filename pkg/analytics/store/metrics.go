@@ -12,6 +12,8 @@ type RelayMetrics struct {
 	Reqs    int64  // REQs fulfilled
 	Filters int64  // filters fulfilled
 	Events  int64  // events saved or replaced
+	Counts  int64  // COUNTs fulfilled
+	Expired int64  // expired events deleted
 }
 
 // BlossomMetrics holds aggregated blossom counters for a single day.
@@ -25,19 +27,21 @@ type BlossomMetrics struct {
 // SaveRelayMetrics writes the given relay metrics to the database for the given day.
 // On conflict it increments the existing counters.
 func (s *T) SaveRelayMetrics(ctx context.Context, m RelayMetrics) error {
-	if m.Reqs == 0 && m.Filters == 0 && m.Events == 0 {
+	if m.Reqs == 0 && m.Filters == 0 && m.Events == 0 && m.Counts == 0 && m.Expired == 0 {
 		return nil
 	}
 
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO relay_metrics (day, reqs, filters, events)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO relay_metrics (day, reqs, filters, events, counts, expired)
+		VALUES (?, ?, ?, ?, ?, ?)
 		ON CONFLICT(day)
 		DO UPDATE SET
 			reqs    = relay_metrics.reqs    + excluded.reqs,
 			filters = relay_metrics.filters + excluded.filters,
-			events  = relay_metrics.events  + excluded.events
-	`, m.Day, m.Reqs, m.Filters, m.Events)
+			events  = relay_metrics.events  + excluded.events,
+			counts  = relay_metrics.counts  + excluded.counts,
+			expired = relay_metrics.expired + excluded.expired
+	`, m.Day, m.Reqs, m.Filters, m.Events, m.Counts, m.Expired)
 	if err != nil {
 		return fmt.Errorf("failed to save relay metrics: %w", err)
 	}
@@ -68,7 +72,7 @@ func (s *T) SaveBlossomMetrics(ctx context.Context, m BlossomMetrics) error {
 
 // QueryRelayMetrics returns daily relay metrics for the given date range.
 func (s *T) QueryRelayMetrics(ctx context.Context, from, to string) ([]RelayMetrics, error) {
-	query := "SELECT day, reqs, filters, events FROM relay_metrics"
+	query := "SELECT day, reqs, filters, events, counts, expired FROM relay_metrics"
 	var conds []string
 	var args []any
 	if from != "" {
@@ -93,7 +97,7 @@ func (s *T) QueryRelayMetrics(ctx context.Context, from, to string) ([]RelayMetr
 	var result []RelayMetrics
 	for rows.Next() {
 		var m RelayMetrics
-		if err := rows.Scan(&m.Day, &m.Reqs, &m.Filters, &m.Events); err != nil {
+		if err := rows.Scan(&m.Day, &m.Reqs, &m.Filters, &m.Events, &m.Counts, &m.Expired); err != nil {
 			return nil, fmt.Errorf("failed to scan relay metrics row: %w", err)
 		}
 		m.Day = normalizeDay(m.Day)
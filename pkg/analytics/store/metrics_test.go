@@ -3,6 +3,7 @@ package store
 import (
 	"database/sql"
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -17,8 +18,13 @@ func TestSaveRelayMetrics(t *testing.T) {
 	}{
 		{
 			name:    "all counters are persisted",
-			metrics: RelayMetrics{Day: "2024-01-01", Reqs: 100, Filters: 250, Events: 75},
-			want:    RelayMetrics{Day: "2024-01-01", Reqs: 100, Filters: 250, Events: 75},
+			metrics: RelayMetrics{Day: "2024-01-01", Reqs: 100, Filters: 250, Events: 75, Counts: 12, Expired: 3},
+			want:    RelayMetrics{Day: "2024-01-01", Reqs: 100, Filters: 250, Events: 75, Counts: 12, Expired: 3},
+		},
+		{
+			name:    "only expired events",
+			metrics: RelayMetrics{Day: "2024-03-10", Expired: 7},
+			want:    RelayMetrics{Day: "2024-03-10", Expired: 7},
 		},
 		{
 			name:    "different day",
@@ -58,10 +64,10 @@ func TestSaveRelayMetrics_AccumulatesAcrossCalls(t *testing.T) {
 	}
 	defer s.Close()
 
-	if err := s.SaveRelayMetrics(ctx, RelayMetrics{Day: "2024-01-01", Reqs: 10, Filters: 20, Events: 5}); err != nil {
+	if err := s.SaveRelayMetrics(ctx, RelayMetrics{Day: "2024-01-01", Reqs: 10, Filters: 20, Events: 5, Counts: 4}); err != nil {
 		t.Fatalf("first SaveRelayMetrics: %v", err)
 	}
-	if err := s.SaveRelayMetrics(ctx, RelayMetrics{Day: "2024-01-01", Reqs: 3, Filters: 7, Events: 2}); err != nil {
+	if err := s.SaveRelayMetrics(ctx, RelayMetrics{Day: "2024-01-01", Reqs: 3, Filters: 7, Events: 2, Counts: 1}); err != nil {
 		t.Fatalf("second SaveRelayMetrics: %v", err)
 	}
 
@@ -70,7 +76,7 @@ func TestSaveRelayMetrics_AccumulatesAcrossCalls(t *testing.T) {
 		t.Fatalf("queryRelayMetrics: %v", err)
 	}
 
-	want := RelayMetrics{Day: "2024-01-01", Reqs: 13, Filters: 27, Events: 7}
+	want := RelayMetrics{Day: "2024-01-01", Reqs: 13, Filters: 27, Events: 7, Counts: 5}
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("mismatch\n got: %+v\nwant: %+v", got, want)
 	}
@@ -110,6 +116,49 @@ func TestSaveRelayMetrics_DifferentDaysAreIndependent(t *testing.T) {
 	}
 }
 
+func TestNew_AddsCountsToExistingRelayMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "analytics.db")
+
+	// relay_metrics as created by older versions, without the counts column
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE relay_metrics (
+			day     DATE NOT NULL,
+			reqs    INTEGER NOT NULL DEFAULT 0,
+			filters INTEGER NOT NULL DEFAULT 0,
+			events  INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (day)
+		);
+		INSERT INTO relay_metrics (day, reqs, filters, events) VALUES ('2024-01-01', 1, 2, 3);
+	`); err != nil {
+		t.Fatalf("create old relay_metrics: %v", err)
+	}
+	db.Close()
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.SaveRelayMetrics(ctx, RelayMetrics{Day: "2024-01-01", Counts: 4}); err != nil {
+		t.Fatalf("SaveRelayMetrics: %v", err)
+	}
+
+	got, err := queryRelayMetrics(s.db, "2024-01-01")
+	if err != nil {
+		t.Fatalf("queryRelayMetrics: %v", err)
+	}
+
+	want := RelayMetrics{Day: "2024-01-01", Reqs: 1, Filters: 2, Events: 3, Counts: 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("mismatch\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
 // --- SaveBlossomMetrics ---
 
 func TestSaveBlossomMetrics(t *testing.T) {
@@ -218,10 +267,10 @@ func TestSaveBlossomMetrics_DifferentDaysAreIndependent(t *testing.T) {
 func queryRelayMetrics(db *sql.DB, day string) (RelayMetrics, error) {
 	var m RelayMetrics
 	err := db.QueryRow(`
-		SELECT day, reqs, filters, events
+		SELECT day, reqs, filters, events, counts, expired
 		FROM relay_metrics
 		WHERE day = ?
-	`, day).Scan(&m.Day, &m.Reqs, &m.Filters, &m.Events)
+	`, day).Scan(&m.Day, &m.Reqs, &m.Filters, &m.Events, &m.Counts, &m.Expired)
 	if err != nil {
 		return RelayMetrics{}, fmt.Errorf("scan relay_metrics: %w", err)
 	}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// AppPopularity is the number of downloads and impressions of an app of a pubkey.
+type AppPopularity struct {
+	AppID       string
+	AppPubkey   string
+	Downloads   int
+	Impressions int
+}
+
+// QueryPopularity returns the downloads and impressions of every app since the given day (YYYY-MM-DD, inclusive).
+// Downloads that could not be attributed to an app are ignored.
+func (s *T) QueryPopularity(ctx context.Context, from string) ([]AppPopularity, error) {
+	query := `SELECT app_id, app_pubkey, SUM(downloads), SUM(impressions)
+		FROM (
+			SELECT app_id, app_pubkey, count AS downloads, 0 AS impressions
+			FROM app_downloads WHERE day >= ? AND app_id != '' AND app_pubkey != ''
+			UNION ALL
+			SELECT app_id, app_pubkey, 0 AS downloads, count AS impressions
+			FROM app_impressions WHERE day >= ?
+		)
+		GROUP BY app_id, app_pubkey`
+
+	rows, err := s.db.QueryContext(ctx, query, from, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query popularity: %w", err)
+	}
+	defer rows.Close()
+
+	var apps []AppPopularity
+	for rows.Next() {
+		var app AppPopularity
+		if err := rows.Scan(&app.AppID, &app.AppPubkey, &app.Downloads, &app.Impressions); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		apps = append(apps, app)
+	}
+	return apps, rows.Err()
+}
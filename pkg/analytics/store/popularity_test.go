@@ -0,0 +1,52 @@
+package store
+
+import (
+	"cmp"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+func TestQueryPopularity(t *testing.T) {
+	s, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.Close()
+
+	h1 := blossom.ComputeHash([]byte("file1"))
+	h2 := blossom.ComputeHash([]byte("file2"))
+	downloads := []DownloadCount{
+		{Download{Hash: h1, AppID: "com.example.app1", AppPubkey: pubkey1, Day: "2024-01-01", Source: SourceApp, Type: Install, CountryCode: "US"}, 10},
+		{Download{Hash: h1, AppID: "com.example.app1", AppPubkey: pubkey1, Day: "2024-01-02", Source: SourceApp, Type: Update, CountryCode: "US"}, 5},
+		{Download{Hash: h2, AppID: "com.example.app2", AppPubkey: pubkey2, Day: "2023-12-31", Source: SourceApp, Type: Install, CountryCode: "US"}, 100},
+		{Download{Hash: h2, Day: "2024-01-02", Source: SourceApp, Type: Install, CountryCode: "US"}, 7}, // not attributed to an app
+	}
+	if err := s.SaveDownloads(ctx, downloads); err != nil {
+		t.Fatalf("SaveDownloads: %v", err)
+	}
+
+	impressions := []ImpressionCount{
+		{Impression{AppID: "com.example.app1", AppPubkey: pubkey1, Day: "2024-01-01", Source: SourceApp, Type: ImpressionDetail, CountryCode: "US"}, 3},
+		{Impression{AppID: "com.example.app2", AppPubkey: pubkey2, Day: "2024-01-02", Source: SourceWeb, Type: ImpressionDetail, CountryCode: "FR"}, 4},
+	}
+	if err := s.SaveImpressions(ctx, impressions); err != nil {
+		t.Fatalf("SaveImpressions: %v", err)
+	}
+
+	got, err := s.QueryPopularity(ctx, "2024-01-01")
+	if err != nil {
+		t.Fatalf("QueryPopularity: %v", err)
+	}
+	slices.SortFunc(got, func(a, b AppPopularity) int { return cmp.Compare(a.AppID, b.AppID) })
+
+	want := []AppPopularity{
+		{AppID: "com.example.app1", AppPubkey: pubkey1, Downloads: 15, Impressions: 3},
+		{AppID: "com.example.app2", AppPubkey: pubkey2, Downloads: 0, Impressions: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -43,6 +43,8 @@ CREATE TABLE IF NOT EXISTS relay_metrics (
   reqs          INTEGER NOT NULL DEFAULT 0, -- REQs fulfilled
   filters       INTEGER NOT NULL DEFAULT 0, -- filters fulfilled
   events        INTEGER NOT NULL DEFAULT 0, -- events saved or replaced
+  counts        INTEGER NOT NULL DEFAULT 0, -- COUNTs fulfilled
+  expired       INTEGER NOT NULL DEFAULT 0, -- expired events deleted
   PRIMARY KEY (day)
 );
 
@@ -29,6 +29,9 @@ func New(path string) (*T, error) {
 	if _, err := db.Exec(schema); err != nil {
 		return nil, fmt.Errorf("failed to apply base schema: %w", err)
 	}
+	if err := addMissingColumns(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
 	if _, err := db.Exec("PRAGMA journal_mode = WAL;"); err != nil {
 		return nil, fmt.Errorf("failed to set WAL mode: %w", err)
 	}
@@ -54,6 +57,40 @@ func (s *T) Path() string {
 	return s.path
 }
 
+// columns added to existing tables after their creation.
+// CREATE TABLE IF NOT EXISTS doesn't modify tables created by older versions, so these are added
+// with ALTER TABLE when missing.
+var columns = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{table: "relay_metrics", name: "counts", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "relay_metrics", name: "expired", definition: "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// addMissingColumns adds the [columns] that are missing from the database tables.
+func addMissingColumns(db *sql.DB) error {
+	for _, c := range columns {
+		var exists bool
+		err := db.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)`, c.table, c.name,
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to check column %s.%s: %w", c.table, c.name, err)
+		}
+		if exists {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.name, err)
+		}
+	}
+	return nil
+}
+
 // inClause returns an SQL IN clause for the given number of placeholders, with the first placeholder repeated n times.
 func inClause(n int) string {
 	if n <= 0 {
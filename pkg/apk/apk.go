@@ -0,0 +1,183 @@
+// Package apk extracts the signer certificates from the APK Signing Block of Android packages.
+// Only APK Signature Scheme v2 and v3 are supported, because v1 (JAR) signatures live inside the zip entries.
+// Learn more here: https://source.android.com/docs/security/features/apksigning/v2
+package apk
+
+import (
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrNotZip           = errors.New("apk: end of central directory not found")
+	ErrNoSigningBlock   = errors.New("apk: APK Signing Block not found (v1-only signatures are not supported)")
+	ErrNoSignatureBlock = errors.New("apk: no v2 or v3 signature in the APK Signing Block")
+)
+
+const (
+	eocdSignature = 0x06054b50
+	eocdMinSize   = 22
+	eocdMaxSize   = eocdMinSize + 0xffff // the zip comment is at most 65535 bytes
+
+	signingBlockMagic = "APK Sig Block 42"
+	signingBlockMax   = 16 << 20
+
+	idSignatureV2 = 0x7109871a
+	idSignatureV3 = 0xf05368c0
+)
+
+// SignerCertificates returns the certificate of each signer of the APK, read from its APK Signing Block.
+// The v3 signature is preferred over the v2, because it reflects key rotations.
+func SignerCertificates(r io.ReaderAt, size int64) ([]*x509.Certificate, error) {
+	block, err := signingBlock(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, err := idValuePairs(block)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range []uint32{idSignatureV3, idSignatureV2} {
+		if value, ok := pairs[id]; ok {
+			return certificates(value)
+		}
+	}
+	return nil, ErrNoSignatureBlock
+}
+
+// signingBlock returns the id-value pairs section of the APK Signing Block,
+// which is located right before the zip central directory.
+func signingBlock(r io.ReaderAt, size int64) ([]byte, error) {
+	tailSize := min(size, eocdMaxSize)
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("apk: failed to read the end of central directory: %w", err)
+	}
+
+	eocd := -1
+	for i := len(tail) - eocdMinSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == eocdSignature {
+			eocd = i
+			break
+		}
+	}
+	if eocd < 0 {
+		return nil, ErrNotZip
+	}
+
+	cdOffset := int64(binary.LittleEndian.Uint32(tail[eocd+16:]))
+	if cdOffset < 32 || cdOffset > size {
+		return nil, ErrNoSigningBlock
+	}
+
+	// the block ends with its size (excluding the leading size field) and the magic
+	footer := make([]byte, 24)
+	if _, err := r.ReadAt(footer, cdOffset-24); err != nil {
+		return nil, fmt.Errorf("apk: failed to read the APK Signing Block footer: %w", err)
+	}
+	if string(footer[8:]) != signingBlockMagic {
+		return nil, ErrNoSigningBlock
+	}
+
+	blockSize := int64(binary.LittleEndian.Uint64(footer))
+	if blockSize < 24 || blockSize > signingBlockMax || blockSize+8 > cdOffset {
+		return nil, fmt.Errorf("apk: invalid APK Signing Block size %d", blockSize)
+	}
+
+	// skip the leading size field, and drop the trailing size and magic
+	pairs := make([]byte, blockSize-24)
+	if _, err := r.ReadAt(pairs, cdOffset-blockSize); err != nil {
+		return nil, fmt.Errorf("apk: failed to read the APK Signing Block: %w", err)
+	}
+	return pairs, nil
+}
+
+// idValuePairs parses the uint64 length-prefixed id-value pairs of the APK Signing Block.
+func idValuePairs(data []byte) (map[uint32][]byte, error) {
+	pairs := make(map[uint32][]byte)
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errors.New("apk: truncated id-value pair")
+		}
+
+		length := binary.LittleEndian.Uint64(data)
+		if length < 4 || length > uint64(len(data)-8) {
+			return nil, fmt.Errorf("apk: invalid id-value pair length %d", length)
+		}
+
+		id := binary.LittleEndian.Uint32(data[8:])
+		pairs[id] = data[12 : 8+length]
+		data = data[8+length:]
+	}
+	return pairs, nil
+}
+
+// certificates returns the first certificate of each signer of a v2 or v3 signature block.
+// Both schemes start the signed data of each signer with the digests, followed by the certificates.
+func certificates(block []byte) ([]*x509.Certificate, error) {
+	signers, err := lengthPrefixed(block)
+	if err != nil {
+		return nil, fmt.Errorf("apk: invalid signers: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for len(signers) > 0 {
+		var signer, signedData, certsData, der []byte
+		if signer, signers, err = next(signers); err != nil {
+			return nil, fmt.Errorf("apk: invalid signer: %w", err)
+		}
+		if signedData, _, err = next(signer); err != nil {
+			return nil, fmt.Errorf("apk: invalid signed data: %w", err)
+		}
+		if _, signedData, err = next(signedData); err != nil {
+			return nil, fmt.Errorf("apk: invalid digests: %w", err)
+		}
+		if certsData, _, err = next(signedData); err != nil {
+			return nil, fmt.Errorf("apk: invalid certificates: %w", err)
+		}
+		if der, _, err = next(certsData); err != nil {
+			return nil, fmt.Errorf("apk: signer has no certificate: %w", err)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("apk: invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("apk: no signers")
+	}
+	return certs, nil
+}
+
+// lengthPrefixed returns the content of a uint32 length-prefixed sequence that spans the whole data.
+func lengthPrefixed(data []byte) ([]byte, error) {
+	content, rest, err := next(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data")
+	}
+	return content, nil
+}
+
+// next splits the data into its first uint32 length-prefixed element and the rest.
+func next(data []byte) (element, rest []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	length := binary.LittleEndian.Uint32(data)
+	if uint64(length) > uint64(len(data)-4) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	return data[4 : 4+length], data[4+length:], nil
+}
@@ -0,0 +1,166 @@
+package apk
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignerCertificates(t *testing.T) {
+	cert := newCertificate(t, "developer")
+	rotated := newCertificate(t, "rotated")
+
+	tests := []struct {
+		name string
+		apk  []byte
+		want *x509.Certificate
+		err  error
+	}{
+		{
+			name: "v2",
+			apk:  newAPK(map[uint32][]byte{idSignatureV2: signatureBlock(cert)}),
+			want: cert,
+		},
+		{
+			name: "v3 is preferred over v2",
+			apk:  newAPK(map[uint32][]byte{idSignatureV2: signatureBlock(cert), idSignatureV3: signatureBlock(rotated)}),
+			want: rotated,
+		},
+		{
+			name: "no signature",
+			apk:  newAPK(map[uint32][]byte{0x42726577: {0, 0, 0, 0}}),
+			err:  ErrNoSignatureBlock,
+		},
+		{
+			name: "no signing block",
+			apk:  newAPK(nil),
+			err:  ErrNoSigningBlock,
+		},
+		{
+			name: "not a zip",
+			apk:  bytes.Repeat([]byte{1}, 100),
+			err:  ErrNotZip,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certs, err := SignerCertificates(bytes.NewReader(test.apk), int64(len(test.apk)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if test.err != nil {
+				return
+			}
+			if len(certs) != 1 || !certs[0].Equal(test.want) {
+				t.Errorf("expected the certificate of %s, got %v", test.want.Subject, certs)
+			}
+		})
+	}
+}
+
+func TestRemote(t *testing.T) {
+	cert := newCertificate(t, "developer")
+	apk := newAPK(map[uint32][]byte{idSignatureV2: signatureBlock(cert)})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "app.apk", time.Time{}, bytes.NewReader(apk))
+	}))
+	defer server.Close()
+
+	remote, err := OpenRemote(t.Context(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("OpenRemote: %v", err)
+	}
+
+	certs, err := SignerCertificates(remote, remote.Size())
+	if err != nil {
+		t.Fatalf("SignerCertificates: %v", err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(cert) {
+		t.Errorf("expected the developer certificate, got %v", certs)
+	}
+}
+
+func newCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+// newAPK returns a minimal zip with an APK Signing Block made of the given id-value pairs,
+// or without a signing block if pairs is nil.
+func newAPK(pairs map[uint32][]byte) []byte {
+	var apk bytes.Buffer
+	apk.WriteString("PK\x03\x04 local file headers and data")
+
+	if pairs != nil {
+		var content bytes.Buffer
+		for id, value := range pairs {
+			binary.Write(&content, binary.LittleEndian, uint64(4+len(value)))
+			binary.Write(&content, binary.LittleEndian, id)
+			content.Write(value)
+		}
+
+		size := uint64(content.Len() + 24)
+		binary.Write(&apk, binary.LittleEndian, size)
+		apk.Write(content.Bytes())
+		binary.Write(&apk, binary.LittleEndian, size)
+		apk.WriteString(signingBlockMagic)
+	}
+
+	cdOffset := apk.Len()
+	apk.WriteString("PK\x01\x02 central directory")
+
+	eocd := make([]byte, eocdMinSize)
+	binary.LittleEndian.PutUint32(eocd, eocdSignature)
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(cdOffset))
+	apk.Write(eocd)
+	return apk.Bytes()
+}
+
+// signatureBlock returns a v2/v3 signature block with a single signer using the certificate.
+func signatureBlock(cert *x509.Certificate) []byte {
+	digests := prefixed()
+	certificates := prefixed(prefixed(cert.Raw))
+	signedData := prefixed(append(digests, certificates...))
+	signer := prefixed(append(signedData, prefixed()...))
+	return prefixed(signer)
+}
+
+// prefixed returns the concatenation of the data, prefixed by its uint32 length.
+func prefixed(data ...[]byte) []byte {
+	content := bytes.Join(data, nil)
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(content)))
+	return append(out, content...)
+}
@@ -0,0 +1,87 @@
+package apk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Remote is an APK served over HTTP, read with range requests so that only
+// the end of central directory and the APK Signing Block are downloaded.
+type Remote struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	size   int64
+}
+
+// OpenRemote returns the APK at the url, after checking with a HEAD request that the server supports range requests.
+func OpenRemote(ctx context.Context, client *http.Client, url string) (*Remote, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("apk: invalid url: %w", err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("apk: failed to HEAD %s: %w", url, err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("apk: HEAD %s: %s", url, res.Status)
+	}
+	if res.ContentLength <= 0 {
+		return nil, fmt.Errorf("apk: HEAD %s: unknown content length", url)
+	}
+	if res.Header.Get("Accept-Ranges") != "bytes" {
+		return nil, fmt.Errorf("apk: HEAD %s: range requests are not supported", url)
+	}
+	return &Remote{ctx: ctx, client: client, url: url, size: res.ContentLength}, nil
+}
+
+// Size returns the size of the APK in bytes.
+func (r *Remote) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements the [io.ReaderAt] interface with a range request.
+func (r *Remote) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("apk: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	end := min(off+int64(len(p)), r.size) - 1
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("apk: GET %s: %s", r.url, res.Status)
+	}
+
+	n, err := io.ReadFull(res.Body, p[:end-off+1])
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
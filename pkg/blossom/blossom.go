@@ -367,9 +367,10 @@ func RateUploadIP(limiter rate.Limiter) func(r blossy.Request, hints blossy.Uplo
 			cost = float64(hints.Size) / 10_000_000
 		}
 
-		ip := r.IP().Group()
-		if !limiter.Allow(ip, cost) {
-			slog.Debug("blossom: rejecting upload", "ip", ip)
+		// the pubkey of the authorization is charged too, if present, see [rate.Limiter.AllowAuthed]
+		allowed, key := limiter.AllowAuthed(r.IP().Group(), []string{r.Pubkey()}, cost)
+		if !allowed {
+			slog.Debug("blossom: rejecting upload", "key", key)
 			return ErrRateLimited
 		}
 		return nil
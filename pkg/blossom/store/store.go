@@ -135,3 +135,31 @@ func (s *T) Has(ctx context.Context, hash blossom.Hash) (bool, error) {
 	}
 	return exists, nil
 }
+
+// CreatedBefore returns the metadata of the blobs uploaded before the given time, the oldest first.
+func (s *T) CreatedBefore(ctx context.Context, before time.Time) ([]BlobMeta, error) {
+	query := `SELECT hash, type, size, created_at, auth_pubkey FROM blobs WHERE created_at < ? ORDER BY created_at`
+	rows, err := s.DB.QueryContext(ctx, query, before.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []BlobMeta
+	for rows.Next() {
+		var meta BlobMeta
+		var createdAt int64
+		var authPubkey sql.NullString
+
+		if err := rows.Scan(&meta.Hash, &meta.Type, &meta.Size, &createdAt, &authPubkey); err != nil {
+			return nil, fmt.Errorf("failed to scan blob metadata: %w", err)
+		}
+		meta.CreatedAt = time.Unix(createdAt, 0).UTC()
+		meta.AuthPubkey = authPubkey.String
+		blobs = append(blobs, meta)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query blobs: %w", err)
+	}
+	return blobs, nil
+}
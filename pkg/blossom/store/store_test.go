@@ -61,3 +61,30 @@ func TestSaveAndQuery(t *testing.T) {
 		t.Errorf("expected blobmeta %v, got %v", want, got)
 	}
 }
+
+func TestCreatedBefore(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	old := BlobMeta{Hash: blossom.ComputeHash([]byte("old")), Type: "image/png", Size: 10, CreatedAt: now.Add(-48 * time.Hour)}
+	older := BlobMeta{Hash: blossom.ComputeHash([]byte("older")), Type: "image/png", Size: 20, CreatedAt: now.Add(-72 * time.Hour), AuthPubkey: "alice"}
+	recent := BlobMeta{Hash: blossom.ComputeHash([]byte("recent")), Type: "image/png", Size: 30, CreatedAt: now}
+
+	for _, b := range []BlobMeta{old, older, recent} {
+		if _, err := store.Save(ctx, b); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	blobs, err := store.CreatedBefore(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("CreatedBefore failed: %v", err)
+	}
+	if want := []BlobMeta{older, old}; !reflect.DeepEqual(blobs, want) {
+		t.Errorf("expected blobs %v, got %v", want, blobs)
+	}
+}
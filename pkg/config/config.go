@@ -18,6 +18,7 @@ import (
 	"github.com/zapstore/relay/pkg/analytics"
 	"github.com/zapstore/relay/pkg/blossom"
 	"github.com/zapstore/relay/pkg/dashboard"
+	"github.com/zapstore/relay/pkg/gc"
 	"github.com/zapstore/relay/pkg/indexing"
 	"github.com/zapstore/relay/pkg/rate"
 	"github.com/zapstore/relay/pkg/relay"
@@ -28,11 +29,12 @@ var Version = "dev"
 
 type Config struct {
 	Sys       SystemConfig
-	Limiter   rate.Config
+	Limiter   rate.TiersConfig
 	Analytics analytics.Config
 	Indexing  indexing.Config
 	Relay     relay.Config
 	Blossom   blossom.Config
+	GC        gc.Config
 	Dashboard dashboard.Config
 }
 
@@ -124,11 +126,12 @@ func Load() (Config, error) {
 func New() Config {
 	return Config{
 		Sys:       NewSystemConfig(),
-		Limiter:   rate.NewConfig(),
+		Limiter:   rate.NewTiersConfig(),
 		Analytics: analytics.NewConfig(),
 		Indexing:  indexing.NewConfig(),
 		Relay:     relay.NewConfig(),
 		Blossom:   blossom.NewConfig(),
+		GC:        gc.NewConfig(),
 		Dashboard: dashboard.NewConfig(),
 	}
 }
@@ -152,6 +155,9 @@ func (c Config) Validate() error {
 	if err := c.Blossom.Validate(); err != nil {
 		return fmt.Errorf("blossom: %w", err)
 	}
+	if err := c.GC.Validate(); err != nil {
+		return fmt.Errorf("gc: %w", err)
+	}
 	if err := c.Dashboard.Validate(); err != nil {
 		return fmt.Errorf("dashboard: %w", err)
 	}
@@ -172,6 +178,8 @@ func (c Config) String() string {
 	b.WriteByte('\n')
 	b.WriteString(c.Blossom.String())
 	b.WriteByte('\n')
+	b.WriteString(c.GC.String())
+	b.WriteByte('\n')
 	b.WriteString(c.Dashboard.String())
 	return b.String()
 }
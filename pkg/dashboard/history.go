@@ -0,0 +1,238 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/zapstore/relay/pkg/relay"
+	relaystore "github.com/zapstore/relay/pkg/relay/store"
+)
+
+// FieldChange is a tag key, or the content, whose values differ between two versions of an event.
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// historyVersion is a version of an event, with the changes from the version before it.
+type historyVersion struct {
+	Event      nostr.Event
+	Current    bool
+	ReplacedBy string
+	ReplacedAt time.Time
+	Changes    []FieldChange
+	Oldest     bool // the oldest version has no changes to show
+}
+
+type historyPageData struct {
+	Address  string
+	Versions []historyVersion
+	Recent   []relaystore.HistoricalEvent
+	IsAdmin  bool
+}
+
+// historyPage shows the versions of the event with the 'address' query param, or the most recently replaced
+// events when it's missing. The address is either "<kind>:<pubkey>:<d>" or a NIP-19 naddr.
+func (d *T) historyPage(w http.ResponseWriter, r *http.Request) {
+	token, ok := d.authenticate(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	data := historyPageData{
+		Address: strings.TrimSpace(r.URL.Query().Get("address")),
+		IsAdmin: d.auth.IsAdmin(token),
+	}
+
+	if data.Address == "" {
+		recent, err := d.relay.RecentHistory(ctx, 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Recent = recent
+
+	} else {
+		kind, pubkey, dTag, err := parseAddress(data.Address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		versions, err := d.versions(ctx, kind, pubkey, dTag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Versions = versions
+	}
+
+	if err := d.template.ExecuteTemplate(w, "history", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// versions returns the current and archived versions of the event, most recent first.
+func (d *T) versions(ctx context.Context, kind int, pubkey, dTag string) ([]historyVersion, error) {
+	filter := nostr.Filter{Kinds: []int{kind}, Authors: []string{pubkey}, Limit: 1}
+	if nostr.IsAddressableKind(kind) {
+		filter.Tags = nostr.TagMap{"d": {dTag}}
+	}
+
+	current, err := d.relay.Query(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	history, err := d.relay.EventHistory(ctx, kind, pubkey, dTag)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]historyVersion, 0, len(current)+len(history))
+	for _, e := range current {
+		versions = append(versions, historyVersion{Event: e, Current: true})
+	}
+	for _, h := range history {
+		versions = append(versions, historyVersion{Event: h.Event, ReplacedBy: h.ReplacedBy, ReplacedAt: h.ReplacedAt})
+	}
+
+	for i := range versions {
+		if i == len(versions)-1 {
+			versions[i].Oldest = true
+			break
+		}
+		versions[i].Changes = diffVersions(versions[i+1].Event, versions[i].Event)
+	}
+	return versions, nil
+}
+
+// parseAddress parses "<kind>:<pubkey>:<d>" or a NIP-19 naddr into the kind, pubkey and 'd' tag of an event.
+// The 'd' tag is empty for replaceable events, e.g. "0:<pubkey>".
+func parseAddress(address string) (kind int, pubkey, dTag string, err error) {
+	if strings.HasPrefix(address, "naddr1") {
+		_, v, err := nip19.Decode(address)
+		if err != nil {
+			return 0, "", "", fmt.Errorf("invalid naddr: %w", err)
+		}
+		pointer, ok := v.(nostr.EntityPointer)
+		if !ok {
+			return 0, "", "", errors.New("invalid naddr")
+		}
+		return pointer.Kind, pointer.PublicKey, pointer.Identifier, nil
+	}
+
+	parts := strings.SplitN(address, ":", 3)
+	if len(parts) < 2 {
+		return 0, "", "", errors.New("address must be <kind>:<pubkey>:<d> or an naddr")
+	}
+	kind, err = strconv.Atoi(parts[0])
+	if err != nil || !(nostr.IsReplaceableKind(kind) || nostr.IsAddressableKind(kind)) {
+		return 0, "", "", fmt.Errorf("kind %q is not replaceable or addressable", parts[0])
+	}
+	if !nostr.IsValidPublicKey(parts[1]) {
+		return 0, "", "", fmt.Errorf("invalid pubkey %q", parts[1])
+	}
+	if len(parts) == 3 {
+		dTag = parts[2]
+	}
+	return kind, parts[1], dTag, nil
+}
+
+// diffVersions returns the fields that changed from the older to the newer version, the content last.
+// Tags are compared by key, with the values of repeated keys (e.g. 'image') compared in order.
+func diffVersions(older, newer nostr.Event) []FieldChange {
+	oldTags, newTags := tagValues(older.Tags), tagValues(newer.Tags)
+
+	keys := make([]string, 0, len(oldTags)+len(newTags))
+	for k := range oldTags {
+		keys = append(keys, k)
+	}
+	for k := range newTags {
+		if _, ok := oldTags[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	var changes []FieldChange
+	for _, k := range keys {
+		if oldTags[k] != newTags[k] {
+			changes = append(changes, FieldChange{Field: k, Old: oldTags[k], New: newTags[k]})
+		}
+	}
+	if older.Content != newer.Content {
+		changes = append(changes, FieldChange{Field: "content", Old: older.Content, New: newer.Content})
+	}
+	return changes
+}
+
+// tagValues maps each tag key to its values, one line per tag.
+func tagValues(tags nostr.Tags) map[string]string {
+	values := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		if len(tag) < 2 {
+			continue
+		}
+		line := strings.Join(tag[1:], " ")
+		if v, ok := values[tag[0]]; ok {
+			line = v + "\n" + line
+		}
+		values[tag[0]] = line
+	}
+	return values
+}
+
+// restoreVersionBody is the JSON payload for POST /history/restore.
+type restoreVersionBody struct {
+	ID string `json:"id"`
+}
+
+func (d *T) restoreVersion(w http.ResponseWriter, r *http.Request) {
+	token, ok := d.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !d.auth.IsAdmin(token) {
+		http.Error(w, "forbidden: admin access required", http.StatusForbidden)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req restoreVersionBody
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restored, err := d.restorer.RestoreVersion(r.Context(), req.ID)
+	if errors.Is(err, relaystore.ErrVersionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, relay.ErrEventBanned) || errors.Is(err, relay.ErrEventDeleted) || errors.Is(err, relay.ErrEventPubkeyBlocked) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("event version restored", "id", restored.ID, "kind", restored.Kind, "pubkey", restored.PubKey, "admin", token.Signer)
+	w.WriteHeader(http.StatusNoContent)
+}
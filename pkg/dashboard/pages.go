@@ -4,6 +4,7 @@ import (
 	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
@@ -14,6 +15,8 @@ import (
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"github.com/zapstore/defender/pkg/models"
 	"github.com/zapstore/relay/pkg/analytics/store"
+	"github.com/zapstore/relay/pkg/gc"
+	relaystore "github.com/zapstore/relay/pkg/relay/store"
 )
 
 // ChartDataset represents a single dataset line in a chart.
@@ -70,17 +73,23 @@ func (d *T) relayPage(w http.ResponseWriter, r *http.Request) {
 	reqs := make([]int64, len(days))
 	filters := make([]int64, len(days))
 	events := make([]int64, len(days))
-	totalReqs, totalFilters, totalEvents := int64(0), int64(0), int64(0)
+	counts := make([]int64, len(days))
+	expired := make([]int64, len(days))
+	totalReqs, totalFilters, totalEvents, totalCounts, totalExpired := int64(0), int64(0), int64(0), int64(0), int64(0)
 
 	for i, day := range days {
 		if m, ok := byDay[day]; ok {
 			reqs[i] = m.Reqs
 			filters[i] = m.Filters
 			events[i] = m.Events
+			counts[i] = m.Counts
+			expired[i] = m.Expired
 
 			totalReqs += m.Reqs
 			totalFilters += m.Filters
 			totalEvents += m.Events
+			totalCounts += m.Counts
+			totalExpired += m.Expired
 		}
 	}
 
@@ -89,6 +98,8 @@ func (d *T) relayPage(w http.ResponseWriter, r *http.Request) {
 			{Label: "Requests", Value: totalReqs},
 			{Label: "Filters", Value: totalFilters},
 			{Label: "Events", Value: totalEvents},
+			{Label: "Counts", Value: totalCounts},
+			{Label: "Expired", Value: totalExpired},
 		},
 	}
 	data.Chart = ChartData{
@@ -99,6 +110,8 @@ func (d *T) relayPage(w http.ResponseWriter, r *http.Request) {
 			{Label: "Requests", Data: reqs, BorderColor: "#6366f1", BackgroundColor: "rgba(99,102,241,0.08)"},
 			{Label: "Filters", Data: filters, BorderColor: "#06b6d4", BackgroundColor: "rgba(6,182,212,0.08)"},
 			{Label: "Events", Data: events, BorderColor: "#10b981", BackgroundColor: "rgba(16,185,129,0.08)"},
+			{Label: "Counts", Data: counts, BorderColor: "#f59e0b", BackgroundColor: "rgba(245,158,11,0.08)"},
+			{Label: "Expired", Data: expired, BorderColor: "#ef4444", BackgroundColor: "rgba(239,68,68,0.08)"},
 		},
 	}
 
@@ -110,6 +123,10 @@ func (d *T) relayPage(w http.ResponseWriter, r *http.Request) {
 type blossomPageData struct {
 	Cards []CardData
 	Chart ChartData
+
+	Reclaimable gc.Report // blobs that are no longer referenced by any event, as of ReportedAt
+	ReportedAt  time.Time
+	GCError     string
 }
 
 func (d *T) blossomPage(w http.ResponseWriter, r *http.Request) {
@@ -169,6 +186,11 @@ func (d *T) blossomPage(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	data.Reclaimable, data.ReportedAt, err = d.collector.LastReport()
+	if err != nil {
+		data.GCError = err.Error()
+	}
+
 	if err := d.template.ExecuteTemplate(w, "blossom", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -203,6 +225,7 @@ type AppRow struct {
 	AppID       string
 	Impressions int
 	Downloads   int
+	Verified    bool // published by a developer with a valid identity proof for the APK certificate
 }
 
 const defaultAppID = "dev.zapstore.app"
@@ -645,8 +668,18 @@ func (d *T) appRows(ctx context.Context, from, to string) ([]AppRow, error) {
 		appMap[id].Downloads += row.Count
 	}
 
+	appIDs := make([]string, 0, len(appMap))
+	for id := range appMap {
+		appIDs = append(appIDs, id)
+	}
+	verified, err := d.relay.VerifiedApps(ctx, appIDs...)
+	if err != nil {
+		return nil, err
+	}
+
 	apps := make([]AppRow, 0, len(appMap))
 	for _, v := range appMap {
+		v.Verified = verified[v.AppID]
 		apps = append(apps, *v)
 	}
 	return apps, nil
@@ -703,9 +736,10 @@ func (d *T) appChart(ctx context.Context, from, to, appID string) (ChartData, er
 }
 
 type defenderPageData struct {
-	Policies []models.Policy
-	Audits   []models.Audit
-	IsAdmin  bool
+	Policies  []models.Policy
+	Audits    []models.Audit
+	Conflicts []relaystore.CertificateConflict
+	IsAdmin   bool
 }
 
 func (d *T) defenderPage(w http.ResponseWriter, r *http.Request) {
@@ -726,10 +760,16 @@ func (d *T) defenderPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	conflicts, err := d.relay.CertificateConflicts(ctx, relaystore.ConflictPending, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	data := defenderPageData{
-		Policies: policies,
-		Audits:   audits,
-		IsAdmin:  d.auth.IsAdmin(token),
+		Policies:  policies,
+		Audits:    audits,
+		Conflicts: conflicts,
+		IsAdmin:   d.auth.IsAdmin(token),
 	}
 	if err := d.template.ExecuteTemplate(w, "defender", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -822,6 +862,50 @@ func (d *T) deletePolicy(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// resolveConflictBody is the JSON payload for POST /defender/conflicts.
+type resolveConflictBody struct {
+	CertificateHash string                    `json:"certificate_hash"`
+	Pubkey          string                    `json:"pubkey"`
+	Status          relaystore.ConflictStatus `json:"status"`
+}
+
+func (d *T) resolveConflict(w http.ResponseWriter, r *http.Request) {
+	token, ok := d.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !d.auth.IsAdmin(token) {
+		http.Error(w, "forbidden: admin access required", http.StatusForbidden)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req resolveConflictBody
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Status != relaystore.ConflictAllowed && req.Status != relaystore.ConflictBlocked {
+		http.Error(w, "status must be either allowed or blocked", http.StatusBadRequest)
+		return
+	}
+
+	err = d.relay.ResolveCertificateConflict(r.Context(), req.CertificateHash, req.Pubkey, req.Status)
+	if errors.Is(err, relaystore.ErrConflictNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("certificate conflict resolved", "certificate_hash", req.CertificateHash, "pubkey", req.Pubkey, "status", req.Status)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // dayRange returns every day from `from` to `to` inclusive in ascending order.
 func dayRange(from, to string) []string {
 	start, _ := time.Parse("2006-01-02", from)
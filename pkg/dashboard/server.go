@@ -11,10 +11,12 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/pippellia-btc/rely/v2"
 	defender "github.com/zapstore/defender/pkg/client"
 	"github.com/zapstore/relay/pkg/analytics"
 	"github.com/zapstore/relay/pkg/blossom"
+	"github.com/zapstore/relay/pkg/gc"
 	"github.com/zapstore/relay/pkg/rate"
 	"github.com/zapstore/relay/pkg/relay"
 )
@@ -34,8 +36,16 @@ type T struct {
 	limiter   rate.Limiter
 	defender  defender.T
 	relay     relay.DB
+	restorer  Restorer
 	blossom   blossom.DB
 	analytics analytics.DB
+	collector *gc.Collector
+}
+
+// Restorer restores the archived versions of events, checking that they can be published again.
+type Restorer interface {
+	// RestoreVersion makes the archived version with the given ID the current version of its event again.
+	RestoreVersion(ctx context.Context, ID string) (nostr.Event, error)
 }
 
 // New parses the embedded templates and returns a ready-to-use Server.
@@ -44,8 +54,10 @@ func New(
 	limiter rate.Limiter,
 	defender defender.T,
 	relay relay.DB,
+	restorer Restorer,
 	blossom blossom.DB,
 	analytics analytics.DB,
+	collector *gc.Collector,
 ) (*T, error) {
 	funcs := template.FuncMap{
 		"json": func(v any) (string, error) {
@@ -60,6 +72,19 @@ func New(
 			}
 			return string(runes[:n]) + "…"
 		},
+
+		"bytes": func(n int64) string {
+			const unit = 1024
+			if n < unit {
+				return fmt.Sprintf("%d B", n)
+			}
+			div, exp := int64(unit), 0
+			for m := n / unit; m >= unit; m /= unit {
+				div *= unit
+				exp++
+			}
+			return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+		},
 	}
 	tmpl, err := template.New("").Funcs(funcs).ParseFS(templateFiles, "templates/*.html")
 	if err != nil {
@@ -72,8 +97,10 @@ func New(
 		limiter:   limiter,
 		defender:  defender,
 		relay:     relay,
+		restorer:  restorer,
 		blossom:   blossom,
 		analytics: analytics,
+		collector: collector,
 	}, nil
 }
 
@@ -110,6 +137,10 @@ func (d *T) StartAndServe(ctx context.Context, addr string) error {
 	mux.HandleFunc("GET /tabs/defender", d.rateLimit(d.defenderPage))
 	mux.HandleFunc("POST /defender/policies", d.rateLimit(d.createPolicy))
 	mux.HandleFunc("DELETE /defender/policies", d.rateLimit(d.deletePolicy))
+	mux.HandleFunc("POST /defender/conflicts", d.rateLimit(d.resolveConflict))
+
+	mux.HandleFunc("GET /tabs/history", d.rateLimit(d.historyPage))
+	mux.HandleFunc("POST /history/restore", d.rateLimit(d.restoreVersion))
 
 	server := &http.Server{
 		Addr:              addr,
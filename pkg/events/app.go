@@ -6,6 +6,7 @@ package events
 import (
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -46,6 +47,57 @@ type App struct {
 	URL        string   // Website URL
 	Repository string   // Source code repository URL
 	License    string   // SPDX license ID
+
+	// Localizations of the metadata by lowercase language code (e.g. "de", "pt-br"), taken from the
+	// language-qualified 'name', 'summary' and 'description' tags, e.g. ["name", "Signal", "de"].
+	// The unqualified tags and the content are in the default language.
+	Localizations map[string]Localization
+}
+
+// Localization is the metadata of an app in a language. Empty fields are not localized.
+type Localization struct {
+	Name    string
+	Summary string
+	Content string // from the 'description' tag
+}
+
+// Localize returns the metadata of the app in the language, falling back to the default language
+// for the fields that are not localized in it.
+func (app App) Localize(lang string) Localization {
+	l := app.Localizations[strings.ToLower(lang)]
+	if l.Name == "" {
+		l.Name = app.Name
+	}
+	if l.Summary == "" {
+		l.Summary = app.Summary
+	}
+	if l.Content == "" {
+		l.Content = app.Content
+	}
+	return l
+}
+
+// localize sets the field of the localization of the language, reporting an error if the language
+// is invalid or the field is already set.
+func (app *App) localize(lang, key, value string) error {
+	if err := ValidateLanguage(lang); err != nil {
+		return fmt.Errorf("invalid language in '%s' tag: %w", key, err)
+	}
+
+	lang = strings.ToLower(lang)
+	if app.Localizations == nil {
+		app.Localizations = make(map[string]Localization)
+	}
+
+	l := app.Localizations[lang]
+	field := map[string]*string{"name": &l.Name, "summary": &l.Summary, "description": &l.Content}[key]
+	if *field != "" {
+		return fmt.Errorf("duplicate '%s' tag for language %q", key, lang)
+	}
+
+	*field = value
+	app.Localizations[lang] = l
+	return nil
 }
 
 func (app App) Validate() error {
@@ -74,6 +126,16 @@ func ParseApp(event *nostr.Event) (App, error) {
 			continue
 		}
 
+		if lang := Language(tag); lang != "" {
+			switch tag[0] {
+			case "name", "summary", "description":
+				if err := app.localize(lang, tag[0], tag[1]); err != nil {
+					return App{}, err
+				}
+				continue
+			}
+		}
+
 		switch tag[0] {
 		case "d":
 			if app.D != "" {
@@ -3,6 +3,7 @@ package events
 import (
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -61,6 +62,9 @@ func (a *Asset) Validate() error {
 		if a.VersionCode == "" {
 			return fmt.Errorf("missing or empty 'version_code' tag (required for Android)")
 		}
+		if _, err := a.ParseVersionCode(); err != nil {
+			return fmt.Errorf("invalid 'version_code' tag: %w", err)
+		}
 		if len(a.APKCertificateHashes) == 0 {
 			return fmt.Errorf("missing 'apk_certificate_hash' tag (required for Android)")
 		}
@@ -68,6 +72,19 @@ func (a *Asset) Validate() error {
 	return nil
 }
 
+// ParseVersionCode returns the version code of the asset as an integer.
+// Android version codes are positive integers, used to decide whether an APK is an update of another.
+func (a *Asset) ParseVersionCode() (int64, error) {
+	code, err := strconv.ParseInt(a.VersionCode, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer", a.VersionCode)
+	}
+	if code <= 0 {
+		return 0, fmt.Errorf("%d is not positive", code)
+	}
+	return code, nil
+}
+
 func (a *Asset) isAndroid() bool {
 	for _, p := range a.Platforms {
 		if strings.HasPrefix(p, "android-") {
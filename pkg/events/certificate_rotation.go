@@ -0,0 +1,84 @@
+package events
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindCertificateRotation is the kind of the event with which the relay operator authorizes a publisher
+// to ship assets for an app signed with a new set of certificates.
+const KindCertificateRotation = 3064
+
+// CertificateRotation represents a parsed Certificate Rotation event (kind 3064).
+// The event is only meaningful when signed by the relay operator.
+type CertificateRotation struct {
+	I                    string   // App identifier (reverse-domain, e.g. com.example.app)
+	Pubkey               string   // Pubkey of the publisher allowed to rotate the certificates
+	APKCertificateHashes []string // New APK certificate hashes
+}
+
+// Validate checks that all required fields are present and valid.
+func (r CertificateRotation) Validate() error {
+	if r.I == "" {
+		return fmt.Errorf("missing or empty 'i' tag (app identifier)")
+	}
+	if r.Pubkey == "" {
+		return fmt.Errorf("missing or empty 'p' tag (publisher pubkey)")
+	}
+	if !nostr.IsValidPublicKey(r.Pubkey) {
+		return fmt.Errorf("invalid pubkey in 'p' tag: %s", r.Pubkey)
+	}
+	if len(r.APKCertificateHashes) == 0 {
+		return fmt.Errorf("missing 'apk_certificate_hash' tag")
+	}
+	for _, hash := range r.APKCertificateHashes {
+		if err := ValidateHash(hash); err != nil {
+			return fmt.Errorf("invalid 'apk_certificate_hash' tag: %w", err)
+		}
+	}
+	return nil
+}
+
+// ParseCertificateRotation extracts a CertificateRotation from a nostr.Event.
+// Returns an error if the event kind is wrong or if duplicate singular tags are found.
+func ParseCertificateRotation(event *nostr.Event) (CertificateRotation, error) {
+	if event.Kind != KindCertificateRotation {
+		return CertificateRotation{}, fmt.Errorf("invalid kind: expected %d, got %d", KindCertificateRotation, event.Kind)
+	}
+
+	rotation := CertificateRotation{}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "i":
+			if rotation.I != "" {
+				return CertificateRotation{}, fmt.Errorf("duplicate 'i' tag")
+			}
+			rotation.I = tag[1]
+
+		case "p":
+			if rotation.Pubkey != "" {
+				return CertificateRotation{}, fmt.Errorf("duplicate 'p' tag")
+			}
+			rotation.Pubkey = tag[1]
+
+		case "apk_certificate_hash":
+			rotation.APKCertificateHashes = append(rotation.APKCertificateHashes, tag[1])
+		}
+	}
+	return rotation, nil
+}
+
+// ValidateCertificateRotation parses and validates a Certificate Rotation event.
+// It doesn't check who signed the event, which is the responsibility of the relay.
+func ValidateCertificateRotation(event *nostr.Event) error {
+	rotation, err := ParseCertificateRotation(event)
+	if err != nil {
+		return err
+	}
+	return rotation.Validate()
+}
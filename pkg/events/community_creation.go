@@ -2,6 +2,7 @@ package events
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -9,6 +10,17 @@ import (
 
 const KindCommunityCreation = 10222
 
+const (
+	// KindProfileList is the kind of the NIP-51 follow sets that whitelist the publishers of a content section.
+	KindProfileList = 30000
+
+	// KindBadgeDefinition is the kind of the NIP-58 badge definitions that grant publish rights in a content section.
+	KindBadgeDefinition = 30009
+
+	// KindBadgeAward is the kind of the NIP-58 events with which the issuer of a badge awards it to pubkeys.
+	KindBadgeAward = 8
+)
+
 // ContentSection represents one named content section within a community,
 // grouping a name, the event kinds it accepts, the profile-list addresses
 // that whitelist publishers, and the badge definitions that grant publish rights.
@@ -63,16 +75,16 @@ func (s ContentSection) Validate() error {
 		return fmt.Errorf("content section %q has no 'k' tags", s.Name)
 	}
 	for _, list := range s.Lists {
-		if list.Kind != 30000 {
-			return fmt.Errorf("invalid list ref %q: expected kind 30000, got %d", list, list.Kind)
+		if list.Kind != KindProfileList {
+			return fmt.Errorf("invalid list ref %q: expected kind %d, got %d", list, KindProfileList, list.Kind)
 		}
 		if err := list.Validate(); err != nil {
 			return fmt.Errorf("invalid list ref %q: %w", list, err)
 		}
 	}
 	for _, badge := range s.Badges {
-		if badge.Kind != 30009 {
-			return fmt.Errorf("invalid badge ref %q: expected kind 30009, got %d", badge, badge.Kind)
+		if badge.Kind != KindBadgeDefinition {
+			return fmt.Errorf("invalid badge ref %q: expected kind %d, got %d", badge, KindBadgeDefinition, badge.Kind)
 		}
 		if err := badge.Validate(); err != nil {
 			return fmt.Errorf("invalid badge ref %q: %w", badge, err)
@@ -81,6 +93,22 @@ func (s ContentSection) Validate() error {
 	return nil
 }
 
+// IsOpen returns whether anyone can publish in the section, because it references no lists nor badges.
+func (s ContentSection) IsOpen() bool {
+	return len(s.Lists) == 0 && len(s.Badges) == 0
+}
+
+// SectionsAccepting returns the content sections of the community that accept the given kind.
+func (c CommunityCreation) SectionsAccepting(kind int) []ContentSection {
+	var sections []ContentSection
+	for _, s := range c.Sections {
+		if slices.Contains(s.Kinds, kind) {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
 func (c CommunityCreation) Validate() error {
 	if len(c.Relays) == 0 {
 		return fmt.Errorf("missing required 'r' tag (at least one relay URL)")
@@ -1,8 +1,18 @@
 package events
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -54,6 +64,64 @@ func TestValidateApp_OnlyUnknownFTags_Rejected(t *testing.T) {
 	}
 }
 
+func TestParseApp_Localizations(t *testing.T) {
+	event := &nostr.Event{
+		Kind:    KindApp,
+		Content: "A private messenger.",
+		Tags: nostr.Tags{
+			{"d", "org.signal"},
+			{"name", "Signal"},
+			{"summary", "Private messenger"},
+			{"f", "android-arm64-v8a"},
+			{"summary", "Privater Messenger", "de"},
+			{"description", "Ein privater Messenger.", "de"},
+			{"name", "Sinal", "pt-BR"},
+		},
+	}
+
+	app, err := ParseApp(event)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if app.Name != "Signal" || app.Summary != "Private messenger" {
+		t.Errorf("expected the default name and summary, got %q and %q", app.Name, app.Summary)
+	}
+
+	de := app.Localize("de")
+	if de.Name != "Signal" || de.Summary != "Privater Messenger" || de.Content != "Ein privater Messenger." {
+		t.Errorf("unexpected german localization: %+v", de)
+	}
+
+	pt := app.Localize("pt-br")
+	if pt.Name != "Sinal" || pt.Summary != "Private messenger" || pt.Content != "A private messenger." {
+		t.Errorf("unexpected portuguese localization: %+v", pt)
+	}
+
+	if fr := app.Localize("fr"); fr != (Localization{Name: app.Name, Summary: app.Summary, Content: app.Content}) {
+		t.Errorf("expected the default language for a missing localization, got %+v", fr)
+	}
+}
+
+func TestParseApp_InvalidLocalizations(t *testing.T) {
+	tests := []struct {
+		name string
+		tags nostr.Tags
+	}{
+		{name: "duplicate localized name", tags: nostr.Tags{{"name", "Signal", "de"}, {"name", "Signal", "DE"}}},
+		{name: "invalid language", tags: nostr.Tags{{"name", "Signal", "german"}}},
+		{name: "invalid subtag", tags: nostr.Tags{{"summary", "Messenger", "de-"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags := append(nostr.Tags{{"d", "org.signal"}, {"name", "Signal"}}, test.tags...)
+			if _, err := ParseApp(&nostr.Event{Kind: KindApp, Tags: tags}); err == nil {
+				t.Fatal("expected parse error, got nil")
+			}
+		})
+	}
+}
+
 func TestParseAsset_UnknownFTagsIgnored(t *testing.T) {
 	event := &nostr.Event{
 		Kind: KindAsset,
@@ -99,6 +167,44 @@ func TestValidateAsset_OnlyUnknownFTags_Rejected(t *testing.T) {
 	}
 }
 
+func TestValidateAsset_VersionCode(t *testing.T) {
+	tests := []struct {
+		versionCode string
+		isValid     bool
+	}{
+		{versionCode: "100", isValid: true},
+		{versionCode: "2147483647", isValid: true},
+		{versionCode: "0", isValid: false},
+		{versionCode: "-5", isValid: false},
+		{versionCode: "1.2", isValid: false},
+		{versionCode: "abc", isValid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.versionCode, func(t *testing.T) {
+			event := &nostr.Event{
+				Kind: KindAsset,
+				Tags: nostr.Tags{
+					{"i", "com.example.app"},
+					{"x", validHash},
+					{"version", "1.0.0"},
+					{"f", "android-arm64-v8a"},
+					{"version_code", test.versionCode},
+					{"apk_certificate_hash", validHash},
+				},
+			}
+
+			err := ValidateAsset(event)
+			if test.isValid && err != nil {
+				t.Errorf("expected valid, got %v", err)
+			}
+			if !test.isValid && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+		})
+	}
+}
+
 func TestParseStack_UnknownFTagsIgnored(t *testing.T) {
 	pk := "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
 	event := &nostr.Event{
@@ -166,6 +272,172 @@ func TestValidateAppSettingsIdentifier(t *testing.T) {
 	}
 }
 
+func TestValidateCertificateRotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		tags  nostr.Tags
+		valid bool
+	}{
+		{
+			name:  "valid",
+			tags:  nostr.Tags{{"i", "com.example.app"}, {"p", validPubkey}, {"apk_certificate_hash", validHash}},
+			valid: true,
+		},
+		{
+			name: "missing i",
+			tags: nostr.Tags{{"p", validPubkey}, {"apk_certificate_hash", validHash}},
+		},
+		{
+			name: "invalid p",
+			tags: nostr.Tags{{"i", "com.example.app"}, {"p", "npub"}, {"apk_certificate_hash", validHash}},
+		},
+		{
+			name: "missing apk_certificate_hash",
+			tags: nostr.Tags{{"i", "com.example.app"}, {"p", validPubkey}},
+		},
+		{
+			name: "invalid apk_certificate_hash",
+			tags: nostr.Tags{{"i", "com.example.app"}, {"p", validPubkey}, {"apk_certificate_hash", "abc"}},
+		},
+		{
+			name: "duplicate i",
+			tags: nostr.Tags{{"i", "com.example.app"}, {"i", "com.other.app"}, {"p", validPubkey}, {"apk_certificate_hash", validHash}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateCertificateRotation(&nostr.Event{Kind: KindCertificateRotation, Tags: test.tags})
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected validation error, got nil")
+			}
+		})
+	}
+}
+
+// testProof returns the hash and the base64 DER of a self-signed certificate, and the base64 signature
+// of the validPubkey made with its key.
+func testProof(t *testing.T) (certHash, certificate, signature string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte(validPubkey))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := sha256.Sum256(der)
+	return hex.EncodeToString(fingerprint[:]), base64.StdEncoding.EncodeToString(der), base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestValidateIdentityProof(t *testing.T) {
+	certHash, certificate, signature := testProof(t)
+	expiry := nostr.Tag{"expiry", "2000000000"}
+
+	tests := []struct {
+		name   string
+		pubkey string
+		tags   nostr.Tags
+		valid  bool
+	}{
+		{
+			name:   "valid with certificate",
+			pubkey: validPubkey,
+			tags:   nostr.Tags{{"d", certHash}, {"signature", signature}, {"certificate", certificate}, expiry},
+			valid:  true,
+		},
+		{
+			name:   "valid without certificate",
+			pubkey: validPubkey,
+			tags:   nostr.Tags{{"d", certHash}, {"signature", "not verified here"}, expiry},
+			valid:  true,
+		},
+		{
+			name:   "invalid certificate",
+			pubkey: validPubkey,
+			tags:   nostr.Tags{{"d", certHash}, {"signature", signature}, {"certificate", "AAAA"}, expiry},
+		},
+		{
+			name:   "missing expiry",
+			pubkey: validPubkey,
+			tags:   nostr.Tags{{"d", certHash}, {"signature", signature}, {"certificate", certificate}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := &nostr.Event{Kind: KindIdentityProof, PubKey: test.pubkey, CreatedAt: 1700000000, Tags: test.tags}
+			err := ValidateIdentityProof(event)
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestVerifyIdentityProof(t *testing.T) {
+	certHash, certificate, signature := testProof(t)
+	tests := []struct {
+		name   string
+		pubkey string
+		proof  IdentityProof
+		valid  bool
+	}{
+		{
+			name:   "valid",
+			pubkey: validPubkey,
+			proof:  IdentityProof{CertHash: certHash, Signature: signature, Certificate: certificate},
+			valid:  true,
+		},
+		{
+			name:   "signature of another pubkey",
+			pubkey: strings.Repeat("b", 64),
+			proof:  IdentityProof{CertHash: certHash, Signature: signature, Certificate: certificate},
+		},
+		{
+			name:   "certificate doesn't match d",
+			pubkey: validPubkey,
+			proof:  IdentityProof{CertHash: validHash, Signature: signature, Certificate: certificate},
+		},
+		{
+			name:   "signature is not base64",
+			pubkey: validPubkey,
+			proof:  IdentityProof{CertHash: certHash, Signature: "not base64!", Certificate: certificate},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cert, err := test.proof.ParseCertificate()
+			if err != nil {
+				t.Fatalf("failed to parse certificate: %v", err)
+			}
+
+			err = test.proof.Verify(test.pubkey, cert)
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.valid && !errors.Is(err, ErrInvalidProof) {
+				t.Fatalf("expected %v, got %v", ErrInvalidProof, err)
+			}
+		})
+	}
+}
+
 // communityEvent is a helper that builds a minimal valid kind-10222 event.
 func communityEvent(extraTags ...nostr.Tag) *nostr.Event {
 	tags := nostr.Tags{
@@ -451,3 +723,42 @@ func TestParseCommunityCreation_MalformedRefsDropped(t *testing.T) {
 		t.Errorf("expected no badges, got %v", c.Sections[0].Badges)
 	}
 }
+
+// TestCommunityCreation_SectionsAccepting checks that sections are resolved by kind,
+// and that sections without lists nor badges are open.
+func TestCommunityCreation_SectionsAccepting(t *testing.T) {
+	event := &nostr.Event{
+		Kind: KindCommunityCreation,
+		Tags: nostr.Tags{
+			{"r", validRelayURL},
+			{"content", "General"},
+			{"k", "1111"},
+			{"content", "Apps"},
+			{"k", "32267"},
+			{"k", "1111"},
+			{"a", "30000:" + validPubkey + ":Apps"},
+		},
+	}
+	c, err := ParseCommunityCreation(event)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+
+	if sections := c.SectionsAccepting(1111); len(sections) != 2 {
+		t.Errorf("expected 2 sections accepting kind 1111, got %d", len(sections))
+	}
+	if sections := c.SectionsAccepting(7); len(sections) != 0 {
+		t.Errorf("expected no sections accepting kind 7, got %d", len(sections))
+	}
+
+	sections := c.SectionsAccepting(32267)
+	if len(sections) != 1 || sections[0].Name != "Apps" {
+		t.Fatalf("expected the Apps section accepting kind 32267, got %v", sections)
+	}
+	if sections[0].IsOpen() {
+		t.Error("expected the Apps section not to be open")
+	}
+	if !c.Sections[0].IsOpen() {
+		t.Error("expected the General section to be open")
+	}
+}
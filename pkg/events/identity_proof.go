@@ -1,6 +1,14 @@
 package events
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"strconv"
 
@@ -19,6 +27,62 @@ type IdentityProof struct {
 
 	// expiry tag: unix timestamp after which the proof is no longer valid
 	Expiry int64
+
+	// certificate tag (optional): base64-encoded DER of the certificate identified by the d tag.
+	// When absent, the certificate is taken from the APKs of the publisher's assets with the same hash.
+	Certificate string
+}
+
+var ErrInvalidProof = errors.New("invalid identity proof")
+
+// ParseCertificate decodes the certificate tag. It returns nil if the tag is absent.
+func (p IdentityProof) ParseCertificate() (*x509.Certificate, error) {
+	if p.Certificate == "" {
+		return nil, nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(p.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'certificate' tag: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'certificate' tag: %w", err)
+	}
+	return cert, nil
+}
+
+// Verify checks that the certificate is the one identified by the d tag, and that the signature
+// is a valid signature of the hex-encoded Nostr pubkey made with the certificate's key.
+// RSA keys sign with PKCS#1 v1.5 and SHA-256, ECDSA keys with SHA-256, and Ed25519 keys directly.
+func (p IdentityProof) Verify(pubkey string, cert *x509.Certificate) error {
+	fingerprint := sha256.Sum256(cert.Raw)
+	if hex.EncodeToString(fingerprint[:]) != p.CertHash {
+		return fmt.Errorf("%w: certificate doesn't match the 'd' tag", ErrInvalidProof)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(p.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: 'signature' tag is not valid base64", ErrInvalidProof)
+	}
+
+	var algorithm x509.SignatureAlgorithm
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		algorithm = x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		algorithm = x509.ECDSAWithSHA256
+	case ed25519.PublicKey:
+		algorithm = x509.PureEd25519
+	default:
+		return fmt.Errorf("%w: unsupported certificate key type %T", ErrInvalidProof, cert.PublicKey)
+	}
+
+	if err := cert.CheckSignature(algorithm, []byte(pubkey), signature); err != nil {
+		return fmt.Errorf("%w: signature doesn't verify against the certificate: %v", ErrInvalidProof, err)
+	}
+	return nil
 }
 
 func (p IdentityProof) Validate(event *nostr.Event) error {
@@ -39,6 +103,10 @@ func (p IdentityProof) Validate(event *nostr.Event) error {
 	if p.Expiry <= event.CreatedAt.Time().Unix() {
 		return fmt.Errorf("'expiry' must be greater than 'created_at'")
 	}
+
+	if _, err := p.ParseCertificate(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -60,6 +128,8 @@ func ParseIdentityProof(event *nostr.Event) (IdentityProof, error) {
 			proof.CertHash = tag[1]
 		case "signature":
 			proof.Signature = tag[1]
+		case "certificate":
+			proof.Certificate = tag[1]
 		case "expiry":
 			expiry, err := strconv.ParseInt(tag[1], 10, 64)
 			if err != nil {
@@ -72,7 +142,7 @@ func ParseIdentityProof(event *nostr.Event) (IdentityProof, error) {
 }
 
 // ValidateIdentityProof parses and validates a kind 30509 event.
-// It checks the event is structurally valid, but doesn't perform signature verification.
+// It checks the event is structurally valid, but doesn't perform signature verification, see [IdentityProof.Verify].
 func ValidateIdentityProof(event *nostr.Event) error {
 	proof, err := ParseIdentityProof(event)
 	if err != nil {
@@ -30,6 +30,7 @@ var WithValidation = []int{
 	KindAppRelays,
 	KindIdentityProof,
 	KindCommunityCreation,
+	KindCertificateRotation,
 }
 
 // Validate validates an event by routing to the appropriate
@@ -60,6 +61,9 @@ func Validate(event *nostr.Event) error {
 	case KindCommunityCreation:
 		return ValidateCommunityCreation(event)
 
+	case KindCertificateRotation:
+		return ValidateCertificateRotation(event)
+
 	default:
 		return nil
 	}
@@ -77,6 +81,38 @@ func ValidateHash(hash string) error {
 	return nil
 }
 
+// ValidateLanguage validates a language code, made of a primary language subtag of 2 or 3 letters (ISO 639)
+// optionally followed by subtags of 2 to 8 letters or digits, e.g. "de", "pt-BR" or "zh-Hant".
+func ValidateLanguage(code string) error {
+	subtags := strings.Split(code, "-")
+	for i, subtag := range subtags {
+		if i == 0 && (len(subtag) < 2 || len(subtag) > 3) {
+			return fmt.Errorf("invalid primary language subtag %q", subtag)
+		}
+		if len(subtag) < 2 || len(subtag) > 8 {
+			return fmt.Errorf("invalid language subtag %q", subtag)
+		}
+
+		for _, r := range subtag {
+			isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+			isDigit := r >= '0' && r <= '9'
+			if !isLetter && (i == 0 || !isDigit) {
+				return fmt.Errorf("invalid character %q in language %q", r, code)
+			}
+		}
+	}
+	return nil
+}
+
+// Language returns the language of a language-qualified tag, which is its third element,
+// e.g. "de" for ["name", "Signal", "de"]. It returns "" for the tags in the default language.
+func Language(tag nostr.Tag) string {
+	if len(tag) > 2 {
+		return tag[2]
+	}
+	return ""
+}
+
 // Find the value of the first tag with the given key.
 func Find(tags nostr.Tags, key string) (string, bool) {
 	for _, tag := range tags {
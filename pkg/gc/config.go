@@ -0,0 +1,49 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Config struct {
+	// Enabled activates the periodic deletion of the unreferenced blobs. When disabled, the reclaimable blobs
+	// are only reported, by the dashboard and the "gc" command. Default is false.
+	Enabled bool `env:"GC_ENABLED"`
+
+	// Interval is the interval between two reports of the reclaimable blobs, and collections if enabled.
+	// Default is 24 hours.
+	Interval time.Duration `env:"GC_INTERVAL"`
+
+	// GracePeriod is the time after its upload during which a blob is never collected, leaving time to publish
+	// the events referencing it. Default is 7 days.
+	GracePeriod time.Duration `env:"GC_GRACE_PERIOD"`
+}
+
+func NewConfig() Config {
+	return Config{
+		Interval:    24 * time.Hour,
+		GracePeriod: 7 * 24 * time.Hour,
+	}
+}
+
+func (c Config) Validate() error {
+	if c.Interval < time.Minute {
+		return errors.New("interval must be at least 1 minute")
+	}
+	if c.GracePeriod < 24*time.Hour {
+		return errors.New("grace period must be at least 24 hours")
+	}
+	return nil
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("GC:\n"+
+		"\tEnabled: %t\n"+
+		"\tInterval: %s\n"+
+		"\tGrace Period: %s\n",
+		c.Enabled,
+		c.Interval,
+		c.GracePeriod,
+	)
+}
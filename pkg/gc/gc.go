@@ -0,0 +1,177 @@
+// The gc package is responsible for deleting the blobs that are no longer referenced by any event,
+// e.g. the blobs of deleted assets or of pending assets that expired, from Bunny and blossom.db.
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/zapstore/relay/pkg/blossom"
+	blossomstore "github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/relay"
+)
+
+// ErrNoReferences is returned when the relay references no blob at all, which is more likely an empty or
+// misconfigured relay.db than a blossom server to wipe out.
+var ErrNoReferences = errors.New("no blob is referenced by the relay")
+
+// Storage is where the blobs are stored, e.g. the Bunny storage zone.
+type Storage interface {
+	// Delete the file at the path. Deleting a missing file is not an error.
+	Delete(ctx context.Context, path string) error
+}
+
+// Report lists the unreferenced blobs, either reclaimable or deleted.
+type Report struct {
+	Blobs []blossomstore.BlobMeta
+	Bytes int64
+}
+
+func (r *Report) add(blob blossomstore.BlobMeta) {
+	r.Blobs = append(r.Blobs, blob)
+	r.Bytes += blob.Size
+}
+
+// Collector deletes the blobs uploaded more than [Config.GracePeriod] ago whose hash is not
+// among the ReferencedHashes of the relay database.
+type Collector struct {
+	config  Config
+	relay   relay.DB
+	blossom blossom.DB
+	storage Storage
+
+	mu         sync.Mutex
+	last       Report
+	lastErr    error
+	reportedAt time.Time
+}
+
+func NewCollector(config Config, relay relay.DB, blossom blossom.DB, storage Storage) *Collector {
+	return &Collector{
+		config:  config,
+		relay:   relay,
+		blossom: blossom,
+		storage: storage,
+	}
+}
+
+// Reclaimable returns the report of the blobs that would be deleted by [Collector.Collect], without deleting them.
+func (c *Collector) Reclaimable(ctx context.Context) (Report, error) {
+	blobs, err := c.blossom.CreatedBefore(ctx, time.Now().Add(-c.config.GracePeriod))
+	if err != nil {
+		return Report{}, err
+	}
+
+	// the references are listed after the blobs, so that the blobs referenced by the events
+	// published in between are kept
+	referenced, err := c.relay.ReferencedHashes(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	if len(referenced) == 0 {
+		return Report{}, ErrNoReferences
+	}
+
+	var report Report
+	for _, blob := range blobs {
+		if _, ok := referenced[blob.Hash.Hex()]; !ok {
+			report.add(blob)
+		}
+	}
+	return report, nil
+}
+
+// Collect deletes the reclaimable blobs from the storage, then their metadata from blossom.db,
+// and returns the report of the deleted blobs. Blobs that fail to be deleted are retried on the next collection.
+// Each blob is checked again against the assets and pending events of the relay right before its deletion,
+// so that the blobs referenced by the assets published or promoted during the collection are kept.
+func (c *Collector) Collect(ctx context.Context) (Report, error) {
+	reclaimable, err := c.Reclaimable(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var deleted Report
+	var errs []error
+	for _, blob := range reclaimable.Blobs {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		referenced, err := c.relay.IsReferenced(ctx, blob.Hash.Hex())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("blob %s: %w", blob.Hash, err))
+			continue
+		}
+		if referenced {
+			continue
+		}
+
+		if err := c.storage.Delete(ctx, blossom.BlobPath(blob.Hash, blob.Type)); err != nil {
+			errs = append(errs, fmt.Errorf("blob %s: %w", blob.Hash, err))
+			continue
+		}
+		if err := c.blossom.Delete(ctx, blob.Hash); err != nil {
+			errs = append(errs, fmt.Errorf("blob %s: %w", blob.Hash, err))
+			continue
+		}
+		deleted.add(blob)
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// LastReport returns the reclaimable blobs found by the last run of [Collector.Run] and when it happened,
+// or the error of that run. It's zero until the first run completes.
+func (c *Collector) LastReport() (report Report, reportedAt time.Time, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last, c.reportedAt, c.lastErr
+}
+
+// Run reports the reclaimable blobs at startup and then every [Config.Interval], until the context is cancelled.
+// If the collection is [Config.Enabled], the reclaimable blobs are deleted before each periodic report.
+func (c *Collector) Run(ctx context.Context) {
+	c.report(ctx)
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if c.config.Enabled {
+				c.collect(ctx)
+			}
+			c.report(ctx)
+		}
+	}
+}
+
+func (c *Collector) report(ctx context.Context) {
+	report, err := c.Reclaimable(ctx)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = report
+	c.lastErr = err
+	c.reportedAt = time.Now()
+}
+
+func (c *Collector) collect(ctx context.Context) {
+	deleted, err := c.Collect(ctx)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("gc: failed to collect the unreferenced blobs", "error", err)
+	}
+	if len(deleted.Blobs) > 0 {
+		slog.Info("gc: deleted the unreferenced blobs", "count", len(deleted.Blobs), "bytes", deleted.Bytes)
+	}
+}
@@ -0,0 +1,190 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	blossomstore "github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/events"
+	relaystore "github.com/zapstore/relay/pkg/relay/store"
+)
+
+var ctx = context.Background()
+
+// storage records the deleted paths, failing for the ones in fail. It calls onDelete after each deletion.
+type storage struct {
+	deleted  []string
+	fail     map[string]bool
+	onDelete func()
+}
+
+func (s *storage) Delete(ctx context.Context, path string) error {
+	if s.fail[path] {
+		return errors.New("storage unavailable")
+	}
+	s.deleted = append(s.deleted, path)
+	if s.onDelete != nil {
+		s.onDelete()
+	}
+	return nil
+}
+
+func TestCollector(t *testing.T) {
+	relayDB, err := relaystore.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create relay store: %v", err)
+	}
+	defer relayDB.Close()
+
+	blossomDB, err := blossomstore.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create blossom store: %v", err)
+	}
+	defer blossomDB.Close()
+
+	config := NewConfig()
+	storage := &storage{}
+	collector := NewCollector(config, relayDB, blossomDB, storage)
+
+	if _, err := collector.Reclaimable(ctx); !errors.Is(err, ErrNoReferences) {
+		t.Fatalf("expected %v with an empty relay, got %v", ErrNoReferences, err)
+	}
+
+	old := time.Now().UTC().Add(-config.GracePeriod - time.Hour).Truncate(time.Second)
+	referenced := blossomstore.BlobMeta{Hash: blossom.ComputeHash([]byte("apk")), Type: "application/vnd.android.package-archive", Size: 100, CreatedAt: old}
+	orphan := blossomstore.BlobMeta{Hash: blossom.ComputeHash([]byte("orphan")), Type: "image/png", Size: 10, CreatedAt: old}
+	recent := blossomstore.BlobMeta{Hash: blossom.ComputeHash([]byte("recent")), Type: "image/png", Size: 1, CreatedAt: time.Now().UTC()}
+
+	for _, b := range []blossomstore.BlobMeta{referenced, orphan, recent} {
+		if _, err := blossomDB.Save(ctx, b); err != nil {
+			t.Fatalf("failed to save blob: %v", err)
+		}
+	}
+
+	asset := &nostr.Event{ID: "asset", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindAsset, Tags: nostr.Tags{{"x", referenced.Hash.Hex()}}}
+	if _, err := relayDB.Save(ctx, asset); err != nil {
+		t.Fatalf("failed to save asset: %v", err)
+	}
+
+	report, err := collector.Reclaimable(ctx)
+	if err != nil {
+		t.Fatalf("Reclaimable: %v", err)
+	}
+	expected := Report{Blobs: []blossomstore.BlobMeta{orphan}, Bytes: orphan.Size}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("expected the report %v, got %v", expected, report)
+	}
+	if len(storage.deleted) > 0 {
+		t.Fatalf("expected the report to delete nothing, got %v", storage.deleted)
+	}
+
+	orphanPath := "blobs/" + orphan.Hash.Hex() + ".png"
+	storage.fail = map[string]bool{orphanPath: true}
+	if _, err := collector.Collect(ctx); err == nil {
+		t.Fatal("expected an error when the storage fails")
+	}
+	if has, _ := blossomDB.Has(ctx, orphan.Hash); !has {
+		t.Fatal("expected the blob metadata to be kept when the storage fails")
+	}
+
+	storage.fail = nil
+	deleted, err := collector.Collect(ctx)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected to delete %v, got %v", expected, deleted)
+	}
+	if !reflect.DeepEqual(storage.deleted, []string{orphanPath}) {
+		t.Errorf("expected the storage to delete [%s], got %v", orphanPath, storage.deleted)
+	}
+
+	for _, b := range []blossomstore.BlobMeta{referenced, orphan, recent} {
+		has, err := blossomDB.Has(ctx, b.Hash)
+		if err != nil {
+			t.Fatalf("Has: %v", err)
+		}
+		if want := b.Hash != orphan.Hash; has != want {
+			t.Errorf("blob %s: expected has=%t, got %t", b.Hash, want, has)
+		}
+	}
+}
+
+func TestCollectorRecheck(t *testing.T) {
+	relayDB, err := relaystore.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create relay store: %v", err)
+	}
+	defer relayDB.Close()
+
+	blossomDB, err := blossomstore.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create blossom store: %v", err)
+	}
+	defer blossomDB.Close()
+
+	config := NewConfig()
+	storage := &storage{}
+	collector := NewCollector(config, relayDB, blossomDB, storage)
+
+	old := time.Now().UTC().Add(-config.GracePeriod - time.Hour).Truncate(time.Second)
+	first := blossomstore.BlobMeta{Hash: blossom.ComputeHash([]byte("first")), Type: "image/png", Size: 10, CreatedAt: old}
+	second := blossomstore.BlobMeta{Hash: blossom.ComputeHash([]byte("second")), Type: "image/png", Size: 10, CreatedAt: old}
+	for _, b := range []blossomstore.BlobMeta{first, second} {
+		if _, err := blossomDB.Save(ctx, b); err != nil {
+			t.Fatalf("failed to save blob: %v", err)
+		}
+	}
+
+	// the relay must reference something for the collection to run
+	other := &nostr.Event{ID: "other", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindAsset, Tags: nostr.Tags{{"x", blossom.ComputeHash([]byte("other")).Hex()}}}
+	if _, err := relayDB.Save(ctx, other); err != nil {
+		t.Fatalf("failed to save asset: %v", err)
+	}
+
+	if _, _, err := collector.LastReport(); err != nil {
+		t.Fatalf("expected no report before the first run, got %v", err)
+	}
+
+	// both blobs are reclaimable when the collection starts, but an asset referencing
+	// both is published right after the first deletion, so the second one must be kept
+	storage.onDelete = func() {
+		asset := &nostr.Event{ID: "asset", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindAsset,
+			Tags: nostr.Tags{{"x", first.Hash.Hex()}, {"x", second.Hash.Hex()}}}
+		if _, err := relayDB.Save(ctx, asset); err != nil {
+			t.Errorf("failed to save asset: %v", err)
+		}
+		storage.onDelete = nil
+	}
+
+	deleted, err := collector.Collect(ctx)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(deleted.Blobs) != 1 || len(storage.deleted) != 1 {
+		t.Fatalf("expected only the first blob to be deleted, got %v", deleted.Blobs)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go collector.Run(ctx)
+
+	for {
+		report, reportedAt, err := collector.LastReport()
+		if err != nil {
+			t.Fatalf("LastReport: %v", err)
+		}
+		if !reportedAt.IsZero() {
+			if len(report.Blobs) != 0 {
+				t.Errorf("expected no reclaimable blobs, got %v", report.Blobs)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,25 +1,28 @@
 // The rate is a wrapper around the [github.com/pippellia-btc/rate] package,
-// exposing a [Config] struct for configuring the limiter,
-// and a [NewLimiter] function to create a new ip-rate limiter.
+// exposing a [Config] struct for configuring the buckets of a tier, a [TiersConfig] struct for configuring all tiers,
+// and a [NewLimiter] function to create a new ip and pubkey rate limiter.
 package rate
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
+// Config is the configuration of the buckets of a tier.
+// Its env variables are prefixed with the one of the tier, see [TiersConfig].
 type Config struct {
 	// InitialTokens is the initial number of tokens for a new bucket. Default is 100.
-	InitialTokens int `env:"RATE_INITIAL_TOKENS"`
+	InitialTokens int `env:"INITIAL_TOKENS"`
 
 	// MaxTokens is the maximum number of tokens for a bucket. Default is 300.
-	MaxTokens int `env:"RATE_MAX_TOKENS"`
+	MaxTokens int `env:"MAX_TOKENS"`
 
 	// TokensPerInterval is the number of tokens added to a bucket per interval. Default is 100.
-	TokensPerInterval int `env:"RATE_TOKENS_PER_INTERVAL"`
+	TokensPerInterval int `env:"TOKENS_PER_INTERVAL"`
 
 	// Interval is the duration of the interval. Default is 1 minute.
-	Interval time.Duration `env:"RATE_INTERVAL"`
+	Interval time.Duration `env:"INTERVAL"`
 }
 
 func NewConfig() Config {
@@ -54,10 +57,84 @@ func (c Config) Validate() error {
 }
 
 func (c Config) String() string {
-	return fmt.Sprintf("Rate Limiter:\n"+
-		"\tInitial Tokens: %d\n"+
+	return fmt.Sprintf("\tInitial Tokens: %d\n"+
 		"\tMax Tokens: %d\n"+
 		"\tTokens Per Interval: %d\n"+
-		"\tInterval: %v",
+		"\tInterval: %v\n",
 		c.InitialTokens, c.MaxTokens, c.TokensPerInterval, c.Interval)
 }
+
+// TiersConfig is the configuration of the buckets of each [Tier].
+// Anonymous clients are limited by IP, while authenticated ones are limited by pubkey.
+type TiersConfig struct {
+	// Anonymous is the configuration of the IP buckets. Defaults are the ones of [NewConfig].
+	Anonymous Config `envPrefix:"RATE_"`
+
+	// Authenticated is the configuration of the buckets of the pubkeys authenticated with NIP-42 or NIP-98.
+	// Pubkeys are free to generate, so a new bucket has fewer tokens than an IP bucket, but it refills faster.
+	// Defaults are 50 initial tokens, 600 max tokens and 200 tokens per minute.
+	Authenticated Config `envPrefix:"RATE_AUTHENTICATED_"`
+
+	// Publisher is the configuration of the buckets of the pubkeys allowed by the defender.
+	// Defaults are 300 initial tokens, 1500 max tokens and 500 tokens per minute.
+	Publisher Config `envPrefix:"RATE_PUBLISHER_"`
+
+	// Indexer is the configuration of the bucket of the indexer pubkey, which publishes on behalf of many developers.
+	// Defaults are 1000 initial tokens, 10000 max tokens and 5000 tokens per minute.
+	Indexer Config `envPrefix:"RATE_INDEXER_"`
+}
+
+func NewTiersConfig() TiersConfig {
+	return TiersConfig{
+		Anonymous: NewConfig(),
+		Authenticated: Config{
+			InitialTokens:     50,
+			MaxTokens:         600,
+			TokensPerInterval: 200,
+			Interval:          time.Minute,
+		},
+		Publisher: Config{
+			InitialTokens:     300,
+			MaxTokens:         1500,
+			TokensPerInterval: 500,
+			Interval:          time.Minute,
+		},
+		Indexer: Config{
+			InitialTokens:     1000,
+			MaxTokens:         10_000,
+			TokensPerInterval: 5000,
+			Interval:          time.Minute,
+		},
+	}
+}
+
+func (c TiersConfig) Validate() error {
+	for _, tier := range Tiers {
+		if err := c.Of(tier).Validate(); err != nil {
+			return fmt.Errorf("%s: %w", tier, err)
+		}
+	}
+	return nil
+}
+
+// Of returns the configuration of the tier.
+func (c TiersConfig) Of(tier Tier) Config {
+	switch tier {
+	case Authenticated:
+		return c.Authenticated
+	case Publisher:
+		return c.Publisher
+	case Indexer:
+		return c.Indexer
+	default:
+		return c.Anonymous
+	}
+}
+
+func (c TiersConfig) String() string {
+	blocks := make([]string, len(Tiers))
+	for i, tier := range Tiers {
+		blocks[i] = fmt.Sprintf("Rate Limiter (%s):\n", tier) + c.Of(tier).String()
+	}
+	return strings.TrimSuffix(strings.Join(blocks, ""), "\n")
+}
@@ -3,33 +3,155 @@
 package rate
 
 import (
+	"sync"
 	"time"
 
 	"github.com/pippellia-btc/rate"
 )
 
-// Limiter is a wrapper around the [rate.Limiter] that adds a [Config] to the limiter.
+// Tier determines the buckets that limit a client, each configured by its own [Config].
+type Tier int
+
+const (
+	Anonymous     Tier = iota // clients that are not authenticated, limited by IP
+	Authenticated             // pubkeys authenticated with NIP-42 or NIP-98
+	Publisher                 // authenticated pubkeys allowed by the defender
+	Indexer                   // the authenticated indexer pubkey
+)
+
+// Tiers are all the tiers, from the most to the least restricted.
+var Tiers = []Tier{Anonymous, Authenticated, Publisher, Indexer}
+
+func (t Tier) String() string {
+	switch t {
+	case Anonymous:
+		return "anonymous"
+	case Authenticated:
+		return "authenticated"
+	case Publisher:
+		return "publisher"
+	case Indexer:
+		return "indexer"
+	default:
+		return "unknown"
+	}
+}
+
+// Limiter is a wrapper around the [rate.Limiter] of the IPs that adds a [Config] to the limiter,
+// and the buckets of the authenticated pubkeys, whose refill depends on their [Tier].
 type Limiter struct {
 	*rate.Limiter[string]
 	config Config
+
+	pubkeys map[Tier]*rate.Limiter[string]
+	tiers   *tiers
 }
 
-// NewLimiter creates a new rate limiter with a [rate.FlatRefiller] from the given config.
-func NewLimiter(c Config) Limiter {
-	refiller := rate.FlatRefiller[string]{
+// tiers are the pubkeys with a tier above [Authenticated].
+type tiers struct {
+	mu      sync.RWMutex
+	pubkeys map[string]Tier
+}
+
+// NewLimiter creates a new rate limiter with a [rate.FlatRefiller] per tier from the given config.
+func NewLimiter(c TiersConfig) Limiter {
+	pubkeys := make(map[Tier]*rate.Limiter[string], len(Tiers)-1)
+	for _, tier := range Tiers[1:] {
+		pubkeys[tier] = rate.NewLimiter(refiller(c.Of(tier)))
+	}
+
+	return Limiter{
+		Limiter: rate.NewLimiter(refiller(c.Anonymous)),
+		config:  c.Anonymous,
+		pubkeys: pubkeys,
+		tiers:   &tiers{pubkeys: make(map[string]Tier)},
+	}
+}
+
+func refiller(c Config) rate.FlatRefiller[string] {
+	return rate.FlatRefiller[string]{
 		InitialTokens:     float64(c.InitialTokens),
 		MaxTokens:         float64(c.MaxTokens),
 		TokensPerInterval: float64(c.TokensPerInterval),
 		Interval:          c.Interval,
 	}
-
-	return Limiter{
-		Limiter: rate.NewLimiter(refiller),
-		config:  c,
-	}
 }
 
 func (l Limiter) InitialTokens() float64     { return float64(l.config.InitialTokens) }
 func (l Limiter) MaxTokens() float64         { return float64(l.config.MaxTokens) }
 func (l Limiter) TokensPerInterval() float64 { return float64(l.config.TokensPerInterval) }
 func (l Limiter) Interval() time.Duration    { return l.config.Interval }
+
+// SetTier sets the tier of the pubkey. Setting [Authenticated] or [Anonymous] drops any previous tier.
+func (l Limiter) SetTier(pubkey string, tier Tier) {
+	l.tiers.mu.Lock()
+	defer l.tiers.mu.Unlock()
+
+	if tier <= Authenticated {
+		delete(l.tiers.pubkeys, pubkey)
+		return
+	}
+	l.tiers.pubkeys[pubkey] = tier
+}
+
+// SetPublishers replaces the pubkeys of the [Publisher] tier with the given ones. Indexers are left untouched.
+func (l Limiter) SetPublishers(pubkeys []string) {
+	l.tiers.mu.Lock()
+	defer l.tiers.mu.Unlock()
+
+	for pk, tier := range l.tiers.pubkeys {
+		if tier == Publisher {
+			delete(l.tiers.pubkeys, pk)
+		}
+	}
+	for _, pk := range pubkeys {
+		if _, ok := l.tiers.pubkeys[pk]; !ok {
+			l.tiers.pubkeys[pk] = Publisher
+		}
+	}
+}
+
+// TierOf returns the tier of the authenticated pubkey.
+func (l Limiter) TierOf(pubkey string) Tier {
+	l.tiers.mu.RLock()
+	defer l.tiers.mu.RUnlock()
+
+	if tier, ok := l.tiers.pubkeys[pubkey]; ok {
+		return tier
+	}
+	return Authenticated
+}
+
+// AllowAuthed charges the cost to the bucket of the authenticated pubkey with the highest tier,
+// or to the bucket of the IP group if there are no pubkeys. Empty pubkeys are ignored.
+// Pubkeys of the [Authenticated] tier are charged together with their IP group, as anyone can generate new ones,
+// while the [Publisher] and [Indexer] tiers, granted by the operator, are exempted from the IP bucket.
+// It returns the key of the bucket that denied the request, or of the last charged bucket, for logging.
+func (l Limiter) AllowAuthed(ip string, pubkeys []string, cost float64) (bool, string) {
+	var pubkey string
+	var tier Tier
+	for _, pk := range pubkeys {
+		if pk == "" {
+			continue
+		}
+		if t := l.TierOf(pk); t > tier {
+			pubkey, tier = pk, t
+		}
+	}
+
+	if pubkey == "" {
+		return l.Allow(ip, cost), ip
+	}
+	if tier > Authenticated {
+		return l.pubkeys[tier].Allow(pubkey, cost), pubkey
+	}
+
+	if !l.Allow(ip, cost) {
+		return false, ip
+	}
+	if !l.pubkeys[tier].Allow(pubkey, cost) {
+		l.Reward(ip, cost) // refund the IP, as the request is denied
+		return false, pubkey
+	}
+	return true, pubkey
+}
@@ -0,0 +1,93 @@
+package rate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAllowAuthed(t *testing.T) {
+	bucket := func(tokens int) Config {
+		return Config{InitialTokens: tokens, MaxTokens: tokens, TokensPerInterval: tokens, Interval: time.Hour}
+	}
+
+	limiter := NewLimiter(TiersConfig{
+		Anonymous:     bucket(3),
+		Authenticated: bucket(2),
+		Publisher:     bucket(5),
+		Indexer:       bucket(6),
+	})
+	limiter.SetTier("indexer", Indexer)
+	limiter.SetPublishers([]string{"publisher", "indexer"})
+
+	tests := []struct {
+		name    string
+		ip      string
+		pubkeys []string
+		key     string // the key of the bucket that denies the request
+		allowed int
+	}{
+		{name: "anonymous", ip: "1.1.1.1", pubkeys: nil, key: "1.1.1.1", allowed: 3},
+		{name: "empty pubkey", ip: "1.1.1.1", pubkeys: []string{""}, key: "1.1.1.1", allowed: 0},
+		{name: "authenticated", ip: "2.2.2.2", pubkeys: []string{"alice"}, key: "alice", allowed: 2},
+		{name: "authenticated, exhausted IP", ip: "2.2.2.2", pubkeys: []string{"carol"}, key: "2.2.2.2", allowed: 1},
+		{name: "publisher", ip: "2.2.2.2", pubkeys: []string{"bob", "publisher"}, key: "publisher", allowed: 5},
+		{name: "indexer", ip: "2.2.2.2", pubkeys: []string{"publisher", "indexer"}, key: "indexer", allowed: 6},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allowed := 0
+			for {
+				ok, key := limiter.AllowAuthed(test.ip, test.pubkeys, 1)
+				if !ok {
+					if key != test.key {
+						t.Fatalf("expected key %q, got %q", test.key, key)
+					}
+					break
+				}
+				allowed++
+			}
+
+			if allowed != test.allowed {
+				t.Errorf("expected %d allowed requests, got %d", test.allowed, allowed)
+			}
+		})
+	}
+
+	limiter.SetPublishers(nil)
+	if tier := limiter.TierOf("publisher"); tier != Authenticated {
+		t.Errorf("expected the dropped publisher to be authenticated, got %s", tier)
+	}
+	if tier := limiter.TierOf("indexer"); tier != Indexer {
+		t.Errorf("expected the indexer to keep its tier, got %s", tier)
+	}
+}
+
+func TestAllowAuthedRotatingPubkeys(t *testing.T) {
+	bucket := func(tokens int) Config {
+		return Config{InitialTokens: tokens, MaxTokens: tokens, TokensPerInterval: tokens, Interval: time.Hour}
+	}
+
+	limiter := NewLimiter(TiersConfig{
+		Anonymous:     bucket(5),
+		Authenticated: bucket(100),
+		Publisher:     bucket(100),
+		Indexer:       bucket(100),
+	})
+
+	// a fresh pubkey per request doesn't get around the bucket of the IP
+	allowed := 0
+	for i := range 50 {
+		if ok, _ := limiter.AllowAuthed("1.2.3.4", []string{fmt.Sprintf("throwaway-%d", i)}, 1); ok {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("expected the 5 tokens of the IP to be allowed, got %d", allowed)
+	}
+
+	if ok, _ := limiter.AllowAuthed("5.6.7.8", []string{"throwaway-0"}, 1); !ok {
+		t.Error("expected another IP to be allowed")
+	}
+}
@@ -0,0 +1,107 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// statisticsInterval is the interval at which the statistics used to estimate the cost of the filters are refreshed.
+const statisticsInterval = 10 * time.Minute
+
+// costPerToken is the estimated cost of the filters of a REQ or COUNT that is charged one rate limiter token.
+const costPerToken = 1000
+
+// admission estimates the cost of the filters from the cached [store.Statistics] of the stored events.
+// Until the statistics are loaded, every filter costs the minimum.
+type admission struct {
+	responseLimit int
+
+	mu    sync.RWMutex
+	stats store.Statistics
+}
+
+// newAdmission returns an admission for clients whose REQs are capped at the responseLimit, see [Config.ResponseLimit].
+func newAdmission(responseLimit int) *admission {
+	return &admission{responseLimit: responseLimit}
+}
+
+// Update replaces the cached statistics.
+func (a *admission) Update(stats store.Statistics) {
+	a.mu.Lock()
+	a.stats = stats
+	a.mu.Unlock()
+}
+
+// Cost returns the estimated cost of the filter in a REQ, see [store.Statistics.Cost].
+func (a *admission) Cost(filter nostr.Filter) float64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.stats.Cost(filter, a.responseLimit)
+}
+
+// CountCost returns the estimated cost of the filter in a COUNT, which reads all the events it matches,
+// see [store.Statistics.Rows].
+func (a *admission) CountCost(filter nostr.Filter) float64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.stats.Rows(filter)
+}
+
+// tokens returns the rate limiter tokens charged for the filters, proportional to their total estimated cost.
+// Any REQ or COUNT costs at least one token.
+func tokens(filters nostr.Filters, cost func(nostr.Filter) float64) float64 {
+	var total float64
+	for _, f := range filters {
+		total += cost(f)
+	}
+	return max(1, total/costPerToken)
+}
+
+// runStatistics refreshes the statistics of the admission periodically.
+// The first statistics are loaded by [Setup], so that filters are admitted correctly from the start.
+func (r *T) runStatistics(ctx context.Context) {
+	ticker := time.NewTicker(statisticsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := refreshStatistics(ctx, r.store, r.admission); err != nil {
+				slog.Error("relay: failed to refresh statistics", "error", err)
+			}
+		}
+	}
+}
+
+func refreshStatistics(ctx context.Context, db store.T, admission *admission) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	stats, err := db.Statistics(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to compute statistics: %w", err)
+	}
+	admission.Update(stats)
+	return nil
+}
+
+// ExpensiveFilters rejects the filters whose estimated cost is above the budget, e.g. [admission.Cost].
+func ExpensiveFilters(cost func(nostr.Filter) float64, budget float64) func(rely.Client, string, nostr.Filters) error {
+	return func(_ rely.Client, _ string, filters nostr.Filters) error {
+		for _, f := range filters {
+			if cost(f) > budget {
+				return ErrFilterTooExpensive
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/rate"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// client is a [rely.Client] with an IP and authenticated pubkeys, which records the messages sent to it.
+type client struct {
+	rely.Client
+	ip           string
+	pubkeys      []string
+	disconnected bool
+	challenged   bool
+	sent         [][]byte
+}
+
+func (c *client) IP() rely.IP            { return rely.IP{Raw: net.ParseIP(c.ip)} }
+func (c *client) Pubkeys() []string      { return c.pubkeys }
+func (c *client) IsAuthed() bool         { return len(c.pubkeys) > 0 }
+func (c *client) Disconnect()            { c.disconnected = true }
+func (c *client) UID() string            { return c.ip }
+func (c *client) ConnectedAt() time.Time { return time.Time{} }
+func (c *client) SendMessage(msg []byte) { c.sent = append(c.sent, msg) }
+func (c *client) SendAuth()              { c.challenged = true }
+
+func testAdmission() *admission {
+	admission := newAdmission(NewConfig().ResponseLimit)
+	admission.Update(store.Statistics{
+		Events:  200_000,
+		Authors: 1000,
+		Kinds:   map[int]int{events.KindApp: 50_000, events.KindRelease: 150_000},
+		Tags:    map[string]store.TagStatistics{"d": {Rows: 50_000, Values: 50_000}},
+		Oldest:  1700000000,
+		Newest:  1800000000,
+	})
+	return admission
+}
+
+func TestExpensiveFilters(t *testing.T) {
+	admission := testAdmission()
+	reject := ExpensiveFilters(admission.Cost, 10_000)
+	rejectCount := ExpensiveFilters(admission.CountCost, 10_000)
+
+	tests := []struct {
+		name        string
+		filters     nostr.Filters
+		rejected    bool
+		countReject bool
+	}{
+		{
+			name:        "feed of releases",
+			filters:     nostr.Filters{{Kinds: []int{events.KindRelease}, Limit: 20}},
+			rejected:    false,
+			countReject: true,
+		},
+		{
+			name:        "feed of apps",
+			filters:     nostr.Filters{{Kinds: []int{events.KindApp}, Limit: 50}},
+			rejected:    false,
+			countReject: true,
+		},
+		{
+			name:        "all releases",
+			filters:     nostr.Filters{{Kinds: []int{events.KindRelease}, Limit: 100_000}},
+			rejected:    true,
+			countReject: true,
+		},
+		{
+			name:        "search ranks all apps",
+			filters:     nostr.Filters{{Kinds: []int{events.KindApp}, Search: "notes", Limit: 20}},
+			rejected:    false,
+			countReject: false,
+		},
+		{
+			name:        "search on releases",
+			filters:     nostr.Filters{{Kinds: []int{events.KindRelease}, Search: "notes", Limit: 20}},
+			rejected:    false,
+			countReject: false,
+		},
+		{
+			name:        "apps of an author",
+			filters:     nostr.Filters{{Kinds: []int{events.KindApp}, Authors: []string{"alice"}, Limit: 1000}},
+			rejected:    false,
+			countReject: false,
+		},
+		{
+			name:        "all releases, no limit",
+			filters:     nostr.Filters{{Kinds: []int{events.KindRelease}}},
+			rejected:    false,
+			countReject: true,
+		},
+		{
+			name:        "unknown tag doesn't make it free",
+			filters:     nostr.Filters{{Kinds: []int{events.KindRelease}, Tags: nostr.TagMap{"z": {"value"}}, Limit: 100_000}},
+			rejected:    true,
+			countReject: true,
+		},
+		{
+			name:        "one expensive filter among cheap ones",
+			filters:     nostr.Filters{{Kinds: []int{events.KindApp}, Limit: 10}, {Limit: 50_000}},
+			rejected:    true,
+			countReject: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := reject(nil, "sub", test.filters)
+			if rejected := errors.Is(err, ErrFilterTooExpensive); rejected != test.rejected {
+				t.Errorf("REQ: expected rejected %t, got %v", test.rejected, err)
+			}
+
+			err = rejectCount(nil, "sub", test.filters)
+			if rejected := errors.Is(err, ErrFilterTooExpensive); rejected != test.countReject {
+				t.Errorf("COUNT: expected rejected %t, got %v", test.countReject, err)
+			}
+		})
+	}
+}
+
+func TestRateReqIP(t *testing.T) {
+	bucket := func(tokens int) rate.Config {
+		return rate.Config{InitialTokens: tokens, MaxTokens: tokens, TokensPerInterval: tokens, Interval: time.Hour}
+	}
+
+	limiter := rate.NewLimiter(rate.TiersConfig{
+		Anonymous:     bucket(10),
+		Authenticated: bucket(20),
+		Publisher:     bucket(100),
+		Indexer:       bucket(1000),
+	})
+	limiter.SetTier("indexer", rate.Indexer)
+	limiter.SetPublishers([]string{"publisher"})
+
+	admission := testAdmission()
+	rateReq := RateReqIP(limiter, admission.Cost)
+
+	cheap := nostr.Filters{{Kinds: []int{events.KindApp}, Limit: 50}}            // 1 token, the minimum
+	expensive := nostr.Filters{{Kinds: []int{events.KindRelease}, Limit: 5_000}} // 5 tokens
+
+	tests := []struct {
+		name    string
+		client  *client
+		filters nostr.Filters
+		allowed int
+	}{
+		{name: "anonymous, cheap", client: &client{ip: "1.1.1.1"}, filters: cheap, allowed: 10},
+		{name: "anonymous, expensive", client: &client{ip: "2.2.2.2"}, filters: expensive, allowed: 2},
+		{name: "authenticated, limited by the IP", client: &client{ip: "3.3.3.3", pubkeys: []string{"alice"}}, filters: cheap, allowed: 10},
+		{name: "publisher", client: &client{ip: "4.4.4.4", pubkeys: []string{"publisher"}}, filters: expensive, allowed: 20},
+		{name: "indexer", client: &client{ip: "5.5.5.5", pubkeys: []string{"indexer"}}, filters: expensive, allowed: 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allowed := 0
+			for rateReq(test.client, "sub", test.filters) == nil {
+				allowed++
+			}
+
+			if allowed != test.allowed {
+				t.Errorf("expected %d allowed requests, got %d", test.allowed, allowed)
+			}
+			if !test.client.disconnected {
+				t.Error("expected the rate limited client to be disconnected")
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// maxMemberships is the maximum number of membership sets cached before the cache is reset.
+const maxMemberships = 10_000
+
+// memberships caches the pubkeys in the profile lists (kind 30000) and the holders of the badges
+// (kind 30009) that grant publish rights in community sections, keyed by their address.
+// Sets are invalidated when the lists and badge awards change, see [memberships.Invalidate].
+type memberships struct {
+	mu   sync.RWMutex
+	sets map[string]map[string]struct{}
+
+	// generation is incremented on every invalidation, so that sets loaded
+	// concurrently with an invalidation are not cached.
+	generation uint64
+}
+
+func newMemberships() *memberships {
+	return &memberships{sets: make(map[string]map[string]struct{})}
+}
+
+// contains returns whether the pubkey is in the set of the given address, loading the set with load if not cached.
+func (m *memberships) contains(ref events.AddressableRef, pubkey string, load func() ([]string, error)) (bool, error) {
+	key := ref.String()
+
+	m.mu.RLock()
+	set, ok := m.sets[key]
+	generation := m.generation
+	m.mu.RUnlock()
+
+	if !ok {
+		pubkeys, err := load()
+		if err != nil {
+			return false, err
+		}
+
+		set = make(map[string]struct{}, len(pubkeys))
+		for _, pk := range pubkeys {
+			set[pk] = struct{}{}
+		}
+
+		m.mu.Lock()
+		if m.generation == generation {
+			if len(m.sets) >= maxMemberships {
+				clear(m.sets)
+			}
+			m.sets[key] = set
+		}
+		m.mu.Unlock()
+	}
+
+	_, member := set[pubkey]
+	return member, nil
+}
+
+// Invalidate drops the membership sets that the event may change: the profile list itself,
+// or the badges referenced by a badge award. Deletions may remove any list or award, so they reset the cache.
+func (m *memberships) Invalidate(event *nostr.Event) {
+	switch event.Kind {
+	case events.KindProfileList:
+		ref := events.AddressableRef{Kind: events.KindProfileList, Pubkey: event.PubKey, DTag: event.Tags.GetD()}
+		m.mu.Lock()
+		delete(m.sets, ref.String())
+		m.generation++
+		m.mu.Unlock()
+
+	case events.KindBadgeAward:
+		m.mu.Lock()
+		for _, a := range events.FindAll(event.Tags, "a") {
+			if strings.HasPrefix(a, "30009:") {
+				delete(m.sets, a)
+			}
+		}
+		m.generation++
+		m.mu.Unlock()
+
+	case nostr.KindDeletion:
+		m.Reset()
+	}
+}
+
+// Reset drops all the membership sets.
+func (m *memberships) Reset() {
+	m.mu.Lock()
+	clear(m.sets)
+	m.generation++
+	m.mu.Unlock()
+}
+
+// CommunityRights rejects events targeting a community (with an 'h' tag of the community pubkey) whose author
+// has no publish rights in it. The content sections of the community (kind 10222) accepting the event kind
+// are resolved, and the author must be in one of their profile lists (kind 30000), or hold one of their badges
+// (kind 30009, awarded with a kind 8). Sections without lists nor badges are open to anyone, and the community
+// pubkey can always publish. Communities unknown to the relay are not enforced.
+func CommunityRights(db store.T, memberships *memberships) func(_ rely.Client, e *nostr.Event) error {
+	return func(_ rely.Client, e *nostr.Event) error {
+		if e.Kind == events.KindCommunityCreation {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		for _, h := range events.FindAll(e.Tags, "h") {
+			if h == e.PubKey || !nostr.IsValidPublicKey(h) {
+				continue
+			}
+
+			communities, err := db.Query(ctx, nostr.Filter{Kinds: []int{events.KindCommunityCreation}, Authors: []string{h}, Limit: 1})
+			if err != nil {
+				slog.Error("CommunityRights: failed to query community", "error", err, "event", e.ID, "community", h)
+				return ErrInternal
+			}
+			if len(communities) == 0 {
+				continue
+			}
+
+			community, err := events.ParseCommunityCreation(&communities[0])
+			if err != nil {
+				slog.Error("CommunityRights: failed to parse community", "error", err, "community", h)
+				return ErrInternal
+			}
+
+			sections := community.SectionsAccepting(e.Kind)
+			if len(sections) == 0 {
+				return ErrCommunityKindNotAllowed
+			}
+
+			allowed, err := canPublish(ctx, db, memberships, sections, e.PubKey)
+			if err != nil {
+				slog.Error("CommunityRights: failed to check publish rights", "error", err, "event", e.ID, "community", h)
+				return ErrInternal
+			}
+			if !allowed {
+				return ErrCommunityNotMember
+			}
+		}
+		return nil
+	}
+}
+
+// canPublish returns whether the pubkey can publish in any of the sections.
+func canPublish(ctx context.Context, db store.T, memberships *memberships, sections []events.ContentSection, pubkey string) (bool, error) {
+	if slices.ContainsFunc(sections, events.ContentSection.IsOpen) {
+		return true, nil
+	}
+
+	for _, section := range sections {
+		for _, list := range section.Lists {
+			member, err := memberships.contains(list, pubkey, func() ([]string, error) { return db.ListMembers(ctx, list) })
+			if err != nil || member {
+				return member, err
+			}
+		}
+
+		for _, badge := range section.Badges {
+			holder, err := memberships.contains(badge, pubkey, func() ([]string, error) { return db.BadgeHolders(ctx, badge) })
+			if err != nil || holder {
+				return holder, err
+			}
+		}
+	}
+	return false, nil
+}
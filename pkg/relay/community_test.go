@@ -0,0 +1,81 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestCommunityRights(t *testing.T) {
+	community := mustPublicKey(nostr.GeneratePrivateKey())
+	editors := events.AddressableRef{Kind: events.KindProfileList, Pubkey: community, DTag: "editors"}
+	curators := events.AddressableRef{Kind: events.KindBadgeDefinition, Pubkey: community, DTag: "curators"}
+	award := func(ID, pubkey string) *nostr.Event {
+		return &nostr.Event{ID: ID, PubKey: community, CreatedAt: 1700000000, Kind: events.KindBadgeAward,
+			Tags: nostr.Tags{{"a", curators.String()}, {"p", pubkey}}}
+	}
+
+	db := testDB(t,
+		&nostr.Event{ID: "community", PubKey: community, CreatedAt: 1700000000, Kind: events.KindCommunityCreation, Tags: nostr.Tags{
+			{"r", "wss://relay.example.com"},
+			{"content", "apps"}, {"k", "32267"}, {"a", editors.String()},
+			{"content", "stacks"}, {"k", "30267"}, {"badge", curators.String()},
+			{"content", "notes"}, {"k", "1"}, {"a", editors.String()},
+			{"content", "chat"}, {"k", "1"}, {"k", "1111"},
+		}},
+		&nostr.Event{ID: "editors", PubKey: community, CreatedAt: 1700000000, Kind: events.KindProfileList,
+			Tags: nostr.Tags{{"d", "editors"}, {"p", "alice"}}},
+		award("award", "bob"),
+	)
+	memberships := newMemberships()
+	reject := CommunityRights(db, memberships)
+
+	in := func(kind int, pubkey string) *nostr.Event {
+		return &nostr.Event{PubKey: pubkey, CreatedAt: 1700000000, Kind: kind, Tags: nostr.Tags{{"h", community}}}
+	}
+
+	tests := []struct {
+		name  string
+		event *nostr.Event
+		err   error
+	}{
+		{name: "list member", event: in(events.KindApp, "alice")},
+		{name: "not in the list", event: in(events.KindApp, "bob"), err: ErrCommunityNotMember},
+		{name: "badge holder", event: in(events.KindStack, "bob")},
+		{name: "without the badge", event: in(events.KindStack, "alice"), err: ErrCommunityNotMember},
+		{name: "open section", event: in(events.KindComment, "mallory")},
+		{name: "open among restricted sections", event: in(1, "mallory")},
+		{name: "kind not accepted", event: in(events.KindRelease, "alice"), err: ErrCommunityKindNotAllowed},
+		{name: "community pubkey", event: &nostr.Event{PubKey: community, Kind: events.KindRelease, Tags: nostr.Tags{{"h", community}}}},
+		{name: "unknown community", event: &nostr.Event{PubKey: "mallory", Kind: events.KindApp, Tags: nostr.Tags{{"h", mustPublicKey(nostr.GeneratePrivateKey())}}}},
+		{name: "invalid community", event: &nostr.Event{PubKey: "mallory", Kind: events.KindApp, Tags: nostr.Tags{{"h", "community"}}}},
+		{name: "community creation", event: &nostr.Event{PubKey: "mallory", Kind: events.KindCommunityCreation, Tags: nostr.Tags{{"h", community}}}},
+		{name: "outside communities", event: &nostr.Event{PubKey: "mallory", Kind: events.KindApp}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := reject(nil, test.event); !errors.Is(err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("invalidation", func(t *testing.T) {
+		awarded := award("award2", "alice")
+		if _, err := db.Save(context.Background(), awarded); err != nil {
+			t.Fatalf("failed to save award: %v", err)
+		}
+		if err := reject(nil, in(events.KindStack, "alice")); !errors.Is(err, ErrCommunityNotMember) {
+			t.Fatalf("expected the cached holders to be used until invalidated, got %v", err)
+		}
+
+		memberships.Invalidate(awarded)
+		if err := reject(nil, in(events.KindStack, "alice")); err != nil {
+			t.Errorf("expected the new badge holder to publish, got %v", err)
+		}
+	})
+}
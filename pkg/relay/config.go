@@ -33,6 +33,11 @@ type Config struct {
 	// Default is 50.
 	MaxReqFilters int `env:"RELAY_MAX_REQ_FILTERS"`
 
+	// MaxFilterCost is the maximum estimated cost of a filter of a REQ or COUNT, which is roughly
+	// the number of events it reads (capped by the limit or the ResponseLimit for REQs), estimated from the statistics of the stored events.
+	// Filters above it are rejected. Default is 10_000.
+	MaxFilterCost int `env:"RELAY_MAX_FILTER_COST"`
+
 	// ResponseLimit is the maximum number of responses that can be buffered and sent
 	// to a single client connection before backpressure is applied. Default is 500.
 	ResponseLimit int `env:"RELAY_RESPONSE_LIMIT"`
@@ -41,6 +46,11 @@ type Config struct {
 	// Default is all kinds.
 	AllowedKinds []int `env:"RELAY_ALLOWED_EVENT_KINDS"`
 
+	// RewindableChannels are the release channels ('c' tag of kind 30063) that can go back to a lower version code.
+	// Assets released only on these channels are ignored when checking that version codes never decrease.
+	// Default is "beta", "nightly" and "dev".
+	RewindableChannels []string `env:"RELAY_REWINDABLE_CHANNELS" envSeparator:","`
+
 	// ReconcileInterval is the interval used by the reconciliation mechanism of the relay, in which
 	// pending events are checked for completeness, and moved to the normal events or deleted.
 	// Default is 1 minute.
@@ -50,6 +60,32 @@ type Config struct {
 	// Default is 5 hours.
 	RemovePendingAfter time.Duration `env:"RELAY_REMOVE_PENDING_AFTER"`
 
+	// ExpirationInterval is the interval at which the events whose NIP-40 'expiration' tag (or 'expiry' tag,
+	// for identity proofs) has passed are deleted. Expired events are never served, even before deletion.
+	// Default is 1 minute.
+	ExpirationInterval time.Duration `env:"RELAY_EXPIRATION_INTERVAL"`
+
+	// PopularityInterval is the interval at which the popularity of the apps, used to rank searches
+	// with 'sort:popular', is refreshed from the downloads and impressions of the analytics.
+	// Default is 1 hour.
+	PopularityInterval time.Duration `env:"RELAY_POPULARITY_INTERVAL"`
+
+	// HistoryVersions is the maximum number of superseded versions archived for each replaceable
+	// or addressable event, used to investigate and roll back their changes. Default is 20.
+	HistoryVersions int `env:"RELAY_HISTORY_VERSIONS"`
+
+	// HistoryRetention is the duration after which superseded versions are removed from the event history.
+	// Default is 90 days.
+	HistoryRetention time.Duration `env:"RELAY_HISTORY_RETENTION"`
+
+	// SecretKey is the hex secret key the relay uses to sign the notices sent to publishers when
+	// their pending events are promoted or expire. Default is "", which disables the notices.
+	SecretKey string `env:"RELAY_SECRET_KEY"`
+
+	// AdminPubkeys are the pubkeys that can use the NIP-86 management API, authenticated with NIP-98.
+	// They are the admins of the dashboard, so they share its env variable. Default is none, which disables the API.
+	AdminPubkeys []string `env:"DASHBOARD_ADMIN_PUBKEYS"`
+
 	// ProfileRelays are the trusted upstream relays used to resolve kind 0
 	// profiles when an app is published.
 	ProfileRelays []string `env:"RELAY_PROFILE_RELAYS" envSeparator:","`
@@ -65,6 +101,7 @@ func NewConfig() Config {
 		QueueCapacity:   1000,
 		MaxMessageBytes: 500_000,
 		MaxReqFilters:   50,
+		MaxFilterCost:   10_000,
 		ResponseLimit:   500,
 		AllowedKinds: []int{
 			// app kinds
@@ -83,12 +120,23 @@ func NewConfig() Config {
 			events.KindReport,
 			events.KindZap,
 			events.KindCommunityCreation,
+			events.KindProfileList,
+			events.KindBadgeDefinition,
+			events.KindBadgeAward,
 
 			// NIP-C1 identity proof kind
 			events.KindIdentityProof,
+
+			// operator kinds
+			events.KindCertificateRotation,
 		},
+		RewindableChannels: []string{"beta", "nightly", "dev"},
 		ReconcileInterval:  1 * time.Minute,
 		RemovePendingAfter: 5 * time.Hour,
+		ExpirationInterval: 1 * time.Minute,
+		PopularityInterval: 1 * time.Hour,
+		HistoryVersions:    20,
+		HistoryRetention:   90 * 24 * time.Hour,
 		ProfileRelays:      []string{"wss://relay.vertexlab.io"},
 	}
 }
@@ -121,12 +169,30 @@ func (c Config) Validate() error {
 	if c.MaxReqFilters <= 0 {
 		return errors.New("max REQ filters must be greater than 0")
 	}
+	if c.MaxFilterCost <= 0 {
+		return errors.New("max filter cost must be greater than 0")
+	}
 	if c.ResponseLimit <= 0 {
 		return errors.New("response limit must be greater than 0")
 	}
 	if len(c.AllowedKinds) == 0 {
 		slog.Warn("relay allowed kinds is empty. No events will be accepted.")
 	}
+	if c.ExpirationInterval <= 0 {
+		return errors.New("expiration interval must be greater than 0")
+	}
+	if c.PopularityInterval <= 0 {
+		return errors.New("popularity interval must be greater than 0")
+	}
+	if c.HistoryVersions <= 0 {
+		return errors.New("history versions must be greater than 0")
+	}
+	if c.HistoryRetention <= 0 {
+		return errors.New("history retention must be greater than 0")
+	}
+	if c.SecretKey != "" && !nostr.IsValid32ByteHex(c.SecretKey) {
+		return errors.New("secret key is not a valid 32 byte hex string")
+	}
 	for _, relayURL := range c.ProfileRelays {
 		parsed, err := url.Parse(relayURL)
 		if err != nil || (parsed.Scheme != "ws" && parsed.Scheme != "wss") || parsed.Host == "" {
@@ -204,10 +270,18 @@ func (c Config) String() string {
 		"\tQueue Capacity: %d\n"+
 		"\tMax Message Bytes: %d\n"+
 		"\tMax REQ Filters: %d\n"+
+		"\tMax Filter Cost: %d\n"+
 		"\tResponse Limit: %d\n"+
 		"\tAllowed Kinds: %v\n"+
+		"\tRewindable Channels: %v\n"+
+		"\tExpiration Interval: %s\n"+
+		"\tPopularity Interval: %s\n"+
+		"\tHistory Versions: %d\n"+
+		"\tHistory Retention: %s\n"+
+		"\tPending Notices: %t\n"+
 		"\tProfile Relays: %v\n"+
+		"\tAdmin Pubkeys: %v\n"+
 		c.Info.String(),
-		c.Hostname, c.Address, c.QueueCapacity, c.MaxMessageBytes, c.MaxReqFilters, c.ResponseLimit, c.AllowedKinds, c.ProfileRelays,
+		c.Hostname, c.Address, c.QueueCapacity, c.MaxMessageBytes, c.MaxReqFilters, c.MaxFilterCost, c.ResponseLimit, c.AllowedKinds, c.RewindableChannels, c.ExpirationInterval, c.PopularityInterval, c.HistoryVersions, c.HistoryRetention, c.SecretKey != "", c.ProfileRelays, c.AdminPubkeys,
 	)
 }
@@ -0,0 +1,290 @@
+package relay
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/rate"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+var (
+	aliceSK = nostr.GeneratePrivateKey()
+	alice   = mustPublicKey(aliceSK)
+	mallory = mustPublicKey(nostr.GeneratePrivateKey())
+)
+
+func mustPublicKey(sk string) string {
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		panic(err)
+	}
+	return pk
+}
+
+// testEndpointsRelay returns a relay storing the apps of alice and mallory, each with a release and an asset,
+// and a stack of alice listing them. Mallory is blocked by the defender.
+func testEndpointsRelay(t *testing.T) *T {
+	db, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	app := func(pubkey, appID, platform, versionCode string) []*nostr.Event {
+		asset := appID + "-asset"
+		return []*nostr.Event{
+			{ID: appID, PubKey: pubkey, CreatedAt: 1700000000, Kind: events.KindApp, Tags: nostr.Tags{{"d", appID}, {"name", appID}}},
+			{ID: asset, PubKey: pubkey, CreatedAt: 1700000000, Kind: events.KindAsset, Tags: nostr.Tags{
+				{"i", appID}, {"version", "1.0"}, {"version_code", versionCode}, {"f", platform},
+				{"apk_certificate_hash", "cert"}, {"min_allowed_version_code", "5"}}},
+			{ID: appID + "-release", PubKey: pubkey, CreatedAt: 1700000000, Kind: events.KindRelease, Tags: nostr.Tags{
+				{"d", appID + "@1.0"}, {"i", appID}, {"c", "main"}, {"e", asset}}},
+		}
+	}
+
+	stored := slices.Concat(
+		app(alice, "com.example.app", "android-arm64-v8a", "10"),
+		app(alice, "com.armv7.app", "android-armeabi-v7a", "10"),
+		app(mallory, "com.mallory.app", "android-arm64-v8a", "10"),
+	)
+	stored = append(stored, &nostr.Event{ID: "stack", PubKey: alice, CreatedAt: 1700000000, Kind: events.KindStack, Tags: nostr.Tags{
+		{"d", "favorites"},
+		{"a", "32267:" + mallory + ":com.mallory.app"},
+		{"a", "32267:" + alice + ":com.armv7.app"},
+		{"a", "32267:" + alice + ":com.missing.app"},
+		{"a", "32267:" + alice + ":com.example.app"},
+	}})
+
+	for _, e := range stored {
+		if _, err := db.Save(context.Background(), e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	config := NewConfig()
+	config.Hostname = "relay.example.com"
+	config.RemovePendingAfter = time.Hour
+
+	bucket := rate.Config{InitialTokens: 1000, MaxTokens: 1000, TokensPerInterval: 1000, Interval: time.Hour}
+	relay := &T{
+		config:  config,
+		store:   db,
+		limiter: rate.NewLimiter(rate.TiersConfig{Anonymous: bucket, Authenticated: bucket, Publisher: bucket, Indexer: bucket}),
+		blocked: newPubkeySet(),
+	}
+	relay.blocked.Set([]string{mallory})
+	return relay
+}
+
+// serve sends the request to the relay and returns the response status and body.
+func serve(relay *T, req *http.Request) (int, string) {
+	rec := httptest.NewRecorder()
+	relay.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestServeStack(t *testing.T) {
+	relay := testEndpointsRelay(t)
+	stack := "30267:" + alice + ":favorites"
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		apps   []string
+	}{
+		{name: "missing stack", query: "platform=android-arm64-v8a", status: http.StatusBadRequest},
+		{name: "invalid platform", query: "stack=" + stack + "&platform=commodore-64", status: http.StatusBadRequest},
+		{name: "unknown stack", query: "stack=30267:" + alice + ":unknown&platform=android-arm64-v8a", status: http.StatusNotFound},
+		{name: "arm64 with compatible armv7", query: "stack=" + stack + "&platform=android-arm64-v8a", status: http.StatusOK, apps: []string{"com.armv7.app", "com.example.app"}},
+		{name: "armv7", query: "stack=" + stack + "&platform=android-armeabi-v7a", status: http.StatusOK, apps: []string{"com.armv7.app"}},
+		{name: "other channel", query: "stack=" + stack + "&platform=android-arm64-v8a&channel=beta", status: http.StatusOK, apps: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := serve(relay, httptest.NewRequest(http.MethodGet, StacksPath+"?"+test.query, nil))
+			if status != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, status, body)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Apps []stackEntry `json:"apps"`
+			}
+			if err := json.Unmarshal([]byte(body), &res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			apps := []string{}
+			for _, entry := range res.Apps {
+				if entry.App == nil || entry.Release == nil || entry.Asset == nil {
+					t.Errorf("expected %s to have the app, release and asset", entry.AppID)
+				}
+				apps = append(apps, entry.AppID)
+			}
+			if !slices.Equal(apps, test.apps) {
+				t.Errorf("expected the apps %v, got %v", test.apps, apps)
+			}
+		})
+	}
+}
+
+func TestServeUpdates(t *testing.T) {
+	relay := testEndpointsRelay(t)
+
+	installed := func(appID string, versionCode int64, certificate string) installedApp {
+		return installedApp{AppID: appID, Pubkey: alice, VersionCode: versionCode, Platform: "android-arm64-v8a", CertificateHash: certificate}
+	}
+
+	type update struct {
+		appID  string
+		forced bool
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		apps    []installedApp
+		status  int
+		updates []update
+	}{
+		{name: "wrong method", method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{name: "no apps", method: http.MethodPost, apps: []installedApp{}, status: http.StatusBadRequest},
+		{name: "invalid pubkey", method: http.MethodPost, apps: []installedApp{{AppID: "com.example.app", Pubkey: "alice", Platform: "android-arm64-v8a"}}, status: http.StatusBadRequest},
+		{name: "up to date", method: http.MethodPost, apps: []installedApp{installed("com.example.app", 10, "")}, status: http.StatusOK},
+		{name: "update", method: http.MethodPost, apps: []installedApp{installed("com.example.app", 7, "cert")}, status: http.StatusOK, updates: []update{{appID: "com.example.app"}}},
+		{name: "forced update", method: http.MethodPost, apps: []installedApp{installed("com.example.app", 1, "")}, status: http.StatusOK, updates: []update{{appID: "com.example.app", forced: true}}},
+		{name: "other certificate", method: http.MethodPost, apps: []installedApp{installed("com.example.app", 1, "other")}, status: http.StatusOK},
+		{
+			name:   "many apps",
+			method: http.MethodPost,
+			apps: []installedApp{
+				installed("com.missing.app", 1, ""),
+				installed("com.example.app", 7, ""),
+				installed("com.armv7.app", 1, ""), // no arm64 asset
+			},
+			status:  http.StatusOK,
+			updates: []update{{appID: "com.example.app"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]any{"apps": test.apps})
+			status, res := serve(relay, httptest.NewRequest(test.method, UpdatesPath, strings.NewReader(string(body))))
+			if status != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, status, res)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			var decoded struct {
+				Updates []appUpdate `json:"updates"`
+			}
+			if err := json.Unmarshal([]byte(res), &decoded); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			updates := []update{}
+			for _, u := range decoded.Updates {
+				if u.Release == nil || u.Asset == nil {
+					t.Errorf("expected %s to have the release and asset", u.AppID)
+				}
+				updates = append(updates, update{appID: u.AppID, forced: u.Forced})
+			}
+			if test.updates == nil {
+				test.updates = []update{}
+			}
+			if !slices.Equal(updates, test.updates) {
+				t.Errorf("expected the updates %v, got %v", test.updates, updates)
+			}
+		})
+	}
+}
+
+func TestServePending(t *testing.T) {
+	relay := testEndpointsRelay(t)
+
+	pending := []*nostr.Event{
+		{ID: "pending", PubKey: alice, CreatedAt: 1700000000, Kind: events.KindAsset, Tags: nostr.Tags{{"x", strings.Repeat("f", 64)}}},
+		{ID: "other", PubKey: mallory, CreatedAt: 1700000000, Kind: events.KindAsset, Tags: nostr.Tags{{"x", strings.Repeat("e", 64)}}},
+	}
+	for _, e := range pending {
+		if _, err := relay.store.SavePending(context.Background(), e); err != nil {
+			t.Fatalf("SavePending: %v", err)
+		}
+	}
+
+	authorization := func(method, url string) string {
+		auth := nostr.Event{
+			Kind:      KindHTTPAuth,
+			CreatedAt: nostr.Now(),
+			Tags:      nostr.Tags{{"u", url}, {"method", method}},
+		}
+		if err := auth.Sign(aliceSK); err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		raw, _ := json.Marshal(auth)
+		return "Nostr " + base64.StdEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		status        int
+		pending       []string
+	}{
+		{name: "wrong method", method: http.MethodPost, status: http.StatusMethodNotAllowed},
+		{name: "unauthenticated", method: http.MethodGet, status: http.StatusUnauthorized},
+		{name: "other URL", method: http.MethodGet, authorization: authorization(http.MethodGet, "https://other.example.com/v1/pending"), status: http.StatusUnauthorized},
+		{name: "other method", method: http.MethodGet, authorization: authorization(http.MethodPost, "https://relay.example.com/v1/pending"), status: http.StatusUnauthorized},
+		{name: "own pending events", method: http.MethodGet, authorization: authorization(http.MethodGet, "https://relay.example.com/v1/pending"), status: http.StatusOK, pending: []string{"pending"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, PendingPath, nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+
+			status, body := serve(relay, req)
+			if status != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, status, body)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Pending []pendingStatus `json:"pending"`
+			}
+			if err := json.Unmarshal([]byte(body), &res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			IDs := []string{}
+			for _, p := range res.Pending {
+				IDs = append(IDs, p.ID)
+			}
+			if !slices.Equal(IDs, test.pending) {
+				t.Errorf("expected the pending events %v, got %v", test.pending, IDs)
+			}
+		})
+	}
+}
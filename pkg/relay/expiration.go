@@ -0,0 +1,88 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+// expirationBatch is the maximum number of expired events deleted per statement, to keep write transactions short.
+const expirationBatch = 500
+
+// Expired rejects events whose NIP-40 'expiration' tag, or 'expiry' tag for identity proofs, has already passed.
+func Expired(_ rely.Client, e *nostr.Event) error {
+	if expiresAt, ok := expiration(e); ok && expiresAt <= time.Now().Unix() {
+		return ErrEventExpired
+	}
+	return nil
+}
+
+// expiration returns the earliest expiration timestamp of the event, if any.
+// It mirrors the expirations_ai trigger, which indexes the same tags and ignores the values that aren't unsigned integers.
+func expiration(e *nostr.Event) (int64, bool) {
+	var earliest int64
+	var found bool
+	for _, tag := range e.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		if tag[0] != "expiration" && (e.Kind != events.KindIdentityProof || tag[0] != "expiry") {
+			continue
+		}
+
+		if tag[1] == "" || strings.Trim(tag[1], "0123456789") != "" {
+			continue
+		}
+		ts, err := strconv.ParseInt(tag[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if !found || ts < earliest {
+			earliest, found = ts, true
+		}
+	}
+	return earliest, found
+}
+
+// runExpirations periodically deletes the expired events, which are already excluded from queries.
+func (r *T) runExpirations(ctx context.Context) {
+	ticker := time.NewTicker(r.config.ExpirationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			deleted, err := r.deleteExpired(ctx)
+			if deleted > 0 {
+				// expired profile lists and badge awards may change publish rights in communities
+				r.memberships.Reset()
+				r.analytics.RecordExpired(deleted)
+				slog.Info("relay: deleted expired events", "count", deleted)
+			}
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("relay: failed to delete expired events", "error", err)
+			}
+		}
+	}
+}
+
+// deleteExpired deletes all the expired events in batches, and returns how many were deleted.
+func (r *T) deleteExpired(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		deleted, err := r.store.DeleteExpired(ctx, time.Now(), expirationBatch)
+		total += deleted
+		if err != nil || deleted < expirationBatch {
+			return total, err
+		}
+	}
+}
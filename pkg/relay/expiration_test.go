@@ -0,0 +1,41 @@
+package relay
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestExpired(t *testing.T) {
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name string
+		tags nostr.Tags
+		kind int
+		err  error
+	}{
+		{name: "no expiration", kind: events.KindComment},
+		{name: "future", kind: events.KindComment, tags: nostr.Tags{{"expiration", future}}},
+		{name: "past", kind: events.KindComment, tags: nostr.Tags{{"expiration", past}}, err: ErrEventExpired},
+		{name: "earliest", kind: events.KindComment, tags: nostr.Tags{{"expiration", future}, {"expiration", past}}, err: ErrEventExpired},
+		{name: "not a number", kind: events.KindComment, tags: nostr.Tags{{"expiration", "soon"}}},
+		{name: "number prefix", kind: events.KindComment, tags: nostr.Tags{{"expiration", "1abc"}}},
+		{name: "expired proof", kind: events.KindIdentityProof, tags: nostr.Tags{{"expiry", past}}, err: ErrEventExpired},
+		{name: "expiry of other kinds", kind: events.KindComment, tags: nostr.Tags{{"expiry", past}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := &nostr.Event{Kind: test.kind, Tags: test.tags}
+			if err := Expired(nil, event); !errors.Is(err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
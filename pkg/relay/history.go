@@ -0,0 +1,96 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	defender "github.com/zapstore/defender/pkg/client"
+	"github.com/zapstore/defender/pkg/models"
+)
+
+// historyInterval is the interval at which the event history is pruned according to the retention limits.
+const historyInterval = time.Hour
+
+// runHistory prunes the event history at startup and then periodically, keeping the last [Config.HistoryVersions]
+// of each event replaced within the [Config.HistoryRetention].
+func (r *T) runHistory(ctx context.Context) {
+	r.pruneHistory(ctx)
+
+	ticker := time.NewTicker(historyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			r.pruneHistory(ctx)
+		}
+	}
+}
+
+func (r *T) pruneHistory(ctx context.Context) {
+	before := time.Now().Add(-r.config.HistoryRetention)
+	pruned, err := r.store.PruneHistory(ctx, r.config.HistoryVersions, before)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("relay: failed to prune the event history", "error", err)
+	}
+	if pruned > 0 {
+		slog.Info("relay: pruned the event history", "count", pruned)
+	}
+}
+
+// RestoreVersion makes the archived version with the given ID the current version of its event again.
+// Versions that have been banned or deleted by the operator can't be restored, and neither can the
+// versions of pubkeys blocked by the defender.
+// The memberships are reset, because restoring a profile list changes who can publish in the communities.
+func (r *T) RestoreVersion(ctx context.Context, ID string) (nostr.Event, error) {
+	version, err := r.store.HistoricalVersion(ctx, ID)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	checks := []func(rely.Client, *nostr.Event) error{
+		EventBanned(r.store),
+		Tombstoned(r.store),
+		PubkeyBlocked(r.defender),
+	}
+	for _, check := range checks {
+		if err := check(nil, &version.Event); err != nil {
+			return nostr.Event{}, err
+		}
+	}
+
+	restored, err := r.store.RestoreVersion(ctx, ID)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	r.memberships.Reset()
+	return restored, nil
+}
+
+// PubkeyBlocked rejects the events whose pubkey has been blocked in the defender policies.
+func PubkeyBlocked(d defender.T) func(_ rely.Client, e *nostr.Event) error {
+	return func(_ rely.Client, e *nostr.Event) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		policy, err := d.GetPolicy(ctx, models.Entity{ID: e.PubKey, Platform: models.PlatformNostr})
+		if errors.Is(err, defender.ErrPolicyNotFound) {
+			return nil
+		}
+		if err != nil {
+			slog.Error("defender: failed to get policy", "err", err, "pubkey", e.PubKey)
+			return ErrInternal
+		}
+		if policy.Status == models.StatusBlocked {
+			return ErrEventPubkeyBlocked
+		}
+		return nil
+	}
+}
@@ -0,0 +1,138 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	defender "github.com/zapstore/defender/pkg/client"
+	"github.com/zapstore/defender/pkg/models"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// testDefender returns a defender client backed by in-memory policies, initially blocking the given pubkeys.
+func testDefender(t *testing.T, blocked ...string) defender.T {
+	var mu sync.Mutex
+	policies := make(map[string]models.Policy)
+	for _, pubkey := range blocked {
+		policies[pubkey] = models.Policy{Entity: models.Entity{ID: pubkey, Platform: models.PlatformNostr}, Status: models.StatusBlocked}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/v1/policies" {
+			list := []models.Policy{}
+			for _, p := range policies {
+				if status := r.URL.Query().Get("status"); status == "" || string(p.Status) == status {
+					list = append(list, p)
+				}
+			}
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+
+		pubkey := path.Base(r.URL.Path)
+		switch r.Method {
+		case http.MethodPut:
+			var policy models.Policy
+			if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			policies[pubkey] = policy
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			policy, ok := policies[pubkey]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(policy)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := defender.New(server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("failed to create defender client: %v", err)
+	}
+	return client
+}
+
+func TestRestoreVersion(t *testing.T) {
+	db, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	relay := &T{store: db, defender: testDefender(t, "mallory"), memberships: newMemberships()}
+
+	replaced := []*nostr.Event{
+		{ID: "list1", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindProfileList, Tags: nostr.Tags{{"d", "team"}, {"p", "bob"}}},
+		{ID: "list2", PubKey: "alice", CreatedAt: 1700000001, Kind: events.KindProfileList, Tags: nostr.Tags{{"d", "team"}}},
+		{ID: "banned1", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}}},
+		{ID: "banned2", PubKey: "alice", CreatedAt: 1700000001, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}}},
+		{ID: "blocked1", PubKey: "mallory", CreatedAt: 1700000000, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.mallory"}}},
+		{ID: "blocked2", PubKey: "mallory", CreatedAt: 1700000001, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.mallory"}}},
+	}
+	for _, e := range replaced {
+		if _, err := db.Replace(ctx, e); err != nil {
+			t.Fatalf("failed to replace event %s: %v", e.ID, err)
+		}
+	}
+	if _, err := db.BanEvent(ctx, "banned1", "spam"); err != nil {
+		t.Fatalf("failed to ban event: %v", err)
+	}
+
+	tests := []struct {
+		ID  string
+		err error
+	}{
+		{ID: "banned1", err: ErrEventBanned},
+		{ID: "blocked1", err: ErrEventPubkeyBlocked},
+		{ID: "missing", err: store.ErrVersionNotFound},
+		{ID: "list1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ID, func(t *testing.T) {
+			team := events.AddressableRef{Kind: events.KindProfileList, Pubkey: "alice", DTag: "team"}
+			relay.memberships.sets[team.String()] = map[string]struct{}{}
+
+			restored, err := relay.RestoreVersion(ctx, test.ID)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if restored.ID != test.ID {
+				t.Errorf("expected to restore %s, got %s", test.ID, restored.ID)
+			}
+			if len(relay.memberships.sets) != 0 {
+				t.Error("expected the memberships to be reset")
+			}
+		})
+	}
+
+	// the replaced version can't be re-published to supersede the restored one
+	if err := Tombstoned(db)(nil, replaced[1]); !errors.Is(err, ErrEventDeleted) {
+		t.Errorf("expected re-publishing the replaced version to fail with %v, got %v", ErrEventDeleted, err)
+	}
+	if _, err := relay.RestoreVersion(ctx, "list2"); !errors.Is(err, ErrEventDeleted) {
+		t.Errorf("expected restoring the replaced version to fail with %v, got %v", ErrEventDeleted, err)
+	}
+}
@@ -0,0 +1,566 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip77"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy/storage/vector"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// NegentropyPath is the path of the websocket endpoint serving NIP-77 negentropy reconciliation.
+// NEG-* messages are served on a dedicated endpoint because the rely read loop only supports
+// the standard nostr messages.
+const NegentropyPath = "/negentropy"
+
+// SyncKinds are the event kinds that can be reconciled with NIP-77 negentropy.
+var SyncKinds = []int{
+	events.KindApp,
+	events.KindRelease,
+	events.KindAsset,
+	events.KindStack,
+}
+
+const (
+	// negentropyFrameLimit is the maximum size of a NEG-MSG produced by the relay.
+	negentropyFrameLimit = 256_000
+
+	// negentropyMaxSessions is the maximum number of negentropy sessions a connection can keep open.
+	negentropyMaxSessions = 4
+
+	// negentropyMaxItems is the maximum number of events a connection can reconcile across its open sessions.
+	negentropyMaxItems = 500_000
+
+	// negentropyQueue is the maximum number of messages of a connection waiting to be answered.
+	negentropyQueue = 8
+
+	// negentropyIdleTimeout is the duration after which an idle negentropy connection is closed.
+	negentropyIdleTimeout = time.Minute
+
+	// negentropyWriteTimeout is the maximum duration of writing a message to a negentropy connection.
+	negentropyWriteTimeout = 10 * time.Second
+
+	// syncBatchSize is the number of missing events fetched per REQ by [T.Sync].
+	syncBatchSize = 100
+)
+
+var negentropyUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// validateSyncFilter returns an error if the filter can't be used for negentropy reconciliation.
+// If the filter doesn't specify kinds, it's scoped to the [SyncKinds].
+func validateSyncFilter(filter *nostr.Filter) error {
+	if filter.Search != "" {
+		return errors.New("blocked: search is not supported")
+	}
+	if len(filter.Kinds) == 0 {
+		filter.Kinds = slices.Clone(SyncKinds)
+		return nil
+	}
+	for _, k := range filter.Kinds {
+		if !slices.Contains(SyncKinds, k) {
+			return fmt.Errorf("blocked: negentropy is supported only for kinds %v", SyncKinds)
+		}
+	}
+	return nil
+}
+
+// negentropySessions are the open negentropy sessions, by client UID and subscription ID.
+type negentropySessions struct {
+	mu      sync.Mutex
+	clients map[string]map[string]negentropySession
+
+	lastUID  atomic.Uint64
+	done     chan struct{}
+	shutdown sync.Once
+}
+
+type negentropySession struct {
+	neg   *negentropy.Negentropy
+	items int
+}
+
+func newNegentropySessions() *negentropySessions {
+	return &negentropySessions{
+		clients: make(map[string]map[string]negentropySession),
+		done:    make(chan struct{}),
+	}
+}
+
+// Connect returns the UID of a new connection.
+func (s *negentropySessions) Connect() string {
+	return strconv.FormatUint(s.lastUID.Add(1), 10)
+}
+
+// Done returns a channel that is closed when the relay shuts down, so that the connections are closed.
+func (s *negentropySessions) Done() <-chan struct{} {
+	return s.done
+}
+
+// Shutdown closes the channel returned by [negentropySessions.Done].
+func (s *negentropySessions) Shutdown() {
+	s.shutdown.Do(func() { close(s.done) })
+}
+
+// Get returns the session of the client with the given subscription ID, if any.
+func (s *negentropySessions) Get(client, id string) (*negentropy.Negentropy, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.clients[client][id]
+	return session.neg, ok
+}
+
+// Budget returns how many more sessions the client can open, and how many more items they can hold.
+func (s *negentropySessions) Budget(client string) (sessions, items int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions, items = negentropyMaxSessions, negentropyMaxItems
+	for _, session := range s.clients[client] {
+		sessions--
+		items -= session.items
+	}
+	return sessions, items
+}
+
+// Open stores the session of the client with the given subscription ID.
+func (s *negentropySessions) Open(client, id string, neg *negentropy.Negentropy, items int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[client]; !ok {
+		s.clients[client] = make(map[string]negentropySession, 1)
+	}
+	s.clients[client][id] = negentropySession{neg: neg, items: items}
+}
+
+// Close removes the session of the client with the given subscription ID.
+func (s *negentropySessions) Close(client, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients[client], id)
+	if len(s.clients[client]) == 0 {
+		delete(s.clients, client)
+	}
+}
+
+// CloseAll removes all the sessions of the client.
+func (s *negentropySessions) CloseAll(client string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, client)
+}
+
+// negentropyClient is a connection to the [NegentropyPath] endpoint.
+type negentropyClient interface {
+	// UID is the unique identifier of the connection, which the sessions are tied to.
+	UID() string
+	IP() rely.IP
+	SendMessage(msg []byte)
+	Disconnect()
+}
+
+// negentropyConn is a websocket connection to the [NegentropyPath] endpoint.
+type negentropyConn struct {
+	uid  string
+	ip   rely.IP
+	conn *websocket.Conn
+
+	mu     sync.Mutex // serializes the writes
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *negentropyConn) UID() string { return c.uid }
+func (c *negentropyConn) IP() rely.IP { return c.ip }
+
+func (c *negentropyConn) SendMessage(msg []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(negentropyWriteTimeout))
+	if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		c.Disconnect()
+	}
+}
+
+// Disconnect closes the connection, which stops its read loop.
+func (c *negentropyConn) Disconnect() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// serveNegentropy upgrades the request to a websocket serving NEG-OPEN, NEG-MSG and NEG-CLOSE messages,
+// until the client disconnects, stays idle for too long or the relay shuts down.
+// The read loop only queues the messages, which are answered one at a time by a worker goroutine,
+// so that loading the events of a NEG-OPEN doesn't block reading from the connection.
+func (r *T) serveNegentropy(w http.ResponseWriter, req *http.Request) {
+	ip := rely.GetIP(req)
+	if !r.limiter.Allow(ip.Group(), 1.0) {
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	conn, err := negentropyUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		slog.Debug("relay: failed to upgrade negentropy connection", "ip", ip.Group(), "error", err)
+		return
+	}
+	conn.SetReadLimit(r.config.MaxMessageBytes)
+
+	c := &negentropyConn{uid: r.negentropy.Connect(), ip: ip, conn: conn, closed: make(chan struct{})}
+	defer r.negentropy.CloseAll(c.uid)
+	defer c.Disconnect()
+
+	go func() {
+		select {
+		case <-r.negentropy.Done():
+			c.Disconnect()
+		case <-c.closed:
+		}
+	}()
+
+	queue := make(chan []byte, negentropyQueue)
+	worker := sync.WaitGroup{}
+	worker.Add(1)
+	go func() {
+		defer worker.Done()
+		for data := range queue {
+			if err := r.handleNegentropy(c, data); err != nil {
+				r.limiter.Penalize(ip.Group(), 5.0)
+				c.Disconnect()
+				return
+			}
+		}
+	}()
+
+	defer worker.Wait()
+	defer close(queue)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(negentropyIdleTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		select {
+		case queue <- data:
+		default:
+			// the client doesn't wait for the replies before sending more messages
+			r.limiter.Penalize(ip.Group(), 5.0)
+			return
+		}
+	}
+}
+
+// handleNegentropy answers a NIP-77 NEG-OPEN, NEG-MSG or NEG-CLOSE message.
+// It returns an error if the message is not one of them, in which case the client should be disconnected.
+func (r *T) handleNegentropy(c negentropyClient, message []byte) error {
+	switch env := nip77.ParseNegMessage(string(message)).(type) {
+	case *nip77.OpenEnvelope:
+		if err := r.rateNegentropy(c, 5.0); err != nil {
+			c.SendMessage(negError(env.SubscriptionID, err.Error()))
+			return nil
+		}
+
+		msg, err := r.openNegentropy(c, env)
+		if err != nil {
+			c.SendMessage(negError(env.SubscriptionID, err.Error()))
+			return nil
+		}
+		reply, _ := nip77.MessageEnvelope{SubscriptionID: env.SubscriptionID, Message: msg}.MarshalJSON()
+		c.SendMessage(reply)
+
+	case *nip77.MessageEnvelope:
+		if err := r.rateNegentropy(c, 1.0); err != nil {
+			c.SendMessage(negError(env.SubscriptionID, err.Error()))
+			return nil
+		}
+
+		neg, ok := r.negentropy.Get(c.UID(), env.SubscriptionID)
+		if !ok {
+			c.SendMessage(negError(env.SubscriptionID, "closed: unknown subscription"))
+			return nil
+		}
+
+		msg, err := neg.Reconcile(env.Message)
+		if err != nil {
+			r.negentropy.Close(c.UID(), env.SubscriptionID)
+			c.SendMessage(negError(env.SubscriptionID, "invalid: "+err.Error()))
+			return nil
+		}
+		reply, _ := nip77.MessageEnvelope{SubscriptionID: env.SubscriptionID, Message: msg}.MarshalJSON()
+		c.SendMessage(reply)
+
+	case *nip77.CloseEnvelope:
+		r.negentropy.Close(c.UID(), env.SubscriptionID)
+
+	default:
+		return ErrNegentropyUnsupported
+	}
+	return nil
+}
+
+// rateNegentropy charges the bucket of the client's IP.
+// Rate-limited clients are disconnected, like in [RateReqIP].
+func (r *T) rateNegentropy(c negentropyClient, cost float64) error {
+	if !r.limiter.Allow(c.IP().Group(), cost) {
+		c.Disconnect()
+		slog.Debug("relay: rejecting negentropy and disconnecting", "ip", c.IP().Group())
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// openNegentropy opens a negentropy session in server mode, loaded with the events matching the filter,
+// and returns the reply to the initial message. The session counts towards the limits of the client.
+// As per NIP-77, an open session with the same subscription ID is closed first.
+func (r *T) openNegentropy(c negentropyClient, env *nip77.OpenEnvelope) (string, error) {
+	r.negentropy.Close(c.UID(), env.SubscriptionID)
+
+	filter := env.Filter
+	if err := validateSyncFilter(&filter); err != nil {
+		return "", err
+	}
+
+	sessions, items := r.negentropy.Budget(c.UID())
+	if sessions <= 0 {
+		return "", ErrNegentropySessions
+	}
+	if items <= 0 {
+		return "", ErrNegentropyTooBig
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	loaded, err := r.store.SyncItems(ctx, filter, items)
+	if errors.Is(err, store.ErrTooManyItems) {
+		return "", ErrNegentropyTooBig
+	}
+	if err != nil {
+		slog.Error("relay: failed to load negentropy items", "error", err, "filter", filter)
+		return "", fmt.Errorf("error: %w", ErrInternal)
+	}
+
+	vec := vector.New()
+	for _, item := range loaded {
+		vec.Insert(item.CreatedAt, item.ID)
+	}
+	vec.Seal()
+
+	neg := negentropy.New(vec, negentropyFrameLimit)
+	msg, err := neg.Reconcile(env.Message)
+	if err != nil {
+		return "", fmt.Errorf("invalid: %w", err)
+	}
+
+	r.negentropy.Open(c.UID(), env.SubscriptionID, neg, len(loaded))
+	return msg, nil
+}
+
+// negError returns the NEG-ERR message for the given subscription and reason.
+func negError(id, reason string) []byte {
+	msg, _ := json.Marshal([]string{"NEG-ERR", id, reason})
+	return msg
+}
+
+// Sync reconciles the events of the given filter with the relay at the provided url, using NIP-77 negentropy.
+// Events that the remote relay has and the local store doesn't are downloaded, and saved only if they pass
+// the same checks of the events published by clients, except rate-limiting. Assets whose blob isn't available
+// are saved as pending, like the published ones. Events that only exist locally are left untouched.
+// It returns the number of events saved, including the pending ones.
+func (r *T) Sync(ctx context.Context, url string, filter nostr.Filter) (int, error) {
+	if err := validateSyncFilter(&filter); err != nil {
+		return 0, err
+	}
+
+	missing, err := missingIDs(ctx, r.store, url, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reconcile with %s: %w", url, err)
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	remote, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+	defer remote.Close()
+
+	saved, rejected := 0, 0
+	for batch := range slices.Chunk(missing, syncBatchSize) {
+		fetched, err := remote.QuerySync(ctx, nostr.Filter{IDs: batch, Limit: len(batch)})
+		if err != nil {
+			return saved, fmt.Errorf("failed to fetch missing events: %w", err)
+		}
+
+		for _, event := range fetched {
+			if !slices.Contains(filter.Kinds, event.Kind) {
+				continue
+			}
+
+			if err := r.saveSynced(ctx, event); err != nil {
+				slog.Debug("relay: rejected synced event", "event", event.ID, "error", err)
+				rejected++
+				continue
+			}
+			saved++
+		}
+	}
+
+	if rejected > 0 {
+		slog.Warn("relay: some synced events were rejected", "url", url, "rejected", rejected)
+	}
+	return saved, nil
+}
+
+// saveSynced runs the checks on an event downloaded by [T.Sync], and saves it if it passes them.
+// Checks run without a client, as the event doesn't come from one.
+func (r *T) saveSynced(ctx context.Context, event *nostr.Event) error {
+	if err := r.check(event); err != nil {
+		return err
+	}
+
+	_, err := r.saveEvent(ctx, event)
+	return err
+}
+
+// missingIDs runs the client side of the negentropy protocol against the relay at the provided url,
+// and returns the IDs of the events the remote relay has and the local store doesn't.
+func missingIDs(ctx context.Context, db store.T, url string, filter nostr.Filter) ([]string, error) {
+	items, err := db.SyncItems(ctx, filter, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	vec := vector.New()
+	for _, item := range items {
+		vec.Insert(item.CreatedAt, item.ID)
+	}
+	vec.Seal()
+
+	endpoint := strings.TrimSuffix(url, "/") + NegentropyPath
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", endpoint, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+
+	// the client pushes the IDs into the Haves and HaveNots channels while reconciling,
+	// so they must be drained concurrently. The channels are closed only when reconciliation is complete,
+	// so the goroutines also stop when this function returns early, and are always joined.
+	var missing []string
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	neg := negentropy.New(vec, negentropyFrameLimit)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			// mirrors only download, so the events we have are ignored
+			select {
+			case <-stop:
+				return
+			case _, ok := <-neg.Haves:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case id, ok := <-neg.HaveNots:
+				if !ok {
+					return
+				}
+				missing = append(missing, id)
+			}
+		}
+	}()
+
+	id := "sync"
+	open, _ := nip77.OpenEnvelope{SubscriptionID: id, Filter: filter, Message: neg.Start()}.MarshalJSON()
+	if err := conn.WriteMessage(websocket.TextMessage, open); err != nil {
+		return nil, fmt.Errorf("failed to send NEG-OPEN: %w", err)
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from relay: %w", err)
+		}
+
+		var env *nip77.MessageEnvelope
+		switch e := nip77.ParseNegMessage(string(data)).(type) {
+		case *nip77.ErrorEnvelope:
+			if e.SubscriptionID == id {
+				return nil, fmt.Errorf("relay returned NEG-ERR: %s", e.Reason)
+			}
+		case *nip77.MessageEnvelope:
+			if e.SubscriptionID == id {
+				env = e
+			}
+		}
+		if env == nil {
+			// on the main websocket the relay can send other messages, like AUTH or NOTICE
+			continue
+		}
+
+		next, err := neg.Reconcile(env.Message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reconcile: %w", err)
+		}
+
+		if next == "" {
+			// reconciliation is complete, and the channels are closed
+			clse, _ := nip77.CloseEnvelope{SubscriptionID: id}.MarshalJSON()
+			conn.WriteMessage(websocket.TextMessage, clse)
+
+			wg.Wait()
+			return missing, nil
+		}
+
+		msg, _ := nip77.MessageEnvelope{SubscriptionID: id, Message: next}.MarshalJSON()
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return nil, fmt.Errorf("failed to send NEG-MSG: %w", err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip77"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy/storage/vector"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/rate"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+func testNegentropyRelay(t *testing.T, apps int) *T {
+	db, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for i := range apps {
+		app := &nostr.Event{
+			ID:        fmt.Sprintf("%064x", i),
+			PubKey:    "alice",
+			CreatedAt: nostr.Timestamp(1700000000 + i),
+			Kind:      events.KindApp,
+			Tags:      nostr.Tags{{"d", fmt.Sprintf("com.example.app%d", i)}},
+		}
+		if _, err := db.Save(context.Background(), app); err != nil {
+			t.Fatalf("failed to save app: %v", err)
+		}
+	}
+
+	bucket := rate.Config{InitialTokens: 1000, MaxTokens: 1000, TokensPerInterval: 1000, Interval: time.Hour}
+	return &T{
+		store:      db,
+		limiter:    rate.NewLimiter(rate.TiersConfig{Anonymous: bucket, Authenticated: bucket, Publisher: bucket, Indexer: bucket}),
+		negentropy: newNegentropySessions(),
+	}
+}
+
+// lastReply parses the last message sent to the client.
+func lastReply(t *testing.T, c *client) nostr.Envelope {
+	if len(c.sent) == 0 {
+		t.Fatal("expected a reply, got none")
+	}
+	return nip77.ParseNegMessage(string(c.sent[len(c.sent)-1]))
+}
+
+func TestNegentropyReconcile(t *testing.T) {
+	relay := testNegentropyRelay(t, 10)
+	c := &client{ip: "1.1.1.1"}
+
+	// the client has the first half of the apps, and one the relay doesn't have
+	vec := vector.New()
+	for i := range 5 {
+		vec.Insert(nostr.Timestamp(1700000000+i), fmt.Sprintf("%064x", i))
+	}
+	vec.Insert(1600000000, fmt.Sprintf("%064x", 999))
+	vec.Seal()
+
+	neg := negentropy.New(vec, negentropyFrameLimit)
+	var haves, haveNots []string
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for id := range neg.Haves {
+			haves = append(haves, id)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for id := range neg.HaveNots {
+			haveNots = append(haveNots, id)
+		}
+	}()
+
+	open, _ := nip77.OpenEnvelope{SubscriptionID: "sync", Filter: nostr.Filter{Kinds: []int{events.KindApp}}, Message: neg.Start()}.MarshalJSON()
+	if err := relay.handleNegentropy(c, open); err != nil {
+		t.Fatalf("NEG-OPEN: %v", err)
+	}
+
+	for range 10 {
+		env, ok := lastReply(t, c).(*nip77.MessageEnvelope)
+		if !ok {
+			t.Fatalf("expected a NEG-MSG, got %s", c.sent[len(c.sent)-1])
+		}
+
+		next, err := neg.Reconcile(env.Message)
+		if err != nil {
+			t.Fatalf("failed to reconcile: %v", err)
+		}
+		if next == "" {
+			break
+		}
+
+		msg, _ := nip77.MessageEnvelope{SubscriptionID: "sync", Message: next}.MarshalJSON()
+		if err := relay.handleNegentropy(c, msg); err != nil {
+			t.Fatalf("NEG-MSG: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if want := []string{fmt.Sprintf("%064x", 999)}; !slices.Equal(haves, want) {
+		t.Errorf("expected the client to have %v, got %v", want, haves)
+	}
+	if len(haveNots) != 5 {
+		t.Errorf("expected the client to miss 5 events, got %v", haveNots)
+	}
+}
+
+func TestNegentropyLimits(t *testing.T) {
+	relay := testNegentropyRelay(t, 3)
+	c := &client{ip: "1.1.1.1"}
+
+	vec := vector.New()
+	vec.Seal()
+	start := negentropy.New(vec, negentropyFrameLimit).Start()
+
+	open := func(id string, filter nostr.Filter) nostr.Envelope {
+		msg, _ := nip77.OpenEnvelope{SubscriptionID: id, Filter: filter, Message: start}.MarshalJSON()
+		if err := relay.handleNegentropy(c, msg); err != nil {
+			t.Fatalf("NEG-OPEN: %v", err)
+		}
+		return lastReply(t, c)
+	}
+
+	for i := range negentropyMaxSessions {
+		if _, ok := open(fmt.Sprintf("sub%d", i), nostr.Filter{}).(*nip77.MessageEnvelope); !ok {
+			t.Fatalf("expected session %d to open, got %s", i, c.sent[len(c.sent)-1])
+		}
+	}
+
+	reply, ok := open("one-too-many", nostr.Filter{}).(*nip77.ErrorEnvelope)
+	if !ok || reply.Reason != ErrNegentropySessions.Error() {
+		t.Fatalf("expected %v, got %s", ErrNegentropySessions, c.sent[len(c.sent)-1])
+	}
+
+	// re-opening an existing session replaces it
+	if _, ok := open("sub0", nostr.Filter{}).(*nip77.MessageEnvelope); !ok {
+		t.Fatalf("expected sub0 to re-open, got %s", c.sent[len(c.sent)-1])
+	}
+
+	clse, _ := nip77.CloseEnvelope{SubscriptionID: "sub0"}.MarshalJSON()
+	if err := relay.handleNegentropy(c, clse); err != nil {
+		t.Fatalf("NEG-CLOSE: %v", err)
+	}
+	if _, ok := open("another", nostr.Filter{}).(*nip77.MessageEnvelope); !ok {
+		t.Fatalf("expected a session to open after NEG-CLOSE, got %s", c.sent[len(c.sent)-1])
+	}
+
+	reply, ok = open("search", nostr.Filter{Search: "notes"}).(*nip77.ErrorEnvelope)
+	if !ok || reply.Reason != "blocked: search is not supported" {
+		t.Fatalf("expected search to be blocked, got %s", c.sent[len(c.sent)-1])
+	}
+
+	relay.negentropy.CloseAll(c.UID())
+	if sessions, items := relay.negentropy.Budget(c.UID()); sessions != negentropyMaxSessions || items != negentropyMaxItems {
+		t.Errorf("expected the full budget after disconnecting, got %d sessions and %d items", sessions, items)
+	}
+
+	if err := relay.handleNegentropy(c, []byte(`["REQ","sub",{}]`)); !errors.Is(err, ErrNegentropyUnsupported) {
+		t.Errorf("expected %v, got %v", ErrNegentropyUnsupported, err)
+	}
+}
+
+func TestServeNegentropy(t *testing.T) {
+	relay := testNegentropyRelay(t, 3)
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	local, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer local.Close()
+
+	missing, err := missingIDs(context.Background(), local, url, nostr.Filter{Kinds: []int{events.KindApp}})
+	if err != nil {
+		t.Fatalf("missingIDs: %v", err)
+	}
+	if len(missing) != 3 {
+		t.Errorf("expected 3 missing events, got %v", missing)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+NegentropyPath, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// the connection is closed when the relay shuts down
+	relay.negentropy.Shutdown()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	var netErr net.Error
+	if err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/defender/pkg/models"
+	"github.com/zapstore/relay/pkg/rate"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// ManagementContentType is the content type of the NIP-86 management API requests,
+// which are served on the relay address regardless of the path.
+const ManagementContentType = "application/nostr+json+rpc"
+
+// maxManagementBody is the maximum size of the body of a NIP-86 request.
+const maxManagementBody = 64 << 10
+
+// maxModerationEvents is the maximum number of events returned by 'listeventsneedingmoderation'.
+const maxModerationEvents = 500
+
+// managementMethods are the NIP-86 methods supported by the relay.
+var managementMethods = []string{
+	"supportedmethods",
+	"banpubkey",
+	"allowpubkey",
+	"listbannedpubkeys",
+	"listallowedpubkeys",
+	"banevent",
+	"allowevent",
+	"listbannedevents",
+	"allowkind",
+	"disallowkind",
+	"listallowedkinds",
+	"listeventsneedingmoderation",
+}
+
+// errInvalidParams is returned by the NIP-86 methods whose params are missing or invalid.
+var errInvalidParams = errors.New("invalid params")
+
+type managementRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type managementResponse struct {
+	Result any    `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// pubkeyReason is a pubkey and the reason of its policy, as returned by the NIP-86 list methods.
+type pubkeyReason struct {
+	Pubkey string `json:"pubkey"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// eventReason is an event ID and the reason of its ban or moderation, as returned by the NIP-86 list methods.
+type eventReason struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// serveManagement serves the NIP-86 management API.
+//
+// Request:  {"method": <method>, "params": [...]}, with a NIP-98 'Authorization' header signed by one of the
+// [Config.AdminPubkeys], whose 'payload' tag is the sha256 of the body.
+// Response: {"result": <result>, "error": <error message, if any>}
+//
+// Pubkeys are banned and allowed through the defender, events are banned in the relay database
+// (allowing an event also lifts its tombstone, if deleted by the operator),
+// and kind changes apply to the running relay only. Reports (kind 1984) and certificate conflicts
+// waiting for an admin decision are the events needing moderation.
+func (r *T) serveManagement(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := rely.GetIP(req).Group()
+	if !r.limiter.Allow(ip, 1.0) {
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxManagementBody+1))
+	if err != nil || len(body) > maxManagementBody {
+		http.Error(w, "failed to read the request body", http.StatusBadRequest)
+		return
+	}
+
+	admin, err := authenticateHTTPPayload(req, r.config.Hostname, body)
+	if err != nil {
+		r.limiter.Penalize(ip, 10)
+		writeManagement(w, http.StatusUnauthorized, managementResponse{Error: err.Error()})
+		return
+	}
+	if !slices.Contains(r.config.AdminPubkeys, admin) {
+		r.limiter.Penalize(ip, 10)
+		writeManagement(w, http.StatusForbidden, managementResponse{Error: "forbidden: the pubkey is not an admin"})
+		return
+	}
+
+	var request managementRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeManagement(w, http.StatusBadRequest, managementResponse{Error: "invalid JSON-RPC request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := r.manage(ctx, request)
+	switch {
+	case errors.Is(err, errInvalidParams):
+		writeManagement(w, http.StatusOK, managementResponse{Error: err.Error()})
+
+	case err != nil:
+		slog.Error("relay: failed to run management method", "error", err, "method", request.Method, "admin", admin)
+		writeManagement(w, http.StatusInternalServerError, managementResponse{Error: ErrInternal.Error()})
+
+	default:
+		slog.Info("relay: management method", "method", request.Method, "params", len(request.Params), "admin", admin)
+		writeManagement(w, http.StatusOK, managementResponse{Result: result})
+	}
+}
+
+func writeManagement(w http.ResponseWriter, status int, response managementResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("relay: failed to encode management response", "error", err)
+	}
+}
+
+// manage runs the NIP-86 method of the request. Invalid or unknown methods and params return [errInvalidParams].
+func (r *T) manage(ctx context.Context, request managementRequest) (any, error) {
+	params := request.Params
+
+	switch request.Method {
+	case "supportedmethods":
+		return managementMethods, nil
+
+	case "banpubkey", "allowpubkey":
+		pubkey, err := pubkeyParam(params)
+		if err != nil {
+			return nil, err
+		}
+
+		status, tier := models.StatusBlocked, rate.Authenticated
+		if request.Method == "allowpubkey" {
+			status, tier = models.StatusAllowed, rate.Publisher
+		}
+
+		policy := models.Policy{
+			Entity:    models.Entity{ID: pubkey, Platform: models.PlatformNostr},
+			Status:    status,
+			Reason:    optionalParam(params, 1),
+			AddedBy:   "nip86",
+			CreatedAt: time.Now().UTC(),
+		}
+		if err := r.defender.SetPolicy(ctx, policy); err != nil {
+			return nil, fmt.Errorf("failed to set policy: %w", err)
+		}
+
+		if r.limiter.TierOf(pubkey) != rate.Indexer {
+			r.limiter.SetTier(pubkey, tier)
+		}
+
+		// the HTTP endpoints stop serving the pubkey without waiting for the next refresh
+		if status == models.StatusBlocked {
+			r.blocked.Add(pubkey)
+		} else {
+			r.blocked.Remove(pubkey)
+		}
+		return true, nil
+
+	case "listbannedpubkeys", "listallowedpubkeys":
+		status := models.StatusBlocked
+		if request.Method == "listallowedpubkeys" {
+			status = models.StatusAllowed
+		}
+
+		policies, err := r.defender.ListPolicies(ctx, models.PlatformNostr, status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list policies: %w", err)
+		}
+
+		pubkeys := make([]pubkeyReason, len(policies))
+		for i, p := range policies {
+			pubkeys[i] = pubkeyReason{Pubkey: p.Entity.ID, Reason: p.Reason}
+		}
+		return pubkeys, nil
+
+	case "banevent":
+		ID, err := eventIDParam(params)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.store.BanEvent(ctx, ID, optionalParam(params, 1)); err != nil {
+			return nil, err
+		}
+		return true, nil
+
+	case "allowevent":
+		ID, err := eventIDParam(params)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.store.UnbanEvent(ctx, ID); err != nil {
+			return nil, err
+		}
+		if _, err := r.store.RemoveTombstone(ctx, ID); err != nil {
+			return nil, err
+		}
+		return true, nil
+
+	case "listbannedevents":
+		banned, err := r.store.BannedEvents(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		IDs := make([]eventReason, len(banned))
+		for i, b := range banned {
+			IDs[i] = eventReason{ID: b.ID, Reason: b.Reason}
+		}
+		return IDs, nil
+
+	case "allowkind", "disallowkind":
+		kind, err := kindParam(params)
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Method == "allowkind" {
+			r.allowedKinds.Allow(kind)
+		} else {
+			r.allowedKinds.Disallow(kind)
+		}
+		return true, nil
+
+	case "listallowedkinds":
+		return r.allowedKinds.List(), nil
+
+	case "listeventsneedingmoderation":
+		return r.eventsNeedingModeration(ctx)
+
+	default:
+		return nil, fmt.Errorf("%w: unsupported method %q", errInvalidParams, request.Method)
+	}
+}
+
+// eventsNeedingModeration returns the events rejected for a certificate conflict that is waiting for an admin decision,
+// followed by the stored events reported by other pubkeys, the most reported first.
+func (r *T) eventsNeedingModeration(ctx context.Context) ([]eventReason, error) {
+	conflicts, err := r.store.CertificateConflicts(ctx, store.ConflictPending, maxModerationEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	reported, err := r.store.ReportedEvents(ctx, maxModerationEvents-len(conflicts))
+	if err != nil {
+		return nil, err
+	}
+
+	IDs := make([]eventReason, 0, len(conflicts)+len(reported))
+	for _, c := range conflicts {
+		reason := fmt.Sprintf("certificate conflict: the APK certificate is already used by %s for %s", c.OwnerPubkey, c.OwnerAppID)
+		IDs = append(IDs, eventReason{ID: c.EventID, Reason: reason})
+	}
+	for _, e := range reported {
+		IDs = append(IDs, eventReason{ID: e.ID, Reason: fmt.Sprintf("reported by %d pubkeys", e.Reporters)})
+	}
+	return IDs, nil
+}
+
+// stringParam returns the string param at index i.
+func stringParam(params []json.RawMessage, i int) (string, error) {
+	if i >= len(params) {
+		return "", fmt.Errorf("%w: missing param %d", errInvalidParams, i)
+	}
+
+	var s string
+	if err := json.Unmarshal(params[i], &s); err != nil {
+		return "", fmt.Errorf("%w: param %d must be a string", errInvalidParams, i)
+	}
+	return s, nil
+}
+
+// optionalParam returns the string param at index i, or "" if missing or invalid. It's used for the reasons.
+func optionalParam(params []json.RawMessage, i int) string {
+	s, _ := stringParam(params, i)
+	return s
+}
+
+// pubkeyParam returns the hex pubkey of the first param.
+func pubkeyParam(params []json.RawMessage) (string, error) {
+	pubkey, err := stringParam(params, 0)
+	if err != nil {
+		return "", err
+	}
+	if !nostr.IsValidPublicKey(pubkey) {
+		return "", fmt.Errorf("%w: invalid pubkey %q", errInvalidParams, pubkey)
+	}
+	return pubkey, nil
+}
+
+// eventIDParam returns the hex event ID of the first param.
+func eventIDParam(params []json.RawMessage) (string, error) {
+	ID, err := stringParam(params, 0)
+	if err != nil {
+		return "", err
+	}
+	if !nostr.IsValid32ByteHex(ID) {
+		return "", fmt.Errorf("%w: invalid event ID %q", errInvalidParams, ID)
+	}
+	return ID, nil
+}
+
+// kindParam returns the kind of the first param.
+func kindParam(params []json.RawMessage) (int, error) {
+	if len(params) == 0 {
+		return 0, fmt.Errorf("%w: missing param 0", errInvalidParams)
+	}
+
+	var kind int
+	if err := json.Unmarshal(params[0], &kind); err != nil || kind < 0 || kind > 65535 {
+		return 0, fmt.Errorf("%w: invalid kind %s", errInvalidParams, params[0])
+	}
+	return kind, nil
+}
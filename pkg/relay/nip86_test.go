@@ -0,0 +1,281 @@
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// testManagementRelay returns the relay of [testEndpointsRelay], administered by alice,
+// with a pending certificate conflict and a reported asset.
+func testManagementRelay(t *testing.T) *T {
+	relay := testEndpointsRelay(t)
+	relay.config.AdminPubkeys = []string{alice}
+	relay.defender = testDefender(t, mallory)
+	relay.allowedKinds = newAllowedKinds([]int{events.KindApp, events.KindRelease})
+
+	ctx := context.Background()
+	conflict := store.CertificateConflict{
+		CertificateHash: "cert",
+		Pubkey:          mallory,
+		AppID:           "com.example.app",
+		EventID:         "impersonation",
+		OwnerPubkey:     alice,
+		OwnerAppID:      "com.example.app",
+	}
+	if err := relay.store.SaveCertificateConflict(ctx, conflict); err != nil {
+		t.Fatalf("failed to save conflict: %v", err)
+	}
+
+	report := &nostr.Event{ID: "report", PubKey: mallory, CreatedAt: 1700000000, Kind: events.KindReport, Tags: nostr.Tags{{"e", "com.example.app-asset", "spam"}}}
+	if _, err := relay.store.Save(ctx, report); err != nil {
+		t.Fatalf("failed to save report: %v", err)
+	}
+	return relay
+}
+
+// managementRequestOf returns a NIP-86 request with the body, authorized with NIP-98 by the secret key for the url.
+func managementRequestOf(t *testing.T, sk, url, body string) *http.Request {
+	hash := sha256.Sum256([]byte(body))
+	auth := nostr.Event{
+		Kind:      KindHTTPAuth,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"u", url}, {"method", http.MethodPost}, {"payload", hex.EncodeToString(hash[:])}},
+	}
+	if err := auth.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	raw, _ := json.Marshal(auth)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", ManagementContentType)
+	req.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(raw))
+	return req
+}
+
+func TestServeManagement(t *testing.T) {
+	relay := testManagementRelay(t)
+	supported := `{"method":"supportedmethods","params":[]}`
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+		error  string
+	}{
+		{
+			name: "wrong method",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Content-Type", ManagementContentType)
+				return req
+			},
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name: "unauthenticated",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(supported))
+				req.Header.Set("Content-Type", ManagementContentType)
+				return req
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "relay URL without path",
+			req:    func() *http.Request { return managementRequestOf(t, aliceSK, "https://relay.example.com", supported) },
+			status: http.StatusOK,
+		},
+		{
+			name:   "relay URL with path",
+			req:    func() *http.Request { return managementRequestOf(t, aliceSK, "wss://relay.example.com/", supported) },
+			status: http.StatusOK,
+		},
+		{
+			name:   "other host",
+			req:    func() *http.Request { return managementRequestOf(t, aliceSK, "https://other.example.com", supported) },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "other payload",
+			req: func() *http.Request {
+				req := managementRequestOf(t, aliceSK, "https://relay.example.com", supported)
+				req.Body = http.NoBody
+				return req
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "not an admin",
+			req: func() *http.Request {
+				return managementRequestOf(t, nostr.GeneratePrivateKey(), "https://relay.example.com", supported)
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "invalid JSON",
+			req: func() *http.Request {
+				return managementRequestOf(t, aliceSK, "https://relay.example.com", `{"method":`)
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "unsupported method",
+			req: func() *http.Request {
+				return managementRequestOf(t, aliceSK, "https://relay.example.com", `{"method":"stats"}`)
+			},
+			status: http.StatusOK,
+			error:  `invalid params: unsupported method "stats"`,
+		},
+		{
+			name: "invalid params",
+			req: func() *http.Request {
+				return managementRequestOf(t, aliceSK, "https://relay.example.com", `{"method":"banpubkey","params":["alice"]}`)
+			},
+			status: http.StatusOK,
+			error:  `invalid params: invalid pubkey "alice"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := serve(relay, test.req())
+			if status != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, status, body)
+			}
+			if status == http.StatusMethodNotAllowed {
+				return
+			}
+
+			var res managementResponse
+			if err := json.Unmarshal([]byte(body), &res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if status == http.StatusOK && res.Error != test.error {
+				t.Errorf("expected the error %q, got %q", test.error, res.Error)
+			}
+		})
+	}
+}
+
+func TestManage(t *testing.T) {
+	relay := testManagementRelay(t)
+	ctx := context.Background()
+	bob := mustPublicKey(nostr.GeneratePrivateKey())
+	event := strings.Repeat("e", 64)
+
+	tests := []struct {
+		method string
+		params string
+		result string
+		err    error
+		check  func(t *testing.T)
+	}{
+		{method: "supportedmethods", result: mustJSON(managementMethods)},
+		{method: "listbannedpubkeys", result: `[{"pubkey":"` + mallory + `"}]`},
+		{method: "banpubkey", params: `[]`, err: errInvalidParams},
+		{
+			method: "banpubkey",
+			params: `["` + bob + `", "spam"]`,
+			result: "true",
+			check: func(t *testing.T) {
+				if !relay.blocked.Contains(bob) {
+					t.Error("expected bob to be blocked without waiting for the refresh")
+				}
+			},
+		},
+		{
+			method: "allowpubkey",
+			params: `["` + mallory + `"]`,
+			result: "true",
+			check: func(t *testing.T) {
+				if relay.blocked.Contains(mallory) {
+					t.Error("expected mallory to be unblocked without waiting for the refresh")
+				}
+			},
+		},
+		{method: "listbannedpubkeys", result: `[{"pubkey":"` + bob + `","reason":"spam"}]`},
+		{method: "listallowedpubkeys", result: `[{"pubkey":"` + mallory + `"}]`},
+		{method: "banevent", params: `["not an ID"]`, err: errInvalidParams},
+		{method: "banevent", params: `["` + event + `", "malware"]`, result: "true"},
+		{method: "listbannedevents", result: `[{"id":"` + event + `","reason":"malware"}]`},
+		{method: "allowevent", params: `["` + event + `"]`, result: "true"},
+		{method: "listbannedevents", result: `[]`},
+		{method: "allowkind", params: `["1"]`, err: errInvalidParams},
+		{method: "allowkind", params: `[70000]`, err: errInvalidParams},
+		{
+			method: "allowkind",
+			params: `[1]`,
+			result: "true",
+			check: func(t *testing.T) {
+				if !relay.allowedKinds.Contains(1) {
+					t.Error("expected kind 1 to be allowed")
+				}
+			},
+		},
+		{
+			method: "disallowkind",
+			params: `[30063]`,
+			result: "true",
+			check: func(t *testing.T) {
+				if relay.allowedKinds.Contains(events.KindRelease) {
+					t.Error("expected kind 30063 to be disallowed")
+				}
+			},
+		},
+		{method: "listallowedkinds", result: `[1,32267]`},
+		{
+			method: "listeventsneedingmoderation",
+			result: mustJSON([]eventReason{
+				{ID: "impersonation", Reason: "certificate conflict: the APK certificate is already used by " + alice + " for com.example.app"},
+				{ID: "com.example.app-asset", Reason: "reported by 1 pubkeys"},
+			}),
+		},
+		{method: "unknown", err: errInvalidParams},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			request := managementRequest{Method: test.method}
+			if test.params != "" {
+				if err := json.Unmarshal([]byte(test.params), &request.Params); err != nil {
+					t.Fatalf("invalid params: %v", err)
+				}
+			}
+
+			result, err := relay.manage(ctx, request)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := mustJSON(result); got != test.result {
+				t.Errorf("expected the result %s, got %s", test.result, got)
+			}
+			if test.check != nil {
+				test.check(t)
+			}
+		})
+	}
+}
+
+func mustJSON(v any) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(raw)
+}
@@ -0,0 +1,107 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+// KindHTTPAuth is the kind of NIP-98 HTTP authorization events.
+const KindHTTPAuth = 27235
+
+// maxAuthSkew is the maximum difference between the created_at of a NIP-98 event and the current time.
+const maxAuthSkew = time.Minute
+
+var ErrUnauthorized = errors.New("unauthorized: missing or invalid NIP-98 'Authorization' header")
+
+// authenticateHTTP validates the NIP-98 authorization header of the request and returns the pubkey
+// that signed it. The event must be a recent kind 27235, whose 'u' tag is the requested URL on the
+// relay hostname, and whose 'method' tag is the request method.
+func authenticateHTTP(req *http.Request, hostname string) (string, error) {
+	event, err := authorizationEvent(req, hostname)
+	if err != nil {
+		return "", err
+	}
+	return event.PubKey, nil
+}
+
+// authenticateHTTPPayload is like [authenticateHTTP], but it also requires the 'payload' tag of the event
+// to be the hex sha256 of the request body, so that the authorization can't be replayed with a different body.
+func authenticateHTTPPayload(req *http.Request, hostname string, body []byte) (string, error) {
+	event, err := authorizationEvent(req, hostname)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(body)
+	payload, _ := events.Find(event.Tags, "payload")
+	if !strings.EqualFold(payload, hex.EncodeToString(hash[:])) {
+		return "", fmt.Errorf("%w: 'payload' tag doesn't match the sha256 of the request body", ErrUnauthorized)
+	}
+	return event.PubKey, nil
+}
+
+// authorizationEvent returns the NIP-98 event of the authorization header of the request, after validating it.
+func authorizationEvent(req *http.Request, hostname string) (*nostr.Event, error) {
+	header := req.Header.Get("Authorization")
+	encoded, ok := strings.CutPrefix(header, "Nostr ")
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid base64", ErrUnauthorized)
+	}
+
+	var event nostr.Event
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return nil, fmt.Errorf("%w: invalid event JSON", ErrUnauthorized)
+	}
+	if event.Kind != KindHTTPAuth {
+		return nil, fmt.Errorf("%w: event must be of kind %d", ErrUnauthorized, KindHTTPAuth)
+	}
+
+	skew := time.Since(event.CreatedAt.Time())
+	if skew > maxAuthSkew || skew < -maxAuthSkew {
+		return nil, fmt.Errorf("%w: event created_at is too far from the current time", ErrUnauthorized)
+	}
+
+	method, _ := events.Find(event.Tags, "method")
+	if !strings.EqualFold(method, req.Method) {
+		return nil, fmt.Errorf("%w: 'method' tag doesn't match the request method", ErrUnauthorized)
+	}
+
+	u, _ := events.Find(event.Tags, "u")
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Hostname() != hostname || rootPath(parsed.Path) != rootPath(req.URL.Path) {
+		return nil, fmt.Errorf("%w: 'u' tag doesn't match the request URL", ErrUnauthorized)
+	}
+
+	if !event.CheckID() {
+		return nil, fmt.Errorf("%w: invalid event ID", ErrUnauthorized)
+	}
+	if ok, err := event.CheckSignature(); err != nil || !ok {
+		return nil, fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+	}
+	return &event, nil
+}
+
+// rootPath returns the path, or "/" if empty, because clients sign the relay URL without a trailing slash
+// (e.g. NIP-86 requests to "https://relay.example.com"), while the request always has one.
+func rootPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
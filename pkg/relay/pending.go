@@ -0,0 +1,212 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// PendingPath is the path of the HTTP endpoint where publishers list their pending events,
+// authenticated with NIP-98.
+const PendingPath = "/v1/pending"
+
+// KindPendingNotice is the kind of the ephemeral events signed by the relay to notify a publisher
+// that one of their pending events has been promoted, has expired or has been rejected.
+// Publishers receive them by subscribing to {"kinds": [23063], "#p": [<their pubkey>]}, after authenticating
+// as that pubkey with NIP-42. Subscriptions asking for the notices of other pubkeys are rejected.
+const KindPendingNotice = 23063
+
+var ErrPendingNoticeUnauthed = errors.New("auth-required: pending notices are only sent to the publishers authenticated as their 'p' tag")
+
+// Outcomes of the readiness checks of pending events, as reported by [PendingPath].
+const (
+	outcomeBlobMissing   = "blob not found in blossom nor at any 'url' tag"
+	outcomeCheckFailed   = "check failed: "
+	outcomeAssetsMissing = "waiting for the assets "
+)
+
+// Statuses reported by [KindPendingNotice] events.
+const (
+	noticePromoted = "promoted"
+	noticeExpired  = "expired"
+	noticeRejected = "rejected"
+)
+
+// pendingStatus is a pending event, as returned by [PendingPath].
+type pendingStatus struct {
+	ID            string `json:"id"`
+	Kind          int    `json:"kind"`
+	Hash          string `json:"hash"`
+	ReceivedAt    int64  `json:"received_at"`
+	ExpiresAt     int64  `json:"expires_at"`
+	LastCheckedAt int64  `json:"last_checked_at,omitempty"`
+	LastOutcome   string `json:"last_outcome,omitempty"`
+}
+
+// servePending serves GET /v1/pending
+//
+// Request:  NIP-98 'Authorization' header signed by the publisher.
+// Response: {"pending": [{"id", "kind", "hash", "received_at", "expires_at", "last_checked_at", "last_outcome"}, ...]}
+//
+// Only the pending events of the authenticated pubkey are returned, most recent first.
+// Events that are neither promoted nor listed here have expired after [Config.RemovePendingAfter].
+func (r *T) servePending(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := rely.GetIP(req).Group()
+	if !r.limiter.Allow(ip, 1.0) {
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	pubkey, err := authenticateHTTP(req, r.config.Hostname)
+	if err != nil {
+		r.limiter.Penalize(ip, 10)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	defer cancel()
+
+	pending, err := r.store.PendingByPubkey(ctx, pubkey)
+	if err != nil {
+		slog.Error("relay: failed to query pending events", "error", err, "pubkey", pubkey)
+		http.Error(w, ErrInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	statuses := make([]pendingStatus, len(pending))
+	for i, p := range pending {
+		statuses[i] = pendingStatus{
+			ID:          p.ID,
+			Kind:        p.Kind,
+			Hash:        p.Hash,
+			ReceivedAt:  p.ReceivedAt.Unix(),
+			ExpiresAt:   p.ReceivedAt.Add(r.config.RemovePendingAfter).Unix(),
+			LastOutcome: p.LastOutcome,
+		}
+		if !p.LastCheckedAt.IsZero() {
+			statuses[i].LastCheckedAt = p.LastCheckedAt.Unix()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"pending": statuses}); err != nil {
+		slog.Error("relay: failed to encode pending events", "error", err)
+	}
+}
+
+// PendingNoticesUnauthed rejects the filters asking for the pending notices signed by the relay for pubkeys
+// the client is not authenticated as, meaning the filters whose kinds include [KindPendingNotice] without a '#p' tag
+// of only its authenticated pubkeys. Unauthenticated clients are sent an AUTH challenge.
+//
+// Filters without kinds are not rejected, so that generic queries like {"#p": [<pubkey>]} keep working.
+func PendingNoticesUnauthed(signer string) func(c rely.Client, _ string, filters nostr.Filters) error {
+	return func(c rely.Client, _ string, filters nostr.Filters) error {
+		for _, filter := range filters {
+			if !asksForNotices(filter, signer) {
+				continue
+			}
+
+			recipients := filter.Tags["p"]
+			if len(recipients) > 0 && !slices.ContainsFunc(recipients, func(pk string) bool {
+				return !slices.Contains(c.Pubkeys(), pk)
+			}) {
+				continue
+			}
+
+			if !c.IsAuthed() {
+				c.SendAuth()
+			}
+			return ErrPendingNoticeUnauthed
+		}
+		return nil
+	}
+}
+
+// asksForNotices returns whether the filter explicitly asks for [KindPendingNotice] events
+// and may match the ones signed by the signer, whose tags are 'p', 'e', 'status' and 'x'.
+func asksForNotices(filter nostr.Filter, signer string) bool {
+	if !slices.Contains(filter.Kinds, KindPendingNotice) {
+		return false
+	}
+	if len(filter.IDs) > 0 {
+		return false
+	}
+	if len(filter.Authors) > 0 && !slices.Contains(filter.Authors, signer) {
+		return false
+	}
+	for key := range filter.Tags {
+		if !slices.Contains([]string{"p", "e", "status", "x"}, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// notifyPending broadcasts a [KindPendingNotice] signed by the relay, telling the publisher of the
+// pending event that it has been promoted, has expired or has been rejected.
+// It's a no-op if [Config.SecretKey] is not set.
+func (r *T) notifyPending(p store.PendingEvent, status string) {
+	if r.config.SecretKey == "" {
+		return
+	}
+
+	content := "your event " + p.ID + " has been published"
+	switch status {
+	case noticeExpired:
+		content = "your event " + p.ID + " has been removed because the referenced blob was not uploaded in time"
+		if p.Kind == events.KindRelease {
+			content = "your event " + p.ID + " has been removed because the referenced assets were not published in time"
+		}
+	case noticeRejected:
+		content = "your event " + p.ID + " has been removed because it was rejected by the relay"
+	}
+
+	notice := &nostr.Event{
+		Kind:      KindPendingNotice,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"p", p.Pubkey},
+			{"e", p.ID},
+			{"status", status},
+		},
+		Content: content,
+	}
+	if p.Hash != "" {
+		notice.Tags = append(notice.Tags, nostr.Tag{"x", p.Hash})
+	}
+
+	if err := notice.Sign(r.config.SecretKey); err != nil {
+		slog.Error("relay: failed to sign pending notice", "event", p.ID, "error", err)
+		return
+	}
+	if err := r.server.Broadcast(notice); err != nil {
+		slog.Error("relay: failed to broadcast pending notice", "event", p.ID, "error", err)
+	}
+}
+
+// dropPending removes a pending event that was rejected by the checks when it was about to be promoted,
+// and notifies its publisher.
+func (r *T) dropPending(ctx context.Context, p store.PendingEvent, reason error) error {
+	slog.Warn("relay: dropping rejected pending event", "event", p.ID, "reason", reason)
+	if err := r.store.DeletePending(ctx, p.ID); err != nil {
+		return fmt.Errorf("failed to delete pending event %s: %w", p.ID, err)
+	}
+	r.notifyPending(p, noticeRejected)
+	return nil
+}
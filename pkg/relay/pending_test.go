@@ -0,0 +1,128 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+func TestPendingNoticesUnauthed(t *testing.T) {
+	reject := PendingNoticesUnauthed("relay")
+	notices := []int{KindPendingNotice}
+
+	tests := []struct {
+		name     string
+		client   *client
+		filters  nostr.Filters
+		rejected bool
+	}{
+		{name: "own notices", client: &client{pubkeys: []string{"alice"}}, filters: nostr.Filters{{Kinds: notices, Tags: nostr.TagMap{"p": {"alice"}}}}},
+		{name: "notices of others", client: &client{pubkeys: []string{"alice"}}, filters: nostr.Filters{{Kinds: notices, Tags: nostr.TagMap{"p": {"alice", "bob"}}}}, rejected: true},
+		{name: "unauthenticated", client: &client{}, filters: nostr.Filters{{Kinds: notices, Tags: nostr.TagMap{"p": {"alice"}}}}, rejected: true},
+		{name: "all notices", client: &client{pubkeys: []string{"alice"}}, filters: nostr.Filters{{Kinds: notices}}, rejected: true},
+		{name: "notices of the relay", client: &client{}, filters: nostr.Filters{{Kinds: notices, Authors: []string{"relay"}}}, rejected: true},
+		{name: "notices among other kinds", client: &client{}, filters: nostr.Filters{{Kinds: []int{events.KindApp}}, {Kinds: []int{events.KindApp, KindPendingNotice}}}, rejected: true},
+		{name: "notices of other authors", client: &client{}, filters: nostr.Filters{{Kinds: notices, Authors: []string{"alice"}}}},
+		{name: "notices by ID", client: &client{}, filters: nostr.Filters{{Kinds: notices, IDs: []string{"abc"}}}},
+		{name: "notices with other tags", client: &client{}, filters: nostr.Filters{{Kinds: notices, Tags: nostr.TagMap{"d": {"com.example.app"}}}}},
+		{name: "all events of the relay", client: &client{}, filters: nostr.Filters{{Authors: []string{"relay"}}}},
+		{name: "everything", client: &client{}, filters: nostr.Filters{{Kinds: []int{events.KindApp}}, {Limit: 10}}},
+		{name: "mentions", client: &client{}, filters: nostr.Filters{{Tags: nostr.TagMap{"p": {"alice"}}}}},
+		{name: "references", client: &client{}, filters: nostr.Filters{{Tags: nostr.TagMap{"e": {"abc"}}}}},
+		{name: "other kinds", client: &client{}, filters: nostr.Filters{{Kinds: []int{events.KindApp, events.KindRelease}}}},
+		{name: "other authors", client: &client{}, filters: nostr.Filters{{Authors: []string{"alice"}}}},
+		{name: "IDs", client: &client{}, filters: nostr.Filters{{IDs: []string{"abc"}}}},
+		{name: "other tags", client: &client{}, filters: nostr.Filters{{Tags: nostr.TagMap{"d": {"com.example.app"}}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := reject(test.client, "sub", test.filters)
+			if rejected := errors.Is(err, ErrPendingNoticeUnauthed); rejected != test.rejected {
+				t.Fatalf("expected rejected %t, got %v", test.rejected, err)
+			}
+			if challenged := test.rejected && !test.client.IsAuthed(); test.client.challenged != challenged {
+				t.Errorf("expected challenged %t, got %t", challenged, test.client.challenged)
+			}
+		})
+	}
+}
+
+func TestPromoteRechecks(t *testing.T) {
+	db, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	relay := &T{
+		config: NewConfig(),
+		store:  db,
+		server: rely.NewRelay(),
+		checks: []func(rely.Client, *nostr.Event) error{EventBanned(db), Tombstoned(db)},
+	}
+
+	hash := strings.Repeat("f", 64)
+	asset := func(ID string) *nostr.Event {
+		return &nostr.Event{ID: ID, PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindAsset, Tags: nostr.Tags{
+			{"i", "com.example.app"}, {"version", "1.0"}, {"x", hash}}}
+	}
+	release := func(ID, d string) *nostr.Event {
+		return &nostr.Event{ID: ID, PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindRelease, Tags: nostr.Tags{
+			{"d", d}, {"i", "com.example.app"}, {"version", "1.0"}, {"c", "main"}, {"e", "asset"}}}
+	}
+
+	pending := []*nostr.Event{
+		asset("asset"),
+		asset("tombstoned-asset"),
+		release("release", "com.example.app@1.0"),
+		release("tombstoned-release", "com.example.app@0.9"),
+	}
+	for _, e := range pending {
+		if _, err := db.SavePending(ctx, e); err != nil {
+			t.Fatalf("SavePending: %v", err)
+		}
+	}
+
+	// the operator deletes an asset and a release while they are pending
+	deletion := &nostr.Event{ID: "deletion", Kind: nostr.KindDeletion, Tags: nostr.Tags{
+		{"e", "tombstoned-asset"},
+		{"a", "30063:alice:com.example.app@0.9"},
+	}}
+	if _, err := db.ForceDeleteRequest(ctx, deletion); err != nil {
+		t.Fatalf("ForceDeleteRequest: %v", err)
+	}
+
+	if err := relay.promote(ctx, hash); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+
+	stored, err := db.Query(ctx, nostr.Filter{Limit: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	IDs := []string{}
+	for _, e := range stored {
+		IDs = append(IDs, e.ID)
+	}
+	slices.Sort(IDs)
+	if want := []string{"asset", "release"}; !slices.Equal(IDs, want) {
+		t.Errorf("expected the stored events %v, got %v", want, IDs)
+	}
+
+	left, err := db.QueryPendingByIDs(ctx, "asset", "tombstoned-asset", "release", "tombstoned-release")
+	if err != nil {
+		t.Fatalf("QueryPendingByIDs: %v", err)
+	}
+	if len(left) != 0 {
+		t.Errorf("expected no pending events left, got %d", len(left))
+	}
+}
@@ -0,0 +1,56 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// popularityWindow is how far back the downloads and impressions are counted in the popularity of the apps.
+const popularityWindow = 30 * 24 * time.Hour
+
+// runPopularity refreshes the popularity of the apps at startup and then periodically.
+// The analytics live in a separate database, so they are copied in the relay database to be used in searches.
+func (r *T) runPopularity(ctx context.Context) {
+	if err := r.refreshPopularity(ctx); err != nil {
+		slog.Error("relay: failed to refresh popularity", "error", err)
+	}
+
+	ticker := time.NewTicker(r.config.PopularityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := r.refreshPopularity(ctx); err != nil {
+				slog.Error("relay: failed to refresh popularity", "error", err)
+			}
+		}
+	}
+}
+
+func (r *T) refreshPopularity(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	counts, err := r.analytics.Popularity(ctx, time.Now().Add(-popularityWindow))
+	if err != nil {
+		return fmt.Errorf("failed to query popularity: %w", err)
+	}
+
+	apps := make([]store.Popularity, len(counts))
+	for i, c := range counts {
+		apps[i] = store.Popularity{
+			AppID:       c.AppID,
+			Pubkey:      c.AppPubkey,
+			Downloads:   c.Downloads,
+			Impressions: c.Impressions,
+		}
+	}
+	return r.store.SavePopularity(ctx, apps)
+}
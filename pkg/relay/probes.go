@@ -0,0 +1,144 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// probeInterval is how often due probes are checked, in addition to when new ones are scheduled.
+	probeInterval = 10 * time.Second
+
+	// probeBatch is the maximum number of HEAD requests made per probing round.
+	probeBatch = 50
+
+	// probeBackoffMin and probeBackoffMax bound the delay before retrying a url whose HEAD request failed.
+	// The delay doubles on every consecutive failure.
+	probeBackoffMin = 30 * time.Second
+	probeBackoffMax = 30 * time.Minute
+)
+
+// assetChecker is used exclusively for the HEAD requests of the url probes.
+var assetChecker = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     30 * time.Second,
+	},
+}
+
+// scheduleProbes schedules the HEAD requests to the urls that should serve the blob with the given hash,
+// and wakes up [T.runProbes].
+func (r *T) scheduleProbes(ctx context.Context, hash string, urls []string) error {
+	urls = slices.DeleteFunc(urls, func(url string) bool {
+		// skip URLs from the zapstore CDN, because they would have been already in the blossom db
+		return strings.HasPrefix(url, "https://cdn.zapstore.dev")
+	})
+	if len(urls) == 0 {
+		return nil
+	}
+
+	if err := r.store.ScheduleProbes(ctx, hash, urls); err != nil {
+		return err
+	}
+
+	select {
+	case r.probes <- struct{}{}:
+	default:
+		// a probing round is already scheduled
+	}
+	return nil
+}
+
+// runProbes makes the HEAD requests of the due url probes, and promotes the pending events
+// whose blob is found. Failed probes are retried with an exponential backoff.
+func (r *T) runProbes(ctx context.Context) {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+		case <-r.probes:
+		}
+
+		err := r.probe(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("probe failed", "error", err)
+		}
+	}
+}
+
+// probe makes the HEAD requests of a batch of due url probes.
+func (r *T) probe(ctx context.Context) error {
+	probes, err := r.store.DueProbes(ctx, time.Now(), probeBatch)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, p := range probes {
+		p.Available, p.LastStatus = head(ctx, p.URL)
+		p.CheckedAt = time.Now().UTC()
+
+		if p.Available {
+			p.Failures = 0
+		} else {
+			p.Failures++
+			p.NextCheckAt = p.CheckedAt.Add(backoff(p.Failures))
+		}
+
+		if err := r.store.SaveProbe(ctx, p); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if !p.Available {
+			outcome := fmt.Sprintf("%sHEAD %s: %s", outcomeCheckFailed, p.URL, p.LastStatus)
+			if err := r.store.SetPendingOutcome(ctx, p.Hash, outcome); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if err := r.promote(ctx, p.Hash); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// head makes a HEAD request to the url, and returns whether it succeeded with its status or error.
+func head(ctx context.Context, url string) (bool, string) {
+	ctx, cancel := context.WithTimeout(ctx, assetChecker.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, "malformed url"
+	}
+
+	res, err := assetChecker.Do(req)
+	if err != nil {
+		return false, err.Error()
+	}
+	res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 300, res.Status
+}
+
+// backoff returns the delay before the next HEAD request of a url that failed the given number of times in a row.
+func backoff(failures int) time.Duration {
+	delay := probeBackoffMin
+	for i := 1; i < failures && delay < probeBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, probeBackoffMax)
+}
@@ -0,0 +1,243 @@
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/apk"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// certificateFetcher is used exclusively for the range requests that read the APK Signing Block
+// of the assets linked to identity proofs. The 'url' tags are chosen by the publishers, so it only
+// follows HTTPS redirects and refuses to connect to non-public addresses, see [publicAddressOnly].
+var certificateFetcher = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: publicAddressOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return errCertificateURL
+		}
+		if len(via) >= 5 {
+			return errors.New("stopped after 5 redirects")
+		}
+		return nil
+	},
+}
+
+var (
+	errCertificateURL     = errors.New("the certificate can only be fetched from an HTTPS URL")
+	errCertificateAddress = errors.New("the certificate can only be fetched from a public address")
+)
+
+// publicAddressOnly is a [net.Dialer] control function that refuses to connect to loopback, private,
+// link-local, multicast and unspecified addresses. It checks the resolved address right before connecting,
+// so it can't be bypassed with DNS rebinding.
+func publicAddressOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", errCertificateAddress, ip)
+	}
+	return nil
+}
+
+// proofsInterval is the interval at which the identity proofs that haven't verified their certificate are retried.
+const proofsInterval = time.Hour
+
+// handleProof verifies an identity proof that has just been saved.
+// Proofs with a 'certificate' tag are verified immediately, as they passed [IdentityProofInvalid] already.
+// The others are verified asynchronously, because the certificate must be fetched from the APKs of their linked assets.
+func (r *T) handleProof(ctx context.Context, proof *nostr.Event) {
+	if _, ok := events.Find(proof.Tags, "certificate"); !ok {
+		r.enqueueProof(*proof)
+		return
+	}
+
+	if err := r.verifyProof(ctx, proof); err != nil {
+		slog.Error("relay: failed to verify identity proof", "event", proof.ID, "error", err)
+	}
+}
+
+func (r *T) enqueueProof(proof nostr.Event) {
+	select {
+	case r.proofJobs <- proof:
+	default:
+		slog.Warn("identity proof queue is full, the proof will be verified later", "event", proof.ID)
+	}
+}
+
+// runProofWorker verifies the queued identity proofs. At startup and then periodically, it also retries
+// the proofs that haven't verified their certificate, like the ones dropped because the queue was full.
+func (r *T) runProofWorker(ctx context.Context) {
+	r.retryProofs(ctx)
+
+	ticker := time.NewTicker(proofsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case proof := <-r.proofJobs:
+			if err := r.verifyProof(ctx, &proof); err != nil {
+				slog.Warn("identity proof verification failed", "event", proof.ID, "pubkey", proof.PubKey, "error", err)
+			}
+
+		case <-ticker.C:
+			r.retryProofs(ctx)
+		}
+	}
+}
+
+func (r *T) retryProofs(ctx context.Context) {
+	proofs, err := r.store.UnverifiedProofs(ctx)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			slog.Error("relay: failed to query unverified identity proofs", "error", err)
+		}
+		return
+	}
+
+	for _, proof := range proofs {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := r.verifyProof(ctx, &proof); err != nil {
+			slog.Warn("identity proof verification failed", "event", proof.ID, "pubkey", proof.PubKey, "error", err)
+		}
+	}
+}
+
+// verifyProof verifies the identity proof against the certificate in its 'certificate' tag or,
+// if absent, against the certificate of the APKs of the publisher's assets signed with it.
+// Valid proofs make the publisher a verified developer of the certificate's apps, while invalid ones are deleted.
+func (r *T) verifyProof(ctx context.Context, event *nostr.Event) error {
+	proof, err := events.ParseIdentityProof(event)
+	if err != nil {
+		return err
+	}
+
+	cert, err := proof.ParseCertificate()
+	if err != nil {
+		return err
+	}
+
+	if cert == nil {
+		cert, err = r.linkedCertificate(ctx, event.PubKey, proof.CertHash)
+		if err != nil {
+			// the proof is kept, because it's unknown whether it's invalid
+			return fmt.Errorf("failed to fetch the certificate: %w", err)
+		}
+	}
+
+	if err := proof.Verify(event.PubKey, cert); err != nil {
+		if _, err := r.store.Delete(ctx, nostr.Filter{IDs: []string{event.ID}}); err != nil {
+			slog.Error("relay: failed to delete invalid identity proof", "event", event.ID, "error", err)
+		}
+		return err
+	}
+
+	verified := store.VerifiedCertificate{
+		CertificateHash: proof.CertHash,
+		Pubkey:          event.PubKey,
+		ProofID:         event.ID,
+		ExpiresAt:       time.Unix(proof.Expiry, 0),
+		VerifiedAt:      time.Now(),
+	}
+	if err := r.store.SaveVerifiedCertificate(ctx, verified); err != nil {
+		return err
+	}
+
+	slog.Info("identity proof verified", "event", event.ID, "pubkey", event.PubKey, "certificate", proof.CertHash)
+	return nil
+}
+
+// linkedCertificate returns the certificate with the given hash, taken from the APK Signing Block
+// of one of the assets of the pubkey signed with it. The APKs are read from the HTTPS 'url' tags of the assets.
+func (r *T) linkedCertificate(ctx context.Context, pubkey, hash string) (*x509.Certificate, error) {
+	filter := nostr.Filter{
+		Kinds:   []int{events.KindAsset},
+		Authors: []string{pubkey},
+		Tags:    nostr.TagMap{"apk_certificate_hash": {hash}},
+		Limit:   5,
+	}
+
+	assets, err := r.store.Query(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query linked assets: %w", err)
+	}
+
+	var errs []error
+	for _, asset := range assets {
+		for _, url := range events.FindAll(asset.Tags, "url") {
+			cert, err := fetchCertificate(ctx, url, hash)
+			if err == nil {
+				return cert, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, errors.New("no linked asset with a 'url' tag")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// fetchCertificate reads the signer certificates of the APK at the HTTPS url, and returns the one with the given hash.
+func fetchCertificate(ctx context.Context, rawURL, hash string) (*x509.Certificate, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil {
+		return nil, errCertificateURL
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	remote, err := apk.OpenRemote(ctx, certificateFetcher, parsed.String())
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := apk.SignerCertificates(remote, remote.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cert := range certs {
+		fingerprint := sha256.Sum256(cert.Raw)
+		if hex.EncodeToString(fingerprint[:]) == hash {
+			return cert, nil
+		}
+	}
+	return nil, errors.New("the APK is not signed with the certificate")
+}
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -30,13 +31,40 @@ import (
 var (
 	ErrEventKindNotAllowed = errors.New("event kind is not in the allowed list")
 	ErrEventPubkeyBlocked  = errors.New("event pubkey is not allowed. Visit https://zapstore.dev/docs/publish for more information.")
+	ErrEventExpired        = errors.New("event has expired (NIP-40)")
+	ErrEventBanned         = errors.New("event is banned by the relay operator")
+	ErrEventDeleted        = errors.New("event was deleted by the relay operator")
 
 	ErrAppAlreadyExists = errors.New(`failed to publish app: another pubkey has already published an app with the same 'd' tag identifier.
 		This is a precautionary measure because Android doesn't allow apps with the same identifier to be installed side by side.
 		Please use a different identifier or contact the Zapstore team for more information.`)
 
-	ErrTooManyFilters  = errors.New("number of filters exceed the maximum allowed per REQ")
-	ErrFiltersTooVague = errors.New("filters are too vague")
+	ErrCertificateMismatch = errors.New(`failed to publish asset: the APK is signed with a certificate that differs from the previous releases of this app.
+		Android refuses to install an update signed with a different certificate.
+		If you rotated your signing key, please contact the Zapstore team.`)
+	ErrRotationNotOperator = errors.New("certificate rotation events can only be published by the relay operator")
+
+	ErrVersionCodeRegression = errors.New("failed to publish asset: the version_code is lower than the one of a previous asset of this app")
+
+	ErrCertificateImpersonation = errors.New(`failed to publish asset: the APK is signed with a certificate that another pubkey already uses.
+		This is a precautionary measure against impersonation, and the asset is now under review by the Zapstore team.
+		If you are the developer of this app, please contact the Zapstore team.`)
+
+	ErrReleaseAssetMismatch = errors.New("failed to publish release: the referenced assets don't match its 'i' and 'version' tags")
+
+	ErrCommunityKindNotAllowed = errors.New("failed to publish in community: none of its content sections accepts this kind")
+	ErrCommunityNotMember      = errors.New(`failed to publish in community: you have no publish rights in the content sections accepting this kind.
+		Publishing requires being in one of their profile lists (kind 30000) or holding one of their badges (kind 30009).`)
+
+	ErrIdentityProofUnlinked = errors.New(`failed to publish identity proof: the proof has no 'certificate' tag, and none of your assets is signed with the certificate.
+		Please add the base64 DER of the certificate in a 'certificate' tag, or publish an asset signed with it first.`)
+
+	ErrNegentropySessions    = errors.New("blocked: too many open negentropy sessions")
+	ErrNegentropyUnsupported = errors.New("unsupported message: only NEG-OPEN, NEG-MSG and NEG-CLOSE are served on " + NegentropyPath)
+	ErrNegentropyTooBig      = errors.New("blocked: this query is too big")
+
+	ErrTooManyFilters     = errors.New("number of filters exceed the maximum allowed per REQ")
+	ErrFilterTooExpensive = errors.New("filter matches too many events, please narrow it with authors, tags, a shorter time window or IDs")
 
 	ErrInternal    = errors.New("internal error, please contact the Zapstore team.")
 	ErrRateLimited = errors.New("rate-limited: slow down chief")
@@ -74,8 +102,20 @@ type T struct {
 	blossom         Blossom
 	profileUploader ProfileUploader
 	uploads         chan upload
+	probes          chan struct{}
+	promoting       sync.Mutex
+	memberships     *memberships
+	admission       *admission
+	allowedKinds    *allowedKinds
+	negentropy      *negentropySessions
+	blocked         *pubkeySet // pubkeys blocked by the defender, refreshed by [T.runTiers]
+
+	// checks are the event reject functions, except rate-limiting.
+	// They also apply to the events that don't come from clients, like the ones downloaded by [T.Sync].
+	checks []func(rely.Client, *nostr.Event) error
 
 	profileJobs chan string
+	proofJobs   chan nostr.Event
 }
 
 type upload struct {
@@ -122,24 +162,64 @@ func Setup(
 		rely.RegistrationFailWithin(3*time.Second),
 	)
 
-	server.Reject.Event.Clear()
-	server.Reject.Event.Append(
-		RateEventIP(limiter),
-		KindNotAllowed(config.AllowedKinds),
+	indexer := config.Info.Pubkey
+	if indexer == "" {
+		indexer = indexerPubkeyFallback
+	}
+	limiter.SetTier(indexer, rate.Indexer)
+
+	memberships := newMemberships()
+	kinds := newAllowedKinds(config.AllowedKinds)
+	admission := newAdmission(config.ResponseLimit)
+	if err := refreshStatistics(context.Background(), store, admission); err != nil {
+		return nil, err
+	}
+
+	checks := []func(rely.Client, *nostr.Event) error{
+		KindNotAllowed(kinds),
 		rely.InvalidID,
+		EventBanned(store),
+		Tombstoned(store),
 		rely.InvalidSignature,
 		InvalidStructure,
+		Expired,
 		NotAnchored(store),
 		NotAllowed(defender),
+		CommunityRights(store, memberships),
 		AppOwnership(store, config.Info.Pubkey),
-	)
+		VersionCodeRegression(store, config.RewindableChannels),
+		CertificateImpersonation(store, config.Info.Pubkey),
+		CertificateContinuity(store, config.Info.Pubkey),
+		IdentityProofInvalid,
+		IdentityProofUnlinked(store),
+	}
+
+	server.Reject.Event.Clear()
+	server.Reject.Event.Append(RateEventIP(limiter))
+	server.Reject.Event.Append(checks...)
 
 	server.Reject.Req.Clear()
 	server.Reject.Req.Append(
-		RateReqIP(limiter),
+		RateReqIP(limiter, admission.Cost),
+		FiltersExceed(config.MaxReqFilters),
+		UnsupportedQuery,
+		ExpensiveFilters(admission.Cost, float64(config.MaxFilterCost)),
+	)
+
+	if config.SecretKey != "" {
+		signer, err := nostr.GetPublicKey(config.SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive the pubkey of the secret key: %w", err)
+		}
+		server.Reject.Req.Append(PendingNoticesUnauthed(signer))
+	}
+
+	server.Reject.Count.Clear()
+	server.Reject.Count.Append(
+		RateReqIP(limiter, admission.CountCost),
 		FiltersExceed(config.MaxReqFilters),
 		UnsupportedQuery,
-		VagueFilters(3),
+		ExpensiveFilters(admission.CountCost, float64(config.MaxFilterCost)),
 	)
 
 	relay := &T{
@@ -155,23 +235,98 @@ func Setup(
 		blossom:         blssm,
 		profileUploader: profileUploader,
 		uploads:         make(chan upload, 100),
+		probes:          make(chan struct{}, 1),
+		memberships:     memberships,
+		admission:       admission,
+		allowedKinds:    kinds,
+		checks:          checks,
+		negentropy:      newNegentropySessions(),
+		blocked:         newPubkeySet(),
 		profileJobs:     make(chan string, 100),
+		proofJobs:       make(chan nostr.Event, 100),
 	}
 
 	server.On.Event = relay.save
 	server.On.Req = relay.query
+	server.On.Count = relay.count
 	return relay, nil
 }
 
 // StartAndServe starts the relay, listens to the provided address and handles http requests.
+// Requests are handled by [T.ServeHTTP], which extends the rely.Relay with additional endpoints.
 func (r *T) StartAndServe(ctx context.Context, addr string) error {
 	go r.runReconcile(ctx)
+	go r.runProbes(ctx)
 	go r.runProfileWorker(ctx)
-	return r.server.StartAndServe(ctx, addr)
+	go r.runProofWorker(ctx)
+	go r.runExpirations(ctx)
+	go r.runPopularity(ctx)
+	go r.runStatistics(ctx)
+	go r.runTiers(ctx)
+	go r.runHistory(ctx)
+
+	r.server.Start(ctx)
+	exit := make(chan error, 1)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           r,
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+	// negentropy connections are hijacked, so they must be closed separately
+	server.RegisterOnShutdown(r.negentropy.Shutdown)
+
+	go func() {
+		slog.Info("serving the relay", "address", addr)
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			exit <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := server.Shutdown(ctx)
+		r.server.Wait()
+		return err
+
+	case err := <-exit:
+		return err
+	}
+}
+
+// ServeHTTP implements the [http.Handler] interface.
+// It serves NIP-77 negentropy on [NegentropyPath], the bulk update check on [UpdatesPath],
+// the publisher's pending events on [PendingPath], the stack expansion on [StacksPath],
+// the NIP-86 management API on any path with the [ManagementContentType], and delegates everything else to the rely.Relay.
+func (r *T) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == NegentropyPath && req.Header.Get("Upgrade") == "websocket":
+		r.serveNegentropy(w, req)
+
+	case req.URL.Path == UpdatesPath:
+		r.serveUpdates(w, req)
+
+	case req.URL.Path == PendingPath:
+		r.servePending(w, req)
+
+	case req.URL.Path == StacksPath:
+		r.serveStack(w, req)
+
+	case req.Header.Get("Content-Type") == ManagementContentType:
+		r.serveManagement(w, req)
+
+	default:
+		r.server.ServeHTTP(w, req)
+	}
 }
 
 // NotifyUpload notifies the relay that the upload of the blob with the given hash and mime type is complete.
-// The signal is used to trigger the reconciliation of pending events.
+// The signal is used to promote the pending events waiting on the blob.
 func (r *T) NotifyUpload(hash blossom.Hash, mime string) error {
 	select {
 	case r.uploads <- upload{hash: hash, mime: mime}:
@@ -213,101 +368,196 @@ func (r *T) runReconcile(ctx context.Context) {
 		case u := <-r.uploads:
 			if u.mime == "application/vnd.android.package-archive" {
 				// because assets are supposed to reference APKs in their "x" tags,
-				// reconcile only when an APK is uploaded
-				err := r.reconcile(ctx)
+				// promote only when an APK is uploaded
+				err := r.promote(ctx, u.hash.Hex())
 				if err != nil && !errors.Is(err, context.Canceled) {
-					slog.Error("reconcile failed", "error", err)
+					slog.Error("promote failed", "hash", u.hash.Hex(), "error", err)
 				}
 			}
 		}
 	}
 }
 
-// reconcile is responsible for checking whether to promote pending events to normal events
-// so they can be served in queries.
+// reconcile is responsible for checking whether to promote pending assets and releases to normal events
+// so they can be served in queries, and for deleting the expired ones.
+// Blobs are checked only in the local blossom database and in the cached probes, so no network request is made.
+// Promotions normally happen on upload or probe success; this is a safety net for missed notifications.
 func (r *T) reconcile(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	assets, err := r.store.QueryPending(ctx, events.KindAsset)
+	hashes, err := r.store.PendingHashes(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to reconcile events: %w", err)
 	}
 
-	errs := make([]error, 0, len(assets))
-	for _, asset := range assets {
-
-		ready, err := isAssetReady(ctx, r.blossom, &asset)
+	var errs []error
+	for _, hash := range hashes {
+		ready, err := r.isBlobReady(ctx, hash)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to check if asset is ready: %w", err))
+			errs = append(errs, fmt.Errorf("failed to check if blob %s is ready: %w", hash, err))
 			continue
 		}
 
 		if ready {
-			if _, err := r.store.Save(ctx, &asset); err != nil {
-				errs = append(errs, fmt.Errorf("failed to save event %s: %w", asset.ID, err))
-				continue
+			if err := r.promote(ctx, hash); err != nil {
+				errs = append(errs, err)
 			}
-			if err := r.store.DeletePending(ctx, asset.ID); err != nil {
-				errs = append(errs, fmt.Errorf("failed to delete pending event %s: %w", asset.ID, err))
+		}
+	}
+
+	releases, err := r.store.QueryPending(ctx, events.KindRelease)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := r.promoteReleases(ctx, releases); err != nil {
+		errs = append(errs, err)
+	}
+
+	cutoff := time.Now().UTC().Add(-r.config.RemovePendingAfter)
+	expired, err := r.store.DeleteExpiredPending(ctx, cutoff)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete expired pending events: %w", err))
+	}
+	for _, p := range expired {
+		r.notifyPending(p, noticeExpired)
+	}
+
+	if err := r.store.DeleteStaleProbes(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// promote saves and broadcasts all pending events waiting on the blob with the given hash,
+// which must be ready. Pending events that no longer pass the checks are dropped.
+func (r *T) promote(ctx context.Context, hash string) error {
+	r.promoting.Lock()
+	defer r.promoting.Unlock()
+
+	pending, err := r.store.QueryPendingByHash(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("failed to promote events: %w", err)
+	}
+
+	var errs []error
+	var promoted []string
+	for _, event := range pending {
+		p := store.PendingEvent{ID: event.ID, Kind: event.Kind, Pubkey: event.PubKey, Hash: hash}
+		if err := r.check(&event); err != nil {
+			if errors.Is(err, ErrInternal) {
+				errs = append(errs, fmt.Errorf("failed to check event %s: %w", event.ID, err))
 				continue
 			}
-			if err := r.server.Broadcast(&asset); err != nil {
-				errs = append(errs, fmt.Errorf("failed to broadcast event %s: %w", asset.ID, err))
-				continue
+			if err := r.dropPending(ctx, p, err); err != nil {
+				errs = append(errs, err)
 			}
+			continue
+		}
+
+		if _, err := r.store.Save(ctx, &event); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save event %s: %w", event.ID, err))
+			continue
+		}
+		if err := r.store.DeletePending(ctx, event.ID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete pending event %s: %w", event.ID, err))
+			continue
+		}
+		promoted = append(promoted, event.ID)
+
+		if err := r.server.Broadcast(&event); err != nil {
+			errs = append(errs, fmt.Errorf("failed to broadcast event %s: %w", event.ID, err))
+			continue
 		}
+		r.notifyPending(p, noticePromoted)
 	}
 
-	cutoff := time.Now().UTC().Add(-r.config.RemovePendingAfter)
-	if err := r.store.DeleteExpiredPending(ctx, cutoff); err != nil {
-		errs = append(errs, fmt.Errorf("failed to delete expired pending events: %w", err))
+	if err := r.promoteReleasesOf(ctx, promoted...); err != nil {
+		errs = append(errs, err)
 	}
 	return errors.Join(errs...)
 }
 
+// check runs the checks on an event that doesn't come from a client, like a synced event or a pending event
+// about to be promoted, whose bans, tombstones and anchoring events may have changed since it was received.
+func (r *T) check(event *nostr.Event) error {
+	for _, check := range r.checks {
+		if err := check(nil, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *T) save(c rely.Client, event *nostr.Event) rely.EventResult {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	r.analytics.RecordEvent(c, event)
 
+	isPending, err := r.saveEvent(ctx, event)
+	if err != nil {
+		return rely.Fail(err.Error())
+	}
+
+	switch {
+	case isPending && event.Kind == events.KindAsset:
+		// avoid broadcasting the event until it is fully saved
+		return rely.Success().NoBroadcast().WithReply("the event will be saved when the referenced blob is uploaded. Check its status at " + PendingPath)
+
+	case isPending && event.Kind == events.KindRelease:
+		// avoid broadcasting the event until it is fully saved
+		return rely.Success().NoBroadcast().WithReply("the event will be saved when all the referenced assets are published. Check its status at " + PendingPath)
+	}
+	return rely.Success()
+}
+
+// saveEvent saves an event that passed the checks, either as a normal event or as pending,
+// in which case it must not be broadcasted.
+func (r *T) saveEvent(ctx context.Context, event *nostr.Event) (isPending bool, err error) {
 	switch {
 	case event.Kind == nostr.KindDeletion:
 		if err := r.handleDelete(ctx, event); err != nil {
 			slog.Error("relay: failed to fullfil delete", "event", event.ID, "error", err)
-			return rely.Fail(err.Error())
+			return false, err
 		}
 
 	case event.Kind == events.KindAsset:
-		isPending, err := r.saveAsset(ctx, event)
+		isPending, err = r.saveAsset(ctx, event)
 		if err != nil {
 			slog.Error("relay: failed to save asset event", "event", event.ID, "error", err)
-			return rely.Fail(err.Error())
+			return false, err
 		}
 
-		if isPending {
-			// avoid broadcasting the event until it is fully saved
-			return rely.Success().NoBroadcast().WithReply("the event will be saved when the referenced blob is uploaded")
+	case event.Kind == events.KindRelease:
+		isPending, err = r.saveRelease(ctx, event)
+		if err != nil {
+			slog.Error("relay: failed to save release event", "event", event.ID, "error", err)
+			return false, err
 		}
 
 	case nostr.IsRegularKind(event.Kind):
 		if _, err := r.store.Save(ctx, event); err != nil {
 			slog.Error("relay: failed to save regular event", "event", event.ID, "error", err)
-			return rely.Fail(err.Error())
+			return false, err
 		}
 
 	case nostr.IsReplaceableKind(event.Kind) || nostr.IsAddressableKind(event.Kind):
 		saved, err := r.store.Replace(ctx, event)
 		if err != nil {
 			slog.Error("relay: failed to replace event", "event", event.ID, "error", err)
-			return rely.Fail(err.Error())
+			return false, err
 		}
 		if saved && event.Kind == events.KindApp {
 			r.enqueueProfile(event.PubKey)
 		}
+		if saved && event.Kind == events.KindIdentityProof {
+			r.handleProof(ctx, event)
+		}
 	}
-	return rely.Success()
+
+	r.memberships.Invalidate(event)
+	return isPending, nil
 }
 
 // handleDelete handles deletion events, either from the operator or a regular NIP-09 deletion.
@@ -346,14 +596,14 @@ func (r *T) handleDelete(ctx context.Context, event *nostr.Event) error {
 }
 
 // saveAsset saves an asset event to the store.
-// If the asset references a blob that is not in blossom yet, it will be saved as pending, until the
-// runReconcile loop saves it to the store or deletes it if too much time has passed.
+// If the asset references a blob that is not in blossom yet, it will be saved as pending and its "url" tags
+// are scheduled for probing, until the blob is uploaded or found at one of the urls, or too much time has passed.
 func (r *T) saveAsset(ctx context.Context, event *nostr.Event) (isPending bool, err error) {
 	if event.Kind != events.KindAsset {
 		return false, errors.New("event is not an asset")
 	}
 
-	ready, err := isAssetReady(ctx, r.blossom, event)
+	ready, err := r.isAssetReady(ctx, event)
 	if err != nil {
 		return false, fmt.Errorf("failed to check if asset is ready: %w", err)
 	}
@@ -362,6 +612,9 @@ func (r *T) saveAsset(ctx context.Context, event *nostr.Event) (isPending bool,
 		if _, err := r.store.Save(ctx, event); err != nil {
 			return false, fmt.Errorf("failed to save the asset event: %w", err)
 		}
+		if err := r.promoteReleasesOf(ctx, event.ID); err != nil {
+			slog.Error("relay: failed to promote pending releases", "asset", event.ID, "error", err)
+		}
 		return false, nil
 	}
 
@@ -369,76 +622,46 @@ func (r *T) saveAsset(ctx context.Context, event *nostr.Event) (isPending bool,
 	if _, err := r.store.SavePending(ctx, event); err != nil {
 		return false, fmt.Errorf("failed to save the asset event as pending: %w", err)
 	}
-	return true, nil
-}
 
-// assetChecker is used exclusively for HEAD requests in isAssetReady.
-var assetChecker = &http.Client{
-	Timeout: 10 * time.Second,
-	Transport: &http.Transport{
-		MaxIdleConnsPerHost: 4,
-		IdleConnTimeout:     30 * time.Second,
-	},
+	hash, _ := events.Find(event.Tags, "x")
+	if err := r.store.SetPendingOutcome(ctx, hash, outcomeBlobMissing); err != nil {
+		slog.Error("relay: failed to record pending outcome", "event", event.ID, "error", err)
+	}
+	if err := r.scheduleProbes(ctx, hash, events.FindAll(event.Tags, "url")); err != nil {
+		slog.Error("relay: failed to schedule url probes", "event", event.ID, "error", err)
+	}
+	return true, nil
 }
 
 // isAssetReady returns whether the asset's blob has been correctly uploaded.
-// It first checks the local blossom database, and then falls back to checking all "url" tags in the event.
-func isAssetReady(ctx context.Context, b Blossom, asset *nostr.Event) (bool, error) {
+func (r *T) isAssetReady(ctx context.Context, asset *nostr.Event) (bool, error) {
 	if asset.Kind != events.KindAsset {
 		return false, errors.New("event must be an asset event")
 	}
 
-	// first we check the local blossom database
 	xTag, ok := events.Find(asset.Tags, "x")
 	if !ok {
 		return false, errors.New("asset doesn't have an 'x' tag")
 	}
+	return r.isBlobReady(ctx, xTag)
+}
 
-	hash, err := blossom.ParseHash(xTag)
+// isBlobReady returns whether the blob with the given hash is in the local blossom database,
+// or has been found at one of the probed urls.
+func (r *T) isBlobReady(ctx context.Context, hex string) (bool, error) {
+	hash, err := blossom.ParseHash(hex)
 	if err != nil {
 		return false, fmt.Errorf("invalid x tag: %w", err)
 	}
 
-	found, err := b.Has(ctx, hash)
+	found, err := r.blossom.Has(ctx, hash)
 	if err != nil {
 		return false, fmt.Errorf("failed to check hash: %w", err)
 	}
 	if found {
 		return true, nil
 	}
-
-	// if the hash is not found locally, we check the "url" tags
-	// by making a HEAD request for each URL
-	urls := events.FindAll(asset.Tags, "url")
-	if len(urls) == 0 {
-		return false, nil
-	}
-
-	for _, url := range urls {
-		if strings.HasPrefix(url, "https://cdn.zapstore.dev") {
-			// skip URLs from the zapstore CDN, because they would have been already in the blossom db
-			continue
-		}
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
-		if err != nil {
-			slog.Warn("isAssetReady: skipping malformed url tag", "event", asset.ID, "url", url, "error", err)
-			continue
-		}
-
-		res, err := assetChecker.Do(req)
-		if err != nil {
-			slog.Warn("isAssetReady: skipping url", "event", asset.ID, "url", url, "error", err)
-			continue
-		}
-
-		if res.StatusCode >= 200 && res.StatusCode < 300 {
-			res.Body.Close()
-			return true, nil
-		}
-		res.Body.Close()
-	}
-	return false, nil
+	return r.store.IsAvailable(ctx, hex)
 }
 
 func (r *T) query(ctx context.Context, c rely.Client, id string, filters nostr.Filters) ([]nostr.Event, error) {
@@ -462,6 +685,23 @@ func (r *T) query(ctx context.Context, c rely.Client, id string, filters nostr.F
 	return result, nil
 }
 
+func (r *T) count(c rely.Client, id string, filters nostr.Filters) (int64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := r.store.Count(ctx, filters...)
+	if errors.Is(err, store.ErrUnsupportedREQ) {
+		return 0, false, err
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("relay: failed to count events", "error", err, "filters", filters)
+		return 0, false, err
+	}
+
+	r.analytics.RecordCount(c, id, filters)
+	return int64(count), false, nil
+}
+
 // recordDemandSignals records discovery misses and release requests non-blocking.
 // Release-request signals are gated to known Zapstore client subscription prefixes,
 // filtering out bots and non-Zapstore clients.
@@ -524,30 +764,29 @@ func RateConnectionIP(limiter rate.Limiter) func(_ rely.Stats, request *http.Req
 	}
 }
 
+// RateEventIP charges the buckets of the client's IP and authenticated pubkey, see [rate.Limiter.AllowAuthed].
 func RateEventIP(limiter rate.Limiter) func(client rely.Client, _ *nostr.Event) error {
 	return func(client rely.Client, _ *nostr.Event) error {
 		cost := 5.0
-		ip := client.IP().Group()
-		if !limiter.Allow(ip, cost) {
+		allowed, key := limiter.AllowAuthed(client.IP().Group(), client.Pubkeys(), cost)
+		if !allowed {
 			client.Disconnect()
-			slog.Debug("relay: rejecting event and disconnecting", "ip", ip)
+			slog.Debug("relay: rejecting event and disconnecting", "key", key)
 			return ErrRateLimited
 		}
 		return nil
 	}
 }
 
-func RateReqIP(limiter rate.Limiter) func(client rely.Client, id string, filters nostr.Filters) error {
+// RateReqIP charges the buckets of the client's IP and authenticated pubkey, see [rate.Limiter.AllowAuthed],
+// proportionally to the estimated cost of the filters, e.g. [admission.Cost].
+func RateReqIP(limiter rate.Limiter, estimate func(nostr.Filter) float64) func(client rely.Client, id string, filters nostr.Filters) error {
 	return func(client rely.Client, id string, filters nostr.Filters) error {
-		ip := client.IP().Group()
-		cost := 1.0
-		if len(filters) > 10 {
-			cost = 5.0
-		}
-
-		if !limiter.Allow(ip, cost) {
+		cost := tokens(filters, estimate)
+		allowed, key := limiter.AllowAuthed(client.IP().Group(), client.Pubkeys(), cost)
+		if !allowed {
 			client.Disconnect()
-			slog.Debug("relay: rejecting req and disconnecting", "ip", ip)
+			slog.Debug("relay: rejecting req and disconnecting", "key", key)
 			return ErrRateLimited
 		}
 		return nil
@@ -563,60 +802,88 @@ func FiltersExceed(n int) func(_ rely.Client, _ string, filters nostr.Filters) e
 	}
 }
 
-// VagueFilters rejects filters whose specificity score is below the given minimum.
-// Set min to 0 to disable the check entirely.
-func VagueFilters(min int) func(rely.Client, string, nostr.Filters) error {
-	return func(_ rely.Client, _ string, filters nostr.Filters) error {
-		if min <= 0 {
-			return nil
-		}
-		for _, f := range filters {
-			if specificity(f) < min {
-				return ErrFiltersTooVague
-			}
+// allowedKinds are the event kinds that can be published to the relay, which the operator
+// can change at runtime with the NIP-86 management API. Changes are lost on restart.
+type allowedKinds struct {
+	mu    sync.RWMutex
+	kinds []int
+}
+
+func newAllowedKinds(kinds []int) *allowedKinds {
+	return &allowedKinds{kinds: slices.Clone(kinds)}
+}
+
+// Contains returns whether the kind is allowed.
+func (a *allowedKinds) Contains(kind int) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Contains(a.kinds, kind)
+}
+
+// List returns the allowed kinds, sorted.
+func (a *allowedKinds) List() []int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Sorted(slices.Values(a.kinds))
+}
+
+// Allow adds the kind to the allowed kinds.
+func (a *allowedKinds) Allow(kind int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !slices.Contains(a.kinds, kind) {
+		a.kinds = append(a.kinds, kind)
+	}
+}
+
+// Disallow removes the kind from the allowed kinds.
+func (a *allowedKinds) Disallow(kind int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.kinds = slices.DeleteFunc(a.kinds, func(k int) bool { return k == kind })
+}
+
+func KindNotAllowed(kinds *allowedKinds) func(_ rely.Client, e *nostr.Event) error {
+	return func(_ rely.Client, e *nostr.Event) error {
+		if !kinds.Contains(e.Kind) {
+			return fmt.Errorf("%w: %v", ErrEventKindNotAllowed, kinds.List())
 		}
 		return nil
 	}
 }
 
-// specificity estimates how specific a filter is, based on the presence of conditions.
-// TODO: make it more accurate by considering what the conditions are (e.g. 1 kind vs 10 kinds).
-func specificity(filter nostr.Filter) int {
-	points := 0
-	if len(filter.IDs) > 0 {
-		points += 10
-	}
-	if filter.Search != "" {
-		points += 3
-	}
-	if len(filter.Authors) > 0 {
-		points += 2
-	}
-	if len(filter.Tags) > 0 {
-		points += 2
-	}
-	if len(filter.Kinds) > 0 {
-		points += 1
-	}
-	if filter.Since != nil {
-		points += 1
-	}
-	if filter.Until != nil {
-		points += 1
-	}
-	if filter.LimitZero {
-		points += 5
-	}
-	if !filter.LimitZero && filter.Limit < 100 {
-		points += 2
+// EventBanned rejects the events banned by the operator with the NIP-86 management API.
+func EventBanned(db store.T) func(_ rely.Client, e *nostr.Event) error {
+	return func(_ rely.Client, e *nostr.Event) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		banned, err := db.IsEventBanned(ctx, e.ID)
+		if err != nil {
+			slog.Error("EventBanned: failed to check event", "error", err, "event", e.ID)
+			return ErrInternal
+		}
+		if banned {
+			return ErrEventBanned
+		}
+		return nil
 	}
-	return points
 }
 
-func KindNotAllowed(kinds []int) func(_ rely.Client, e *nostr.Event) error {
+// Tombstoned rejects the events deleted by the operator, by ID or by addressable coordinate,
+// so that they can't be re-published after a takedown.
+func Tombstoned(db store.T) func(_ rely.Client, e *nostr.Event) error {
 	return func(_ rely.Client, e *nostr.Event) error {
-		if !slices.Contains(kinds, e.Kind) {
-			return fmt.Errorf("%w: %v", ErrEventKindNotAllowed, kinds)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		tombstoned, err := db.IsTombstoned(ctx, e)
+		if err != nil {
+			slog.Error("Tombstoned: failed to check event", "error", err, "event", e.ID)
+			return ErrInternal
+		}
+		if tombstoned {
+			return ErrEventDeleted
 		}
 		return nil
 	}
@@ -706,6 +973,246 @@ func AppOwnership(db store.T, indexerPubkey string) func(_ rely.Client, e *nostr
 	}
 }
 
+// VersionCodeRegression rejects Android assets (kind 3063) whose version code is lower than the highest one
+// published by the same pubkey for the same app ID and variant, because clients would offer them as updates
+// that Android refuses to install. Assets released only on rewindable channels are ignored, so that
+// beta channels can be rewound.
+func VersionCodeRegression(db store.T, rewindable []string) func(_ rely.Client, e *nostr.Event) error {
+	return func(_ rely.Client, e *nostr.Event) error {
+		if e.Kind != events.KindAsset {
+			return nil
+		}
+
+		asset, err := events.ParseAsset(e)
+		if err != nil || asset.VersionCode == "" {
+			// invalid assets are rejected by InvalidStructure
+			return nil
+		}
+
+		code, err := asset.ParseVersionCode()
+		if err != nil {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		latest, found, err := db.MaxVersionCode(ctx, asset.I, e.PubKey, asset.Variant, rewindable)
+		if err != nil {
+			slog.Error("VersionCodeRegression: failed to query max version code", "error", err, "event", e.ID)
+			return ErrInternal
+		}
+
+		if found && code < latest {
+			return fmt.Errorf("%w: got %d, latest is %d", ErrVersionCodeRegression, code, latest)
+		}
+		return nil
+	}
+}
+
+// CertificateImpersonation rejects assets (kind 3063) signed with an APK certificate that is already used by
+// the assets of a different pubkey, which is a strong sign of someone impersonating the developer.
+// The indexer is exempt on both sides, because it publishes apps that developers can later reclaim.
+//
+// Every rejection is recorded as a certificate conflict, which an admin resolves on the dashboard:
+// allowed conflicts let the pubkey publish assets with that certificate, blocked ones keep them rejected.
+func CertificateImpersonation(db store.T, indexerPubkey string) func(_ rely.Client, e *nostr.Event) error {
+	if indexerPubkey == "" {
+		indexerPubkey = indexerPubkeyFallback
+	}
+	return func(_ rely.Client, e *nostr.Event) error {
+		if e.Kind != events.KindAsset || e.PubKey == indexerPubkey {
+			return nil
+		}
+
+		asset, err := events.ParseAsset(e)
+		if err != nil {
+			// invalid assets are rejected by InvalidStructure
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		for _, hash := range asset.APKCertificateHashes {
+			status, err := db.CertificateConflictStatus(ctx, hash, e.PubKey)
+			if err != nil {
+				slog.Error("CertificateImpersonation: failed to query conflict status", "error", err, "event", e.ID)
+				return ErrInternal
+			}
+
+			switch status {
+			case store.ConflictAllowed:
+				continue
+			case store.ConflictBlocked:
+				return ErrCertificateImpersonation
+			}
+
+			owners, err := db.CertificateOwners(ctx, hash)
+			if err != nil {
+				slog.Error("CertificateImpersonation: failed to query certificate owners", "error", err, "event", e.ID)
+				return ErrInternal
+			}
+
+			i := slices.IndexFunc(owners, func(o store.CertificateOwner) bool {
+				return o.Pubkey != e.PubKey && o.Pubkey != indexerPubkey
+			})
+			if i == -1 {
+				continue
+			}
+
+			conflict := store.CertificateConflict{
+				CertificateHash: hash,
+				Pubkey:          e.PubKey,
+				AppID:           asset.I,
+				EventID:         e.ID,
+				OwnerPubkey:     owners[i].Pubkey,
+				OwnerAppID:      owners[i].AppID,
+			}
+			if err := db.SaveCertificateConflict(ctx, conflict); err != nil {
+				slog.Error("CertificateImpersonation: failed to save conflict", "error", err, "event", e.ID)
+				return ErrInternal
+			}
+
+			slog.Warn("CertificateImpersonation: certificate used by another pubkey", "app_id", asset.I, "pubkey", e.PubKey, "owner", owners[i].Pubkey)
+			return ErrCertificateImpersonation
+		}
+		return nil
+	}
+}
+
+// CertificateContinuity rejects assets (kind 3063) signed with APK certificates that were never used
+// in the previous assets of the same app ID and pubkey, because Android refuses to install such updates.
+// The first asset of an app is always accepted.
+//
+// A certificate change is accepted only if the relay operator published a certificate rotation (kind 3064)
+// for the app ID and pubkey, listing all the new certificate hashes.
+// Certificate rotations published by anyone other than the operator are rejected.
+func CertificateContinuity(db store.T, operatorPubkey string) func(_ rely.Client, e *nostr.Event) error {
+	return func(_ rely.Client, e *nostr.Event) error {
+		switch e.Kind {
+		case events.KindCertificateRotation:
+			if e.PubKey != operatorPubkey {
+				return ErrRotationNotOperator
+			}
+			return nil
+
+		case events.KindAsset:
+			// proceed below
+
+		default:
+			return nil
+		}
+
+		asset, err := events.ParseAsset(e)
+		if err != nil || len(asset.APKCertificateHashes) == 0 {
+			// invalid assets are rejected by InvalidStructure
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		known, err := db.CertificateHashes(ctx, asset.I, e.PubKey)
+		if err != nil {
+			slog.Error("CertificateContinuity: failed to query certificate hashes", "error", err, "event", e.ID)
+			return ErrInternal
+		}
+
+		if len(known) == 0 || isSubset(asset.APKCertificateHashes, known) {
+			// first asset for this app ID and pubkey, or same certificates as before
+			return nil
+		}
+
+		if operatorPubkey == "" {
+			return ErrCertificateMismatch
+		}
+
+		filter := nostr.Filter{
+			Kinds:   []int{events.KindCertificateRotation},
+			Authors: []string{operatorPubkey},
+			Tags:    nostr.TagMap{"i": {asset.I}, "p": {e.PubKey}},
+			Limit:   10,
+		}
+
+		rotations, err := db.Query(ctx, filter)
+		if err != nil {
+			slog.Error("CertificateContinuity: failed to query certificate rotations", "error", err, "event", e.ID)
+			return ErrInternal
+		}
+
+		for _, rotation := range rotations {
+			allowed := events.FindAll(rotation.Tags, "apk_certificate_hash")
+			if isSubset(asset.APKCertificateHashes, allowed) {
+				return nil
+			}
+		}
+		return ErrCertificateMismatch
+	}
+}
+
+// IdentityProofInvalid verifies the identity proofs (kind 30509) with a 'certificate' tag against the certificate.
+// Proofs without it must be linked to the publisher's assets, see [IdentityProofUnlinked], and are verified
+// asynchronously after being saved.
+func IdentityProofInvalid(_ rely.Client, e *nostr.Event) error {
+	if e.Kind != events.KindIdentityProof {
+		return nil
+	}
+
+	proof, err := events.ParseIdentityProof(e)
+	if err != nil {
+		// invalid proofs are rejected by InvalidStructure
+		return nil
+	}
+
+	cert, err := proof.ParseCertificate()
+	if err != nil || cert == nil {
+		return err
+	}
+	return proof.Verify(e.PubKey, cert)
+}
+
+// IdentityProofUnlinked rejects identity proofs (kind 30509) without a 'certificate' tag, unless the publisher
+// has assets signed with the certificate, from whose APKs the certificate is taken to verify the proof.
+// Proofs with a 'certificate' tag are verified by [IdentityProofInvalid].
+func IdentityProofUnlinked(db store.T) func(_ rely.Client, e *nostr.Event) error {
+	return func(_ rely.Client, e *nostr.Event) error {
+		if e.Kind != events.KindIdentityProof {
+			return nil
+		}
+
+		proof, err := events.ParseIdentityProof(e)
+		if err != nil || proof.Certificate != "" {
+			// invalid proofs are rejected by InvalidStructure
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		owners, err := db.CertificateOwners(ctx, proof.CertHash)
+		if err != nil {
+			slog.Error("IdentityProofUnlinked: failed to query certificate owners", "error", err, "event", e.ID)
+			return ErrInternal
+		}
+
+		if !slices.ContainsFunc(owners, func(o store.CertificateOwner) bool { return o.Pubkey == e.PubKey }) {
+			return ErrIdentityProofUnlinked
+		}
+		return nil
+	}
+}
+
+// isSubset returns whether all elements of a are in b.
+func isSubset(a, b []string) bool {
+	for _, v := range a {
+		if !slices.Contains(b, v) {
+			return false
+		}
+	}
+	return true
+}
+
 // NotAnchored returns an error if the event is not "anchored" to an existing event.
 // Anchoring means simply that the event references an existing root event.
 func NotAnchored(db store.T) func(_ rely.Client, e *nostr.Event) error {
@@ -730,6 +1237,31 @@ func NotAnchored(db store.T) func(_ rely.Client, e *nostr.Event) error {
 				return errors.New("kind 0: pubkey must have other events on this relay")
 			}
 
+		case events.KindRelease:
+			// assets can be pending or not published yet, in which case the release is held as pending.
+			// The referenced assets that are already known must match the release.
+			release, err := events.ParseRelease(e)
+			if err != nil {
+				return err
+			}
+
+			if _, err := missingAssets(ctx, db, release); err != nil {
+				if errors.Is(err, ErrReleaseAssetMismatch) {
+					return err
+				}
+				slog.Error("NotAnchored: failed to check release assets", "error", err, "event", e.ID)
+				return ErrInternal
+			}
+
+			pending, err := db.QueryPendingByIDs(ctx, release.AssetIDs...)
+			if err != nil {
+				slog.Error("NotAnchored: failed to check pending release assets", "error", err, "event", e.ID)
+				return ErrInternal
+			}
+			if err := checkReleaseAssets(release, pending); err != nil {
+				return err
+			}
+
 		case events.KindComment:
 			aTag, hasA := events.Find(e.Tags, "A")
 			eTag, hasE := events.Find(e.Tags, "e")
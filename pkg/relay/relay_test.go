@@ -0,0 +1,264 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// testDB returns an in-memory store with the saved events.
+func testDB(t *testing.T, saved ...*nostr.Event) store.T {
+	db, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, e := range saved {
+		if _, err := db.Save(context.Background(), e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+	return db
+}
+
+// testAsset returns an asset (kind 3063) of the pubkey for the app ID, with the extra tags.
+func testAsset(ID, pubkey, appID string, tags ...nostr.Tag) *nostr.Event {
+	return &nostr.Event{
+		ID:        ID,
+		PubKey:    pubkey,
+		CreatedAt: 1700000000,
+		Kind:      events.KindAsset,
+		Tags:      append(nostr.Tags{{"i", appID}, {"version", "1.0"}, {"x", "hash-" + ID}}, tags...),
+	}
+}
+
+func TestCertificateContinuity(t *testing.T) {
+	rotation := func(ID, pubkey, appID, publisher, cert string) *nostr.Event {
+		return &nostr.Event{ID: ID, PubKey: pubkey, CreatedAt: 1700000000, Kind: events.KindCertificateRotation,
+			Tags: nostr.Tags{{"i", appID}, {"p", publisher}, {"apk_certificate_hash", cert}}}
+	}
+
+	db := testDB(t,
+		testAsset("app-v1", "alice", "com.example.app", nostr.Tag{"apk_certificate_hash", "cert1"}),
+		testAsset("other-v1", "alice", "com.example.other", nostr.Tag{"apk_certificate_hash", "cert1"}),
+		rotation("rotation", "operator", "com.example.app", "alice", "cert2"),
+		rotation("forged-rotation", "mallory", "com.example.app", "alice", "cert3"),
+	)
+	reject := CertificateContinuity(db, "operator")
+
+	tests := []struct {
+		name  string
+		event *nostr.Event
+		err   error
+	}{
+		{name: "first asset", event: testAsset("new", "bob", "com.example.app", nostr.Tag{"apk_certificate_hash", "cert9"})},
+		{name: "same certificate", event: testAsset("app-v2", "alice", "com.example.app", nostr.Tag{"apk_certificate_hash", "cert1"})},
+		{name: "rotated certificate", event: testAsset("app-v2", "alice", "com.example.app", nostr.Tag{"apk_certificate_hash", "cert2"})},
+		{name: "rotation of another app", event: testAsset("other-v2", "alice", "com.example.other", nostr.Tag{"apk_certificate_hash", "cert2"}), err: ErrCertificateMismatch},
+		{name: "rotation not by the operator", event: testAsset("app-v2", "alice", "com.example.app", nostr.Tag{"apk_certificate_hash", "cert3"}), err: ErrCertificateMismatch},
+		{name: "other certificate", event: testAsset("app-v2", "alice", "com.example.app", nostr.Tag{"apk_certificate_hash", "cert4"}), err: ErrCertificateMismatch},
+		{name: "without certificate", event: testAsset("app-v2", "alice", "com.example.app")},
+		{name: "rotation by the operator", event: rotation("new-rotation", "operator", "com.example.app", "alice", "cert4")},
+		{name: "rotation by others", event: rotation("new-rotation", "mallory", "com.example.app", "mallory", "cert4"), err: ErrRotationNotOperator},
+		{name: "other kind", event: &nostr.Event{Kind: events.KindApp, PubKey: "alice"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := reject(nil, test.event); !errors.Is(err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("without operator", func(t *testing.T) {
+		rotated := testAsset("app-v2", "alice", "com.example.app", nostr.Tag{"apk_certificate_hash", "cert2"})
+		if err := CertificateContinuity(db, "")(nil, rotated); !errors.Is(err, ErrCertificateMismatch) {
+			t.Errorf("expected error %v, got %v", ErrCertificateMismatch, err)
+		}
+	})
+}
+
+func TestCertificateImpersonation(t *testing.T) {
+	ctx := context.Background()
+	cert := func(hash string) nostr.Tag { return nostr.Tag{"apk_certificate_hash", hash} }
+
+	db := testDB(t,
+		testAsset("alice-app", "alice", "com.example.app", cert("cert1")),
+		testAsset("indexed-app", "indexer", "com.example.indexed", cert("cert2")),
+	)
+	reject := CertificateImpersonation(db, "indexer")
+
+	conflicts := []struct {
+		hash, pubkey string
+		status       store.ConflictStatus
+	}{
+		{hash: "cert1", pubkey: "carol", status: store.ConflictAllowed},
+		{hash: "cert3", pubkey: "dave", status: store.ConflictBlocked},
+	}
+	for _, c := range conflicts {
+		if err := db.SaveCertificateConflict(ctx, store.CertificateConflict{CertificateHash: c.hash, Pubkey: c.pubkey}); err != nil {
+			t.Fatalf("failed to save conflict: %v", err)
+		}
+		if err := db.ResolveCertificateConflict(ctx, c.hash, c.pubkey, c.status); err != nil {
+			t.Fatalf("failed to resolve conflict: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		event    *nostr.Event
+		err      error
+		conflict store.ConflictStatus
+	}{
+		{name: "owner", event: testAsset("alice-app-v2", "alice", "com.example.other", cert("cert1"))},
+		{name: "new certificate", event: testAsset("bob-app", "bob", "com.example.bob", cert("cert4"))},
+		{name: "certificate of another pubkey", event: testAsset("mallory-app", "mallory", "com.example.app", cert("cert1")), err: ErrCertificateImpersonation, conflict: store.ConflictPending},
+		{name: "allowed conflict", event: testAsset("carol-app", "carol", "com.example.app", cert("cert1")), conflict: store.ConflictAllowed},
+		{name: "blocked conflict", event: testAsset("dave-app", "dave", "com.example.dave", cert("cert3")), err: ErrCertificateImpersonation, conflict: store.ConflictBlocked},
+		{name: "certificate of the indexer", event: testAsset("bob-indexed", "bob", "com.example.indexed", cert("cert2"))},
+		{name: "indexer", event: testAsset("indexer-app", "indexer", "com.example.app", cert("cert1"))},
+		{name: "other kind", event: &nostr.Event{Kind: events.KindApp, PubKey: "mallory"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := reject(nil, test.event); !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			asset, _ := events.ParseAsset(test.event)
+			for _, hash := range asset.APKCertificateHashes {
+				status, err := db.CertificateConflictStatus(ctx, hash, test.event.PubKey)
+				if err != nil {
+					t.Fatalf("CertificateConflictStatus: %v", err)
+				}
+				if status != test.conflict {
+					t.Errorf("expected the conflict status %q, got %q", test.conflict, status)
+				}
+			}
+		})
+	}
+}
+
+func TestVersionCodeRegression(t *testing.T) {
+	code := func(c string) nostr.Tag { return nostr.Tag{"version_code", c} }
+	release := func(ID, channel, asset string) *nostr.Event {
+		return &nostr.Event{ID: ID, PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindRelease,
+			Tags: nostr.Tags{{"d", "com.example.app@" + ID}, {"i", "com.example.app"}, {"c", channel}, {"e", asset}}}
+	}
+
+	db := testDB(t,
+		testAsset("stable", "alice", "com.example.app", code("100")),
+		testAsset("beta", "alice", "com.example.app", code("200")),
+		testAsset("fdroid", "alice", "com.example.app", code("150"), nostr.Tag{"variant", "fdroid"}),
+		release("1.0", "main", "stable"),
+		release("2.0-beta", "beta", "beta"),
+	)
+	reject := VersionCodeRegression(db, []string{"beta"})
+
+	tests := []struct {
+		name  string
+		event *nostr.Event
+		err   error
+	}{
+		{name: "higher", event: testAsset("new", "alice", "com.example.app", code("201"))},
+		{name: "lower than the stable", event: testAsset("new", "alice", "com.example.app", code("90")), err: ErrVersionCodeRegression},
+		{name: "rewound beta", event: testAsset("new", "alice", "com.example.app", code("120"))},
+		{name: "lower variant", event: testAsset("new", "alice", "com.example.app", code("140"), nostr.Tag{"variant", "fdroid"}), err: ErrVersionCodeRegression},
+		{name: "new variant", event: testAsset("new", "alice", "com.example.app", code("1"), nostr.Tag{"variant", "play"})},
+		{name: "other pubkey", event: testAsset("new", "bob", "com.example.app", code("1"))},
+		{name: "other app", event: testAsset("new", "alice", "com.example.other", code("1"))},
+		{name: "invalid version code", event: testAsset("new", "alice", "com.example.app", code("latest"))},
+		{name: "without version code", event: testAsset("new", "alice", "com.example.app")},
+		{name: "other kind", event: &nostr.Event{Kind: events.KindApp, PubKey: "alice"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := reject(nil, test.event); !errors.Is(err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("without rewindable channels", func(t *testing.T) {
+		rewound := testAsset("new", "alice", "com.example.app", code("120"))
+		if err := VersionCodeRegression(db, nil)(nil, rewound); !errors.Is(err, ErrVersionCodeRegression) {
+			t.Errorf("expected error %v, got %v", ErrVersionCodeRegression, err)
+		}
+	})
+}
+
+func TestNotAnchored(t *testing.T) {
+	alice := mustPublicKey(nostr.GeneratePrivateKey())
+	app := &nostr.Event{ID: "app", PubKey: alice, CreatedAt: 1700000000, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example.app"}}}
+	appRef := events.AddressableRef{Kind: events.KindApp, Pubkey: alice, DTag: "com.example.app"}.String()
+	release := func(version string, assets ...string) *nostr.Event {
+		tags := nostr.Tags{{"d", "com.example.app@" + version}, {"i", "com.example.app"}, {"version", version}, {"c", "main"}}
+		for _, asset := range assets {
+			tags = append(tags, nostr.Tag{"e", asset})
+		}
+		return &nostr.Event{ID: "release", PubKey: alice, CreatedAt: 1700000000, Kind: events.KindRelease, Tags: tags}
+	}
+
+	db := testDB(t,
+		app,
+		testAsset("asset", alice, "com.example.app"),
+		testAsset("other-asset", alice, "com.example.other"),
+		&nostr.Event{ID: "comment", PubKey: "bob", CreatedAt: 1700000000, Kind: events.KindComment, Tags: nostr.Tags{{"A", appRef}}},
+	)
+
+	pending := []*nostr.Event{
+		testAsset("pending-asset", alice, "com.example.app"),
+		testAsset("pending-other-asset", alice, "com.example.other"),
+	}
+	for _, e := range pending {
+		if _, err := db.SavePending(context.Background(), e); err != nil {
+			t.Fatalf("SavePending: %v", err)
+		}
+	}
+	reject := NotAnchored(db)
+
+	tests := []struct {
+		name     string
+		event    *nostr.Event
+		err      error
+		rejected bool // with an error other than a sentinel one
+	}{
+		{name: "root kind", event: &nostr.Event{PubKey: "mallory", Kind: events.KindApp}},
+		{name: "profile of a publisher", event: &nostr.Event{PubKey: alice, Kind: events.KindProfile}},
+		{name: "profile of a stranger", event: &nostr.Event{PubKey: "mallory", Kind: events.KindProfile}, rejected: true},
+		{name: "release", event: release("1.0", "asset")},
+		{name: "release of pending assets", event: release("1.0", "asset", "pending-asset")},
+		{name: "release of missing assets", event: release("1.0", "asset", "missing-asset")},
+		{name: "release of another version", event: release("2.0", "asset"), err: ErrReleaseAssetMismatch},
+		{name: "release of another app", event: release("1.0", "asset", "other-asset"), err: ErrReleaseAssetMismatch},
+		{name: "release of another pending app", event: release("1.0", "pending-other-asset"), err: ErrReleaseAssetMismatch},
+		{name: "comment on an app", event: &nostr.Event{PubKey: "bob", Kind: events.KindComment, Tags: nostr.Tags{{"A", appRef}}}},
+		{name: "reply", event: &nostr.Event{PubKey: "bob", Kind: events.KindComment, Tags: nostr.Tags{{"e", "comment"}}}},
+		{name: "comment on a missing app", event: &nostr.Event{PubKey: "bob", Kind: events.KindComment, Tags: nostr.Tags{{"A", events.AddressableRef{Kind: events.KindApp, Pubkey: alice, DTag: "com.example.missing"}.String()}}}, rejected: true},
+		{name: "comment on a release", event: &nostr.Event{PubKey: "bob", Kind: events.KindComment, Tags: nostr.Tags{{"A", events.AddressableRef{Kind: events.KindRelease, Pubkey: alice, DTag: "com.example.app@1.0"}.String()}}}, rejected: true},
+		{name: "comment without root", event: &nostr.Event{PubKey: "bob", Kind: events.KindComment}, rejected: true},
+		{name: "zap of an app", event: &nostr.Event{PubKey: "bob", Kind: events.KindZap, Tags: nostr.Tags{{"a", appRef}}}},
+		{name: "zap of an event", event: &nostr.Event{PubKey: "bob", Kind: events.KindZap, Tags: nostr.Tags{{"e", "asset"}}}},
+		{name: "zap of a missing event", event: &nostr.Event{PubKey: "bob", Kind: events.KindZap, Tags: nostr.Tags{{"e", "missing"}}}, rejected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := reject(nil, test.event)
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+			if test.err == nil && (err != nil) != test.rejected {
+				t.Errorf("expected rejected %t, got %v", test.rejected, err)
+			}
+		})
+	}
+}
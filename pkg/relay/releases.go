@@ -0,0 +1,156 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// checkReleaseAssets returns an error if any of the assets referenced by the release
+// is not a kind 3063 with the same 'i' and 'version' tags of the release.
+func checkReleaseAssets(release events.Release, assets []nostr.Event) error {
+	for _, asset := range assets {
+		if asset.Kind != events.KindAsset {
+			return fmt.Errorf("%w: 'e' tag %s must reference a kind %d, got kind %d",
+				ErrReleaseAssetMismatch, asset.ID, events.KindAsset, asset.Kind)
+		}
+
+		appID, _ := events.Find(asset.Tags, "i")
+		if appID != release.I {
+			return fmt.Errorf("%w: asset %s has 'i' tag %q, expected %q",
+				ErrReleaseAssetMismatch, asset.ID, appID, release.I)
+		}
+
+		version, _ := events.Find(asset.Tags, "version")
+		if version != release.Version {
+			return fmt.Errorf("%w: asset %s has 'version' tag %q, expected %q",
+				ErrReleaseAssetMismatch, asset.ID, version, release.Version)
+		}
+	}
+	return nil
+}
+
+// missingAssets returns the IDs of the assets referenced by the release that are not stored yet,
+// and an error if any of the stored ones doesn't match the release.
+func missingAssets(ctx context.Context, db store.T, release events.Release) ([]string, error) {
+	stored, err := db.Query(ctx, nostr.Filter{IDs: release.AssetIDs, Limit: len(release.AssetIDs)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query release assets: %w", err)
+	}
+	if err := checkReleaseAssets(release, stored); err != nil {
+		return nil, err
+	}
+
+	missing := slices.DeleteFunc(slices.Clone(release.AssetIDs), func(id string) bool {
+		return slices.ContainsFunc(stored, func(e nostr.Event) bool { return e.ID == id })
+	})
+	return slices.Compact(missing), nil
+}
+
+// saveRelease saves a release event to the store.
+// If the release references assets that are not stored yet, it will be saved as pending until
+// they are all stored, or deleted by the runReconcile loop if too much time has passed.
+func (r *T) saveRelease(ctx context.Context, event *nostr.Event) (isPending bool, err error) {
+	release, err := events.ParseRelease(event)
+	if err != nil {
+		return false, err
+	}
+
+	missing, err := missingAssets(ctx, r.store, release)
+	if err != nil {
+		return false, err
+	}
+
+	if len(missing) == 0 {
+		if _, err := r.store.Replace(ctx, event); err != nil {
+			return false, fmt.Errorf("failed to replace the release event: %w", err)
+		}
+		return false, nil
+	}
+
+	if _, err := r.store.SavePending(ctx, event); err != nil {
+		return false, fmt.Errorf("failed to save the release event as pending: %w", err)
+	}
+
+	outcome := fmt.Sprintf("%s%v", outcomeAssetsMissing, missing)
+	if err := r.store.SetPendingOutcomeByID(ctx, event.ID, outcome); err != nil {
+		slog.Error("relay: failed to record pending outcome", "event", event.ID, "error", err)
+	}
+	return true, nil
+}
+
+// promoteReleases saves and broadcasts the pending releases whose assets are now all stored.
+// Releases whose stored assets don't match them, or that no longer pass the checks, are dropped.
+func (r *T) promoteReleases(ctx context.Context, releases []nostr.Event) error {
+	var errs []error
+	for _, event := range releases {
+		p := store.PendingEvent{ID: event.ID, Kind: event.Kind, Pubkey: event.PubKey}
+		release, err := events.ParseRelease(&event)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse pending release %s: %w", event.ID, err))
+			continue
+		}
+
+		missing, err := missingAssets(ctx, r.store, release)
+		if err != nil {
+			if !errors.Is(err, ErrReleaseAssetMismatch) {
+				errs = append(errs, err)
+				continue
+			}
+
+			if err := r.dropPending(ctx, p, err); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if len(missing) > 0 {
+			outcome := fmt.Sprintf("%s%v", outcomeAssetsMissing, missing)
+			if err := r.store.SetPendingOutcomeByID(ctx, event.ID, outcome); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if err := r.check(&event); err != nil {
+			if errors.Is(err, ErrInternal) {
+				errs = append(errs, fmt.Errorf("failed to check event %s: %w", event.ID, err))
+				continue
+			}
+			if err := r.dropPending(ctx, p, err); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if _, err := r.store.Replace(ctx, &event); err != nil {
+			errs = append(errs, fmt.Errorf("failed to replace event %s: %w", event.ID, err))
+			continue
+		}
+		if err := r.store.DeletePending(ctx, event.ID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete pending event %s: %w", event.ID, err))
+			continue
+		}
+		if err := r.server.Broadcast(&event); err != nil {
+			errs = append(errs, fmt.Errorf("failed to broadcast event %s: %w", event.ID, err))
+			continue
+		}
+		r.notifyPending(p, noticePromoted)
+	}
+	return errors.Join(errs...)
+}
+
+// promoteReleasesOf promotes the pending releases referencing any of the given assets, which have just been stored.
+func (r *T) promoteReleasesOf(ctx context.Context, assetIDs ...string) error {
+	releases, err := r.store.QueryPendingReferencing(ctx, events.KindRelease, assetIDs...)
+	if err != nil {
+		return fmt.Errorf("failed to promote releases: %w", err)
+	}
+	return r.promoteReleases(ctx, releases)
+}
@@ -0,0 +1,252 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// StacksPath is the path of the HTTP endpoint that expands a stack (kind 30267) into the installable
+// releases of its apps, so that clients don't have to send one REQ per app, release and asset.
+const StacksPath = "/v1/stacks"
+
+// maxStackEntries is the maximum number of apps of a stack that are expanded.
+const maxStackEntries = 500
+
+// compatiblePlatforms are the platforms whose assets can run on each platform, besides the platform itself,
+// in order of preference. For example, 64-bit ARM Android devices can install 32-bit ARM APKs.
+var compatiblePlatforms = map[string][]string{
+	"android-arm64-v8a": {"android-armeabi-v7a"},
+	"android-x86_64":    {"android-x86"},
+	"darwin-arm64":      {"darwin-x86_64"},
+	"windows-aarch64":   {"windows-x86_64"},
+}
+
+// stackEntry is an app of a stack, with its latest release and the asset for the requested platform.
+type stackEntry struct {
+	AppID   string       `json:"app_id"`
+	Pubkey  string       `json:"pubkey"`
+	App     *nostr.Event `json:"app"`
+	Release *nostr.Event `json:"release"`
+	Asset   *nostr.Event `json:"asset"`
+}
+
+// serveStack serves GET /v1/stacks
+//
+// Query parameters: stack=30267:<pubkey>:<d-tag> (required), platform (required), channel (default "main").
+// Response:         {"stack": <event>, "apps": [{"app_id", "pubkey", "app", "release", "asset"}, ...]}
+//
+// Apps are returned in the order of the stack, each with its latest release on the channel and the asset for
+// the platform, or for the most preferred compatible platform. Apps that are missing, have no installable
+// asset, or whose publisher is blocked are dropped.
+func (r *T) serveStack(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ref, err := events.ParseAddressableRef(req.URL.Query().Get("stack"))
+	if err != nil || ref.Kind != events.KindStack {
+		http.Error(w, "stack must be a valid 30267:<pubkey>:<d-tag> address", http.StatusBadRequest)
+		return
+	}
+
+	platform := req.URL.Query().Get("platform")
+	if !slices.Contains(events.PlatformIdentifiers, platform) {
+		http.Error(w, "platform must be a valid platform identifier", http.StatusBadRequest)
+		return
+	}
+
+	channel := req.URL.Query().Get("channel")
+	if channel == "" {
+		channel = defaultChannel
+	}
+
+	ip := rely.GetIP(req).Group()
+	if !r.limiter.Allow(ip, 1.0) {
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	filter := nostr.Filter{
+		Kinds:   []int{events.KindStack},
+		Authors: []string{ref.Pubkey},
+		Tags:    nostr.TagMap{"d": {ref.DTag}},
+		Limit:   1,
+	}
+	stacks, err := r.store.Query(ctx, filter)
+	if err != nil {
+		slog.Error("relay: failed to query stack", "error", err, "stack", ref)
+		http.Error(w, ErrInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(stacks) == 0 {
+		http.Error(w, "stack not found", http.StatusNotFound)
+		return
+	}
+
+	stack, err := events.ParseStack(&stacks[0])
+	if err != nil {
+		http.Error(w, "invalid stack: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(stack.Apps) > maxStackEntries {
+		stack.Apps = stack.Apps[:maxStackEntries]
+	}
+
+	// the cost grows with the stack size, like for the update check
+	if !r.limiter.Allow(ip, float64(len(stack.Apps))/10) {
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	entries, err := r.expandStack(ctx, stack, channel, platform)
+	if err != nil {
+		slog.Error("relay: failed to expand stack", "error", err, "stack", ref)
+		http.Error(w, ErrInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.indexing != nil {
+		for _, entry := range entries {
+			r.indexing.RecordReleaseRequest(entry.AppID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"stack": stacks[0], "apps": entries}); err != nil {
+		slog.Error("relay: failed to encode stack", "error", err)
+	}
+}
+
+// expandStack returns the entries of the stack that have an installable asset for the channel and platform,
+// dropping those whose publisher is blocked. Entries keep the order of the stack.
+// The latest releases, the releases and assets, and the apps are each resolved in a single query.
+func (r *T) expandStack(ctx context.Context, stack events.Stack, channel, platform string) ([]stackEntry, error) {
+	var apps []events.AppIdentifier
+	for _, app := range stack.Apps {
+		if r.blocked.Contains(app.Pubkey) || slices.Contains(apps, app) {
+			continue
+		}
+		apps = append(apps, app)
+	}
+
+	latests, err := r.latestCompatibleReleases(ctx, apps, channel, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var released []events.AppIdentifier
+	var IDs []string
+	for _, app := range apps {
+		latest, ok := latests[app]
+		if !ok {
+			continue
+		}
+		released = append(released, app)
+		IDs = append(IDs, latest.ReleaseID, latest.AssetID)
+	}
+
+	if len(released) == 0 {
+		return []stackEntry{}, nil
+	}
+
+	byID, err := r.eventsByID(ctx, IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	appEvents, err := r.appEvents(ctx, released)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]stackEntry, 0, len(released))
+	for _, app := range released {
+		entry := stackEntry{
+			AppID:   app.AppID,
+			Pubkey:  app.Pubkey,
+			App:     appEvents[app],
+			Release: byID[latests[app].ReleaseID],
+			Asset:   byID[latests[app].AssetID],
+		}
+
+		if entry.App == nil || entry.Release == nil || entry.Asset == nil {
+			// the app was deleted, or its release or asset expired meanwhile
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// latestCompatibleReleases returns the latest release of each app for the channel and platform, falling back
+// to the platforms compatible with it, in order of preference. Apps without any release are not in the map.
+func (r *T) latestCompatibleReleases(ctx context.Context, apps []events.AppIdentifier, channel, platform string) (map[events.AppIdentifier]store.LatestRelease, error) {
+	platforms := append([]string{platform}, compatiblePlatforms[platform]...)
+
+	keys := make([]store.ReleaseKey, 0, len(apps)*len(platforms))
+	for _, app := range apps {
+		for _, p := range platforms {
+			keys = append(keys, store.ReleaseKey{AppID: app.AppID, Pubkey: app.Pubkey, Channel: channel, Platform: p})
+		}
+	}
+
+	releases, err := r.store.LatestReleasesOf(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	latests := make(map[events.AppIdentifier]store.LatestRelease, len(apps))
+	for _, app := range apps {
+		for _, p := range platforms {
+			key := store.ReleaseKey{AppID: app.AppID, Pubkey: app.Pubkey, Channel: channel, Platform: p}
+			if latest, ok := releases[key]; ok {
+				latests[app] = latest
+				break
+			}
+		}
+	}
+	return latests, nil
+}
+
+// eventsByID returns the stored events with the given IDs, indexed by ID.
+func (r *T) eventsByID(ctx context.Context, IDs []string) (map[string]*nostr.Event, error) {
+	fetched, err := r.store.Query(ctx, nostr.Filter{IDs: IDs, Limit: len(IDs)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query releases and assets: %w", err)
+	}
+
+	byID := make(map[string]*nostr.Event, len(fetched))
+	for i := range fetched {
+		byID[fetched[i].ID] = &fetched[i]
+	}
+	return byID, nil
+}
+
+// appEvents returns the app events (kind 32267) of the apps, indexed by their app identifier.
+func (r *T) appEvents(ctx context.Context, apps []events.AppIdentifier) (map[events.AppIdentifier]*nostr.Event, error) {
+	fetched, err := r.store.Apps(ctx, apps)
+	if err != nil {
+		return nil, err
+	}
+
+	appEvents := make(map[events.AppIdentifier]*nostr.Event, len(fetched))
+	for i := range fetched {
+		ID := events.AppIdentifier{Pubkey: fetched[i].PubKey, AppID: fetched[i].Tags.GetD()}
+		appEvents[ID] = &fetched[i]
+	}
+	return appEvents, nil
+}
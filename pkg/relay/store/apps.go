@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+// Apps returns the app events (kind 32267) with the given identifiers, matching each pubkey only with its
+// own app IDs. Identifiers without an app are skipped.
+func (s T) Apps(ctx context.Context, apps []events.AppIdentifier) ([]nostr.Event, error) {
+	if len(apps) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, 0, 2*len(apps)+1)
+	args = append(args, events.KindApp)
+	for _, app := range apps {
+		args = append(args, app.Pubkey, app.AppID)
+	}
+
+	query := `SELECT e.id FROM events e
+		JOIN tags d ON d.event_id = e.id AND d.key = 'd'
+		WHERE e.kind = ? AND (e.pubkey, d.value) IN (VALUES (?, ?)` + strings.Repeat(", (?, ?)", len(apps)-1) + `)`
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query apps: %w", err)
+	}
+	defer rows.Close()
+
+	var IDs []string
+	for rows.Next() {
+		var ID string
+		if err := rows.Scan(&ID); err != nil {
+			return nil, fmt.Errorf("failed to scan app: %w", err)
+		}
+		IDs = append(IDs, ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query apps: %w", err)
+	}
+
+	if len(IDs) == 0 {
+		return nil, nil
+	}
+	return s.Query(ctx, nostr.Filter{IDs: IDs, Limit: len(IDs)})
+}
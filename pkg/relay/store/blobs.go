@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ReferencedHashes returns the sha256 hashes of the blobs referenced by the relay: the 'x' tags of the assets
+// (kind 3063 and legacy 1063), the 'icon' and 'image' URLs of the events, including their archived versions,
+// the 'picture' and 'banner' URLs of the profiles, and the blobs the pending events are waiting on.
+// URLs reference the blob whose hash is their last path segment, without the extension.
+func (s T) ReferencedHashes(ctx context.Context) (map[string]struct{}, error) {
+	query := `SELECT t.value, 0 FROM tags t JOIN events e ON e.id = t.event_id
+		WHERE t.key = 'x' AND e.kind IN (3063, 1063)
+	UNION
+		SELECT hash, 0 FROM pending_events WHERE hash != ''
+	UNION
+		SELECT json_extract(i.value, '$[1]'), 1 FROM events e, json_each(e.tags) i
+		WHERE json_extract(i.value, '$[0]') IN ('icon', 'image')
+	UNION
+		SELECT json_extract(i.value, '$[1]'), 1 FROM event_history h, json_each(h.tags) i
+		WHERE json_extract(i.value, '$[0]') IN ('icon', 'image')
+	UNION
+		SELECT json_extract(e.content, '$.picture'), 1 FROM events e
+		WHERE e.kind = 0 AND json_valid(e.content)
+	UNION
+		SELECT json_extract(e.content, '$.banner'), 1 FROM events e
+		WHERE e.kind = 0 AND json_valid(e.content)`
+
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query referenced hashes: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]struct{})
+	for rows.Next() {
+		var value sql.NullString
+		var isURL bool
+		if err := rows.Scan(&value, &isURL); err != nil {
+			return nil, fmt.Errorf("failed to scan referenced hash: %w", err)
+		}
+
+		hash := strings.ToLower(value.String)
+		if isURL {
+			hash = hashFromURL(value.String)
+		}
+		if nostr.IsValid32ByteHex(hash) {
+			hashes[hash] = struct{}{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query referenced hashes: %w", err)
+	}
+	return hashes, nil
+}
+
+// IsReferenced returns whether the blob with the sha256 hash is referenced by the 'x' tag of an asset
+// or by a pending event. It's used to check a single blob right before deleting it, so it only does indexed lookups:
+// the URL references of [T.ReferencedHashes] must be checked against a snapshot taken beforehand.
+func (s T) IsReferenced(ctx context.Context, hash string) (bool, error) {
+	hash = strings.ToLower(hash)
+
+	var referenced bool
+	err := s.DB.QueryRowContext(ctx, `SELECT
+		EXISTS (SELECT 1 FROM tags t JOIN events e ON e.id = t.event_id
+			WHERE t.key = 'x' AND t.value IN (?, upper(?)) AND e.kind IN (3063, 1063))
+		OR EXISTS (SELECT 1 FROM pending_events WHERE hash IN (?, upper(?)))`,
+		hash, hash, hash, hash).Scan(&referenced)
+	if err != nil {
+		return false, fmt.Errorf("failed to check the references of %s: %w", hash, err)
+	}
+	return referenced, nil
+}
+
+// hashFromURL returns the last path segment of the URL without the extension, lowercased.
+// It's the hash of the blob for blossom URLs (e.g. "https://cdn.zapstore.dev/<hash>.png").
+func hashFromURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	name := path.Base(u.Path)
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
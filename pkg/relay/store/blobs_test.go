@@ -0,0 +1,78 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestReferencedHashes(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	hash := func(c string) string { return strings.Repeat(c, 64) }
+
+	saved := []*nostr.Event{
+		{ID: "asset", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindAsset, Tags: nostr.Tags{{"x", hash("a")}}},
+		{ID: "legacy", PubKey: "alice", CreatedAt: 1700000000, Kind: 1063, Tags: nostr.Tags{{"x", hash("b")}}},
+		{ID: "note", PubKey: "alice", CreatedAt: 1700000000, Kind: 1, Tags: nostr.Tags{{"x", hash("f")}}},
+		{ID: "profile", PubKey: "alice", CreatedAt: 1700000000, Kind: 0,
+			Content: `{"picture":"https://cdn.zapstore.dev/` + hash("c") + `.png","banner":"https://example.com/banner.jpg"}`},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	replaced := []*nostr.Event{
+		{ID: "v1", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindApp,
+			Tags: nostr.Tags{{"d", "com.example"}, {"icon", "https://cdn.zapstore.dev/" + hash("d") + ".webp"}}},
+		{ID: "v2", PubKey: "alice", CreatedAt: 1700000001, Kind: events.KindApp,
+			Tags: nostr.Tags{{"d", "com.example"}, {"image", "https://cdn.zapstore.dev/" + strings.ToUpper(hash("e"))}}},
+	}
+	for _, e := range replaced {
+		if _, err := store.Replace(ctx, e); err != nil {
+			t.Fatalf("failed to replace event %s: %v", e.ID, err)
+		}
+	}
+
+	pending := &nostr.Event{ID: "pending", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindAsset, Tags: nostr.Tags{{"x", hash("1")}}}
+	if _, err := store.SavePending(ctx, pending); err != nil {
+		t.Fatalf("SavePending: %v", err)
+	}
+
+	hashes, err := store.ReferencedHashes(ctx)
+	if err != nil {
+		t.Fatalf("ReferencedHashes: %v", err)
+	}
+
+	expected := map[string]struct{}{
+		hash("a"): {}, // asset 'x' tag
+		hash("b"): {}, // legacy asset 'x' tag
+		hash("c"): {}, // profile picture
+		hash("d"): {}, // archived app icon
+		hash("e"): {}, // app image
+		hash("1"): {}, // pending asset
+	}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("expected the hashes %v, got %v", expected, hashes)
+	}
+
+	for _, h := range []string{hash("a"), hash("b"), hash("c"), hash("d"), hash("e"), hash("1"), hash("f"), hash("9")} {
+		referenced, err := store.IsReferenced(ctx, h)
+		if err != nil {
+			t.Fatalf("IsReferenced: %v", err)
+		}
+		// URL references are only listed by ReferencedHashes
+		if want := h == hash("a") || h == hash("b") || h == hash("1"); referenced != want {
+			t.Errorf("hash %s: expected referenced %t, got %t", h, want, referenced)
+		}
+	}
+}
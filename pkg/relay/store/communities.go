@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zapstore/relay/pkg/events"
+)
+
+// ListMembers returns the pubkeys in the 'p' tags of the profile list (kind 30000) with the given address.
+func (s T) ListMembers(ctx context.Context, list events.AddressableRef) ([]string, error) {
+	query := `SELECT DISTINCT p.value
+		FROM events e
+		JOIN tags d ON d.event_id = e.id AND d.key = 'd'
+		JOIN tags p ON p.event_id = e.id AND p.key = 'p'
+		WHERE e.kind = ? AND e.pubkey = ? AND d.value = ? AND ` + notExpired
+
+	return s.pubkeys(ctx, query, events.KindProfileList, list.Pubkey, list.DTag)
+}
+
+// BadgeHolders returns the pubkeys awarded the badge with the given address, meaning the pubkeys
+// in the 'p' tags of the badge awards (kind 8) that reference the badge and are signed by its issuer.
+func (s T) BadgeHolders(ctx context.Context, badge events.AddressableRef) ([]string, error) {
+	query := `SELECT DISTINCT p.value
+		FROM tags a
+		JOIN events e ON e.id = a.event_id
+		JOIN tags p ON p.event_id = e.id AND p.key = 'p'
+		WHERE a.key = 'a' AND a.value = ? AND e.kind = ? AND e.pubkey = ? AND ` + notExpired
+
+	return s.pubkeys(ctx, query, badge.String(), events.KindBadgeAward, badge.Pubkey)
+}
+
+// pubkeys runs a query that returns a single column of pubkeys.
+func (s T) pubkeys(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pubkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var pubkeys []string
+	for rows.Next() {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return nil, fmt.Errorf("failed to scan pubkey: %w", err)
+		}
+		pubkeys = append(pubkeys, pubkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query pubkeys: %w", err)
+	}
+	return pubkeys, nil
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestCommunityMemberships(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	badge := events.AddressableRef{Kind: events.KindBadgeDefinition, Pubkey: "issuer", DTag: "maintainer"}
+	saved := []nostr.Event{
+		{ID: "list1", PubKey: "owner", Kind: events.KindProfileList, Tags: nostr.Tags{{"d", "editors"}, {"p", "alice"}, {"p", "bob"}}},
+		{ID: "list2", PubKey: "owner", Kind: events.KindProfileList, Tags: nostr.Tags{{"d", "others"}, {"p", "mallory"}}},
+		{ID: "list3", PubKey: "mallory", Kind: events.KindProfileList, Tags: nostr.Tags{{"d", "editors"}, {"p", "mallory"}}},
+		{ID: "award1", PubKey: "issuer", Kind: events.KindBadgeAward, Tags: nostr.Tags{{"a", badge.String()}, {"p", "carol"}, {"p", "dave"}}},
+		{ID: "award2", PubKey: "mallory", Kind: events.KindBadgeAward, Tags: nostr.Tags{{"a", badge.String()}, {"p", "mallory"}}},
+		{ID: "award3", PubKey: "issuer", Kind: events.KindBadgeAward, Tags: nostr.Tags{{"a", badge.String()}, {"p", "erin"}, {"expiration", "1000"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	members, err := store.ListMembers(ctx, events.AddressableRef{Kind: events.KindProfileList, Pubkey: "owner", DTag: "editors"})
+	if err != nil {
+		t.Fatalf("ListMembers: %v", err)
+	}
+	slices.Sort(members)
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("ListMembers: expected %v, got %v", want, members)
+	}
+
+	// awards signed by someone other than the issuer, or expired, don't count
+	holders, err := store.BadgeHolders(ctx, badge)
+	if err != nil {
+		t.Fatalf("BadgeHolders: %v", err)
+	}
+	slices.Sort(holders)
+	if want := []string{"carol", "dave"}; !reflect.DeepEqual(holders, want) {
+		t.Errorf("BadgeHolders: expected %v, got %v", want, holders)
+	}
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zapstore/relay/pkg/events"
+)
+
+var ErrConflictNotFound = errors.New("certificate conflict not found")
+
+// ConflictStatus is the status of a [CertificateConflict].
+type ConflictStatus string
+
+const (
+	ConflictPending ConflictStatus = "pending" // waiting for an admin decision
+	ConflictAllowed ConflictStatus = "allowed" // the pubkey is allowed to use the certificate
+	ConflictBlocked ConflictStatus = "blocked" // the pubkey is not allowed to use the certificate
+)
+
+// IsValid returns whether the status is one of the known conflict statuses.
+func (s ConflictStatus) IsValid() bool {
+	switch s {
+	case ConflictPending, ConflictAllowed, ConflictBlocked:
+		return true
+	default:
+		return false
+	}
+}
+
+// CertificateConflict is an asset rejected because its APK certificate is already used by a different pubkey.
+type CertificateConflict struct {
+	CertificateHash string
+	Pubkey          string // pubkey of the publisher of the rejected asset
+	AppID           string // app identifier of the rejected asset
+	EventID         string // id of the last rejected asset
+	OwnerPubkey     string // pubkey already associated with the certificate
+	OwnerAppID      string // app identifier under which the owner uses the certificate
+	Status          ConflictStatus
+	DetectedAt      time.Time
+	ResolvedAt      time.Time // zero if the conflict is pending
+}
+
+// CertificateOwner is a pubkey that published assets signed with a certificate, and the app they belong to.
+type CertificateOwner struct {
+	Pubkey string
+	AppID  string
+}
+
+// CertificateOwners returns the distinct pubkeys and app IDs of all the assets (kind 3063)
+// signed with the given APK certificate hash.
+func (s T) CertificateOwners(ctx context.Context, hash string) ([]CertificateOwner, error) {
+	query := `SELECT DISTINCT e.pubkey, i.value
+		FROM events e
+		JOIN tags c ON c.event_id = e.id AND c.key = 'apk_certificate_hash'
+		JOIN tags i ON i.event_id = e.id AND i.key = 'i'
+		WHERE e.kind = ? AND c.value = ?`
+
+	rows, err := s.DB.QueryContext(ctx, query, events.KindAsset, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificate owners: %w", err)
+	}
+	defer rows.Close()
+
+	var owners []CertificateOwner
+	for rows.Next() {
+		var owner CertificateOwner
+		if err := rows.Scan(&owner.Pubkey, &owner.AppID); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate owner: %w", err)
+		}
+		owners = append(owners, owner)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query certificate owners: %w", err)
+	}
+	return owners, nil
+}
+
+// SaveCertificateConflict records a certificate conflict as pending.
+// If a conflict for the same certificate hash and pubkey already exists, only its details are updated,
+// preserving its status and detection time.
+func (s T) SaveCertificateConflict(ctx context.Context, c CertificateConflict) error {
+	query := `INSERT INTO certificate_conflicts
+		(certificate_hash, pubkey, app_id, event_id, owner_pubkey, owner_app_id, status, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(certificate_hash, pubkey) DO UPDATE SET
+			app_id = excluded.app_id,
+			event_id = excluded.event_id,
+			owner_pubkey = excluded.owner_pubkey,
+			owner_app_id = excluded.owner_app_id`
+
+	_, err := s.DB.ExecContext(ctx, query,
+		c.CertificateHash, c.Pubkey, c.AppID, c.EventID, c.OwnerPubkey, c.OwnerAppID,
+		ConflictPending, time.Now().UTC().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save certificate conflict: %w", err)
+	}
+	return nil
+}
+
+// CertificateConflictStatus returns the status of the conflict between the certificate hash and the pubkey.
+// It returns an empty status if no conflict has been recorded.
+func (s T) CertificateConflictStatus(ctx context.Context, hash, pubkey string) (ConflictStatus, error) {
+	var status ConflictStatus
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT status FROM certificate_conflicts WHERE certificate_hash = ? AND pubkey = ?`, hash, pubkey,
+	).Scan(&status)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query certificate conflict status: %w", err)
+	}
+	return status, nil
+}
+
+// CertificateConflicts returns up to limit certificate conflicts with the given status, most recent first.
+// An empty status returns conflicts of every status.
+func (s T) CertificateConflicts(ctx context.Context, status ConflictStatus, limit int) ([]CertificateConflict, error) {
+	query := `SELECT certificate_hash, pubkey, app_id, event_id, owner_pubkey, owner_app_id, status, detected_at, resolved_at
+		FROM certificate_conflicts
+		WHERE (? = '' OR status = ?)
+		ORDER BY detected_at DESC
+		LIMIT ?`
+
+	rows, err := s.DB.QueryContext(ctx, query, status, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificate conflicts: %w", err)
+	}
+	defer rows.Close()
+
+	var conflicts []CertificateConflict
+	for rows.Next() {
+		var c CertificateConflict
+		var detectedAt int64
+		var resolvedAt sql.NullInt64
+
+		err := rows.Scan(&c.CertificateHash, &c.Pubkey, &c.AppID, &c.EventID,
+			&c.OwnerPubkey, &c.OwnerAppID, &c.Status, &detectedAt, &resolvedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan certificate conflict: %w", err)
+		}
+
+		c.DetectedAt = time.Unix(detectedAt, 0).UTC()
+		if resolvedAt.Valid {
+			c.ResolvedAt = time.Unix(resolvedAt.Int64, 0).UTC()
+		}
+		conflicts = append(conflicts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query certificate conflicts: %w", err)
+	}
+	return conflicts, nil
+}
+
+// ResolveCertificateConflict sets the status of the conflict between the certificate hash and the pubkey.
+// It returns [ErrConflictNotFound] if no such conflict has been recorded.
+func (s T) ResolveCertificateConflict(ctx context.Context, hash, pubkey string, status ConflictStatus) error {
+	if !status.IsValid() {
+		return fmt.Errorf("invalid conflict status %q", status)
+	}
+
+	var resolvedAt any
+	if status != ConflictPending {
+		resolvedAt = time.Now().UTC().Unix()
+	}
+
+	res, err := s.DB.ExecContext(ctx,
+		`UPDATE certificate_conflicts SET status = ?, resolved_at = ? WHERE certificate_hash = ? AND pubkey = ?`,
+		status, resolvedAt, hash, pubkey,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to resolve certificate conflict: %w", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrConflictNotFound
+	}
+	return nil
+}
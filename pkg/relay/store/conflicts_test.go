@@ -0,0 +1,159 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestCertificateOwners(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	assets := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"apk_certificate_hash", "cert1"}}},
+		{ID: "asset2", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"apk_certificate_hash", "cert1"}}},
+		{ID: "asset3", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.lite"}, {"apk_certificate_hash", "cert1"}}},
+		{ID: "asset4", PubKey: "bob", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"apk_certificate_hash", "cert1"}}},
+		{ID: "asset5", PubKey: "bob", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.bob.app"}, {"apk_certificate_hash", "cert2"}}},
+	}
+
+	for _, e := range assets {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		want []CertificateOwner
+	}{
+		{
+			name: "shared certificate",
+			hash: "cert1",
+			want: []CertificateOwner{
+				{Pubkey: "alice", AppID: "com.example.app"},
+				{Pubkey: "alice", AppID: "com.example.lite"},
+				{Pubkey: "bob", AppID: "com.example.app"},
+			},
+		},
+		{
+			name: "single owner",
+			hash: "cert2",
+			want: []CertificateOwner{{Pubkey: "bob", AppID: "com.bob.app"}},
+		},
+		{
+			name: "unknown certificate",
+			hash: "cert3",
+			want: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := store.CertificateOwners(ctx, test.hash)
+			if err != nil {
+				t.Fatalf("CertificateOwners: %v", err)
+			}
+
+			slices.SortFunc(got, func(a, b CertificateOwner) int {
+				if a.Pubkey != b.Pubkey {
+					return cmp.Compare(a.Pubkey, b.Pubkey)
+				}
+				return cmp.Compare(a.AppID, b.AppID)
+			})
+			if !slices.Equal(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestCertificateConflicts(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	conflict := CertificateConflict{
+		CertificateHash: "cert1",
+		Pubkey:          "mallory",
+		AppID:           "com.example.fake",
+		EventID:         "event1",
+		OwnerPubkey:     "alice",
+		OwnerAppID:      "com.example.app",
+	}
+
+	status, err := store.CertificateConflictStatus(ctx, "cert1", "mallory")
+	if err != nil {
+		t.Fatalf("CertificateConflictStatus: %v", err)
+	}
+	if status != "" {
+		t.Fatalf("expected no status before saving, got %q", status)
+	}
+
+	if err := store.SaveCertificateConflict(ctx, conflict); err != nil {
+		t.Fatalf("SaveCertificateConflict: %v", err)
+	}
+
+	status, err = store.CertificateConflictStatus(ctx, "cert1", "mallory")
+	if err != nil {
+		t.Fatalf("CertificateConflictStatus: %v", err)
+	}
+	if status != ConflictPending {
+		t.Fatalf("expected status %q, got %q", ConflictPending, status)
+	}
+
+	if err := store.ResolveCertificateConflict(ctx, "cert1", "mallory", ConflictBlocked); err != nil {
+		t.Fatalf("ResolveCertificateConflict: %v", err)
+	}
+
+	// saving the same conflict again must not reset the admin decision
+	conflict.EventID = "event2"
+	if err := store.SaveCertificateConflict(ctx, conflict); err != nil {
+		t.Fatalf("SaveCertificateConflict: %v", err)
+	}
+
+	pending, err := store.CertificateConflicts(ctx, ConflictPending, 10)
+	if err != nil {
+		t.Fatalf("CertificateConflicts: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("expected no pending conflicts, got %v", pending)
+	}
+
+	all, err := store.CertificateConflicts(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("CertificateConflicts: %v", err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("expected 1 conflict, got %d", len(all))
+	}
+
+	got := all[0]
+	if got.Status != ConflictBlocked || got.EventID != "event2" || got.OwnerPubkey != "alice" {
+		t.Errorf("unexpected conflict: %+v", got)
+	}
+	if got.ResolvedAt.IsZero() {
+		t.Errorf("expected resolved_at to be set")
+	}
+
+	err = store.ResolveCertificateConflict(ctx, "cert1", "bob", ConflictAllowed)
+	if !errors.Is(err, ErrConflictNotFound) {
+		t.Errorf("expected %v, got %v", ErrConflictNotFound, err)
+	}
+
+	err = store.ResolveCertificateConflict(ctx, "cert1", "mallory", "maybe")
+	if err == nil {
+		t.Errorf("expected error for invalid status")
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// notExpired is the condition excluding the events whose expiration has passed.
+const notExpired = "NOT EXISTS (SELECT 1 FROM expirations x WHERE x.event_id = e.id AND x.expires_at <= unixepoch())"
+
+// errUnexpectedQuery is returned when a query of the default builders can't be spliced with [notExpired],
+// e.g. because nostr-sqlite changed their format. Failing is better than serving expired events.
+var errUnexpectedQuery = errors.New("unexpected query format of the default builder")
+
+// withoutExpired adds the [notExpired] condition to a query of the default builders, which select from "events AS e".
+// The existing conditions are wrapped in parentheses, because the count builder joins filters with OR.
+// It returns [errUnexpectedQuery] if the query doesn't have the expected format.
+func withoutExpired(query string) (string, error) {
+	const from = "FROM events AS e"
+	if strings.Count(query, from) != 1 {
+		return "", fmt.Errorf("%w: %q", errUnexpectedQuery, query)
+	}
+	i := strings.Index(query, from)
+
+	head, tail := query[:i+len(from)], query[i+len(from):]
+	where, ok := strings.CutPrefix(tail, " WHERE ")
+	if !ok {
+		if tail != "" && !strings.HasPrefix(tail, " ORDER BY ") && !strings.HasPrefix(tail, " LIMIT ") {
+			return "", fmt.Errorf("%w: %q", errUnexpectedQuery, query)
+		}
+		return head + " WHERE " + notExpired + tail, nil
+	}
+
+	if strings.Count(where, " ORDER BY ") > 1 {
+		return "", fmt.Errorf("%w: %q", errUnexpectedQuery, query)
+	}
+	conditions, order, ordered := strings.Cut(where, " ORDER BY ")
+	query = head + " WHERE " + notExpired + " AND (" + conditions + ")"
+	if ordered {
+		query += " ORDER BY " + order
+	}
+	return query, nil
+}
+
+// DeleteExpired deletes up to limit events whose expiration is before the given time,
+// and returns how many were deleted.
+func (s T) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	query := `DELETE FROM events WHERE id IN (
+		SELECT event_id FROM expirations WHERE expires_at <= ? ORDER BY expires_at LIMIT ?)`
+
+	res, err := s.DB.ExecContext(ctx, query, before.Unix(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired events: %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	return int(deleted), nil
+}
+
+// backfillExpirations indexes the expiration of the events stored before the expirations table existed.
+// It's a no-op if the table is already populated.
+func backfillExpirations(db *sql.DB) error {
+	var populated bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM expirations)`).Scan(&populated); err != nil {
+		return fmt.Errorf("failed to check expirations: %w", err)
+	}
+	if populated {
+		return nil
+	}
+
+	query := `INSERT OR IGNORE INTO expirations (event_id, expires_at)
+		SELECT e.id, MIN(CAST(json_extract(t.value, '$[1]') AS INTEGER))
+		FROM events e
+		CROSS JOIN json_each(e.tags) t
+		WHERE e.tags LIKE '%"expir%'
+			AND json_array_length(t.value) > 1
+			AND (json_extract(t.value, '$[0]') = 'expiration'
+				OR (e.kind = 30509 AND json_extract(t.value, '$[0]') = 'expiry'))
+			AND json_extract(t.value, '$[1]') GLOB '[0-9]*'
+			AND json_extract(t.value, '$[1]') NOT GLOB '*[^0-9]*'
+		GROUP BY e.id`
+
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to backfill expirations: %w", err)
+	}
+	return nil
+}
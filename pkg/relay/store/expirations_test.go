@@ -0,0 +1,272 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	sqlite "github.com/vertex-lab/nostr-sqlite"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestWithoutExpired(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "no conditions",
+			query: "SELECT e.* FROM events AS e ORDER BY e.created_at DESC, e.id ASC LIMIT ?",
+			want:  "SELECT e.* FROM events AS e WHERE " + notExpired + " ORDER BY e.created_at DESC, e.id ASC LIMIT ?",
+		},
+		{
+			name:  "conditions",
+			query: "SELECT e.* FROM events AS e WHERE e.kind = ? AND e.pubkey = ? ORDER BY e.created_at DESC, e.id ASC LIMIT ?",
+			want:  "SELECT e.* FROM events AS e WHERE " + notExpired + " AND (e.kind = ? AND e.pubkey = ?) ORDER BY e.created_at DESC, e.id ASC LIMIT ?",
+		},
+		{
+			name:  "count of many filters",
+			query: "SELECT COUNT(*) FROM events AS e WHERE (e.kind = ?) OR (e.pubkey = ?)",
+			want:  "SELECT COUNT(*) FROM events AS e WHERE " + notExpired + " AND ((e.kind = ?) OR (e.pubkey = ?))",
+		},
+		{
+			name:  "count of all events",
+			query: "SELECT COUNT(*) FROM events AS e",
+			want:  "SELECT COUNT(*) FROM events AS e WHERE " + notExpired,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := withoutExpired(test.query)
+			if err != nil {
+				t.Fatalf("withoutExpired: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("expected\n%s\ngot\n%s", test.want, got)
+			}
+		})
+	}
+
+	unexpected := []string{
+		"SELECT e.* FROM events e WHERE e.kind = ?",
+		"SELECT e.* FROM events AS e JOIN tags t ON t.event_id = e.id WHERE t.key = ?",
+		"SELECT * FROM (SELECT e.* FROM events AS e) UNION SELECT e.* FROM events AS e",
+	}
+	for _, query := range unexpected {
+		if _, err := withoutExpired(query); !errors.Is(err, errUnexpectedQuery) {
+			t.Errorf("expected %v for %q, got %v", errUnexpectedQuery, query, err)
+		}
+	}
+}
+
+// TestWithoutExpiredBuilders pins the queries of the nostr-sqlite builders that [withoutExpired] splices,
+// so that a change of their format fails here rather than silently serving expired events.
+func TestWithoutExpiredBuilders(t *testing.T) {
+	filter := nostr.Filter{Kinds: []int{1}, Authors: []string{"alice"}, Tags: nostr.TagMap{"e": {"post"}}, Limit: 5}
+
+	queries, err := sqlite.DefaultQueryBuilder(filter)
+	if err != nil {
+		t.Fatalf("DefaultQueryBuilder: %v", err)
+	}
+	want := "SELECT e.* FROM events AS e WHERE e.kind = ? AND e.pubkey = ? AND e.id IN (SELECT event_id FROM tags WHERE key = ? AND value = ?) ORDER BY e.created_at DESC, e.id ASC LIMIT ?"
+	if queries[0].SQL != want {
+		t.Fatalf("the query builder changed format:\n%s", queries[0].SQL)
+	}
+
+	counts, err := sqlite.DefaultCountBuilder(filter, nostr.Filter{IDs: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("DefaultCountBuilder: %v", err)
+	}
+	want = "SELECT COUNT(*) FROM events AS e WHERE (e.kind = ? AND e.pubkey = ? AND e.id IN (SELECT event_id FROM tags WHERE key = ? AND value = ?)) OR (e.id IN (?,?))"
+	if counts[0].SQL != want {
+		t.Fatalf("the count builder changed format:\n%s", counts[0].SQL)
+	}
+
+	for _, query := range append(queries, counts...) {
+		if _, err := withoutExpired(query.SQL); err != nil {
+			t.Errorf("withoutExpired: %v", err)
+		}
+	}
+}
+
+func TestExpirations(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	saved := []nostr.Event{
+		{ID: "expired", Kind: events.KindComment, CreatedAt: 1, Tags: nostr.Tags{{"expiration", past}}},
+		{ID: "expiring", Kind: events.KindComment, CreatedAt: 2, Tags: nostr.Tags{{"expiration", future}}},
+		{ID: "forever", Kind: events.KindComment, CreatedAt: 3},
+		{ID: "earliest", Kind: events.KindComment, CreatedAt: 4, Tags: nostr.Tags{{"expiration", future}, {"expiration", past}}},
+		{ID: "proof", Kind: events.KindIdentityProof, CreatedAt: 5, Tags: nostr.Tags{{"d", "cert"}, {"expiry", past}}},
+		{ID: "not a proof", Kind: events.KindComment, CreatedAt: 6, Tags: nostr.Tags{{"expiry", past}}},
+		{ID: "malformed", Kind: events.KindComment, CreatedAt: 7, Tags: nostr.Tags{{"expiration", "soon"}, {"expiration", "1abc"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	filter := nostr.Filter{Kinds: []int{events.KindComment, events.KindIdentityProof}, Limit: 10}
+	want := []string{"malformed", "not a proof", "forever", "expiring"}
+
+	results, err := store.Query(ctx, filter)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if IDs := eventIDs(results); !slices.Equal(IDs, want) {
+		t.Errorf("Query: expected %v, got %v", want, IDs)
+	}
+
+	count, err := store.Count(ctx, filter)
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if count != len(want) {
+		t.Errorf("Count: expected %d, got %d", len(want), count)
+	}
+
+	deleted, err := store.DeleteExpired(ctx, time.Now(), 2)
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeleteExpired: expected 2 deletions with limit 2, got %d", deleted)
+	}
+
+	deleted, err = store.DeleteExpired(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpired: expected 1 deletion, got %d", deleted)
+	}
+
+	var remaining int
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM expirations").Scan(&remaining); err != nil {
+		t.Fatalf("failed to count expirations: %v", err)
+	}
+	if remaining != 1 {
+		t.Errorf("expected only the expiration of 'expiring' to remain, got %d", remaining)
+	}
+}
+
+func TestBackfillExpirations(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	stored := []nostr.Event{
+		{ID: "comment", Kind: events.KindComment, Tags: nostr.Tags{{"expiration", "200"}, {"expiration", "100"}}},
+		{ID: "proof", Kind: events.KindIdentityProof, Tags: nostr.Tags{{"d", "cert"}, {"expiry", "300"}}},
+		{ID: "forever", Kind: events.KindComment},
+		{ID: "malformed", Kind: events.KindComment, Tags: nostr.Tags{{"expiration", "soon"}}},
+	}
+	for _, e := range stored {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	// simulate a database created before the expirations table existed
+	if _, err := store.DB.Exec(`DELETE FROM expirations`); err != nil {
+		t.Fatalf("failed to empty expirations: %v", err)
+	}
+	if err := backfillExpirations(store.DB); err != nil {
+		t.Fatalf("backfillExpirations: %v", err)
+	}
+
+	rows, err := store.DB.Query(`SELECT event_id, expires_at FROM expirations ORDER BY event_id`)
+	if err != nil {
+		t.Fatalf("failed to query expirations: %v", err)
+	}
+	defer rows.Close()
+
+	got := make(map[string]int64)
+	for rows.Next() {
+		var ID string
+		var expiresAt int64
+		if err := rows.Scan(&ID, &expiresAt); err != nil {
+			t.Fatalf("failed to scan expiration: %v", err)
+		}
+		got[ID] = expiresAt
+	}
+
+	want := map[string]int64{"comment": 100, "proof": 300}
+	if len(got) != len(want) || got["comment"] != want["comment"] || got["proof"] != want["proof"] {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func eventIDs(events []nostr.Event) []string {
+	IDs := make([]string, len(events))
+	for i, e := range events {
+		IDs[i] = e.ID
+	}
+	return IDs
+}
+
+func TestExpiredHandWrittenQueries(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	repo := "https://github.com/example/notes"
+
+	saved := []nostr.Event{
+		{ID: "live", PubKey: "alice", CreatedAt: 1700000001, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "com.alice.notes"}, {"name", "Notes"}, {"repository", repo}}},
+		{ID: "expired", PubKey: "bob", CreatedAt: 1700000002, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "com.bob.notes"}, {"name", "Notes"}, {"repository", repo}, {"expiration", past}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	for _, search := range []string{"notes", repo} {
+		filter := nostr.Filter{Kinds: []int{events.KindApp}, Search: search, Limit: 10}
+
+		results, err := store.Query(ctx, filter)
+		if err != nil {
+			t.Fatalf("Query(%q): %v", search, err)
+		}
+		if IDs := eventIDs(results); !slices.Equal(IDs, []string{"live"}) {
+			t.Errorf("Query(%q): expected [live], got %v", search, IDs)
+		}
+
+		count, err := store.Count(ctx, filter)
+		if err != nil {
+			t.Fatalf("Count(%q): %v", search, err)
+		}
+		if count != 1 {
+			t.Errorf("Count(%q): expected 1, got %d", search, count)
+		}
+	}
+
+	items, err := store.SyncItems(ctx, nostr.Filter{Kinds: []int{events.KindApp}}, 0)
+	if err != nil {
+		t.Fatalf("SyncItems: %v", err)
+	}
+	if want := []SyncItem{{ID: "live", CreatedAt: 1700000001}}; !slices.Equal(items, want) {
+		t.Errorf("SyncItems: expected %v, got %v", want, items)
+	}
+}
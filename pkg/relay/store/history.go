@@ -0,0 +1,268 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+var ErrVersionNotFound = errors.New("version not found in the event history")
+
+// HistoricalEvent is a version of a replaceable or addressable event that has been superseded by a newer one.
+type HistoricalEvent struct {
+	nostr.Event
+	D          string // 'd' tag of addressable events, empty for replaceable events
+	ReplacedBy string // ID of the version that superseded the event
+	ReplacedAt time.Time
+}
+
+const historyColumns = "id, pubkey, created_at, kind, d, tags, content, sig, replaced_by, replaced_at"
+
+// EventHistory returns the archived versions of the replaceable or addressable event with the given kind,
+// pubkey and 'd' tag (empty for replaceable events), most recent first. The current version is not included.
+func (s T) EventHistory(ctx context.Context, kind int, pubkey, d string) ([]HistoricalEvent, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+historyColumns+` FROM event_history
+		WHERE kind = ? AND pubkey = ? AND d = ?
+		ORDER BY created_at DESC`, kind, pubkey, d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event history: %w", err)
+	}
+	defer rows.Close()
+
+	history, err := scanHistory(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event history: %w", err)
+	}
+	return history, nil
+}
+
+// RecentHistory returns up to limit archived versions, the most recently replaced first.
+func (s T) RecentHistory(ctx context.Context, limit int) ([]HistoricalEvent, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+historyColumns+` FROM event_history
+		ORDER BY replaced_at DESC, created_at DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event history: %w", err)
+	}
+	defer rows.Close()
+
+	history, err := scanHistory(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event history: %w", err)
+	}
+	return history, nil
+}
+
+// HistoricalVersion returns the archived version with the given ID.
+// It returns [ErrVersionNotFound] if the ID is not in the event history.
+func (s T) HistoricalVersion(ctx context.Context, ID string) (HistoricalEvent, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+historyColumns+` FROM event_history WHERE id = ?`, ID)
+	if err != nil {
+		return HistoricalEvent{}, fmt.Errorf("failed to query event history: %w", err)
+	}
+	defer rows.Close()
+
+	history, err := scanHistory(rows)
+	if err != nil {
+		return HistoricalEvent{}, fmt.Errorf("failed to query event history: %w", err)
+	}
+	if len(history) == 0 {
+		return HistoricalEvent{}, ErrVersionNotFound
+	}
+	return history[0], nil
+}
+
+// RestoreVersion makes the archived version with the given ID the current version of its event again.
+// The current version is archived as replaced by the restored one, so the restore can be undone the same way.
+// Note that the restored version is older than the one it replaces, so other relays may still serve the latter.
+// For this reason the replaced version is tombstoned, by coordinate up to its created_at for addressable events
+// and by ID for replaceable ones, so that it can't be re-published and supersede the restored one again.
+// Undoing the restore, or restoring another version the tombstone covers, requires removing it first.
+// It returns [ErrVersionNotFound] if the ID is not in the event history.
+func (s T) RestoreVersion(ctx context.Context, ID string) (nostr.Event, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+historyColumns+` FROM event_history WHERE id = ?`, ID)
+	if err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to query event history: %w", err)
+	}
+	history, err := scanHistory(rows)
+	rows.Close()
+	if err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to query event history: %w", err)
+	}
+	if len(history) == 0 {
+		return nostr.Event{}, ErrVersionNotFound
+	}
+	version := history[0]
+
+	current := `SELECT e.id FROM events e
+		WHERE e.kind = ? AND e.pubkey = ?
+		AND (e.kind < 30000 OR EXISTS (SELECT 1 FROM tags t WHERE t.event_id = e.id AND t.key = 'd' AND t.value = ?))`
+
+	archive := `INSERT OR IGNORE INTO event_history (` + historyColumns + `)
+		SELECT e.id, e.pubkey, e.created_at, e.kind, ?, json(e.tags), e.content, e.sig, ?, ?
+		FROM events e WHERE e.id IN (` + current + `)`
+
+	args := []any{version.D, version.ID, time.Now().UTC().Unix(), version.Kind, version.PubKey, version.D}
+	if _, err := tx.ExecContext(ctx, archive, args...); err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to archive the current version: %w", err)
+	}
+
+	if err := tombstoneReplaced(ctx, tx, version, current); err != nil {
+		return nostr.Event{}, err
+	}
+
+	// the current version is newer than the restored one, so the event_history_ad trigger doesn't archive it again
+	_, err = tx.ExecContext(ctx, `DELETE FROM events WHERE id IN (`+current+`)`, version.Kind, version.PubKey, version.D)
+	if err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to delete the current version: %w", err)
+	}
+
+	tags, err := json.Marshal(version.Tags)
+	if err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to marshal the event tags: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO events (id, pubkey, created_at, kind, tags, content, sig) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		version.ID, version.PubKey, version.CreatedAt, version.Kind, tags, version.Content, version.Sig)
+	if err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to restore the version: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM event_history WHERE id = ?`, ID); err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to delete the restored version from the history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return version.Event, nil
+}
+
+// tombstoneReplaced tombstones the current versions selected by the query, which are replaced by the restored version.
+func tombstoneReplaced(ctx context.Context, tx *sql.Tx, version HistoricalEvent, current string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, created_at FROM events WHERE id IN (`+current+`)`,
+		version.Kind, version.PubKey, version.D)
+	if err != nil {
+		return fmt.Errorf("failed to query the current version: %w", err)
+	}
+	defer rows.Close()
+
+	var IDs []string
+	var latest int64
+	for rows.Next() {
+		var ID string
+		var createdAt int64
+		if err := rows.Scan(&ID, &createdAt); err != nil {
+			return fmt.Errorf("failed to scan the current version: %w", err)
+		}
+		IDs = append(IDs, ID)
+		latest = max(latest, createdAt)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query the current version: %w", err)
+	}
+	rows.Close()
+
+	if len(IDs) == 0 {
+		return nil
+	}
+
+	if nostr.IsAddressableKind(version.Kind) {
+		ref := events.AddressableRef{Kind: version.Kind, Pubkey: version.PubKey, DTag: version.D}
+		return extendTombstone(ctx, tx, ref, latest, version.ID)
+	}
+
+	for _, ID := range IDs {
+		if err := addTombstone(ctx, tx, ID, sql.NullInt64{}, version.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneHistory deletes the archived versions replaced before the given time, and the versions beyond the
+// most recent maxVersions of each event. It returns the number of versions deleted.
+func (s T) PruneHistory(ctx context.Context, maxVersions int, before time.Time) (int, error) {
+	query := `DELETE FROM event_history
+		WHERE replaced_at < ? OR id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY kind, pubkey, d ORDER BY created_at DESC) AS rank
+				FROM event_history
+			)
+			WHERE rank > ?
+		)`
+
+	res, err := s.DB.ExecContext(ctx, query, before.Unix(), maxVersions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune event history: %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	return int(deleted), nil
+}
+
+// purgeIDs deletes the archived versions with the given IDs, restricted to the pubkey if not empty.
+func purgeIDs(ctx context.Context, tx *sql.Tx, pubkey string, IDs []string) error {
+	args := make([]any, 0, len(IDs)+2)
+	args = append(args, pubkey, pubkey)
+	for _, ID := range IDs {
+		args = append(args, ID)
+	}
+
+	query := `DELETE FROM event_history WHERE (? = '' OR pubkey = ?) AND id ` + inClause(len(IDs))
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to delete the archived versions by ID: %w", err)
+	}
+	return nil
+}
+
+// purgeCoordinate deletes the archived versions of the addressable coordinate created up to until,
+// or all of them if until is NULL.
+func purgeCoordinate(ctx context.Context, tx *sql.Tx, ref events.AddressableRef, until sql.NullInt64) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM event_history
+		WHERE kind = ? AND pubkey = ? AND d = ? AND (? IS NULL OR created_at <= ?)`,
+		ref.Kind, ref.Pubkey, ref.DTag, until, until)
+	if err != nil {
+		return fmt.Errorf("failed to delete the archived versions of %s: %w", ref, err)
+	}
+	return nil
+}
+
+// scanHistory scans rows of [historyColumns] into historical events.
+func scanHistory(rows *sql.Rows) ([]HistoricalEvent, error) {
+	var history []HistoricalEvent
+	for rows.Next() {
+		var h HistoricalEvent
+		var tags string
+		var replacedAt int64
+
+		err := rows.Scan(&h.ID, &h.PubKey, &h.CreatedAt, &h.Kind, &h.D, &tags, &h.Content, &h.Sig, &h.ReplacedBy, &replacedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan historical event: %w", err)
+		}
+		if err := json.Unmarshal([]byte(tags), &h.Tags); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the tags of %s: %w", h.ID, err)
+		}
+
+		h.ReplacedAt = time.Unix(replacedAt, 0).UTC()
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
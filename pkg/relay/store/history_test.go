@@ -0,0 +1,237 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestEventHistory(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	app := func(ID string, createdAt nostr.Timestamp, d, name string) *nostr.Event {
+		return &nostr.Event{ID: ID, PubKey: "alice", CreatedAt: createdAt, Kind: events.KindApp, Tags: nostr.Tags{{"d", d}, {"name", name}}}
+	}
+
+	replaced := []*nostr.Event{
+		app("v1", 1700000000, "com.example", "Example"),
+		app("v2", 1700000001, "com.example", "Defaced"),
+		app("v3", 1700000002, "com.example", "Defaced again"),
+		app("other", 1700000003, "com.other", "Other"),
+		{ID: "profile1", PubKey: "alice", CreatedAt: 1700000000, Kind: nostr.KindProfileMetadata, Content: `{"name":"alice"}`},
+		{ID: "profile2", PubKey: "alice", CreatedAt: 1700000001, Kind: nostr.KindProfileMetadata, Content: `{"name":"mallory"}`},
+	}
+	for _, e := range replaced {
+		if _, err := store.Replace(ctx, e); err != nil {
+			t.Fatalf("failed to replace event %s: %v", e.ID, err)
+		}
+	}
+
+	// deleting the current version doesn't archive it, because no newer version exists
+	if _, err := store.Delete(ctx, nostr.Filter{IDs: []string{"other"}}); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	history, err := store.EventHistory(ctx, events.KindApp, "alice", "com.example")
+	if err != nil {
+		t.Fatalf("EventHistory: %v", err)
+	}
+	if IDs := historyIDs(history); !reflect.DeepEqual(IDs, []string{"v2", "v1"}) {
+		t.Fatalf("expected the history [v2 v1], got %v", IDs)
+	}
+	if history[0].ReplacedBy != "v3" || history[1].ReplacedBy != "v2" {
+		t.Errorf("expected v2 and v1 to be replaced by v3 and v2, got %s and %s", history[0].ReplacedBy, history[1].ReplacedBy)
+	}
+	if !reflect.DeepEqual(history[1].Tags, replaced[0].Tags) {
+		t.Errorf("expected the archived tags %v, got %v", replaced[0].Tags, history[1].Tags)
+	}
+
+	profiles, err := store.EventHistory(ctx, nostr.KindProfileMetadata, "alice", "")
+	if err != nil {
+		t.Fatalf("EventHistory: %v", err)
+	}
+	if IDs := historyIDs(profiles); !reflect.DeepEqual(IDs, []string{"profile1"}) {
+		t.Errorf("expected the profile history [profile1], got %v", IDs)
+	}
+
+	restored, err := store.RestoreVersion(ctx, "v1")
+	if err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+	if restored.ID != "v1" {
+		t.Errorf("expected to restore v1, got %s", restored.ID)
+	}
+
+	current, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Authors: []string{"alice"}, Tags: nostr.TagMap{"d": {"com.example"}}, Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if IDs := eventIDs(current); !reflect.DeepEqual(IDs, []string{"v1"}) {
+		t.Errorf("expected the current version [v1], got %v", IDs)
+	}
+
+	history, err = store.EventHistory(ctx, events.KindApp, "alice", "com.example")
+	if err != nil {
+		t.Fatalf("EventHistory: %v", err)
+	}
+	if IDs := historyIDs(history); !reflect.DeepEqual(IDs, []string{"v3", "v2"}) {
+		t.Errorf("expected the history [v3 v2] after the restore, got %v", IDs)
+	}
+	if history[0].ReplacedBy != "v1" {
+		t.Errorf("expected v3 to be replaced by v1, got %s", history[0].ReplacedBy)
+	}
+
+	// the replaced versions can't be re-published, while newer versions can
+	if _, err := store.RestoreVersion(ctx, "profile1"); err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+
+	tombstones := []struct {
+		event      *nostr.Event
+		tombstoned bool
+	}{
+		{event: replaced[2], tombstoned: true},
+		{event: replaced[1], tombstoned: true},
+		{event: app("v4", 1700000003, "com.example", "Example 2"), tombstoned: false},
+		{event: replaced[5], tombstoned: true},
+		{event: &nostr.Event{ID: "profile3", PubKey: "alice", CreatedAt: 1700000002, Kind: nostr.KindProfileMetadata}, tombstoned: false},
+	}
+	for _, test := range tombstones {
+		tombstoned, err := store.IsTombstoned(ctx, test.event)
+		if err != nil {
+			t.Fatalf("IsTombstoned: %v", err)
+		}
+		if tombstoned != test.tombstoned {
+			t.Errorf("expected %s to be tombstoned %t, got %t", test.event.ID, test.tombstoned, tombstoned)
+		}
+	}
+
+	if _, err := store.RestoreVersion(ctx, "missing"); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("expected %v, got %v", ErrVersionNotFound, err)
+	}
+}
+
+func TestDeletionPurgesHistory(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	app := func(ID string, createdAt nostr.Timestamp, d string) *nostr.Event {
+		return &nostr.Event{ID: ID, PubKey: "alice", CreatedAt: createdAt, Kind: events.KindApp, Tags: nostr.Tags{{"d", d}}}
+	}
+
+	replaced := []*nostr.Event{
+		app("v1", 1700000000, "com.example"),
+		app("v2", 1700000001, "com.example"),
+		app("v3", 1700000002, "com.example"),
+		app("o1", 1700000000, "com.other"),
+		app("o2", 1700000001, "com.other"),
+	}
+	for _, e := range replaced {
+		if _, err := store.Replace(ctx, e); err != nil {
+			t.Fatalf("failed to replace event %s: %v", e.ID, err)
+		}
+	}
+
+	deletion := func(pubkey string, createdAt nostr.Timestamp, tags ...nostr.Tag) *nostr.Event {
+		return &nostr.Event{ID: "deletion", PubKey: pubkey, CreatedAt: createdAt, Kind: nostr.KindDeletion, Tags: tags}
+	}
+	assertHistory := func(d string, expected []string) {
+		t.Helper()
+		history, err := store.EventHistory(ctx, events.KindApp, "alice", d)
+		if err != nil {
+			t.Fatalf("EventHistory: %v", err)
+		}
+		if IDs := historyIDs(history); !reflect.DeepEqual(IDs, expected) {
+			t.Errorf("expected the history of %s %v, got %v", d, expected, IDs)
+		}
+	}
+
+	// NIP-09 deletions only apply to the versions of the same pubkey
+	if _, err := store.DeleteRequest(ctx, deletion("bob", 1700000010, nostr.Tag{"e", "o1"})); err != nil {
+		t.Fatalf("DeleteRequest: %v", err)
+	}
+	assertHistory("com.other", []string{"o1"})
+
+	// the versions up to the request are deleted, the newer current version is kept
+	example := events.AddressableRef{Kind: events.KindApp, Pubkey: "alice", DTag: "com.example"}
+	if _, err := store.DeleteRequest(ctx, deletion("alice", 1700000001, nostr.Tag{"a", example.String()})); err != nil {
+		t.Fatalf("DeleteRequest: %v", err)
+	}
+	assertHistory("com.example", []string{})
+
+	current, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Tags: nostr.TagMap{"d": {"com.example"}}, Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if IDs := eventIDs(current); !reflect.DeepEqual(IDs, []string{"v3"}) {
+		t.Errorf("expected the current version [v3], got %v", IDs)
+	}
+
+	// operator deletions apply to any pubkey
+	if _, err := store.ForceDeleteRequest(ctx, deletion("operator", 1700000010, nostr.Tag{"e", "o1"})); err != nil {
+		t.Fatalf("ForceDeleteRequest: %v", err)
+	}
+	assertHistory("com.other", []string{})
+
+	if _, err := store.RestoreVersion(ctx, "o1"); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("expected %v, got %v", ErrVersionNotFound, err)
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for i, ID := range []string{"v1", "v2", "v3", "v4"} {
+		app := &nostr.Event{ID: ID, PubKey: "alice", CreatedAt: nostr.Timestamp(1700000000 + i), Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}}}
+		if _, err := store.Replace(ctx, app); err != nil {
+			t.Fatalf("failed to replace event %s: %v", ID, err)
+		}
+	}
+
+	deleted, err := store.PruneHistory(ctx, 2, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("PruneHistory: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 version beyond the limit to be pruned, got %d", deleted)
+	}
+
+	history, err := store.EventHistory(ctx, events.KindApp, "alice", "com.example")
+	if err != nil {
+		t.Fatalf("EventHistory: %v", err)
+	}
+	if IDs := historyIDs(history); !reflect.DeepEqual(IDs, []string{"v3", "v2"}) {
+		t.Errorf("expected the history [v3 v2], got %v", IDs)
+	}
+
+	deleted, err = store.PruneHistory(ctx, 2, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("PruneHistory: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected the 2 versions past the retention to be pruned, got %d", deleted)
+	}
+}
+
+func historyIDs(history []HistoricalEvent) []string {
+	IDs := make([]string, len(history))
+	for i, h := range history {
+		IDs[i] = h.ID
+	}
+	return IDs
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zapstore/relay/pkg/events"
+)
+
+// BannedEvent is an event banned by the operator.
+type BannedEvent struct {
+	ID       string
+	Reason   string
+	BannedAt time.Time
+}
+
+// ReportedEvent is a stored event reported (kind 1984) by other pubkeys.
+type ReportedEvent struct {
+	ID        string
+	Reporters int // number of distinct pubkeys that reported the event
+}
+
+// BanEvent bans the event with the given ID, deleting it from the events and the pending events.
+// It returns whether a stored or pending event was deleted. Banning an event twice updates its reason.
+func (s T) BanEvent(ctx context.Context, ID, reason string) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO banned_events (id, reason, banned_at) VALUES (?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET reason = excluded.reason`, ID, reason, time.Now().UTC().Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to ban event: %w", err)
+	}
+
+	var deleted int64
+	for _, table := range []string{"events", "pending_events"} {
+		res, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE id = ?", ID)
+		if err != nil {
+			return false, fmt.Errorf("failed to delete banned event from %s: %w", table, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return false, fmt.Errorf("failed to check rows affected: %w", err)
+		}
+		deleted += affected
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return deleted > 0, nil
+}
+
+// UnbanEvent lifts the ban of the event with the given ID, so that it can be published again.
+// It returns whether the event was banned.
+func (s T) UnbanEvent(ctx context.Context, ID string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM banned_events WHERE id = ?`, ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unban event: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// IsEventBanned returns whether the event with the given ID is banned.
+func (s T) IsEventBanned(ctx context.Context, ID string) (bool, error) {
+	var banned bool
+	if err := s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM banned_events WHERE id = ?)`, ID).Scan(&banned); err != nil {
+		return false, fmt.Errorf("failed to check banned event: %w", err)
+	}
+	return banned, nil
+}
+
+// BannedEvents returns all the banned events, most recent first.
+func (s T) BannedEvents(ctx context.Context) ([]BannedEvent, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, reason, banned_at FROM banned_events ORDER BY banned_at DESC, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query banned events: %w", err)
+	}
+	defer rows.Close()
+
+	var banned []BannedEvent
+	for rows.Next() {
+		var b BannedEvent
+		var bannedAt int64
+		if err := rows.Scan(&b.ID, &b.Reason, &bannedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan banned event: %w", err)
+		}
+		b.BannedAt = time.Unix(bannedAt, 0)
+		banned = append(banned, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query banned events: %w", err)
+	}
+	return banned, nil
+}
+
+// ReportedEvents returns up to limit stored events referenced by the 'e' tags of reports (kind 1984),
+// the most reported first.
+func (s T) ReportedEvents(ctx context.Context, limit int) ([]ReportedEvent, error) {
+	query := `SELECT t.value, COUNT(DISTINCT r.pubkey) AS reporters
+		FROM events r
+		JOIN tags t ON t.event_id = r.id AND t.key = 'e'
+		WHERE r.kind = ? AND EXISTS (SELECT 1 FROM events WHERE id = t.value)
+		GROUP BY t.value
+		ORDER BY reporters DESC, t.value
+		LIMIT ?`
+
+	rows, err := s.DB.QueryContext(ctx, query, events.KindReport, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reported events: %w", err)
+	}
+	defer rows.Close()
+
+	var reported []ReportedEvent
+	for rows.Next() {
+		var r ReportedEvent
+		if err := rows.Scan(&r.ID, &r.Reporters); err != nil {
+			return nil, fmt.Errorf("failed to scan reported event: %w", err)
+		}
+		reported = append(reported, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query reported events: %w", err)
+	}
+	return reported, nil
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestBanEvent(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	post := nostr.Event{ID: "post", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindForumPost, Content: "spam"}
+	if _, err := store.Save(ctx, &post); err != nil {
+		t.Fatalf("failed to save event %s: %v", post.ID, err)
+	}
+
+	deleted, err := store.BanEvent(ctx, "post", "spam")
+	if err != nil {
+		t.Fatalf("BanEvent: %v", err)
+	}
+	if !deleted {
+		t.Error("expected the banned event to be deleted")
+	}
+
+	found, err := store.Query(ctx, nostr.Filter{IDs: []string{"post"}})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("expected the banned event to be deleted, got %v", eventIDs(found))
+	}
+
+	// banning an event that is not stored records the ban, and updates the reason
+	if deleted, err := store.BanEvent(ctx, "missing", "illegal"); err != nil || deleted {
+		t.Fatalf("BanEvent of a missing event: deleted %v, error %v", deleted, err)
+	}
+	if _, err := store.BanEvent(ctx, "missing", "malware"); err != nil {
+		t.Fatalf("BanEvent: %v", err)
+	}
+
+	banned, err := store.IsEventBanned(ctx, "post")
+	if err != nil {
+		t.Fatalf("IsEventBanned: %v", err)
+	}
+	if !banned {
+		t.Error("expected the event to be banned")
+	}
+
+	list, err := store.BannedEvents(ctx)
+	if err != nil {
+		t.Fatalf("BannedEvents: %v", err)
+	}
+	reasons := make(map[string]string)
+	for _, b := range list {
+		reasons[b.ID] = b.Reason
+	}
+	if want := map[string]string{"post": "spam", "missing": "malware"}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("expected banned events %v, got %v", want, reasons)
+	}
+
+	unbanned, err := store.UnbanEvent(ctx, "post")
+	if err != nil {
+		t.Fatalf("UnbanEvent: %v", err)
+	}
+	if !unbanned {
+		t.Error("expected the event to be unbanned")
+	}
+	if banned, _ := store.IsEventBanned(ctx, "post"); banned {
+		t.Error("expected the event not to be banned anymore")
+	}
+}
+
+func TestReportedEvents(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	saved := []nostr.Event{
+		{ID: "app", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}}},
+		{ID: "post", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindForumPost},
+		{ID: "report1", PubKey: "bob", CreatedAt: 1700000001, Kind: events.KindReport, Tags: nostr.Tags{{"e", "app", "malware"}, {"e", "post", "spam"}}},
+		{ID: "report2", PubKey: "carol", CreatedAt: 1700000002, Kind: events.KindReport, Tags: nostr.Tags{{"e", "app", "malware"}}},
+		{ID: "report3", PubKey: "carol", CreatedAt: 1700000003, Kind: events.KindReport, Tags: nostr.Tags{{"e", "app", "impersonation"}}},
+		{ID: "report4", PubKey: "bob", CreatedAt: 1700000004, Kind: events.KindReport, Tags: nostr.Tags{{"e", "deleted", "spam"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	reported, err := store.ReportedEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("ReportedEvents: %v", err)
+	}
+
+	want := []ReportedEvent{{ID: "app", Reporters: 2}, {ID: "post", Reporters: 1}}
+	if !reflect.DeepEqual(reported, want) {
+		t.Errorf("expected reported events %v, got %v", want, reported)
+	}
+}
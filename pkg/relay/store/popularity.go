@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Popularity is the number of downloads and impressions of an app of a pubkey, collected by the analytics.
+type Popularity struct {
+	AppID       string
+	Pubkey      string
+	Downloads   int
+	Impressions int
+}
+
+// Weights of the signals in the popularity score. Downloads are a stronger signal than impressions,
+// because they require the user to install the app.
+const (
+	downloadsWeight   = 0.7
+	impressionsWeight = 0.3
+)
+
+// popularRank returns the ranking of searches with 'sort:popular'. The BM25 relevance (negative, lower is better)
+// is amplified by the popularity score, the recency of the latest release (halving after 90 days),
+// and whether the developer is verified. It expects the 'd' tag of the app joined as d.
+func popularRank(relevance string) string {
+	return relevance + ` * (1
+	+ 2.0 * COALESCE((SELECT score FROM app_popularity WHERE app_id = d.value AND pubkey = e.pubkey), 0)
+	+ 0.5 * COALESCE((SELECT 1.0 / (1 + (unixepoch() - MAX(created_at)) / 7776000.0)
+		FROM latest_releases WHERE app_id = d.value AND pubkey = e.pubkey), 0)
+	+ 0.5 * EXISTS (SELECT 1 FROM verified_apps WHERE app_id = d.value AND pubkey = e.pubkey))`
+}
+
+// SavePopularity replaces the popularity of all apps with the given one, scoring each app in [0, 1]
+// by its log-scaled downloads and impressions relative to the most popular app.
+func (s T) SavePopularity(ctx context.Context, apps []Popularity) error {
+	var maxDownloads, maxImpressions int
+	for _, app := range apps {
+		maxDownloads = max(maxDownloads, app.Downloads)
+		maxImpressions = max(maxImpressions, app.Impressions)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM app_popularity"); err != nil {
+		return fmt.Errorf("failed to clear popularity: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR REPLACE INTO app_popularity (app_id, pubkey, downloads, impressions, score, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for _, app := range apps {
+		score := downloadsWeight*logRatio(app.Downloads, maxDownloads) + impressionsWeight*logRatio(app.Impressions, maxImpressions)
+		if _, err := stmt.ExecContext(ctx, app.AppID, app.Pubkey, app.Downloads, app.Impressions, score, now); err != nil {
+			return fmt.Errorf("failed to save popularity of %s: %w", app.AppID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// logRatio returns log(1+n) / log(1+max), which is 0 when max is 0.
+func logRatio(n, max int) float64 {
+	if max <= 0 || n <= 0 {
+		return 0
+	}
+	return math.Log1p(float64(n)) / math.Log1p(float64(max))
+}
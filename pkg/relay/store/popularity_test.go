@@ -0,0 +1,68 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestSearchPopular(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	saved := []nostr.Event{
+		{ID: "clone", PubKey: "mallory", CreatedAt: 1700000001, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.clone.signal"}, {"name", "Signal"}}},
+		{ID: "real", PubKey: "alice", CreatedAt: 1700000002, Kind: events.KindApp, Tags: nostr.Tags{{"d", "org.thoughtcrime.securesms"}, {"name", "Signal Private Messenger"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	popularity := []Popularity{
+		{AppID: "org.thoughtcrime.securesms", Pubkey: "alice", Downloads: 1_000_000, Impressions: 50_000},
+		{AppID: "com.clone.signal", Pubkey: "mallory", Downloads: 3, Impressions: 10},
+	}
+	if err := store.SavePopularity(ctx, popularity); err != nil {
+		t.Fatalf("SavePopularity: %v", err)
+	}
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{search: "signal", want: []string{"clone", "real"}},
+		{search: "signal sort:popular", want: []string{"real", "clone"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.search, func(t *testing.T) {
+			results, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: test.search, Limit: 10})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if IDs := eventIDs(results); !reflect.DeepEqual(IDs, test.want) {
+				t.Errorf("expected %v, got %v", test.want, IDs)
+			}
+		})
+	}
+
+	// refreshing replaces the previous popularity
+	if err := store.SavePopularity(ctx, popularity[1:]); err != nil {
+		t.Fatalf("SavePopularity: %v", err)
+	}
+
+	var count int
+	if err := store.DB.QueryRow("SELECT COUNT(*) FROM app_popularity").Scan(&count); err != nil {
+		t.Fatalf("failed to count popularity: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 app after the refresh, got %d", count)
+	}
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Probe is the cached result of the HEAD requests to a 'url' tag of the pending assets waiting on a blob.
+type Probe struct {
+	Hash        string
+	URL         string
+	Available   bool   // whether the last HEAD request succeeded
+	Failures    int    // consecutive failed HEAD requests
+	LastStatus  string // status or error of the last HEAD request
+	CheckedAt   time.Time
+	NextCheckAt time.Time
+}
+
+// ScheduleProbes schedules an immediate HEAD request to each of the urls that should serve the blob
+// with the given hash. URLs that are already scheduled keep their schedule and cached result.
+func (s T) ScheduleProbes(ctx context.Context, hash string, urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC().Unix()
+	query := `INSERT OR IGNORE INTO url_probes (hash, url, next_check_at) VALUES (?, ?, ?)`
+
+	for _, url := range urls {
+		if _, err := s.DB.ExecContext(ctx, query, hash, url, now); err != nil {
+			return fmt.Errorf("failed to schedule probe of %s: %w", url, err)
+		}
+	}
+	return nil
+}
+
+// DueProbes returns up to limit unavailable probes whose next check is before the given time,
+// and whose blob is still awaited by a pending event. The most overdue probes are returned first.
+func (s T) DueProbes(ctx context.Context, now time.Time, limit int) ([]Probe, error) {
+	query := `SELECT hash, url, available, failures, last_status, checked_at, next_check_at
+		FROM url_probes
+		WHERE available = 0 AND next_check_at <= ?
+			AND EXISTS (SELECT 1 FROM pending_events WHERE pending_events.hash = url_probes.hash)
+		ORDER BY next_check_at ASC
+		LIMIT ?`
+
+	rows, err := s.DB.QueryContext(ctx, query, now.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due probes: %w", err)
+	}
+	defer rows.Close()
+
+	var probes []Probe
+	for rows.Next() {
+		var p Probe
+		var status sql.NullString
+		var checkedAt sql.NullInt64
+		var nextCheckAt int64
+
+		err := rows.Scan(&p.Hash, &p.URL, &p.Available, &p.Failures, &status, &checkedAt, &nextCheckAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan probe: %w", err)
+		}
+
+		p.LastStatus = status.String
+		if checkedAt.Valid {
+			p.CheckedAt = time.Unix(checkedAt.Int64, 0).UTC()
+		}
+		p.NextCheckAt = time.Unix(nextCheckAt, 0).UTC()
+		probes = append(probes, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query due probes: %w", err)
+	}
+	return probes, nil
+}
+
+// SaveProbe stores the result of a HEAD request, and the time of the next one.
+func (s T) SaveProbe(ctx context.Context, p Probe) error {
+	query := `UPDATE url_probes SET available = ?, failures = ?, last_status = ?, checked_at = ?, next_check_at = ?
+		WHERE hash = ? AND url = ?`
+
+	_, err := s.DB.ExecContext(ctx, query,
+		p.Available, p.Failures, p.LastStatus, p.CheckedAt.Unix(), p.NextCheckAt.Unix(), p.Hash, p.URL,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save probe of %s: %w", p.URL, err)
+	}
+	return nil
+}
+
+// IsAvailable returns whether a HEAD request to any of the urls of the blob with the given hash succeeded.
+func (s T) IsAvailable(ctx context.Context, hash string) (bool, error) {
+	var available bool
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM url_probes WHERE hash = ? AND available = 1)`, hash,
+	).Scan(&available)
+	if err != nil {
+		return false, fmt.Errorf("failed to check probes: %w", err)
+	}
+	return available, nil
+}
+
+// DeleteStaleProbes removes the probes of the blobs no pending event is waiting on.
+func (s T) DeleteStaleProbes(ctx context.Context) error {
+	query := `DELETE FROM url_probes
+		WHERE NOT EXISTS (SELECT 1 FROM pending_events WHERE pending_events.hash = url_probes.hash)`
+
+	if _, err := s.DB.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to delete stale probes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestProbes(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	asset := nostr.Event{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash1"}}}
+	if _, err := store.SavePending(ctx, &asset); err != nil {
+		t.Fatalf("SavePending: %v", err)
+	}
+
+	urls := []string{"https://example.com/a.apk", "https://mirror.example.com/a.apk"}
+	if err := store.ScheduleProbes(ctx, "hash1", urls); err != nil {
+		t.Fatalf("ScheduleProbes: %v", err)
+	}
+
+	// probes of blobs no pending event is waiting on are never due
+	if err := store.ScheduleProbes(ctx, "hash2", []string{"https://example.com/b.apk"}); err != nil {
+		t.Fatalf("ScheduleProbes: %v", err)
+	}
+
+	now := time.Now()
+	due, err := store.DueProbes(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("DueProbes: %v", err)
+	}
+	if len(due) != 2 {
+		t.Fatalf("expected 2 due probes, got %d", len(due))
+	}
+
+	// the first url fails and is retried later, the second succeeds
+	failed := due[0]
+	failed.Failures = 1
+	failed.LastStatus = "404 Not Found"
+	failed.CheckedAt = now
+	failed.NextCheckAt = now.Add(time.Minute)
+
+	succeeded := due[1]
+	succeeded.Available = true
+	succeeded.LastStatus = "200 OK"
+	succeeded.CheckedAt = now
+
+	for _, p := range []Probe{failed, succeeded} {
+		if err := store.SaveProbe(ctx, p); err != nil {
+			t.Fatalf("SaveProbe: %v", err)
+		}
+	}
+
+	due, err = store.DueProbes(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("DueProbes: %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("expected no due probes, got %v", due)
+	}
+
+	due, err = store.DueProbes(ctx, now.Add(2*time.Minute), 10)
+	if err != nil {
+		t.Fatalf("DueProbes: %v", err)
+	}
+	if len(due) != 1 || due[0].URL != failed.URL || due[0].Failures != 1 || due[0].LastStatus != "404 Not Found" {
+		t.Fatalf("expected the failed probe to be due again, got %v", due)
+	}
+
+	available, err := store.IsAvailable(ctx, "hash1")
+	if err != nil {
+		t.Fatalf("IsAvailable: %v", err)
+	}
+	if !available {
+		t.Errorf("expected hash1 to be available")
+	}
+
+	// once the asset is no longer pending, its probes are stale
+	if err := store.DeletePending(ctx, "asset1"); err != nil {
+		t.Fatalf("DeletePending: %v", err)
+	}
+	if err := store.DeleteStaleProbes(ctx); err != nil {
+		t.Fatalf("DeleteStaleProbes: %v", err)
+	}
+
+	available, err = store.IsAvailable(ctx, "hash1")
+	if err != nil {
+		t.Fatalf("IsAvailable: %v", err)
+	}
+	if available {
+		t.Errorf("expected the probes of hash1 to be deleted")
+	}
+}
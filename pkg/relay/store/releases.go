@@ -0,0 +1,235 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+var ErrReleaseNotFound = errors.New("release not found")
+
+// LatestRelease is the most recent release (kind 30063) of an app for a pubkey, channel and platform,
+// together with the asset (kind 3063, or legacy kind 1063) for that platform.
+type LatestRelease struct {
+	AppID       string
+	Pubkey      string
+	Channel     string // 'c' tag of the release, "main" for legacy releases
+	Platform    string // 'f' tag of the asset
+	ReleaseID   string
+	AssetID     string
+	Version     string
+	VersionCode int64 // 0 if the asset has no version code
+	CreatedAt   nostr.Timestamp
+}
+
+// LatestReleaseFilter selects latest releases. Empty fields match any value.
+type LatestReleaseFilter struct {
+	AppID    string
+	Pubkey   string
+	Channel  string
+	Platform string
+	Limit    int
+}
+
+// LatestReleases returns the latest releases matching the filter, most recent first.
+func (s T) LatestReleases(ctx context.Context, filter LatestReleaseFilter) ([]LatestRelease, error) {
+	var conditions []string
+	var args []any
+
+	if filter.AppID != "" {
+		conditions = append(conditions, "app_id = ?")
+		args = append(args, filter.AppID)
+	}
+	if filter.Pubkey != "" {
+		conditions = append(conditions, "pubkey = ?")
+		args = append(args, filter.Pubkey)
+	}
+	if filter.Channel != "" {
+		conditions = append(conditions, "channel = ?")
+		args = append(args, filter.Channel)
+	}
+	if filter.Platform != "" {
+		conditions = append(conditions, "platform = ?")
+		args = append(args, filter.Platform)
+	}
+
+	query := `SELECT app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at
+		FROM latest_releases`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, app_id ASC, platform ASC"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest releases: %w", err)
+	}
+	defer rows.Close()
+
+	releases, err := scanLatestReleases(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest releases: %w", err)
+	}
+	return releases, nil
+}
+
+// ReleaseKey identifies the latest release of an app for a pubkey, channel and platform.
+type ReleaseKey struct {
+	AppID    string
+	Pubkey   string
+	Channel  string
+	Platform string
+}
+
+// LatestReleasesOf returns the latest releases of the keys in a single query, by key.
+// Keys without a release are not in the map.
+func (s T) LatestReleasesOf(ctx context.Context, keys []ReleaseKey) (map[ReleaseKey]LatestRelease, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, 0, 4*len(keys))
+	for _, k := range keys {
+		args = append(args, k.AppID, k.Pubkey, k.Channel, k.Platform)
+	}
+
+	query := `SELECT app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at
+		FROM latest_releases
+		WHERE (app_id, pubkey, channel, platform) IN (VALUES (?, ?, ?, ?)` + strings.Repeat(", (?, ?, ?, ?)", len(keys)-1) + `)`
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest releases: %w", err)
+	}
+	defer rows.Close()
+
+	releases, err := scanLatestReleases(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest releases: %w", err)
+	}
+
+	byKey := make(map[ReleaseKey]LatestRelease, len(releases))
+	for _, r := range releases {
+		byKey[ReleaseKey{AppID: r.AppID, Pubkey: r.Pubkey, Channel: r.Channel, Platform: r.Platform}] = r
+	}
+	return byKey, nil
+}
+
+// scanLatestReleases scans the rows of latest_releases, in the order of the columns selected by [T.LatestReleases].
+func scanLatestReleases(rows *sql.Rows) ([]LatestRelease, error) {
+	var releases []LatestRelease
+	for rows.Next() {
+		var r LatestRelease
+		var versionCode sql.NullInt64
+		err := rows.Scan(&r.AppID, &r.Pubkey, &r.Channel, &r.Platform,
+			&r.ReleaseID, &r.AssetID, &r.Version, &versionCode, &r.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan latest release: %w", err)
+		}
+
+		r.VersionCode = versionCode.Int64
+		releases = append(releases, r)
+	}
+	return releases, rows.Err()
+}
+
+// LatestRelease returns the latest release of the app for the pubkey, channel and platform.
+// It returns [ErrReleaseNotFound] if there is none.
+func (s T) LatestRelease(ctx context.Context, appID, pubkey, channel, platform string) (LatestRelease, error) {
+	if appID == "" || pubkey == "" || channel == "" || platform == "" {
+		return LatestRelease{}, errors.New("app ID, pubkey, channel and platform must be specified")
+	}
+
+	filter := LatestReleaseFilter{AppID: appID, Pubkey: pubkey, Channel: channel, Platform: platform}
+	releases, err := s.LatestReleases(ctx, filter)
+	if err != nil {
+		return LatestRelease{}, err
+	}
+	if len(releases) == 0 {
+		return LatestRelease{}, ErrReleaseNotFound
+	}
+	return releases[0], nil
+}
+
+// LatestVersion returns the version of the most recent release of the app for the pubkey,
+// preferring the "main" channel over the others. Apps whose assets (kind 3063) are not part of any release
+// fall back to the version of their most recent asset. It returns [ErrReleaseNotFound] if there is none.
+func (s T) LatestVersion(ctx context.Context, appID, pubkey string) (string, error) {
+	query := `SELECT version FROM latest_releases
+		WHERE app_id = ? AND pubkey = ?
+		ORDER BY channel = 'main' DESC, created_at DESC, version_code DESC
+		LIMIT 1`
+
+	var version string
+	err := s.DB.QueryRowContext(ctx, query, appID, pubkey).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.latestAssetVersion(ctx, appID, pubkey)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query latest version: %w", err)
+	}
+	return version, nil
+}
+
+// latestAssetVersion returns the 'version' tag of the most recent asset of the app for the pubkey.
+func (s T) latestAssetVersion(ctx context.Context, appID, pubkey string) (string, error) {
+	filter := nostr.Filter{
+		Kinds:   []int{events.KindAsset},
+		Authors: []string{pubkey},
+		Tags:    nostr.TagMap{"i": {appID}},
+		Limit:   1,
+	}
+	assets, err := s.Query(ctx, filter)
+	if err != nil {
+		return "", fmt.Errorf("failed to query latest asset: %w", err)
+	}
+	if len(assets) == 0 {
+		return "", ErrReleaseNotFound
+	}
+
+	version, ok := events.Find(assets[0].Tags, "version")
+	if !ok {
+		return "", fmt.Errorf("no version tag found in asset %s", assets[0].ID)
+	}
+	return version, nil
+}
+
+// backfillLatestReleases populates the latest_releases table from the stored events,
+// if the table is empty. This is needed only once, for databases created before the table existed.
+func backfillLatestReleases(db *sql.DB) error {
+	var populated bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM latest_releases)`).Scan(&populated); err != nil {
+		return fmt.Errorf("failed to check latest releases: %w", err)
+	}
+	if populated {
+		return nil
+	}
+
+	query := `INSERT OR REPLACE INTO latest_releases
+		(app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at)
+		SELECT app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at
+		FROM (
+			SELECT *, ROW_NUMBER() OVER (
+				PARTITION BY app_id, pubkey, channel, platform
+				ORDER BY created_at DESC, COALESCE(version_code, 0) DESC
+			) AS rank
+			FROM release_assets
+			WHERE app_id IS NOT NULL AND app_id != ''
+		)
+		WHERE rank = 1`
+
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to backfill latest releases: %w", err)
+	}
+	return nil
+}
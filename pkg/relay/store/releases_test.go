@@ -0,0 +1,246 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/events/legacy"
+)
+
+func TestLatestReleases(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	stored := []nostr.Event{
+		// assets stored before their releases
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 100, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "1.0"}, {"version_code", "10"}, {"f", "android-arm64-v8a"}}},
+		{ID: "asset2", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 200, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "2.0"}, {"version_code", "20"}, {"f", "android-arm64-v8a"}, {"f", "android-x86_64"}}},
+		{ID: "release1", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 100, Tags: nostr.Tags{{"d", "com.example.app@1.0"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset1"}}},
+		{ID: "release2", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 200, Tags: nostr.Tags{{"d", "com.example.app@2.0"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset2"}}},
+
+		// release stored before its asset
+		{ID: "release3", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 300, Tags: nostr.Tags{{"d", "com.example.app@3.0-beta"}, {"i", "com.example.app"}, {"c", "beta"}, {"e", "asset3"}}},
+		{ID: "asset3", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 300, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "3.0-beta"}, {"version_code", "30"}, {"f", "android-arm64-v8a"}}},
+
+		// an older release stored last doesn't replace the latest
+		{ID: "asset0", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 50, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "0.9"}, {"version_code", "9"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release0", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 50, Tags: nostr.Tags{{"d", "com.example.app@0.9"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset0"}}},
+
+		// the asset of another pubkey is ignored
+		{ID: "asset4", PubKey: "bob", Kind: events.KindAsset, CreatedAt: 400, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "4.0"}, {"version_code", "40"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release4", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 400, Tags: nostr.Tags{{"d", "com.example.app@4.0"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset4"}}},
+
+		// legacy release and file
+		{ID: "file1", PubKey: "carol", Kind: legacy.KindFile, CreatedAt: 100, Tags: nostr.Tags{{"version", "1.5"}, {"version_code", "15"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release5", PubKey: "carol", Kind: events.KindRelease, CreatedAt: 100, Tags: nostr.Tags{{"d", "com.legacy.app@1.5"}, {"e", "file1"}}},
+	}
+
+	for _, e := range stored {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		appID     string
+		pubkey    string
+		channel   string
+		platform  string
+		releaseID string
+		assetID   string
+		version   string
+	}{
+		{name: "main arm64", appID: "com.example.app", pubkey: "alice", channel: "main", platform: "android-arm64-v8a", releaseID: "release2", assetID: "asset2", version: "2.0"},
+		{name: "main x86_64", appID: "com.example.app", pubkey: "alice", channel: "main", platform: "android-x86_64", releaseID: "release2", assetID: "asset2", version: "2.0"},
+		{name: "beta arm64", appID: "com.example.app", pubkey: "alice", channel: "beta", platform: "android-arm64-v8a", releaseID: "release3", assetID: "asset3", version: "3.0-beta"},
+		{name: "legacy", appID: "com.legacy.app", pubkey: "carol", channel: "main", platform: "android-arm64-v8a", releaseID: "release5", assetID: "file1", version: "1.5"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := store.LatestRelease(ctx, test.appID, test.pubkey, test.channel, test.platform)
+			if err != nil {
+				t.Fatalf("LatestRelease: %v", err)
+			}
+			if got.ReleaseID != test.releaseID || got.AssetID != test.assetID || got.Version != test.version {
+				t.Errorf("expected %s/%s/%s, got %+v", test.releaseID, test.assetID, test.version, got)
+			}
+		})
+	}
+
+	_, err = store.LatestRelease(ctx, "com.example.app", "alice", "beta", "android-x86_64")
+	if !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("expected %v, got %v", ErrReleaseNotFound, err)
+	}
+
+	all, err := store.LatestReleases(ctx, LatestReleaseFilter{Pubkey: "alice"})
+	if err != nil {
+		t.Fatalf("LatestReleases: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("expected 3 latest releases for alice, got %d", len(all))
+	}
+
+	keys := make([]ReleaseKey, 0, len(tests)+1)
+	for _, test := range tests {
+		keys = append(keys, ReleaseKey{AppID: test.appID, Pubkey: test.pubkey, Channel: test.channel, Platform: test.platform})
+	}
+	keys = append(keys, ReleaseKey{AppID: "com.example.app", Pubkey: "alice", Channel: "beta", Platform: "android-x86_64"})
+
+	byKey, err := store.LatestReleasesOf(ctx, keys)
+	if err != nil {
+		t.Fatalf("LatestReleasesOf: %v", err)
+	}
+	if len(byKey) != len(tests) {
+		t.Errorf("expected %d latest releases, got %d", len(tests), len(byKey))
+	}
+	for i, test := range tests {
+		if got := byKey[keys[i]]; got.ReleaseID != test.releaseID || got.AssetID != test.assetID {
+			t.Errorf("%s: expected %s/%s, got %+v", test.name, test.releaseID, test.assetID, got)
+		}
+	}
+
+	version, err := store.LatestVersion(ctx, "com.example.app", "alice")
+	if err != nil {
+		t.Fatalf("LatestVersion: %v", err)
+	}
+	if version != "2.0" {
+		t.Errorf("expected the latest main version 2.0, got %s", version)
+	}
+}
+
+func TestLatestVersionWithoutRelease(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// assets published without a release (kind 30063) are not in latest_releases
+	assets := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 100, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "1.0"}}},
+		{ID: "asset2", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 200, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "1.1"}}},
+		{ID: "asset3", PubKey: "bob", Kind: events.KindAsset, CreatedAt: 300, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "9.9"}}},
+	}
+	for _, e := range assets {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	version, err := store.LatestVersion(ctx, "com.example.app", "alice")
+	if err != nil {
+		t.Fatalf("LatestVersion: %v", err)
+	}
+	if version != "1.1" {
+		t.Errorf("expected the version of the latest asset 1.1, got %s", version)
+	}
+
+	if _, err := store.LatestVersion(ctx, "com.missing.app", "alice"); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("expected %v, got %v", ErrReleaseNotFound, err)
+	}
+}
+
+func TestLatestReleasesDelete(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	stored := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 100, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "1.0"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release1", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 100, Tags: nostr.Tags{{"d", "com.example.app@1.0"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset1"}}},
+		{ID: "asset2", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 200, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "2.0"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release2", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 200, Tags: nostr.Tags{{"d", "com.example.app@2.0"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset2"}}},
+	}
+
+	for _, e := range stored {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	latest := func() string {
+		r, err := store.LatestRelease(ctx, "com.example.app", "alice", "main", "android-arm64-v8a")
+		if errors.Is(err, ErrReleaseNotFound) {
+			return ""
+		}
+		if err != nil {
+			t.Fatalf("LatestRelease: %v", err)
+		}
+		return r.ReleaseID
+	}
+
+	if got := latest(); got != "release2" {
+		t.Fatalf("expected release2, got %q", got)
+	}
+
+	// deleting the asset of the latest release falls back to the previous release
+	if _, err := store.Delete(ctx, nostr.Filter{IDs: []string{"asset2"}}); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if got := latest(); got != "release1" {
+		t.Fatalf("expected release1, got %q", got)
+	}
+
+	// replacing the release with a newer version keeps the table up to date
+	replacement := nostr.Event{ID: "release1b", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 300, Tags: nostr.Tags{{"d", "com.example.app@1.0"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset1"}}}
+	if _, err := store.Replace(ctx, &replacement); err != nil {
+		t.Fatalf("failed to replace: %v", err)
+	}
+	if got := latest(); got != "release1b" {
+		t.Fatalf("expected release1b, got %q", got)
+	}
+
+	// deleting the last release empties the table
+	if _, err := store.Delete(ctx, nostr.Filter{IDs: []string{"release1b"}}); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if got := latest(); got != "" {
+		t.Fatalf("expected no latest release, got %q", got)
+	}
+}
+
+func TestBackfillLatestReleases(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	stored := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 100, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "1.0"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release1", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 100, Tags: nostr.Tags{{"d", "com.example.app@1.0"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset1"}}},
+		{ID: "asset2", PubKey: "alice", Kind: events.KindAsset, CreatedAt: 200, Tags: nostr.Tags{{"i", "com.example.app"}, {"version", "2.0"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release2", PubKey: "alice", Kind: events.KindRelease, CreatedAt: 200, Tags: nostr.Tags{{"d", "com.example.app@2.0"}, {"i", "com.example.app"}, {"c", "main"}, {"e", "asset2"}}},
+	}
+
+	for _, e := range stored {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	// simulate a database created before the latest_releases table existed
+	if _, err := store.DB.Exec(`DELETE FROM latest_releases`); err != nil {
+		t.Fatalf("failed to empty latest releases: %v", err)
+	}
+	if err := backfillLatestReleases(store.DB); err != nil {
+		t.Fatalf("backfillLatestReleases: %v", err)
+	}
+
+	got, err := store.LatestRelease(ctx, "com.example.app", "alice", "main", "android-arm64-v8a")
+	if err != nil {
+		t.Fatalf("LatestRelease: %v", err)
+	}
+	if got.ReleaseID != "release2" || got.Version != "2.0" {
+		t.Errorf("expected release2 with version 2.0, got %+v", got)
+	}
+}
@@ -3,16 +3,36 @@
 
 -- Pending events are events that have been received but not yet promoted to the main event table.
 -- Because of that, they can't be served by the relay.
+-- Columns added after the table creation are listed in store.go, which also creates their indexes.
 CREATE TABLE IF NOT EXISTS pending_events (
-    id          TEXT    PRIMARY KEY,    -- event id (sha256)
-    kind        INTEGER NOT NULL,       -- event kind, for efficient filtering during promotion
-    raw         TEXT    NOT NULL,       -- full event JSON
-    received_at INTEGER NOT NULL        -- unix timestamp of when we received the event
+    id              TEXT    PRIMARY KEY,        -- event id (sha256)
+    kind            INTEGER NOT NULL,           -- event kind, for efficient filtering during promotion
+    raw             TEXT    NOT NULL,           -- full event JSON
+    received_at     INTEGER NOT NULL,           -- unix timestamp of when we received the event
+    pubkey          TEXT    NOT NULL DEFAULT '', -- event pubkey, so publishers can list their pending events
+    hash            TEXT    NOT NULL DEFAULT '', -- sha256 of the blob the event is waiting on ('x' tag)
+    last_checked_at INTEGER,                    -- unix timestamp of the last readiness check
+    last_outcome    TEXT                        -- outcome of the last readiness check
 );
 
 CREATE INDEX IF NOT EXISTS idx_pending_events_kind        ON pending_events(kind);
 CREATE INDEX IF NOT EXISTS idx_pending_events_received_at ON pending_events(received_at);
 
+-- URL probes are the cached results of the HEAD requests to the 'url' tags of the pending assets,
+-- used when the blob they wait on is not in the local blossom. Failed probes are retried with an exponential backoff.
+CREATE TABLE IF NOT EXISTS url_probes (
+    hash          TEXT    NOT NULL,           -- sha256 of the blob the url should serve ('x' tag of the asset)
+    url           TEXT    NOT NULL,
+    available     INTEGER NOT NULL DEFAULT 0, -- 1 if the last HEAD request succeeded
+    failures      INTEGER NOT NULL DEFAULT 0, -- consecutive failed HEAD requests
+    last_status   TEXT,                       -- status or error of the last HEAD request
+    checked_at    INTEGER,                    -- unix timestamp of the last HEAD request
+    next_check_at INTEGER NOT NULL,           -- unix timestamp of the next HEAD request
+    PRIMARY KEY (hash, url)
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_probes_next_check_at ON url_probes(next_check_at) WHERE available = 0;
+
 -- Universal single-letter tag indexing for all event kinds.
 -- Covers tags like a, e, f, i, p, t, x, A, E, K, P, etc.
 -- The base schema already indexes 'd' for addressable kinds; INSERT OR IGNORE deduplicates.
@@ -46,17 +66,18 @@ BEGIN
 		AND json_extract(value, '$[0]') IN ('name', 'license', 'url', 'repository');
 END;
 
--- Full-text search index for apps
-CREATE TRIGGER IF NOT EXISTS app_fts_ai AFTER INSERT ON events
+-- Full-text search index for apps, in the default language: the 'name' and 'summary' tags without a language.
+-- It replaces the app_fts_ai trigger, which indexed the first tags regardless of their language and is dropped in store.go.
+CREATE TRIGGER IF NOT EXISTS app_fts_default_ai AFTER INSERT ON events
 WHEN NEW.kind = 32267
 BEGIN
 	INSERT INTO apps_fts (id, name, summary, content)
 	VALUES (
 		NEW.id,
 		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
-			WHERE json_extract(value, '$[0]') = 'name' LIMIT 1),
+			WHERE json_extract(value, '$[0]') = 'name' AND COALESCE(json_extract(value, '$[2]'), '') = '' LIMIT 1),
 		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
-			WHERE json_extract(value, '$[0]') = 'summary' LIMIT 1),
+			WHERE json_extract(value, '$[0]') = 'summary' AND COALESCE(json_extract(value, '$[2]'), '') = '' LIMIT 1),
 		NEW.content
 	);
 END;
@@ -67,6 +88,121 @@ BEGIN
 	DELETE FROM apps_fts WHERE id = OLD.id;
 END;
 
+-- Full-text search index for the localized apps, with a row for each language of the language-qualified
+-- 'name', 'summary' and 'description' tags, e.g. ["name", "Signal", "de"]. Fields that are not localized are
+-- indexed in the default language, so that each row is the complete listing of the app in its language.
+CREATE VIRTUAL TABLE IF NOT EXISTS apps_l10n_fts USING fts5(
+	id UNINDEXED,
+	lang UNINDEXED, -- lowercase language code
+	name,
+	summary,
+	content,
+	tokenize = 'trigram'
+);
+
+-- Localized app metadata is the metadata of the apps in each of their languages, falling back to the default language.
+CREATE VIEW IF NOT EXISTS app_localizations AS
+SELECT
+	l.event_id AS event_id,
+	l.lang AS lang,
+	COALESCE(
+		(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+			WHERE json_extract(value, '$[0]') = 'name' AND lower(json_extract(value, '$[2]')) = l.lang LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+			WHERE json_extract(value, '$[0]') = 'name' AND COALESCE(json_extract(value, '$[2]'), '') = '' LIMIT 1)
+	) AS name,
+	COALESCE(
+		(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+			WHERE json_extract(value, '$[0]') = 'summary' AND lower(json_extract(value, '$[2]')) = l.lang LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+			WHERE json_extract(value, '$[0]') = 'summary' AND COALESCE(json_extract(value, '$[2]'), '') = '' LIMIT 1)
+	) AS summary,
+	COALESCE(
+		(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+			WHERE json_extract(value, '$[0]') = 'description' AND lower(json_extract(value, '$[2]')) = l.lang LIMIT 1),
+		e.content
+	) AS content
+FROM (
+	SELECT DISTINCT e.id AS event_id, lower(json_extract(t.value, '$[2]')) AS lang
+	FROM events e
+	CROSS JOIN json_each(e.tags) t
+	WHERE e.kind = 32267
+		AND json_extract(t.value, '$[0]') IN ('name', 'summary', 'description')
+		AND COALESCE(json_extract(t.value, '$[2]'), '') != ''
+) l
+JOIN events e ON e.id = l.event_id;
+
+-- App languages are the languages in which each app is localized, so that searches in a language
+-- fall back to the default language for the apps that are not localized in it.
+CREATE TABLE IF NOT EXISTS app_languages (
+    event_id TEXT NOT NULL,
+    lang     TEXT NOT NULL, -- lowercase language code
+    PRIMARY KEY (event_id, lang)
+) WITHOUT ROWID;
+
+CREATE TRIGGER IF NOT EXISTS app_l10n_fts_ai AFTER INSERT ON events
+WHEN NEW.kind = 32267
+BEGIN
+	INSERT INTO apps_l10n_fts (id, lang, name, summary, content)
+	SELECT event_id, lang, name, summary, content FROM app_localizations WHERE event_id = NEW.id;
+
+	INSERT OR IGNORE INTO app_languages (event_id, lang)
+	SELECT event_id, lang FROM app_localizations WHERE event_id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS app_l10n_fts_ad AFTER DELETE ON events
+WHEN OLD.kind = 32267
+BEGIN
+	DELETE FROM apps_l10n_fts WHERE id = OLD.id;
+	DELETE FROM app_languages WHERE event_id = OLD.id;
+END;
+
+-- Full-text search index for stacks (kind 30267), by the 'title' and 'description' tags of the NIP-51 set
+CREATE VIRTUAL TABLE IF NOT EXISTS stacks_fts USING fts5(
+	id UNINDEXED,
+	title,
+	description,
+	tokenize = 'trigram'
+);
+
+CREATE TRIGGER IF NOT EXISTS stack_fts_ai AFTER INSERT ON events
+WHEN NEW.kind = 30267
+BEGIN
+	INSERT INTO stacks_fts (id, title, description)
+	VALUES (
+		NEW.id,
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE json_extract(value, '$[0]') = 'title' LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE json_extract(value, '$[0]') = 'description' LIMIT 1)
+	);
+END;
+
+CREATE TRIGGER IF NOT EXISTS stack_fts_ad AFTER DELETE ON events
+WHEN OLD.kind = 30267
+BEGIN
+	DELETE FROM stacks_fts WHERE id = OLD.id;
+END;
+
+-- Full-text search index for releases (kind 30063), by their release notes
+CREATE VIRTUAL TABLE IF NOT EXISTS releases_fts USING fts5(
+	id UNINDEXED,
+	content,
+	tokenize = 'trigram'
+);
+
+CREATE TRIGGER IF NOT EXISTS release_fts_ai AFTER INSERT ON events
+WHEN NEW.kind = 30063
+BEGIN
+	INSERT INTO releases_fts (id, content) VALUES (NEW.id, NEW.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS release_fts_ad AFTER DELETE ON events
+WHEN OLD.kind = 30063
+BEGIN
+	DELETE FROM releases_fts WHERE id = OLD.id;
+END;
+
 -- KindRelease (30063) - multi-character tag indexing
 CREATE TRIGGER IF NOT EXISTS release_tags_ai AFTER INSERT ON events
 WHEN NEW.kind = 30063
@@ -102,3 +238,318 @@ BEGIN
 		AND json_array_length(value) > 1
 		AND json_extract(value, '$[0]') IN ('url', 'fallback', 'version', 'apk_signature_hash');
 END;
+
+-- Certificate conflicts are assets (kind 3063) rejected because they are signed with an APK certificate
+-- that is already used by the assets of a different pubkey. Conflicts are resolved by an admin on the dashboard.
+CREATE TABLE IF NOT EXISTS certificate_conflicts (
+    certificate_hash TEXT    NOT NULL,
+    pubkey           TEXT    NOT NULL,                  -- pubkey of the publisher of the rejected asset
+    app_id           TEXT    NOT NULL,                  -- app identifier of the rejected asset
+    event_id         TEXT    NOT NULL,                  -- id of the last rejected asset
+    owner_pubkey     TEXT    NOT NULL,                  -- pubkey already associated with the certificate
+    owner_app_id     TEXT    NOT NULL,                  -- app identifier under which the owner uses the certificate
+    status           TEXT    NOT NULL DEFAULT 'pending', -- one of 'pending', 'allowed', 'blocked'
+    detected_at      INTEGER NOT NULL,                  -- unix timestamp of the first rejection
+    resolved_at      INTEGER,                           -- unix timestamp of the admin resolution
+    PRIMARY KEY (certificate_hash, pubkey)
+);
+
+CREATE INDEX IF NOT EXISTS idx_certificate_conflicts_status ON certificate_conflicts(status, detected_at DESC);
+
+-- Release assets are all the (release, asset, platform) combinations of the stored releases (kind 30063)
+-- and the assets they reference (kind 3063, or legacy kind 1063) of the same pubkey.
+-- The app ID is the 'i' tag of the release, or the 'd' tag before the '@' for legacy releases.
+-- Tags are read from the event JSON, because the triggers below can run before the tags of the new event are indexed.
+CREATE VIEW IF NOT EXISTS release_assets AS
+SELECT
+	COALESCE(
+		(SELECT json_extract(value, '$[1]') FROM json_each(r.tags) WHERE json_extract(value, '$[0]') = 'i' LIMIT 1),
+		(SELECT substr(json_extract(value, '$[1]'), 1, instr(json_extract(value, '$[1]'), '@') - 1) FROM json_each(r.tags)
+			WHERE json_extract(value, '$[0]') = 'd' AND instr(json_extract(value, '$[1]'), '@') > 1 LIMIT 1)
+	) AS app_id,
+	r.pubkey AS pubkey,
+	COALESCE(
+		(SELECT json_extract(value, '$[1]') FROM json_each(r.tags) WHERE json_extract(value, '$[0]') = 'c' LIMIT 1),
+		'main'
+	) AS channel,
+	json_extract(f.value, '$[1]') AS platform,
+	r.id AS release_id,
+	a.id AS asset_id,
+	COALESCE(
+		(SELECT json_extract(value, '$[1]') FROM json_each(a.tags) WHERE json_extract(value, '$[0]') = 'version' LIMIT 1),
+		''
+	) AS version,
+	CAST((SELECT json_extract(value, '$[1]') FROM json_each(a.tags) WHERE json_extract(value, '$[0]') = 'version_code' LIMIT 1) AS INTEGER) AS version_code,
+	r.created_at AS created_at
+FROM events r
+-- CROSS JOIN forces the join order, so that assets are looked up by the IDs in the 'e' tags of the release
+CROSS JOIN json_each(r.tags) e ON json_extract(e.value, '$[0]') = 'e'
+CROSS JOIN events a ON a.id = json_extract(e.value, '$[1]') AND a.pubkey = r.pubkey AND a.kind IN (3063, 1063)
+CROSS JOIN json_each(a.tags) f ON json_extract(f.value, '$[0]') = 'f' AND json_array_length(f.value) > 1
+WHERE r.kind = 30063;
+
+-- Latest releases are the most recent release of each app ID, pubkey, channel and platform,
+-- together with the asset for that platform. Ties between releases are broken by the highest version code.
+-- The table is maintained by the triggers below, so that the latest release is never re-derived at query time.
+CREATE TABLE IF NOT EXISTS latest_releases (
+    app_id       TEXT    NOT NULL,
+    pubkey       TEXT    NOT NULL,
+    channel      TEXT    NOT NULL,  -- 'c' tag of the release, 'main' for legacy releases
+    platform     TEXT    NOT NULL,  -- 'f' tag of the asset
+    release_id   TEXT    NOT NULL,
+    asset_id     TEXT    NOT NULL,
+    version      TEXT    NOT NULL,  -- 'version' tag of the asset
+    version_code INTEGER,           -- 'version_code' tag of the asset, NULL if missing
+    created_at   INTEGER NOT NULL,  -- created_at of the release
+    PRIMARY KEY (app_id, pubkey, channel, platform)
+);
+
+CREATE INDEX IF NOT EXISTS idx_latest_releases_release_id ON latest_releases(release_id);
+CREATE INDEX IF NOT EXISTS idx_latest_releases_asset_id   ON latest_releases(asset_id);
+
+-- A new release becomes the latest for each of its platforms, unless a more recent release exists.
+CREATE TRIGGER IF NOT EXISTS latest_releases_release_ai AFTER INSERT ON events
+WHEN NEW.kind = 30063
+BEGIN
+	INSERT INTO latest_releases (app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at)
+	SELECT app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at
+	FROM release_assets
+	WHERE release_id = NEW.id AND app_id IS NOT NULL AND app_id != ''
+	ON CONFLICT (app_id, pubkey, channel, platform) DO UPDATE SET
+		release_id = excluded.release_id,
+		asset_id = excluded.asset_id,
+		version = excluded.version,
+		version_code = excluded.version_code,
+		created_at = excluded.created_at
+	WHERE excluded.created_at > latest_releases.created_at
+		OR (excluded.created_at = latest_releases.created_at
+			AND COALESCE(excluded.version_code, 0) >= COALESCE(latest_releases.version_code, 0));
+END;
+
+-- A new asset completes the releases that were stored before it and reference it.
+CREATE TRIGGER IF NOT EXISTS latest_releases_asset_ai AFTER INSERT ON events
+WHEN NEW.kind IN (3063, 1063)
+BEGIN
+	INSERT INTO latest_releases (app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at)
+	SELECT ra.app_id, ra.pubkey, ra.channel, ra.platform, ra.release_id, ra.asset_id, ra.version, ra.version_code, ra.created_at
+	FROM tags t
+	CROSS JOIN release_assets ra ON ra.release_id = t.event_id
+	WHERE t.key = 'e' AND t.value = NEW.id
+		AND ra.asset_id = NEW.id AND ra.app_id IS NOT NULL AND ra.app_id != ''
+	ON CONFLICT (app_id, pubkey, channel, platform) DO UPDATE SET
+		release_id = excluded.release_id,
+		asset_id = excluded.asset_id,
+		version = excluded.version,
+		version_code = excluded.version_code,
+		created_at = excluded.created_at
+	WHERE excluded.created_at > latest_releases.created_at
+		OR (excluded.created_at = latest_releases.created_at
+			AND COALESCE(excluded.version_code, 0) >= COALESCE(latest_releases.version_code, 0));
+END;
+
+-- When a latest release or its asset is deleted, the next most recent release takes its place, if any.
+CREATE TRIGGER IF NOT EXISTS latest_releases_ad AFTER DELETE ON events
+WHEN OLD.kind IN (30063, 3063, 1063)
+BEGIN
+	INSERT OR REPLACE INTO latest_releases (app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at)
+	SELECT app_id, pubkey, channel, platform, release_id, asset_id, version, version_code, created_at
+	FROM (
+		SELECT ra.*, ROW_NUMBER() OVER (
+			PARTITION BY ra.app_id, ra.pubkey, ra.channel, ra.platform
+			ORDER BY ra.created_at DESC, COALESCE(ra.version_code, 0) DESC
+		) AS rank
+		FROM (
+			-- releases of the affected apps, by 'i' tag or by the 'd' tag of legacy releases.
+			-- '@' sorts right before 'A', so the range matches all the 'd' tags starting with '<app_id>@'
+			SELECT t.event_id FROM latest_releases x
+			CROSS JOIN tags t ON t.key = 'i' AND t.value = x.app_id
+			WHERE x.release_id = OLD.id OR x.asset_id = OLD.id
+			UNION
+			SELECT t.event_id FROM latest_releases x
+			CROSS JOIN tags t ON t.key = 'd' AND t.value >= x.app_id || '@' AND t.value < x.app_id || 'A'
+			WHERE x.release_id = OLD.id OR x.asset_id = OLD.id
+		) affected
+		CROSS JOIN release_assets ra ON ra.release_id = affected.event_id
+		JOIN latest_releases l
+			ON l.app_id = ra.app_id AND l.pubkey = ra.pubkey AND l.channel = ra.channel AND l.platform = ra.platform
+		WHERE l.release_id = OLD.id OR l.asset_id = OLD.id
+	)
+	WHERE rank = 1;
+
+	DELETE FROM latest_releases WHERE release_id = OLD.id OR asset_id = OLD.id;
+END;
+
+-- Verified certificates are the APK certificates whose identity proof (kind 30509) has been verified,
+-- meaning that the pubkey proved control of the signing key of the certificate.
+CREATE TABLE IF NOT EXISTS verified_certificates (
+    certificate_hash TEXT    NOT NULL,  -- 'd' tag of the proof
+    pubkey           TEXT    NOT NULL,  -- pubkey of the proof
+    proof_id         TEXT    NOT NULL,
+    expires_at       INTEGER NOT NULL,  -- 'expiry' tag of the proof
+    verified_at      INTEGER NOT NULL,
+    PRIMARY KEY (certificate_hash, pubkey)
+);
+
+CREATE INDEX IF NOT EXISTS idx_verified_certificates_proof_id ON verified_certificates(proof_id);
+
+-- A proof that is deleted or replaced no longer verifies its certificate.
+CREATE TRIGGER IF NOT EXISTS verified_certificates_ad AFTER DELETE ON events
+WHEN OLD.kind = 30509
+BEGIN
+	DELETE FROM verified_certificates WHERE proof_id = OLD.id;
+END;
+
+-- Verified apps are the app IDs and pubkeys with at least one asset signed with a certificate
+-- for which the same pubkey has a valid identity proof.
+CREATE VIEW IF NOT EXISTS verified_apps AS
+SELECT DISTINCT i.value AS app_id, e.pubkey AS pubkey
+FROM verified_certificates v
+JOIN tags c ON c.key = 'apk_certificate_hash' AND c.value = v.certificate_hash
+JOIN events e ON e.id = c.event_id AND e.kind = 3063 AND e.pubkey = v.pubkey
+JOIN tags i ON i.event_id = e.id AND i.key = 'i'
+WHERE v.expires_at > unixepoch();
+
+-- Expirations are the NIP-40 'expiration' tags of the events, and the 'expiry' tags of identity proofs (kind 30509).
+-- Expired events are excluded from queries, and deleted by the relay's expiration sweeper.
+CREATE TABLE IF NOT EXISTS expirations (
+    event_id   TEXT    PRIMARY KEY REFERENCES events(id) ON DELETE CASCADE,
+    expires_at INTEGER NOT NULL  -- earliest expiration timestamp of the event
+);
+
+CREATE INDEX IF NOT EXISTS idx_expirations_expires_at ON expirations(expires_at);
+
+-- The LIKE is a cheap pre-filter, so that json_each only runs on the events that may expire.
+-- Values that aren't unsigned integers are ignored, like the relay does when checking expirations on publish,
+-- because CAST would turn them into 0 and the events would be deleted as expired.
+CREATE TRIGGER IF NOT EXISTS expirations_ai AFTER INSERT ON events
+WHEN NEW.tags LIKE '%"expir%'
+BEGIN
+	INSERT OR IGNORE INTO expirations (event_id, expires_at)
+	SELECT NEW.id, MIN(CAST(json_extract(value, '$[1]') AS INTEGER))
+	FROM json_each(NEW.tags)
+	WHERE json_array_length(value) > 1
+		AND (json_extract(value, '$[0]') = 'expiration'
+			OR (NEW.kind = 30509 AND json_extract(value, '$[0]') = 'expiry'))
+		AND json_extract(value, '$[1]') GLOB '[0-9]*'
+		AND json_extract(value, '$[1]') NOT GLOB '*[^0-9]*'
+	HAVING COUNT(*) > 0;
+END;
+
+-- Popularity of the apps, copied periodically from the downloads and impressions of the analytics database,
+-- which is separate. The score combines both signals in [0, 1], and is used to rank searches with 'sort:popular'.
+CREATE TABLE IF NOT EXISTS app_popularity (
+    app_id      TEXT    NOT NULL,
+    pubkey      TEXT    NOT NULL,
+    downloads   INTEGER NOT NULL,
+    impressions INTEGER NOT NULL,
+    score       REAL    NOT NULL,
+    updated_at  INTEGER NOT NULL,
+    PRIMARY KEY (app_id, pubkey)
+);
+
+-- App name words are the lowercase words of the 'name' tags of the apps (kind 32267), split on spaces and punctuation.
+-- Words shorter than 4 characters are left out, because typos in them can't be told apart from other words.
+CREATE VIEW IF NOT EXISTS app_name_words AS
+SELECT a.event_id AS event_id, w.value AS word
+FROM (
+	-- the name as a JSON array of words, e.g. 'K-9 Mail' becomes '["k","9","mail"]'
+	SELECT e.id AS event_id, '["' || replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(replace(
+		lower(json_extract(n.value, '$[1]')),
+		'"', ' '), '\', ' '), char(9), ' '), char(10), ' '), '-', ' '), '_', ' '), '.', ' '), ',', ' '), ':', ' '), '/', ' '), '(', ' '), ')', ' '), '&', ' '), '+', ' '),
+		' ', '","') || '"]' AS words
+	FROM events e
+	CROSS JOIN json_each(e.tags) n ON json_extract(n.value, '$[0]') = 'name' AND json_array_length(n.value) > 1
+	WHERE e.kind = 32267
+) a
+CROSS JOIN json_each(CASE WHEN json_valid(a.words) THEN a.words ELSE '[]' END) w
+WHERE length(w.value) BETWEEN 4 AND 32;
+
+-- App vocabulary maps the app name words, and their variants with one character deleted, back to the words.
+-- A search word is within one typo (insertion, deletion, substitution or transposition) of a name word
+-- when they share a variant, which is how searches with no results are relaxed.
+CREATE TABLE IF NOT EXISTS app_vocabulary (
+    variant  TEXT NOT NULL,
+    word     TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    PRIMARY KEY (variant, word, event_id)
+) WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS idx_app_vocabulary_event_id ON app_vocabulary(event_id);
+
+CREATE TRIGGER IF NOT EXISTS app_vocabulary_ai AFTER INSERT ON events
+WHEN NEW.kind = 32267
+BEGIN
+	INSERT OR IGNORE INTO app_vocabulary (variant, word, event_id)
+	SELECT word, word, event_id FROM app_name_words WHERE event_id = NEW.id
+	UNION
+	SELECT substr(w.word, 1, p.value - 1) || substr(w.word, p.value + 1), w.word, w.event_id
+	FROM app_name_words w
+	CROSS JOIN json_each('[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24,25,26,27,28,29,30,31,32]') p
+		ON p.value <= length(w.word)
+	WHERE w.event_id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS app_vocabulary_ad AFTER DELETE ON events
+WHEN OLD.kind = 32267
+BEGIN
+	DELETE FROM app_vocabulary WHERE event_id = OLD.id;
+END;
+
+-- Banned events are the events banned by the operator with the NIP-86 management API.
+-- They are deleted when banned, and rejected if published again.
+CREATE TABLE IF NOT EXISTS banned_events (
+    id        TEXT    PRIMARY KEY,       -- event id (sha256)
+    reason    TEXT    NOT NULL DEFAULT '',
+    banned_at INTEGER NOT NULL           -- unix timestamp of the ban
+);
+
+-- Event history archives the replaceable and addressable events superseded by a newer version of the same kind,
+-- pubkey and 'd' tag, so that their changes can be investigated and rolled back from the dashboard.
+-- Events deleted for other reasons (bans, expirations) have no newer version, so they are not archived.
+-- Deletion requests (NIP-09 and operator takedowns) may leave a newer version, so they delete the archived
+-- versions of the events they target in the same transaction: deleted events are never restorable.
+-- Old versions are pruned by the relay according to its retention limits.
+CREATE TABLE IF NOT EXISTS event_history (
+    id          TEXT    PRIMARY KEY,       -- event id (sha256)
+    pubkey      TEXT    NOT NULL,
+    created_at  INTEGER NOT NULL,
+    kind        INTEGER NOT NULL,
+    d           TEXT    NOT NULL,          -- 'd' tag of addressable events, '' for replaceable events
+    tags        TEXT    NOT NULL,          -- event tags JSON
+    content     TEXT    NOT NULL,
+    sig         TEXT    NOT NULL,
+    replaced_by TEXT    NOT NULL,          -- id of the version that superseded the event
+    replaced_at INTEGER NOT NULL           -- unix timestamp of the replacement
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_history_address     ON event_history(kind, pubkey, d, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_event_history_replaced_at ON event_history(replaced_at DESC);
+
+-- A replaced event is deleted after its newer version is inserted, in the same transaction.
+-- The old 'd' tag is read from the event JSON, because its indexed tags are deleted by the cascade.
+CREATE TRIGGER IF NOT EXISTS event_history_ad AFTER DELETE ON events
+WHEN OLD.kind IN (0, 3) OR OLD.kind BETWEEN 10000 AND 19999 OR OLD.kind BETWEEN 30000 AND 39999
+BEGIN
+	INSERT OR IGNORE INTO event_history (id, pubkey, created_at, kind, d, tags, content, sig, replaced_by, replaced_at)
+	SELECT OLD.id, OLD.pubkey, OLD.created_at, OLD.kind, addr.d, json(OLD.tags), OLD.content, OLD.sig, e.id, unixepoch()
+	FROM (
+		SELECT COALESCE((SELECT json_extract(value, '$[1]') FROM json_each(OLD.tags)
+			WHERE json_extract(value, '$[0]') = 'd' LIMIT 1), '') AS d
+	) addr
+	JOIN events e ON e.pubkey = OLD.pubkey AND e.kind = OLD.kind AND e.created_at > OLD.created_at
+	WHERE OLD.kind < 30000
+		OR EXISTS (SELECT 1 FROM tags t WHERE t.event_id = e.id AND t.key = 'd' AND t.value = addr.d)
+	ORDER BY e.created_at DESC
+	LIMIT 1;
+END;
+
+-- Tombstones are the events and addressable coordinates deleted by the relay operator, so that the same signed
+-- events are rejected if re-published by anyone. A coordinate blocks all its versions, or only the versions
+-- created up to its 'until' timestamp, so that newer versions can be published again.
+-- Restoring an archived version also tombstones the versions it replaces, so that they can't supersede it again.
+CREATE TABLE IF NOT EXISTS tombstones (
+    target     TEXT    PRIMARY KEY,  -- event id, or addressable coordinate '<kind>:<pubkey>:<d>'
+    until      INTEGER,              -- latest created_at blocked for coordinates, NULL to block all versions
+    request_id TEXT    NOT NULL,     -- id of the operator deletion request (kind 5), or of the restored version
+    created_at INTEGER NOT NULL      -- unix timestamp of the deletion
+);
@@ -0,0 +1,330 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	sqlite "github.com/vertex-lab/nostr-sqlite"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+// searchIndex is the full-text index of a kind that supports NIP-50 search.
+type searchIndex struct {
+	table     string // FTS5 table, joined with the events as fts
+	rank      string // ORDER BY of the searches sorted by relevance
+	typos     bool   // whether searches with no results are relaxed to the typo corrections of the app names
+	localized bool   // whether the table has a row per language, filtered by the 'lang:' operator
+}
+
+// searchIndexes are the full-text indexes of the searchable kinds.
+var searchIndexes = map[int]searchIndex{
+	events.KindApp:     {table: "apps_fts", rank: "bm25(apps_fts, 0, 20, 5, 1)", typos: true},
+	events.KindStack:   {table: "stacks_fts", rank: "bm25(stacks_fts, 0, 10, 2), e.created_at DESC"},
+	events.KindRelease: {table: "releases_fts", rank: releaseRank},
+}
+
+// localizedAppIndex is the full-text index of the apps in the language of the searches with a 'lang:' operator.
+var localizedAppIndex = searchIndex{table: "apps_l10n_fts", rank: "bm25(apps_l10n_fts, 0, 0, 20, 5, 1)", typos: true, localized: true}
+
+// releaseRank is the ranking of release searches. The BM25 relevance of the release notes (negative, lower is better)
+// is amplified by the recency of the release (halving after 90 days), because recent releases are usually the ones
+// users look for, e.g. the one that fixed a crash.
+const releaseRank = `bm25(releases_fts) * (1 + 1.0 / (1 + (unixepoch() - e.created_at) / 7776000.0))`
+
+// backfillSearchIndexes indexes the localized apps, stacks and releases stored before their full-text indexes existed.
+// Each index is a no-op if already populated.
+func backfillSearchIndexes(db *sql.DB) error {
+	backfills := []struct {
+		table string
+		query string
+	}{
+		{
+			table: "apps_l10n_fts",
+			query: `INSERT INTO apps_l10n_fts (id, lang, name, summary, content)
+			SELECT event_id, lang, name, summary, content FROM app_localizations`,
+		},
+		{
+			table: "app_languages",
+			query: `INSERT OR IGNORE INTO app_languages (event_id, lang) SELECT event_id, lang FROM app_localizations`,
+		},
+		{
+			table: "stacks_fts",
+			query: `INSERT INTO stacks_fts (id, title, description)
+			SELECT e.id,
+				(SELECT json_extract(value, '$[1]') FROM json_each(e.tags) WHERE json_extract(value, '$[0]') = 'title' LIMIT 1),
+				(SELECT json_extract(value, '$[1]') FROM json_each(e.tags) WHERE json_extract(value, '$[0]') = 'description' LIMIT 1)
+			FROM events e WHERE e.kind = 30267`,
+		},
+		{
+			table: "releases_fts",
+			query: `INSERT INTO releases_fts (id, content) SELECT id, content FROM events WHERE kind = 30063`,
+		},
+	}
+
+	for _, b := range backfills {
+		var populated bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM ` + b.table + `)`).Scan(&populated); err != nil {
+			return fmt.Errorf("failed to check %s: %w", b.table, err)
+		}
+		if populated {
+			continue
+		}
+		if _, err := db.Exec(b.query); err != nil {
+			return fmt.Errorf("failed to backfill %s: %w", b.table, err)
+		}
+	}
+	return nil
+}
+
+// Sort orders of the app search, selected with the 'sort:' operator.
+const (
+	sortRelevance = "relevance" // BM25 relevance, the default
+	sortPopular   = "popular"   // relevance blended with popularity, see [popularRank]
+	sortRecent    = "recent"    // most recent release first
+)
+
+// recentRank is the ranking of searches with 'sort:recent'. Apps without releases come last.
+// It expects the 'd' tag of the app joined as d.
+const recentRank = `(SELECT MAX(created_at) FROM latest_releases WHERE app_id = d.value AND pubkey = e.pubkey) DESC NULLS LAST,
+	e.created_at DESC`
+
+// Bounds of the relaxed match of a search, see [search.relaxedMatch].
+const (
+	maxTypoWords   = 8  // words of the term looked up in the app vocabulary
+	maxCorrections = 20 // app name words added to the match
+)
+
+// search is a parsed NIP-50 search: the term matched with FTS, and the operators that
+// filter and sort the results. Operators are words of the form key:value, e.g. "license:MIT".
+type search struct {
+	term string
+	sort string
+	lang string // lang:<language code>, lowercase
+
+	verified  bool     // verified:true
+	platforms []string // platform:<platform identifier>, the 'f' tags of the app
+	licenses  []string // license:<SPDX ID>
+	topics    []string // t:<hashtag>
+	authors   []string // author:<npub or hex pubkey>
+}
+
+// parseSearch splits the search into the term and the operators. As required by NIP-50,
+// unknown operators and invalid values are ignored, and they are removed from the term.
+func parseSearch(s string) search {
+	var parsed search
+	var words []string
+
+	for _, word := range strings.Fields(s) {
+		key, value, ok := operator(word)
+		if !ok {
+			words = append(words, word)
+			continue
+		}
+
+		switch key {
+		case "verified":
+			parsed.verified = value == "true"
+
+		case "lang":
+			if events.ValidateLanguage(value) == nil {
+				parsed.lang = strings.ToLower(value)
+			}
+
+		case "sort":
+			if value == sortRelevance || value == sortPopular || value == sortRecent {
+				parsed.sort = value
+			}
+
+		case "platform":
+			parsed.platforms = append(parsed.platforms, value)
+
+		case "license":
+			parsed.licenses = append(parsed.licenses, value)
+
+		case "t":
+			parsed.topics = append(parsed.topics, value)
+
+		case "author":
+			if pubkey, ok := decodePubkey(value); ok {
+				parsed.authors = append(parsed.authors, pubkey)
+			}
+		}
+	}
+
+	parsed.term = strings.Join(words, " ")
+	return parsed
+}
+
+// operator splits a word of the form key:value, where the key is made of lowercase letters.
+// Words like URLs ("https://...") or times ("10:30") are not operators.
+func operator(word string) (key, value string, ok bool) {
+	key, value, found := strings.Cut(word, ":")
+	if !found || key == "" || value == "" || strings.HasPrefix(value, "//") {
+		return "", "", false
+	}
+	for _, r := range key {
+		if r < 'a' || r > 'z' {
+			return "", "", false
+		}
+	}
+	return key, value, true
+}
+
+// decodePubkey returns the hex pubkey of an npub or hex pubkey.
+func decodePubkey(s string) (string, bool) {
+	if nostr.IsValidPublicKey(s) {
+		return s, true
+	}
+
+	prefix, value, err := nip19.Decode(s)
+	if err != nil || prefix != "npub" {
+		return "", false
+	}
+	pubkey, ok := value.(string)
+	return pubkey, ok
+}
+
+// conditions returns the SQL conditions and arguments of the operators filtering the apps.
+// Values of the same operator are alternatives, while different operators must all match.
+func (s search) conditions() (conditions []string, args []any) {
+	if s.verified {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM verified_apps v
+			WHERE v.pubkey = e.pubkey AND v.app_id = (SELECT value FROM tags WHERE event_id = e.id AND key = 'd' LIMIT 1))`)
+	}
+
+	if len(s.authors) > 0 {
+		conditions = append(conditions, "e.pubkey"+inClause(len(s.authors)))
+		for _, pk := range s.authors {
+			args = append(args, pk)
+		}
+	}
+
+	tags := []struct {
+		key     string
+		values  []string
+		collate string
+	}{
+		{key: "f", values: s.platforms},
+		{key: "license", values: s.licenses, collate: " COLLATE NOCASE"},
+		{key: "t", values: s.topics, collate: " COLLATE NOCASE"},
+	}
+
+	for _, tag := range tags {
+		if len(tag.values) == 0 {
+			continue
+		}
+		conditions = append(conditions,
+			"EXISTS (SELECT 1 FROM tags WHERE event_id = e.id AND key = ? AND value"+tag.collate+inClause(len(tag.values))+")")
+		args = append(args, tag.key)
+		for _, v := range tag.values {
+			args = append(args, v)
+		}
+	}
+	return conditions, args
+}
+
+// forKind drops the operators that apply only to apps when searching other kinds,
+// which are ignored like unknown operators.
+func (s search) forKind(kind int) search {
+	if kind == events.KindApp {
+		return s
+	}
+
+	s.verified = false
+	s.licenses = nil
+	s.lang = ""
+	if s.sort == sortPopular {
+		s.sort = sortRelevance
+	}
+	return s
+}
+
+// indexes returns the full-text indexes searched for the kind. Searches with a 'lang:' operator look for the apps
+// localized in the language first, and then for the other apps in the default language.
+func (s search) indexes(kind int) []searchIndex {
+	if s.lang != "" {
+		return []searchIndex{localizedAppIndex, searchIndexes[kind]}
+	}
+	return []searchIndex{searchIndexes[kind]}
+}
+
+// orderBy returns the ORDER BY expression of the search of the kind on the index,
+// and whether it needs the 'd' tag of the event joined as d.
+func (s search) orderBy(kind int, index searchIndex) (expr string, joinD bool) {
+	switch {
+	case s.sort == sortPopular:
+		return popularRank(index.rank), true
+	case s.sort == sortRecent && kind == events.KindApp:
+		return recentRank, true
+	case s.sort == sortRecent:
+		return "e.created_at DESC", false
+	default:
+		return index.rank, false
+	}
+}
+
+// words returns the words of the term that the trigram tokenizer can match, which have at least 3 characters.
+func (s search) words() []string {
+	var words []string
+	for _, word := range strings.Fields(s.term) {
+		if utf8.RuneCountInString(word) >= 3 {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// strictMatch returns the FTS5 expression matching the events that contain all the words of the term, in any order.
+// Terms without words of at least 3 characters are matched as a single phrase.
+func (s search) strictMatch() string {
+	words := s.words()
+	if len(words) == 0 {
+		return escapeFTS5(s.term)
+	}
+
+	for i, word := range words {
+		words[i] = escapeFTS5(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// relaxedMatch returns the FTS5 expression matching the events that contain any of the words of the term,
+// or, with typos, any app name word within one typo of them, which are looked up in the app_vocabulary table.
+// It's used when the [search.strictMatch] finds nothing, and it returns false if it can't find more than it.
+func (s search) relaxedMatch(typos bool) (sqlite.Query, bool) {
+	words := s.words()
+
+	var variants []string
+	if typos {
+		variants = typoVariants(words[:min(len(words), maxTypoWords)])
+	}
+
+	if len(words) == 0 || (len(words) == 1 && len(variants) == 0) {
+		return sqlite.Query{}, false
+	}
+
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = escapeFTS5(word)
+	}
+
+	args := []any{strings.Join(quoted, " OR ")}
+	if len(variants) == 0 {
+		return sqlite.Query{SQL: "?", Args: args}, true
+	}
+
+	// vocabulary words are split on the double quotes, so they don't need escaping
+	query := `(? || COALESCE((SELECT ' OR ' || group_concat('"' || word || '"', ' OR ') FROM (
+			SELECT DISTINCT word FROM app_vocabulary WHERE variant` + inClause(len(variants)) + ` LIMIT ` + strconv.Itoa(maxCorrections) + `
+		)), ''))`
+
+	for _, v := range variants {
+		args = append(args, v)
+	}
+	return sqlite.Query{SQL: query, Args: args}, true
+}
@@ -0,0 +1,408 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+const (
+	alice = "5c50da132947fa3bf4759eb978d784db12baad1c3e5b6a575410aeb654639b4b"
+	bob   = "805b34f708837dfb3e7f05815ac5760564628b58d5a0ce839ccbb6ef3620fac3"
+)
+
+func TestParseSearch(t *testing.T) {
+	npub, err := nip19.EncodePublicKey(alice)
+	if err != nil {
+		t.Fatalf("failed to encode npub: %v", err)
+	}
+
+	tests := []struct {
+		search string
+		want   search
+	}{
+		{
+			search: "signal messenger",
+			want:   search{term: "signal messenger"},
+		},
+		{
+			search: "signal platform:android-arm64-v8a license:MIT t:privacy sort:recent verified:true",
+			want: search{
+				term:      "signal",
+				sort:      sortRecent,
+				verified:  true,
+				platforms: []string{"android-arm64-v8a"},
+				licenses:  []string{"MIT"},
+				topics:    []string{"privacy"},
+			},
+		},
+		{
+			search: "author:" + npub + " notes author:" + bob + " author:invalid",
+			want:   search{term: "notes", authors: []string{alice, bob}},
+		},
+		{
+			search: "notes lang:pt-BR lang:english",
+			want:   search{term: "notes", lang: "pt-br"},
+		},
+		{
+			search: "notes t:nostr t:privacy",
+			want:   search{term: "notes", topics: []string{"nostr", "privacy"}},
+		},
+		{
+			// unknown operators and values are ignored
+			search: "notes language:en sort:random verified:false",
+			want:   search{term: "notes"},
+		},
+		{
+			// URLs and words with non-lowercase keys are not operators
+			search: "https://github.com/zapstore/zapstore",
+			want:   search{term: "https://github.com/zapstore/zapstore"},
+		},
+		{
+			search: "meeting 10:30 Key:value",
+			want:   search{term: "meeting 10:30 Key:value"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.search, func(t *testing.T) {
+			if got := parseSearch(test.search); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSearchOperators(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	npub, err := nip19.EncodePublicKey(bob)
+	if err != nil {
+		t.Fatalf("failed to encode npub: %v", err)
+	}
+
+	saved := []nostr.Event{
+		{ID: "app1", PubKey: alice, CreatedAt: 1700000001, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "com.alice.notes"}, {"name", "Notes"}, {"f", "android-arm64-v8a"}, {"license", "MIT"}, {"t", "privacy"}}},
+		{ID: "app2", PubKey: bob, CreatedAt: 1700000002, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "com.bob.notes"}, {"name", "Notes"}, {"f", "linux-x86_64"}, {"license", "GPL-3.0"}, {"t", "productivity"}}},
+		{ID: "app3", PubKey: bob, CreatedAt: 1700000003, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "com.bob.notes.pro"}, {"name", "Notes Pro"}, {"f", "android-arm64-v8a"}, {"license", "mit"}, {"t", "Privacy"}}},
+
+		// releases to sort by recency, the oldest for the most recent app
+		{ID: "asset1", PubKey: alice, Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.alice.notes"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release1", PubKey: alice, CreatedAt: 1700000300, Kind: events.KindRelease, Tags: nostr.Tags{{"d", "com.alice.notes@1"}, {"i", "com.alice.notes"}, {"e", "asset1"}}},
+		{ID: "asset3", PubKey: bob, Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.bob.notes.pro"}, {"f", "android-arm64-v8a"}}},
+		{ID: "release3", PubKey: bob, CreatedAt: 1700000100, Kind: events.KindRelease, Tags: nostr.Tags{{"d", "com.bob.notes.pro@1"}, {"i", "com.bob.notes.pro"}, {"e", "asset3"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		search  string
+		want    []string
+		ordered bool // relevance ties are broken arbitrarily, so unordered results are compared as sets
+	}{
+		{search: "notes platform:android-arm64-v8a", want: []string{"app1", "app3"}},
+		{search: "notes platform:android-arm64-v8a platform:linux-x86_64", want: []string{"app1", "app2", "app3"}},
+		{search: "notes license:MIT", want: []string{"app1", "app3"}},
+		{search: "notes t:privacy license:mit", want: []string{"app1", "app3"}},
+		{search: "notes author:" + npub, want: []string{"app2", "app3"}},
+		{search: "notes author:" + npub + " t:privacy", want: []string{"app3"}},
+		{search: "notes sort:recent", want: []string{"app1", "app3", "app2"}, ordered: true},
+		{search: "notes unknown:operator", want: []string{"app1", "app2", "app3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.search, func(t *testing.T) {
+			results, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: test.search, Limit: 10})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+
+			IDs := eventIDs(results)
+			if !test.ordered {
+				slices.Sort(IDs)
+			}
+			if !reflect.DeepEqual(IDs, test.want) {
+				t.Errorf("expected %v, got %v", test.want, IDs)
+			}
+
+			count, err := store.Count(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: test.search})
+			if err != nil {
+				t.Fatalf("Count: %v", err)
+			}
+			if count != len(test.want) {
+				t.Errorf("expected count %d, got %d", len(test.want), count)
+			}
+		})
+	}
+}
+
+func TestSearchRelaxed(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	saved := []nostr.Event{
+		{ID: "app1", PubKey: alice, CreatedAt: 1700000001, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "org.signal"}, {"name", "Signal"}, {"summary", "Private messenger"}}},
+		{ID: "app2", PubKey: alice, CreatedAt: 1700000002, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "org.telegram"}, {"name", "Telegram"}, {"summary", "Cloud-based messenger"}}},
+		{ID: "app3", PubKey: bob, CreatedAt: 1700000003, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "com.bob.notes"}, {"name", "Notes"}}},
+		{ID: "app4", PubKey: bob, CreatedAt: 1700000004, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "com.bob.nodes"}, {"name", "Nodes (Lightning)"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{search: "signal messenger", want: []string{"app1"}},
+		{search: "messenger signal", want: []string{"app1"}},
+		{search: "messenger", want: []string{"app1", "app2"}},
+		{search: "signal whisper", want: []string{"app1"}}, // any of the words
+		{search: "singal", want: []string{"app1"}},         // transposition
+		{search: "signall", want: []string{"app1"}},        // insertion
+		{search: "telgram", want: []string{"app2"}},        // deletion
+		{search: "ligthning", want: []string{"app4"}},      // transposition in a word after punctuation
+		{search: "notes", want: []string{"app3"}},          // strict results exclude the typo corrections
+		{search: "noets", want: []string{"app3", "app4"}},  // within one typo of both
+		{search: "singal author:" + bob, want: []string{}}, // operators still apply
+		{search: "xyzzy", want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.search, func(t *testing.T) {
+			results, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: test.search, Limit: 10})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+
+			IDs := eventIDs(results)
+			slices.Sort(IDs)
+			if len(IDs) == 0 {
+				IDs = []string{}
+			}
+			if !reflect.DeepEqual(IDs, test.want) {
+				t.Errorf("expected %v, got %v", test.want, IDs)
+			}
+
+			count, err := store.Count(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: test.search})
+			if err != nil {
+				t.Fatalf("Count: %v", err)
+			}
+			if count != len(test.want) {
+				t.Errorf("expected count %d, got %d", len(test.want), count)
+			}
+		})
+	}
+
+	// deleted apps leave the vocabulary
+	if _, err := store.Delete(ctx, nostr.Filter{IDs: []string{"app1"}}); err != nil {
+		t.Fatalf("failed to delete app: %v", err)
+	}
+
+	results, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: "singal", Limit: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(results) > 0 {
+		t.Errorf("expected no results after the deletion, got %v", eventIDs(results))
+	}
+}
+
+func TestSearchStacksAndReleases(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	saved := []nostr.Event{
+		{ID: "stack1", PubKey: alice, CreatedAt: 1700000001, Kind: events.KindStack, Tags: nostr.Tags{
+			{"d", "privacy"}, {"title", "Privacy essentials"}, {"description", "Messengers and browsers"}}},
+		{ID: "stack2", PubKey: bob, CreatedAt: 1700000002, Kind: events.KindStack, Tags: nostr.Tags{
+			{"d", "tools"}, {"title", "Developer tools"}, {"description", "Terminals, editors and privacy tools"}}},
+
+		{ID: "release1", PubKey: alice, CreatedAt: 1700000001, Kind: events.KindRelease, Tags: nostr.Tags{{"d", "com.alice.app@1"}, {"i", "com.alice.app"}},
+			Content: "Fixed the crash on Android 15"},
+		{ID: "release2", PubKey: alice, CreatedAt: nostr.Now(), Kind: events.KindRelease, Tags: nostr.Tags{{"d", "com.alice.app@2"}, {"i", "com.alice.app"}},
+			Content: "Fixed the crash on Android 15 when rotating"},
+		{ID: "release3", PubKey: bob, CreatedAt: 1700000003, Kind: events.KindRelease, Tags: nostr.Tags{{"d", "com.bob.app@1"}, {"i", "com.bob.app"}},
+			Content: "New dark theme"},
+
+		// apps are not returned by the searches of other kinds
+		{ID: "app1", PubKey: alice, CreatedAt: 1700000001, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.alice.app"}, {"name", "Privacy crash"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		kind   int
+		search string
+		want   []string
+	}{
+		{kind: events.KindStack, search: "privacy", want: []string{"stack1", "stack2"}}, // title matches rank first
+		{kind: events.KindStack, search: "editors", want: []string{"stack2"}},
+		{kind: events.KindStack, search: "privacy author:" + bob, want: []string{"stack2"}},
+		{kind: events.KindStack, search: "privacy sort:recent", want: []string{"stack2", "stack1"}},
+		{kind: events.KindStack, search: "privacy verified:true license:MIT", want: []string{"stack1", "stack2"}}, // app operators are ignored
+		{kind: events.KindRelease, search: "crash android", want: []string{"release2", "release1"}},               // recent releases rank first
+		{kind: events.KindRelease, search: "which version fixed crash", want: []string{"release2", "release1"}},   // relaxed to any of the words
+		{kind: events.KindRelease, search: "dark theme", want: []string{"release3"}},
+		{kind: events.KindRelease, search: "singal", want: []string{}}, // no typo corrections from the app names
+	}
+
+	for _, test := range tests {
+		t.Run(test.search, func(t *testing.T) {
+			results, err := store.Query(ctx, nostr.Filter{Kinds: []int{test.kind}, Search: test.search, Limit: 10})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+
+			IDs := eventIDs(results)
+			if len(IDs) == 0 {
+				IDs = []string{}
+			}
+			if !reflect.DeepEqual(IDs, test.want) {
+				t.Errorf("expected %v, got %v", test.want, IDs)
+			}
+
+			count, err := store.Count(ctx, nostr.Filter{Kinds: []int{test.kind}, Search: test.search})
+			if err != nil {
+				t.Fatalf("Count: %v", err)
+			}
+			if count != len(test.want) {
+				t.Errorf("expected count %d, got %d", len(test.want), count)
+			}
+		})
+	}
+
+	// deleted events leave the index
+	if _, err := store.Delete(ctx, nostr.Filter{IDs: []string{"stack1"}}); err != nil {
+		t.Fatalf("failed to delete stack: %v", err)
+	}
+
+	results, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindStack}, Search: "essentials", Limit: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(results) > 0 {
+		t.Errorf("expected no results after the deletion, got %v", eventIDs(results))
+	}
+
+	invalid := []nostr.Filter{
+		{Kinds: []int{1}, Search: "privacy"},
+		{Kinds: []int{events.KindApp, events.KindStack}, Search: "privacy"},
+	}
+	for _, filter := range invalid {
+		if err := Validate(filter); !errors.Is(err, ErrUnsupportedREQ) {
+			t.Errorf("Validate(%v): expected %v, got %v", filter.Kinds, ErrUnsupportedREQ, err)
+		}
+	}
+}
+
+func TestSearchLanguage(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	saved := []nostr.Event{
+		{ID: "app1", PubKey: alice, CreatedAt: 1700000001, Kind: events.KindApp, Content: "A private messenger.", Tags: nostr.Tags{
+			{"d", "org.signal"}, {"name", "Signal"}, {"summary", "Private messenger"},
+			{"summary", "Sicherer Messenger", "de"}, {"description", "Ein sicherer Messenger.", "de"}}},
+		{ID: "app2", PubKey: alice, CreatedAt: 1700000002, Kind: events.KindApp, Tags: nostr.Tags{
+			{"d", "org.telegram"}, {"name", "Telegram"}, {"summary", "Cloud-based messenger"}}},
+		{ID: "app3", PubKey: bob, CreatedAt: 1700000003, Kind: events.KindApp, Tags: nostr.Tags{
+			{"name", "Notizen", "de"}, {"d", "com.bob.notes"}, {"name", "Notes"}, {"summary", "Simple notes"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		search  string
+		want    []string
+		ordered bool
+		limit   int
+	}{
+		{search: "notizen lang:de", want: []string{"app3"}},
+		{search: "notizen", want: []string{}}, // localized names are not in the default index
+		{search: "notes", want: []string{"app3"}},
+		{search: "sicherer lang:DE", want: []string{"app1"}},
+		{search: "sicherer lang:fr", want: []string{}},
+		{search: "sicherer lang:invalid", want: []string{}},
+
+		// localized apps first, then the others in the default language
+		{search: "messenger lang:de", want: []string{"app1", "app2"}, ordered: true},
+		{search: "messenger lang:de", want: []string{"app1"}, ordered: true, limit: 1},
+		{search: "private lang:de", want: []string{}},                // the german listing of app1 replaces the default one
+		{search: "simple lang:de", want: []string{"app3"}},           // fields that are not localized are in the default language
+		{search: "sicherer browser lang:de", want: []string{"app1"}}, // relaxed like the default language
+		{search: "notzen lang:de", want: []string{"app3"}},           // including typos
+	}
+
+	for _, test := range tests {
+		t.Run(test.search, func(t *testing.T) {
+			limit := test.limit
+			if limit == 0 {
+				limit = 10
+			}
+
+			results, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: test.search, Limit: limit})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+
+			IDs := eventIDs(results)
+			if !test.ordered {
+				slices.Sort(IDs)
+			}
+			if len(IDs) == 0 {
+				IDs = []string{}
+			}
+			if !reflect.DeepEqual(IDs, test.want) {
+				t.Errorf("expected %v, got %v", test.want, IDs)
+			}
+
+			if test.limit > 0 {
+				return
+			}
+			count, err := store.Count(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: test.search})
+			if err != nil {
+				t.Fatalf("Count: %v", err)
+			}
+			if count != len(test.want) {
+				t.Errorf("expected count %d, got %d", len(test.want), count)
+			}
+		})
+	}
+}
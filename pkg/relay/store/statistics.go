@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Statistics are the row counts of the stored events, used to estimate the cost of the filters before running them.
+type Statistics struct {
+	Events  int                      // number of events
+	Authors int                      // number of distinct pubkeys
+	Kinds   map[int]int              // number of events per kind
+	Tags    map[string]TagStatistics // indexed tags per key
+
+	// Oldest and Newest are the created_at of the oldest and newest events, used to estimate the events
+	// in a time window assuming they are uniformly distributed.
+	Oldest nostr.Timestamp
+	Newest nostr.Timestamp
+}
+
+// TagStatistics are the row counts of the indexed tags with a key.
+type TagStatistics struct {
+	Rows   int // number of tags
+	Values int // number of distinct values
+}
+
+// searchSelectivity is the estimated fraction of the events of a kind matched by a NIP-50 search.
+const searchSelectivity = 0.05
+
+// Statistics computes the statistics of the stored events. The counts are read from the indexes on
+// the kind, pubkey and tags, so it's cheap enough to run periodically but too slow to run on every REQ.
+func (s T) Statistics(ctx context.Context) (Statistics, error) {
+	stats := Statistics{
+		Kinds: make(map[int]int),
+		Tags:  make(map[string]TagStatistics),
+	}
+
+	row := s.DB.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(MIN(created_at), 0), COALESCE(MAX(created_at), 0) FROM events`)
+	if err := row.Scan(&stats.Events, &stats.Oldest, &stats.Newest); err != nil {
+		return Statistics{}, fmt.Errorf("failed to count events: %w", err)
+	}
+
+	row = s.DB.QueryRowContext(ctx, `SELECT COUNT(DISTINCT pubkey) FROM events`)
+	if err := row.Scan(&stats.Authors); err != nil {
+		return Statistics{}, fmt.Errorf("failed to count authors: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT kind, COUNT(*) FROM events GROUP BY kind`)
+	if err != nil {
+		return Statistics{}, fmt.Errorf("failed to count kinds: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, count int
+		if err := rows.Scan(&kind, &count); err != nil {
+			return Statistics{}, fmt.Errorf("failed to scan kind count: %w", err)
+		}
+		stats.Kinds[kind] = count
+	}
+	if err := rows.Err(); err != nil {
+		return Statistics{}, fmt.Errorf("failed to count kinds: %w", err)
+	}
+
+	rows, err = s.DB.QueryContext(ctx, `SELECT key, COUNT(*), COUNT(DISTINCT value) FROM tags GROUP BY key`)
+	if err != nil {
+		return Statistics{}, fmt.Errorf("failed to count tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var tag TagStatistics
+		if err := rows.Scan(&key, &tag.Rows, &tag.Values); err != nil {
+			return Statistics{}, fmt.Errorf("failed to scan tag count: %w", err)
+		}
+		stats.Tags[key] = tag
+	}
+	if err := rows.Err(); err != nil {
+		return Statistics{}, fmt.Errorf("failed to count tags: %w", err)
+	}
+	return stats, nil
+}
+
+// Cost estimates the number of events the database reads to serve the filter in a REQ. Filters with limit 0
+// query no stored events, and filters with IDs match at most one event per ID. Otherwise, the events are read
+// in created_at order from the indexes, so at most [nostr.Filter.Limit] of the [Statistics.Rows] are read,
+// unless a NIP-50 search ranks all of them first. Filters without a limit are capped at the responseLimit
+// of the client before being queried, so it's their effective limit.
+func (s Statistics) Cost(filter nostr.Filter, responseLimit int) float64 {
+	if filter.LimitZero {
+		return 0
+	}
+	rows := s.Rows(filter)
+	if filter.Search != "" || len(filter.IDs) > 0 {
+		return rows
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = responseLimit
+	}
+	if limit > 0 {
+		rows = min(rows, float64(limit))
+	}
+	return rows
+}
+
+// Rows estimates the number of events the filter matches, which is the work the database does to count them.
+// Filters with IDs match at most one event per ID. Otherwise the events of the kinds (or all events) are
+// narrowed by the selectivity of each condition, assumed independent: the average events per author and per tag value,
+// the fraction of the time window, and a fixed fraction for searches.
+func (s Statistics) Rows(filter nostr.Filter) float64 {
+	if len(filter.IDs) > 0 {
+		return float64(len(filter.IDs))
+	}
+
+	rows := float64(s.Events)
+	if len(filter.Kinds) > 0 {
+		rows = 0
+		for _, kind := range filter.Kinds {
+			rows += float64(s.Kinds[kind])
+		}
+	}
+
+	if len(filter.Authors) > 0 && s.Authors > 0 {
+		rows *= min(1, float64(len(filter.Authors))/float64(s.Authors))
+	}
+
+	for key, values := range filter.Tags {
+		rows *= s.tagSelectivity(key, len(values))
+	}
+
+	rows *= s.windowSelectivity(filter.Since, filter.Until)
+
+	if filter.Search != "" {
+		rows *= searchSelectivity
+	}
+	return max(rows, 1)
+}
+
+// tagSelectivity returns the estimated fraction of the events that have one of n values of the tag key.
+// Keys without statistics, e.g. tags first seen after the last refresh, don't narrow the events.
+func (s Statistics) tagSelectivity(key string, n int) float64 {
+	tag := s.Tags[key]
+	if tag.Values == 0 || s.Events == 0 {
+		return 1
+	}
+	perValue := float64(tag.Rows) / float64(tag.Values)
+	return min(1, float64(n)*perValue/float64(s.Events))
+}
+
+// windowSelectivity returns the estimated fraction of the events created between since and until.
+func (s Statistics) windowSelectivity(since, until *nostr.Timestamp) float64 {
+	if since == nil && until == nil {
+		return 1
+	}
+
+	span := float64(s.Newest - s.Oldest)
+	if span <= 0 {
+		return 1
+	}
+
+	from, to := s.Oldest, s.Newest
+	if since != nil {
+		from = max(from, *since)
+	}
+	if until != nil {
+		to = min(to, *until)
+	}
+	if to < from {
+		return 0
+	}
+	return float64(to-from) / span
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestStatistics(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// 100 comments by 10 authors, two on each of 50 posts, and an app of one of the authors
+	for i := range 100 {
+		comment := nostr.Event{
+			ID:        "comment" + strconv.Itoa(i),
+			PubKey:    "author" + strconv.Itoa(i%10),
+			CreatedAt: nostr.Timestamp(1700000000 + i),
+			Kind:      events.KindComment,
+			Tags:      nostr.Tags{{"e", "post" + strconv.Itoa(i%50)}},
+		}
+		if _, err := store.Save(ctx, &comment); err != nil {
+			t.Fatalf("failed to save event %s: %v", comment.ID, err)
+		}
+	}
+
+	app := nostr.Event{ID: "app", PubKey: "author0", CreatedAt: 1700000050, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}}}
+	if _, err := store.Save(ctx, &app); err != nil {
+		t.Fatalf("failed to save event %s: %v", app.ID, err)
+	}
+
+	stats, err := store.Statistics(ctx)
+	if err != nil {
+		t.Fatalf("Statistics: %v", err)
+	}
+
+	want := Statistics{
+		Events:  101,
+		Authors: 10,
+		Kinds:   map[int]int{events.KindComment: 100, events.KindApp: 1},
+		Tags:    map[string]TagStatistics{"e": {Rows: 100, Values: 50}, "d": {Rows: 1, Values: 1}},
+		Oldest:  1700000000,
+		Newest:  1700000099,
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("expected statistics %+v, got %+v", want, stats)
+	}
+
+	since := nostr.Timestamp(1700000066)
+	tests := []struct {
+		name          string
+		filter        nostr.Filter
+		responseLimit int
+		want          float64
+	}{
+		{name: "all events", filter: nostr.Filter{}, want: 50},
+		{name: "all events, limit", filter: nostr.Filter{Limit: 10}, want: 10},
+		{name: "kind", filter: nostr.Filter{Kinds: []int{events.KindComment}, Limit: 200}, want: 100},
+		{name: "kind, limit", filter: nostr.Filter{Kinds: []int{events.KindComment}, Limit: 20}, want: 20},
+		{name: "kind, no limit", filter: nostr.Filter{Kinds: []int{events.KindComment}}, want: 50},
+		{name: "many kinds", filter: nostr.Filter{Kinds: []int{events.KindComment, events.KindApp}, Limit: 200}, want: 101},
+		{name: "kind and author", filter: nostr.Filter{Kinds: []int{events.KindComment}, Authors: []string{"author1"}}, want: 10},
+		{name: "tag", filter: nostr.Filter{Tags: nostr.TagMap{"e": {"post1"}}}, want: 2},
+		{name: "tags", filter: nostr.Filter{Tags: nostr.TagMap{"e": {"post1", "post2", "post3"}}}, want: 6},
+		{name: "time window", filter: nostr.Filter{Kinds: []int{events.KindComment}, Since: &since}, want: 100.0 / 3},
+		{name: "search", filter: nostr.Filter{Kinds: []int{events.KindComment}, Search: "hello"}, want: 5},
+		{name: "search ignores the response limit", filter: nostr.Filter{Kinds: []int{events.KindComment}, Search: "hello"}, responseLimit: 2, want: 5},
+		{name: "search ignores the limit", filter: nostr.Filter{Kinds: []int{events.KindComment}, Search: "hello", Limit: 1}, want: 5},
+		{name: "unknown tag", filter: nostr.Filter{Kinds: []int{events.KindComment}, Tags: nostr.TagMap{"x": {"hash"}}, Limit: 200}, want: 100},
+		{name: "IDs", filter: nostr.Filter{IDs: []string{"comment1", "comment2", "comment3"}, Kinds: []int{events.KindComment}, Limit: 1}, want: 3},
+		{name: "limit zero", filter: nostr.Filter{Kinds: []int{events.KindComment}, LimitZero: true}, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responseLimit := test.responseLimit
+			if responseLimit == 0 {
+				responseLimit = 50
+			}
+			if got := stats.Cost(test.filter, responseLimit); math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("expected cost %v, got %v", test.want, got)
+			}
+		})
+	}
+
+	limited := nostr.Filter{Kinds: []int{events.KindComment}, Limit: 20}
+	if rows := stats.Rows(limited); rows != 100 {
+		t.Errorf("expected the limit to be ignored when counting, got %v rows", rows)
+	}
+}
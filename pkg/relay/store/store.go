@@ -4,6 +4,7 @@ package store
 
 import (
 	"context"
+	"database/sql"
 	_ "embed"
 	"encoding/json"
 	"errors"
@@ -18,7 +19,10 @@ import (
 	"github.com/zapstore/relay/pkg/repourl"
 )
 
-var ErrUnsupportedREQ = errors.New("unsupported REQ")
+var (
+	ErrUnsupportedREQ = errors.New("unsupported REQ")
+	ErrTooManyItems   = errors.New("too many events match the filter")
+)
 
 //go:embed schema.sql
 var schema string
@@ -32,6 +36,7 @@ func New(path string) (T, error) {
 		path,
 		sqlite.WithAdditionalSchema(schema),
 		sqlite.WithQueryBuilder(queryBuilder),
+		sqlite.WithCountBuilder(countBuilder),
 		sqlite.WithBusyTimeout(10*time.Second),
 		sqlite.WithCacheSize(256*sqlite.MiB),
 		sqlite.WithoutEventPolicy(), // events have been validated by the relay
@@ -41,9 +46,95 @@ func New(path string) (T, error) {
 	if err != nil {
 		return T{}, err
 	}
+	if err := migrate(store.DB); err != nil {
+		return T{}, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	if err := backfillLatestReleases(store.DB); err != nil {
+		return T{}, err
+	}
+	if err := backfillExpirations(store.DB); err != nil {
+		return T{}, err
+	}
+	if err := backfillVocabulary(store.DB); err != nil {
+		return T{}, err
+	}
+	if err := backfillSearchIndexes(store.DB); err != nil {
+		return T{}, err
+	}
 	return T{Store: store}, nil
 }
 
+// columns added to existing tables after their creation.
+// CREATE TABLE IF NOT EXISTS doesn't modify tables created by older versions, so these are added
+// with ALTER TABLE when missing.
+var columns = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{table: "pending_events", name: "pubkey", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "pending_events", name: "hash", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "pending_events", name: "last_checked_at", definition: "INTEGER"},
+	{table: "pending_events", name: "last_outcome", definition: "TEXT"},
+}
+
+// droppedTriggers are the triggers created by older versions that have been replaced in schema.sql.
+var droppedTriggers = []string{
+	"app_fts_ai", // replaced by app_fts_default_ai
+}
+
+// migrate drops the [droppedTriggers], adds the missing [columns], fills them for the rows stored by older versions,
+// and creates the indexes that depend on them.
+func migrate(db *sql.DB) error {
+	for _, trigger := range droppedTriggers {
+		if _, err := db.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+			return fmt.Errorf("failed to drop trigger %s: %w", trigger, err)
+		}
+	}
+
+	for _, c := range columns {
+		var exists bool
+		err := db.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)`, c.table, c.name,
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to check column %s.%s: %w", c.table, c.name, err)
+		}
+		if exists {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.name, err)
+		}
+	}
+
+	backfill := `UPDATE pending_events SET
+		pubkey = COALESCE(json_extract(raw, '$.pubkey'), ''),
+		hash = COALESCE((
+			SELECT json_extract(value, '$[1]') FROM json_each(json_extract(raw, '$.tags'))
+			WHERE json_extract(value, '$[0]') = 'x'
+			LIMIT 1
+		), '')
+		WHERE pubkey = ''`
+
+	if _, err := db.Exec(backfill); err != nil {
+		return fmt.Errorf("failed to backfill pending events: %w", err)
+	}
+
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_pending_events_pubkey ON pending_events(pubkey, received_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_pending_events_hash ON pending_events(hash)`,
+	}
+	for _, index := range indexes {
+		if _, err := db.Exec(index); err != nil {
+			return fmt.Errorf("failed to create pending events index: %w", err)
+		}
+	}
+	return nil
+}
+
 // SavePending stores an event in the pending_events table in an idempotent way.
 // It returns true if the event was inserted (i.e. it was not already present), false otherwise.
 func (s T) SavePending(ctx context.Context, event *nostr.Event) (bool, error) {
@@ -51,9 +142,10 @@ func (s T) SavePending(ctx context.Context, event *nostr.Event) (bool, error) {
 	if err != nil {
 		return false, fmt.Errorf("failed to marshal event: %w", err)
 	}
+	hash, _ := events.Find(event.Tags, "x")
 	res, err := s.DB.ExecContext(ctx,
-		`INSERT OR IGNORE INTO pending_events (id, kind, raw, received_at) VALUES (?, ?, ?, ?)`,
-		event.ID, event.Kind, string(raw), time.Now().UTC().Unix(),
+		`INSERT OR IGNORE INTO pending_events (id, kind, raw, received_at, pubkey, hash) VALUES (?, ?, ?, ?, ?, ?)`,
+		event.ID, event.Kind, string(raw), time.Now().UTC().Unix(), event.PubKey, hash,
 	)
 	if err != nil {
 		return false, fmt.Errorf("failed to save pending event: %w", err)
@@ -71,6 +163,85 @@ func (s T) QueryPending(ctx context.Context, kind int) ([]nostr.Event, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to query pending events: %w", err)
 	}
+	return scanRaw(rows)
+}
+
+// QueryPendingByHash returns all pending events waiting on the blob with the given hash.
+func (s T) QueryPendingByHash(ctx context.Context, hash string) ([]nostr.Event, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT raw FROM pending_events WHERE hash = ?`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending events: %w", err)
+	}
+	return scanRaw(rows)
+}
+
+// QueryPendingByIDs returns the pending events with the given IDs.
+func (s T) QueryPendingByIDs(ctx context.Context, IDs ...string) ([]nostr.Event, error) {
+	if len(IDs) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, len(IDs))
+	for i, id := range IDs {
+		args[i] = id
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT raw FROM pending_events WHERE id`+inClause(len(IDs)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending events: %w", err)
+	}
+	return scanRaw(rows)
+}
+
+// QueryPendingReferencing returns the pending events of the given kind with an 'e' tag
+// referencing any of the given IDs.
+func (s T) QueryPendingReferencing(ctx context.Context, kind int, IDs ...string) ([]nostr.Event, error) {
+	if len(IDs) == 0 {
+		return nil, nil
+	}
+
+	args := []any{kind}
+	for _, id := range IDs {
+		args = append(args, id)
+	}
+
+	query := `SELECT raw FROM pending_events
+		WHERE kind = ? AND EXISTS (
+			SELECT 1 FROM json_each(json_extract(raw, '$.tags'))
+			WHERE json_extract(value, '$[0]') = 'e' AND json_extract(value, '$[1]')` + inClause(len(IDs)) + `
+		)`
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending events: %w", err)
+	}
+	return scanRaw(rows)
+}
+
+// PendingHashes returns the distinct hashes of the blobs the pending events are waiting on.
+func (s T) PendingHashes(ctx context.Context) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT DISTINCT hash FROM pending_events WHERE hash != ''`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending hashes: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan pending hash: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query pending hashes: %w", err)
+	}
+	return hashes, nil
+}
+
+// scanRaw scans rows of raw pending event JSON into events, and closes them.
+func scanRaw(rows *sql.Rows) ([]nostr.Event, error) {
 	defer rows.Close()
 
 	var events []nostr.Event
@@ -99,18 +270,200 @@ func (s T) DeletePending(ctx context.Context, ID string) error {
 	return nil
 }
 
-// DeleteExpiredPending removes all pending events that were received before the given cutoff time.
-func (s T) DeleteExpiredPending(ctx context.Context, before time.Time) error {
-	if _, err := s.DB.ExecContext(ctx, `DELETE FROM pending_events WHERE received_at < ?`, before.Unix()); err != nil {
-		return fmt.Errorf("failed to delete expired pending events: %w", err)
+// DeleteExpiredPending removes all pending events that were received before the given cutoff time,
+// and returns them.
+func (s T) DeleteExpiredPending(ctx context.Context, before time.Time) ([]PendingEvent, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`DELETE FROM pending_events WHERE received_at < ?
+		RETURNING `+pendingColumns, before.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired pending events: %w", err)
+	}
+	defer rows.Close()
+
+	expired, err := scanPending(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired pending events: %w", err)
+	}
+	return expired, nil
+}
+
+// PendingEvent is the status of an event waiting in the pending_events table.
+type PendingEvent struct {
+	ID            string
+	Kind          int
+	Pubkey        string
+	Hash          string // sha256 of the blob the event is waiting on
+	ReceivedAt    time.Time
+	LastCheckedAt time.Time // zero if the event has never been checked
+	LastOutcome   string    // outcome of the last readiness check, empty if never checked
+}
+
+const pendingColumns = "id, kind, pubkey, hash, received_at, last_checked_at, last_outcome"
+
+// PendingByPubkey returns the pending events of the pubkey, most recent first.
+func (s T) PendingByPubkey(ctx context.Context, pubkey string) ([]PendingEvent, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+pendingColumns+` FROM pending_events WHERE pubkey = ? ORDER BY received_at DESC`, pubkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending events: %w", err)
+	}
+	defer rows.Close()
+
+	pending, err := scanPending(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending events: %w", err)
+	}
+	return pending, nil
+}
+
+// SetPendingOutcome records the outcome of the latest readiness check of the pending events
+// waiting on the blob with the given hash.
+func (s T) SetPendingOutcome(ctx context.Context, hash, outcome string) error {
+	_, err := s.DB.ExecContext(ctx,
+		`UPDATE pending_events SET last_checked_at = ?, last_outcome = ? WHERE hash = ?`,
+		time.Now().UTC().Unix(), outcome, hash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set pending outcome: %w", err)
 	}
 	return nil
 }
 
+// SetPendingOutcomeByID records the outcome of the latest readiness check of the pending event with the given ID.
+func (s T) SetPendingOutcomeByID(ctx context.Context, ID, outcome string) error {
+	_, err := s.DB.ExecContext(ctx,
+		`UPDATE pending_events SET last_checked_at = ?, last_outcome = ? WHERE id = ?`,
+		time.Now().UTC().Unix(), outcome, ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set pending outcome: %w", err)
+	}
+	return nil
+}
+
+// scanPending scans rows of [pendingColumns] into pending events.
+func scanPending(rows *sql.Rows) ([]PendingEvent, error) {
+	var pending []PendingEvent
+	for rows.Next() {
+		var p PendingEvent
+		var receivedAt int64
+		var checkedAt sql.NullInt64
+		var outcome sql.NullString
+
+		if err := rows.Scan(&p.ID, &p.Kind, &p.Pubkey, &p.Hash, &receivedAt, &checkedAt, &outcome); err != nil {
+			return nil, fmt.Errorf("failed to scan pending event: %w", err)
+		}
+
+		p.ReceivedAt = time.Unix(receivedAt, 0).UTC()
+		if checkedAt.Valid {
+			p.LastCheckedAt = time.Unix(checkedAt.Int64, 0).UTC()
+		}
+		p.LastOutcome = outcome.String
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+// DeleteRequest performs a NIP-09 deletion request (kind 5 event), deleting the referenced events with the same
+// pubkey as the request: the 'e' tags by ID, and the versions of the 'a' tags created up to the request.
+// It returns the number of events deleted.
+//
+// It replaces [sqlite.Store.DeleteRequest] to also delete the archived versions of the deleted events in the
+// same transaction. Otherwise the versions replaced by a newer one than the request would be archived by the
+// event_history_ad trigger, and could be restored from the dashboard.
+//
+// Calling DeleteRequest with a non kind-5 event returns [ErrInvalidDeletionRequest].
+func (s T) DeleteRequest(ctx context.Context, event *nostr.Event) (int, error) {
+	if event.Kind != nostr.KindDeletion {
+		return 0, sqlite.ErrInvalidDeletionRequest
+	}
+
+	eIDs := findAll(event.Tags, "e")
+	aTags := findAll(event.Tags, "a")
+
+	if len(eIDs) == 0 && len(aTags) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deleted int
+
+	// e tags: single batched DELETE with an IN clause, enforcing pubkey match.
+	if len(eIDs) > 0 {
+		query := "DELETE FROM events WHERE pubkey = ? AND id " + inClause(len(eIDs))
+		args := make([]any, 0, 1+len(eIDs))
+		args = append(args, event.PubKey)
+		for _, id := range eIDs {
+			args = append(args, id)
+		}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete by e tags: %w", err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to check rows affected: %w", err)
+		}
+		deleted += int(affected)
+
+		if err := purgeIDs(ctx, tx, event.PubKey, eIDs); err != nil {
+			return 0, err
+		}
+	}
+
+	// a tags: one DELETE per tag, since each has a distinct kind/pubkey/d combination.
+	// Deletes all versions of the addressable event up to the deletion request's created_at.
+	until := sql.NullInt64{Int64: int64(event.CreatedAt), Valid: true}
+	for _, a := range aTags {
+		ref, err := events.ParseAddressableRef(a)
+		if err != nil || ref.Pubkey != event.PubKey || ref.DTag == "" {
+			continue
+		}
+
+		query := `DELETE FROM events
+			WHERE kind = ? AND pubkey = ? AND created_at <= ?
+			AND id IN (SELECT event_id FROM tags WHERE key = 'd' AND value = ?)`
+		args := []any{ref.Kind, ref.Pubkey, until, ref.DTag}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete by a tag %q: %w", a, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to check rows affected: %w", err)
+		}
+		deleted += int(affected)
+
+		if err := purgeCoordinate(ctx, tx, ref, until); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return deleted, nil
+}
+
 // ForceDeleteRequest forces a NIP-09 deletion request (kind 5 event), deleting all referenced events, even
 // if they have different pubkeys from the deletion request. It returns the number of events deleted.
 // This is not a normal NIP-09 deletion, and should only be used by the relay operator.
 //
+// The referenced IDs and coordinates are recorded as tombstones, so that the deleted events can't be published again,
+// and their archived versions are deleted from the event history, so that they can't be restored either.
+// An 'until' tag with a unix timestamp limits the 'a' tags to the versions created up to it, both for the deletion
+// and the tombstones, so that newer versions can still be published.
+//
 // The event is assumed to have been validated before calling this function.
 // Calling DeleteRequest with a non kind-5 event returns [ErrInvalidDeletionRequest].
 func (s T) ForceDeleteRequest(ctx context.Context, event *nostr.Event) (int, error) {
@@ -151,10 +504,15 @@ func (s T) ForceDeleteRequest(ctx context.Context, event *nostr.Event) (int, err
 			return 0, fmt.Errorf("failed to check rows affected: %w", err)
 		}
 		deleted += int(affected)
+
+		if err := purgeIDs(ctx, tx, "", eIDs); err != nil {
+			return 0, err
+		}
 	}
 
 	// a tags: one DELETE per tag, since each has a distinct kind/pubkey/d combination.
-	// Deletes all versions of the addressable event, regardless of creation time.
+	// Deletes all versions of the addressable event up to the 'until' tag, or regardless of creation time.
+	until := deletionUntil(event)
 	for _, a := range aTags {
 		ref, err := events.ParseAddressableRef(a)
 		if err != nil {
@@ -163,8 +521,9 @@ func (s T) ForceDeleteRequest(ctx context.Context, event *nostr.Event) (int, err
 
 		query := `DELETE FROM events
 			WHERE kind = ? AND pubkey = ?
-			AND id IN (SELECT event_id FROM tags WHERE key = 'd' AND value = ?)`
-		args := []any{ref.Kind, ref.Pubkey, ref.DTag}
+			AND id IN (SELECT event_id FROM tags WHERE key = 'd' AND value = ?)
+			AND (? IS NULL OR created_at <= ?)`
+		args := []any{ref.Kind, ref.Pubkey, ref.DTag, until, until}
 
 		res, err := tx.ExecContext(ctx, query, args...)
 		if err != nil {
@@ -176,6 +535,19 @@ func (s T) ForceDeleteRequest(ctx context.Context, event *nostr.Event) (int, err
 			return 0, fmt.Errorf("failed to check rows affected: %w", err)
 		}
 		deleted += int(affected)
+
+		if err := purgeCoordinate(ctx, tx, ref, until); err != nil {
+			return 0, err
+		}
+		if err := addTombstone(ctx, tx, ref.String(), until, event.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, ID := range eIDs {
+		if err := addTombstone(ctx, tx, ID, sql.NullInt64{}, event.ID); err != nil {
+			return 0, err
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -184,6 +556,75 @@ func (s T) ForceDeleteRequest(ctx context.Context, event *nostr.Event) (int, err
 	return deleted, nil
 }
 
+// CertificateHashes returns the distinct APK certificate hashes of all the assets (kind 3063)
+// published by the pubkey for the given app ID.
+func (s T) CertificateHashes(ctx context.Context, appID, pubkey string) ([]string, error) {
+	query := `SELECT DISTINCT t.value
+		FROM events e JOIN tags t ON t.event_id = e.id
+		WHERE e.kind = ?
+		AND e.pubkey = ?
+		AND t.key = 'apk_certificate_hash'
+		AND e.id IN (SELECT event_id FROM tags WHERE key = 'i' AND value = ?)`
+
+	rows, err := s.DB.QueryContext(ctx, query, events.KindAsset, pubkey, appID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificate hashes: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate hash: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query certificate hashes: %w", err)
+	}
+	return hashes, nil
+}
+
+// MaxVersionCode returns the highest version code of the assets (kind 3063) published by the pubkey
+// for the given app ID and variant, where an empty variant matches assets without a 'variant' tag.
+// It returns false if no such asset exists.
+//
+// Assets referenced only by releases (kind 30063) whose 'c' tag is one of the rewindable channels are ignored,
+// so that those channels can go back to a lower version code.
+func (s T) MaxVersionCode(ctx context.Context, appID, pubkey, variant string, rewindable []string) (int64, bool, error) {
+	query := `SELECT MAX(CAST(json_extract(vc.value, '$[1]') AS INTEGER))
+		FROM events e, json_each(e.tags) vc
+		WHERE e.kind = ?
+		AND e.pubkey = ?
+		AND e.id IN (SELECT event_id FROM tags WHERE key = 'i' AND value = ?)
+		AND json_extract(vc.value, '$[0]') = 'version_code'
+		AND COALESCE((SELECT json_extract(v.value, '$[1]') FROM json_each(e.tags) v
+			WHERE json_extract(v.value, '$[0]') = 'variant' LIMIT 1), '') = ?`
+	args := []any{events.KindAsset, pubkey, appID, variant}
+
+	if len(rewindable) > 0 {
+		query += `
+		AND (
+			NOT EXISTS (SELECT 1 FROM tags r JOIN events rel ON rel.id = r.event_id
+				WHERE r.key = 'e' AND r.value = e.id AND rel.kind = ?)
+			OR EXISTS (SELECT 1 FROM tags r JOIN events rel ON rel.id = r.event_id
+				WHERE r.key = 'e' AND r.value = e.id AND rel.kind = ?
+				AND NOT EXISTS (SELECT 1 FROM tags c WHERE c.event_id = rel.id AND c.key = 'c' AND c.value` + inClause(len(rewindable)) + `))
+		)`
+		args = append(args, events.KindRelease, events.KindRelease)
+		for _, c := range rewindable {
+			args = append(args, c)
+		}
+	}
+
+	var code sql.NullInt64
+	if err := s.DB.QueryRowContext(ctx, query, args...).Scan(&code); err != nil {
+		return 0, false, fmt.Errorf("failed to query max version code: %w", err)
+	}
+	return code.Int64, code.Valid, nil
+}
+
 // findAll returns all values of the given key in the tags.
 func findAll(tags nostr.Tags, key string) []string {
 	var values []string
@@ -208,20 +649,21 @@ func Validate(filters ...nostr.Filter) error {
 		// because the order of the result events will inevitably be ambiguous.
 		return fmt.Errorf("%w: there can only be one filter per REQ when using NIP-50 search", ErrUnsupportedREQ)
 	}
-	if !slices.Equal(filters[0].Kinds, []int{events.KindApp}) {
-		return fmt.Errorf("%w: we allow NIP-50 search only for kind %d", ErrUnsupportedREQ, events.KindApp)
+	if len(filters[0].Kinds) != 1 || searchIndexes[filters[0].Kinds[0]].table == "" {
+		return fmt.Errorf("%w: we allow NIP-50 search only for kinds %d, %d and %d",
+			ErrUnsupportedREQ, events.KindApp, events.KindStack, events.KindRelease)
 	}
-	if len(filters[0].Search) < 3 {
+	if search := parseSearch(filters[0].Search); len(search.term) < 3 {
 		// The trigram tokenizer requires at least 3 chars, as well as the repoURL search.
 		return fmt.Errorf("%w: search term must be at least 3 characters", ErrUnsupportedREQ)
 	}
 	return nil
 }
 
-// queryBuilder handles FTS search for apps when there's exactly one app search filter.
-// When the search term is a repository URL (any host, /:user/:repo path), it performs
+// queryBuilder handles FTS search for apps, stacks and releases when there's exactly one search filter.
+// When the term of an app search is a repository URL (any host, /:user/:repo path), it performs
 // an exact match on the `repository` tag instead of FTS. Otherwise, it delegates to
-// the default query builder.
+// the default query builder, excluding the expired events.
 func queryBuilder(filters ...nostr.Filter) ([]sqlite.Query, error) {
 	if err := Validate(filters...); err != nil {
 		return nil, err
@@ -229,7 +671,40 @@ func queryBuilder(filters ...nostr.Filter) ([]sqlite.Query, error) {
 	if searchesIn(filters) > 0 {
 		return searchQuery(filters[0])
 	}
-	return sqlite.DefaultQueryBuilder(filters...)
+
+	queries, err := sqlite.DefaultQueryBuilder(filters...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range queries {
+		if queries[i].SQL, err = withoutExpired(queries[i].SQL); err != nil {
+			return nil, err
+		}
+	}
+	return queries, nil
+}
+
+// countBuilder handles NIP-45 COUNT requests, using the same FTS and repository URL logic
+// of [queryBuilder] when there's exactly one search filter. Otherwise, it delegates to
+// the default count builder, excluding the expired events.
+func countBuilder(filters ...nostr.Filter) ([]sqlite.Query, error) {
+	if err := Validate(filters...); err != nil {
+		return nil, err
+	}
+	if searchesIn(filters) > 0 {
+		return searchCountQuery(filters[0])
+	}
+
+	queries, err := sqlite.DefaultCountBuilder(filters...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range queries {
+		if queries[i].SQL, err = withoutExpired(queries[i].SQL); err != nil {
+			return nil, err
+		}
+	}
+	return queries, nil
 }
 
 // searchesIn counts the number of filters with a non-empty search term.
@@ -256,6 +731,7 @@ func repositoryURLQuery(filter nostr.Filter) ([]sqlite.Query, error) {
 		WHERE e.kind = 32267
 		  AND t.key = 'repository'
 		  AND (t.value = ? OR t.value = ?)
+		  AND ` + notExpired + `
 		LIMIT ?`
 
 	limit := filter.Limit
@@ -266,37 +742,173 @@ func repositoryURLQuery(filter nostr.Filter) ([]sqlite.Query, error) {
 	return []sqlite.Query{{SQL: query, Args: []any{canonical, withGit, limit}}}, nil
 }
 
-// appSearchQuery builds an FTS query for searching apps.
-// Results are ordered by BM25 relevance with custom weights.
+// searchQuery builds the FTS queries for searching the kind of the filter, filtered by the search operators.
+// Results are ordered by the relevance ranking of the kind, see [searchIndexes], unless the search has a 'sort:' operator.
+//
+// There is a query for each attempt of the [searchPhases]. The queries of a phase share the filter limit,
+// and run only if the previous phases found nothing.
 func searchQuery(f nostr.Filter) ([]sqlite.Query, error) {
+	kind := f.Kinds[0]
+	search := parseSearch(f.Search).forKind(kind)
+
 	// Repository URL search: exact match on the `repository` tag (no FTS).
 	// Accepts any /:user/:repo URL (GitHub, GitLab, Codeberg, etc.) with or
 	// without a scheme and with or without trailing path/query.
-	if r, ok := repourl.Parse(f.Search); ok {
+	if r, ok := repourl.Parse(search.term); ok && kind == events.KindApp {
 		f.Search = r.Canonical
 		return repositoryURLQuery(f)
 	}
 
-	f.Search = escapeFTS5(f.Search)
-	conditions, args := appSearchSql(f)
+	var queries []sqlite.Query
+	var previous []searchAttempt
 
-	query := `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
-		FROM events e
-		JOIN apps_fts fts ON e.id = fts.id
+	for _, phase := range searchPhases(f, search) {
+		for i, attempt := range phase {
+			from := `FROM events e
+		JOIN ` + attempt.index.table + ` fts ON e.id = fts.id`
+
+			orderBy, joinD := search.orderBy(kind, attempt.index)
+			if joinD {
+				from += `
+		LEFT JOIN tags d ON d.event_id = e.id AND d.key = 'd'`
+			}
+
+			conditions, args := attempt.after(previous)
+			limit, limitArgs := remainingLimit(phase[:i], f.Limit)
+
+			query := `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
+		` + from + `
 		WHERE ` + strings.Join(conditions, " AND ") + `
-		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
-		LIMIT ?`
+		ORDER BY ` + orderBy + `
+		LIMIT ` + limit
 
-	args = append(args, f.Limit)
-	return []sqlite.Query{{SQL: query, Args: args}}, nil
+			queries = append(queries, sqlite.Query{SQL: query, Args: append(args, limitArgs...)})
+		}
+		previous = append(previous, phase...)
+	}
+	return queries, nil
 }
 
-// appSearchSql converts a nostr.Filter into SQL conditions and arguments.
-// Tags are filtered using subqueries to avoid JOIN and GROUP BY,
-// which would break bm25() ranking.
-func appSearchSql(filter nostr.Filter) (conditions []string, args []any) {
-	conditions = []string{"apps_fts MATCH ?"}
-	args = []any{filter.Search}
+// searchCountQuery builds the queries counting the events matching the search, ignoring the filter limit.
+// Like in [searchQuery], the counts of a phase apply only if those of the previous phases are zero.
+func searchCountQuery(f nostr.Filter) ([]sqlite.Query, error) {
+	kind := f.Kinds[0]
+	search := parseSearch(f.Search).forKind(kind)
+
+	if r, ok := repourl.Parse(search.term); ok && kind == events.KindApp {
+		query := `SELECT COUNT(DISTINCT e.id)
+		FROM events e
+		JOIN tags t ON t.event_id = e.id
+		WHERE e.kind = 32267
+		  AND t.key = 'repository'
+		  AND (t.value = ? OR t.value = ?)
+		  AND ` + notExpired
+
+		return []sqlite.Query{{SQL: query, Args: []any{r.Canonical, r.Canonical + ".git"}}}, nil
+	}
+
+	var queries []sqlite.Query
+	var previous []searchAttempt
+
+	for _, phase := range searchPhases(f, search) {
+		for _, attempt := range phase {
+			conditions, args := attempt.after(previous)
+			query := `SELECT COUNT(*)
+		FROM events e
+		JOIN ` + attempt.index.table + ` fts ON e.id = fts.id
+		WHERE ` + strings.Join(conditions, " AND ")
+
+			queries = append(queries, sqlite.Query{SQL: query, Args: args})
+		}
+		previous = append(previous, phase...)
+	}
+	return queries, nil
+}
+
+// searchAttempt is a full-text match of a search on one of its indexes, as SQL conditions and arguments.
+type searchAttempt struct {
+	index      searchIndex
+	conditions []string
+	args       []any
+}
+
+// searchPhases returns the attempts of the search, grouped in phases that run in order, each only if the previous
+// ones found nothing. The first phase requires all the words of the term, and the second relaxes it to any of the words
+// or, for apps, their typo corrections, see [search.relaxedMatch]. A phase has an attempt for each of the [search.indexes].
+func searchPhases(f nostr.Filter, search search) [][]searchAttempt {
+	var strict, relaxed []searchAttempt
+	for _, index := range search.indexes(f.Kinds[0]) {
+		match := sqlite.Query{SQL: "?", Args: []any{search.strictMatch()}}
+		conditions, args := searchSql(index, match, f, search)
+		strict = append(strict, searchAttempt{index: index, conditions: conditions, args: args})
+
+		if match, ok := search.relaxedMatch(index.typos); ok {
+			conditions, args := searchSql(index, match, f, search)
+			relaxed = append(relaxed, searchAttempt{index: index, conditions: conditions, args: args})
+		}
+	}
+
+	if len(relaxed) == 0 {
+		return [][]searchAttempt{strict}
+	}
+	return [][]searchAttempt{strict, relaxed}
+}
+
+// after returns the conditions and arguments of the attempt, with the conditions that none of the previous
+// attempts matches anything. The subqueries shadow the e and fts aliases of the outer query.
+func (a searchAttempt) after(previous []searchAttempt) (conditions []string, args []any) {
+	conditions = slices.Clone(a.conditions)
+	args = slices.Clone(a.args)
+
+	for _, p := range previous {
+		conditions = append(conditions, "NOT EXISTS ("+p.selectOne()+")")
+		args = append(args, p.args...)
+	}
+	return conditions, args
+}
+
+// selectOne returns the query selecting 1 for each event matched by the attempt, with the attempt arguments.
+func (a searchAttempt) selectOne() string {
+	return `SELECT 1 FROM events e JOIN ` + a.index.table + ` fts ON e.id = fts.id WHERE ` + strings.Join(a.conditions, " AND ")
+}
+
+// remainingLimit returns the LIMIT expression and arguments of an attempt that follows the others in the same phase,
+// which is what's left of the limit after their results.
+func remainingLimit(others []searchAttempt, limit int) (string, []any) {
+	if len(others) == 0 {
+		return "?", []any{limit}
+	}
+
+	expr := "MAX(0, ?"
+	args := []any{limit}
+	for _, o := range others {
+		expr += " - (SELECT COUNT(*) FROM (" + o.selectOne() + " LIMIT ?))"
+		args = append(args, o.args...)
+		args = append(args, limit)
+	}
+	return expr + ")", args
+}
+
+// searchSql converts the FTS5 match on the index, a nostr.Filter and the operators of its search into SQL conditions
+// and arguments. Tags are filtered using subqueries to avoid JOIN and GROUP BY, which would break bm25() ranking.
+func searchSql(index searchIndex, match sqlite.Query, filter nostr.Filter, search search) (conditions []string, args []any) {
+	conditions = []string{index.table + " MATCH " + match.SQL, notExpired}
+	args = slices.Clone(match.Args)
+
+	switch {
+	case index.localized:
+		conditions = append(conditions, "fts.lang = ?")
+		args = append(args, search.lang)
+
+	case search.lang != "":
+		// the apps localized in the language are found in the localized index
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM app_languages WHERE event_id = e.id AND lang = ?)")
+		args = append(args, search.lang)
+	}
+
+	operators, operatorArgs := search.conditions()
+	conditions = append(conditions, operators...)
+	args = append(args, operatorArgs...)
 
 	if len(filter.IDs) > 0 {
 		conditions = append(conditions, "e.id"+inClause(len(filter.IDs)))
@@ -349,3 +961,96 @@ func inClause(n int) string {
 	}
 	return " IN (?" + strings.Repeat(",?", n-1) + ")"
 }
+
+// SyncItem is the (id, created_at) pair of an event, used in NIP-77 negentropy reconciliation.
+type SyncItem struct {
+	ID        string
+	CreatedAt nostr.Timestamp
+}
+
+// SyncItems returns the id and created_at of all the events matching the filter, excluding the expired ones.
+// Unlike Query, the filter limit is ignored, because negentropy needs the full set to reconcile.
+// If max is positive and more than max events match, it returns [ErrTooManyItems].
+// Search filters are not supported.
+func (s T) SyncItems(ctx context.Context, filter nostr.Filter, max int) ([]SyncItem, error) {
+	if filter.Search != "" {
+		return nil, fmt.Errorf("%w: search is not supported for negentropy", ErrUnsupportedREQ)
+	}
+
+	conditions, args := syncSql(filter)
+	conditions = append(conditions, notExpired)
+	query := "SELECT e.id, e.created_at FROM events e WHERE " + strings.Join(conditions, " AND ")
+	if max > 0 {
+		query += " LIMIT ?"
+		args = append(args, max+1)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []SyncItem
+	for rows.Next() {
+		var item SyncItem
+		if err := rows.Scan(&item.ID, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sync item: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query sync items: %w", err)
+	}
+	if max > 0 && len(items) > max {
+		return nil, fmt.Errorf("%w: more than %d", ErrTooManyItems, max)
+	}
+	return items, nil
+}
+
+// syncSql converts a nostr.Filter into SQL conditions and arguments, ignoring search and limit.
+func syncSql(filter nostr.Filter) (conditions []string, args []any) {
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "e.id"+inClause(len(filter.IDs)))
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	}
+
+	if len(filter.Kinds) > 0 {
+		conditions = append(conditions, "e.kind"+inClause(len(filter.Kinds)))
+		for _, k := range filter.Kinds {
+			args = append(args, k)
+		}
+	}
+
+	if len(filter.Authors) > 0 {
+		conditions = append(conditions, "e.pubkey"+inClause(len(filter.Authors)))
+		for _, pk := range filter.Authors {
+			args = append(args, pk)
+		}
+	}
+
+	if filter.Since != nil {
+		conditions = append(conditions, "e.created_at >= ?")
+		args = append(args, filter.Since.Time().Unix())
+	}
+
+	if filter.Until != nil {
+		conditions = append(conditions, "e.created_at <= ?")
+		args = append(args, filter.Until.Time().Unix())
+	}
+
+	for key, vals := range filter.Tags {
+		if len(vals) == 0 {
+			continue
+		}
+		conditions = append(conditions,
+			"EXISTS (SELECT 1 FROM tags WHERE event_id = e.id AND key = ? AND value"+inClause(len(vals))+")")
+		args = append(args, key)
+		for _, v := range vals {
+			args = append(args, v)
+		}
+	}
+	return conditions, args
+}
@@ -8,6 +8,7 @@ import (
 	"slices"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 	sqlite "github.com/vertex-lab/nostr-sqlite"
@@ -37,7 +38,7 @@ func TestSearchQuery(t *testing.T) {
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
 		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ?
+		WHERE apps_fts MATCH ? AND ` + notExpired + `
 		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
 		LIMIT ?`,
 				Args: []any{"\"signal\"", 50},
@@ -55,7 +56,7 @@ func TestSearchQuery(t *testing.T) {
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
 		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ? AND e.id IN (?,?)
+		WHERE apps_fts MATCH ? AND ` + notExpired + ` AND e.id IN (?,?)
 		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
 		LIMIT ?`,
 				Args: []any{"\"signal\"", "abc123", "def456", 10},
@@ -73,7 +74,7 @@ func TestSearchQuery(t *testing.T) {
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
 		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ? AND e.pubkey IN (?,?)
+		WHERE apps_fts MATCH ? AND ` + notExpired + ` AND e.pubkey IN (?,?)
 		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
 		LIMIT ?`,
 				Args: []any{"\"signal\"", "pubkey1", "pubkey2", 20},
@@ -92,7 +93,7 @@ func TestSearchQuery(t *testing.T) {
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
 		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ? AND e.created_at >= ? AND e.created_at <= ?
+		WHERE apps_fts MATCH ? AND ` + notExpired + ` AND e.created_at >= ? AND e.created_at <= ?
 		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
 		LIMIT ?`,
 				Args: []any{"\"signal\"", int64(1700000000), int64(1800000000), 100},
@@ -110,7 +111,7 @@ func TestSearchQuery(t *testing.T) {
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
 		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ? AND EXISTS (SELECT 1 FROM tags WHERE event_id = e.id AND key = ? AND value IN (?,?))
+		WHERE apps_fts MATCH ? AND ` + notExpired + ` AND EXISTS (SELECT 1 FROM tags WHERE event_id = e.id AND key = ? AND value IN (?,?))
 		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
 		LIMIT ?`,
 				Args: []any{"\"signal\"", "t", "productivity", "tools", 25},
@@ -125,8 +126,9 @@ func TestSearchQuery(t *testing.T) {
 				t.Fatalf("searchQuery() error = %v", err)
 			}
 
-			if len(got) != 1 {
-				t.Fatalf("searchQuery() returned %d queries, want 1", len(got))
+			// the strict query, and the relaxed one that runs when it finds nothing
+			if len(got) != 2 {
+				t.Fatalf("searchQuery() returned %d queries, want 2", len(got))
 			}
 
 			if got[0].SQL != tt.want.SQL {
@@ -232,6 +234,88 @@ func TestStoreQueryAppSearch(t *testing.T) {
 	}
 }
 
+func TestStoreCountAppSearch(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	apps := []*nostr.Event{
+		{
+			ID:      "app1",
+			PubKey:  "pubkey1",
+			Kind:    events.KindApp,
+			Tags:    nostr.Tags{{"d", "org.signal.app"}, {"name", "Signal"}, {"repository", "https://github.com/signalapp/Signal-Android"}},
+			Content: "Signal is a privacy-focused messaging app.",
+		},
+		{
+			ID:      "app2",
+			PubKey:  "pubkey2",
+			Kind:    events.KindApp,
+			Tags:    nostr.Tags{{"d", "org.telegram.messenger"}, {"name", "Telegram"}},
+			Content: "Telegram is a cloud-based messaging app. Some say it's an alternative to Signal.",
+		},
+		{
+			ID:      "app3",
+			PubKey:  "pubkey3",
+			Kind:    events.KindApp,
+			Tags:    nostr.Tags{{"d", "com.whatsapp"}, {"name", "WhatsApp"}},
+			Content: "WhatsApp is a popular messaging application.",
+		},
+	}
+
+	for _, app := range apps {
+		if _, err := store.Save(ctx, app); err != nil {
+			t.Fatalf("failed to save app %s: %v", app.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter nostr.Filter
+		want   int
+	}{
+		{
+			name:   "fts search",
+			filter: nostr.Filter{Kinds: []int{events.KindApp}, Search: "signal"},
+			want:   2,
+		},
+		{
+			name:   "limit is ignored",
+			filter: nostr.Filter{Kinds: []int{events.KindApp}, Search: "messaging", Limit: 1},
+			want:   3,
+		},
+		{
+			name:   "fts search with authors",
+			filter: nostr.Filter{Kinds: []int{events.KindApp}, Search: "signal", Authors: []string{"pubkey2"}},
+			want:   1,
+		},
+		{
+			name:   "repository url",
+			filter: nostr.Filter{Kinds: []int{events.KindApp}, Search: "github.com/signalapp/Signal-Android"},
+			want:   1,
+		},
+		{
+			name:   "no search",
+			filter: nostr.Filter{Kinds: []int{events.KindApp}},
+			want:   3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count, err := store.Count(ctx, test.filter)
+			if err != nil {
+				t.Fatalf("store.Count() error = %v", err)
+			}
+			if count != test.want {
+				t.Errorf("expected count %d, got %d", test.want, count)
+			}
+		})
+	}
+}
+
 // Multi-character tag keys indexed per event kind (kind-specific triggers).
 // All single-letter tags are indexed universally by single_letter_tags_ai.
 var (
@@ -358,6 +442,111 @@ func TestAppFTSIndexing(t *testing.T) {
 	}
 }
 
+func TestCertificateHashes(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	assets := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"apk_certificate_hash", "cert1"}}},
+		{ID: "asset2", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"apk_certificate_hash", "cert1"}}},
+		{ID: "asset3", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"apk_certificate_hash", "cert2"}}},
+		{ID: "asset4", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.other.app"}, {"apk_certificate_hash", "cert3"}}},
+		{ID: "asset5", PubKey: "bob", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"apk_certificate_hash", "cert4"}}},
+	}
+
+	for _, e := range assets {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		appID  string
+		pubkey string
+		want   []string
+	}{
+		{name: "distinct hashes", appID: "com.example.app", pubkey: "alice", want: []string{"cert1", "cert2"}},
+		{name: "other app", appID: "com.other.app", pubkey: "alice", want: []string{"cert3"}},
+		{name: "other pubkey", appID: "com.example.app", pubkey: "bob", want: []string{"cert4"}},
+		{name: "unknown app", appID: "com.unknown.app", pubkey: "alice", want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := store.CertificateHashes(ctx, test.appID, test.pubkey)
+			if err != nil {
+				t.Fatalf("CertificateHashes: %v", err)
+			}
+
+			slices.Sort(got)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestMaxVersionCode(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	stored := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"version_code", "100"}}},
+		{ID: "asset2", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"version_code", "120"}}},
+		{ID: "asset3", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"version_code", "200"}}},
+		{ID: "asset4", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"version_code", "150"}, {"variant", "fdroid"}}},
+		{ID: "asset5", PubKey: "bob", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.app"}, {"version_code", "999"}}},
+		{ID: "release1", PubKey: "alice", Kind: events.KindRelease, Tags: nostr.Tags{{"d", "com.example.app@1.2"}, {"c", "main"}, {"e", "asset2"}}},
+		{ID: "release2", PubKey: "alice", Kind: events.KindRelease, Tags: nostr.Tags{{"d", "com.example.app@2.0-beta"}, {"c", "beta"}, {"e", "asset3"}}},
+	}
+
+	for _, e := range stored {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		appID      string
+		pubkey     string
+		variant    string
+		rewindable []string
+		want       int64
+		found      bool
+	}{
+		{name: "highest overall", appID: "com.example.app", pubkey: "alice", want: 200, found: true},
+		{name: "beta is rewindable", appID: "com.example.app", pubkey: "alice", rewindable: []string{"beta"}, want: 120, found: true},
+		{name: "main is rewindable", appID: "com.example.app", pubkey: "alice", rewindable: []string{"main", "beta"}, want: 100, found: true},
+		{name: "variant", appID: "com.example.app", pubkey: "alice", variant: "fdroid", want: 150, found: true},
+		{name: "other pubkey", appID: "com.example.app", pubkey: "bob", rewindable: []string{"beta"}, want: 999, found: true},
+		{name: "unknown variant", appID: "com.example.app", pubkey: "alice", variant: "play", found: false},
+		{name: "unknown app", appID: "com.unknown.app", pubkey: "alice", found: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, found, err := store.MaxVersionCode(ctx, test.appID, test.pubkey, test.variant, test.rewindable)
+			if err != nil {
+				t.Fatalf("MaxVersionCode: %v", err)
+			}
+			if found != test.found {
+				t.Fatalf("expected found %v, got %v", test.found, found)
+			}
+			if got != test.want {
+				t.Errorf("expected %d, got %d", test.want, got)
+			}
+		})
+	}
+}
+
 func TestReleaseTagsIndexing(t *testing.T) {
 	store, err := New(":memory:")
 	if err != nil {
@@ -663,6 +852,171 @@ func TestPendingEvents(t *testing.T) {
 	}
 }
 
+func TestPendingStatus(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	pending := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash1"}}},
+		{ID: "asset2", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash2"}}},
+		{ID: "asset3", PubKey: "bob", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash3"}}},
+	}
+
+	for _, e := range pending {
+		if _, err := store.SavePending(ctx, &e); err != nil {
+			t.Fatalf("SavePending(%s): %v", e.ID, err)
+		}
+	}
+
+	if err := store.SetPendingOutcome(ctx, "hash1", "blob not found"); err != nil {
+		t.Fatalf("SetPendingOutcome: %v", err)
+	}
+
+	// simulate rows saved before the pubkey and hash columns existed
+	if _, err := store.DB.Exec(`UPDATE pending_events SET pubkey = '', hash = ''`); err != nil {
+		t.Fatalf("failed to reset pending columns: %v", err)
+	}
+	if err := migrate(store.DB); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	got, err := store.PendingByPubkey(ctx, "alice")
+	if err != nil {
+		t.Fatalf("PendingByPubkey: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 pending events for alice, got %d", len(got))
+	}
+
+	slices.SortFunc(got, func(a, b PendingEvent) int { return cmp.Compare(a.ID, b.ID) })
+	if got[0].Hash != "hash1" || got[0].LastOutcome != "blob not found" || got[0].LastCheckedAt.IsZero() {
+		t.Errorf("unexpected pending event: %+v", got[0])
+	}
+	if got[1].Hash != "hash2" || got[1].LastOutcome != "" || !got[1].LastCheckedAt.IsZero() {
+		t.Errorf("unexpected pending event: %+v", got[1])
+	}
+
+	expired, err := store.DeleteExpiredPending(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteExpiredPending: %v", err)
+	}
+	if len(expired) != 3 {
+		t.Fatalf("expected 3 expired events, got %d", len(expired))
+	}
+
+	got, err = store.PendingByPubkey(ctx, "bob")
+	if err != nil {
+		t.Fatalf("PendingByPubkey: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no pending events after expiry, got %v", got)
+	}
+}
+
+func TestQueryPendingByHash(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	pending := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash1"}}},
+		{ID: "asset2", PubKey: "bob", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash1"}}},
+		{ID: "asset3", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash2"}}},
+	}
+
+	for _, e := range pending {
+		if _, err := store.SavePending(ctx, &e); err != nil {
+			t.Fatalf("SavePending(%s): %v", e.ID, err)
+		}
+	}
+
+	got, err := store.QueryPendingByHash(ctx, "hash1")
+	if err != nil {
+		t.Fatalf("QueryPendingByHash: %v", err)
+	}
+
+	ids := make([]string, len(got))
+	for i, e := range got {
+		ids[i] = e.ID
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"asset1", "asset2"}) {
+		t.Errorf("expected asset1 and asset2, got %v", ids)
+	}
+
+	hashes, err := store.PendingHashes(ctx)
+	if err != nil {
+		t.Fatalf("PendingHashes: %v", err)
+	}
+	slices.Sort(hashes)
+	if !slices.Equal(hashes, []string{"hash1", "hash2"}) {
+		t.Errorf("expected hash1 and hash2, got %v", hashes)
+	}
+}
+
+func TestQueryPendingReferencing(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	pending := []nostr.Event{
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash1"}}},
+		{ID: "release1", PubKey: "alice", Kind: events.KindRelease, Tags: nostr.Tags{{"e", "asset1"}, {"e", "asset2"}}},
+		{ID: "release2", PubKey: "alice", Kind: events.KindRelease, Tags: nostr.Tags{{"e", "asset3"}}},
+		{ID: "comment1", PubKey: "bob", Kind: events.KindComment, Tags: nostr.Tags{{"e", "asset1"}}},
+	}
+
+	for _, e := range pending {
+		if _, err := store.SavePending(ctx, &e); err != nil {
+			t.Fatalf("SavePending(%s): %v", e.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		ids  []string
+		want []string
+	}{
+		{name: "first asset", ids: []string{"asset1"}, want: []string{"release1"}},
+		{name: "second asset", ids: []string{"asset2"}, want: []string{"release1"}},
+		{name: "many assets", ids: []string{"asset2", "asset3"}, want: []string{"release1", "release2"}},
+		{name: "unknown asset", ids: []string{"asset4"}, want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := store.QueryPendingReferencing(ctx, events.KindRelease, test.ids...)
+			if err != nil {
+				t.Fatalf("QueryPendingReferencing: %v", err)
+			}
+
+			ids := make([]string, len(got))
+			for i, e := range got {
+				ids[i] = e.ID
+			}
+			slices.Sort(ids)
+			if !slices.Equal(ids, test.want) {
+				t.Errorf("expected %v, got %v", test.want, ids)
+			}
+		})
+	}
+
+	got, err := store.QueryPendingByIDs(ctx, "asset1", "release2", "asset4")
+	if err != nil {
+		t.Fatalf("QueryPendingByIDs: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected 2 pending events, got %d", len(got))
+	}
+}
+
 func TestForceDeleteRequest(t *testing.T) {
 	const (
 		alice = "alice"
@@ -803,6 +1157,21 @@ func TestForceDeleteRequest(t *testing.T) {
 			},
 			deleted: 3,
 		},
+		{
+			name: "a tag with until: versions created after it are kept",
+			stored: []nostr.Event{
+				{ID: "v1", PubKey: alice, Kind: 30000, Tags: nostr.Tags{{"d", "doc"}}, CreatedAt: 50},
+				{ID: "v2", PubKey: alice, Kind: 30000, Tags: nostr.Tags{{"d", "doc"}}, CreatedAt: 150},
+				{ID: "v3", PubKey: alice, Kind: 30000, Tags: nostr.Tags{{"d", "doc"}}, CreatedAt: 250},
+			},
+			request: nostr.Event{
+				Kind:      5,
+				PubKey:    alice,
+				CreatedAt: 200,
+				Tags:      nostr.Tags{{"a", "30000:alice:doc"}, {"until", "150"}},
+			},
+			deleted: 2,
+		},
 		{
 			name: "a tag: malformed (missing separators)",
 			request: nostr.Event{
@@ -892,6 +1261,88 @@ func getIndexedTags(t *testing.T, store T, eventID string) nostr.Tags {
 	return tags
 }
 
+func TestSyncItems(t *testing.T) {
+	stored := []nostr.Event{
+		{ID: "app1", PubKey: "alice", CreatedAt: 100, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example.one"}}},
+		{ID: "app2", PubKey: "bob", CreatedAt: 200, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example.two"}}},
+		{ID: "asset1", PubKey: "alice", CreatedAt: 300, Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example.one"}}},
+		{ID: "comment1", PubKey: "bob", CreatedAt: 400, Kind: events.KindComment},
+	}
+
+	since := nostr.Timestamp(150)
+	tests := []struct {
+		name   string
+		filter nostr.Filter
+		max    int
+		want   []SyncItem
+		err    error
+	}{
+		{
+			name:   "by kinds",
+			filter: nostr.Filter{Kinds: []int{events.KindApp, events.KindAsset}},
+			want:   []SyncItem{{ID: "app1", CreatedAt: 100}, {ID: "app2", CreatedAt: 200}, {ID: "asset1", CreatedAt: 300}},
+		},
+		{
+			name:   "limit is ignored",
+			filter: nostr.Filter{Kinds: []int{events.KindApp}, Limit: 1},
+			want:   []SyncItem{{ID: "app1", CreatedAt: 100}, {ID: "app2", CreatedAt: 200}},
+		},
+		{
+			name:   "by authors and since",
+			filter: nostr.Filter{Authors: []string{"alice"}, Since: &since},
+			want:   []SyncItem{{ID: "asset1", CreatedAt: 300}},
+		},
+		{
+			name:   "by tag",
+			filter: nostr.Filter{Kinds: []int{events.KindAsset}, Tags: nostr.TagMap{"i": {"com.example.one"}}},
+			want:   []SyncItem{{ID: "asset1", CreatedAt: 300}},
+		},
+		{
+			name:   "within the max",
+			filter: nostr.Filter{Kinds: []int{events.KindApp}},
+			max:    2,
+			want:   []SyncItem{{ID: "app1", CreatedAt: 100}, {ID: "app2", CreatedAt: 200}},
+		},
+		{
+			name:   "above the max",
+			filter: nostr.Filter{Kinds: []int{events.KindApp, events.KindAsset}},
+			max:    2,
+			err:    ErrTooManyItems,
+		},
+		{
+			name:   "search is unsupported",
+			filter: nostr.Filter{Kinds: []int{events.KindApp}, Search: "example"},
+			err:    ErrUnsupportedREQ,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := New(":memory:")
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			defer store.Close()
+
+			for _, e := range stored {
+				if _, err := store.Save(ctx, &e); err != nil {
+					t.Fatalf("failed to save event %s: %v", e.ID, err)
+				}
+			}
+
+			got, err := store.SyncItems(ctx, test.filter, test.max)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			slices.SortFunc(got, func(a, b SyncItem) int { return cmp.Compare(a.ID, b.ID) })
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
 func BenchmarkSaveApp(b *testing.B) {
 	path := b.TempDir() + "/test.db"
 	store, err := New(path)
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+// Tombstone is an event ID or addressable coordinate deleted by the relay operator.
+type Tombstone struct {
+	Target    string          // event ID, or addressable coordinate "<kind>:<pubkey>:<d>"
+	Until     nostr.Timestamp // latest created_at blocked for coordinates, 0 if all versions are blocked
+	RequestID string          // ID of the operator deletion request, or of the version restored over the deleted ones
+	CreatedAt time.Time
+}
+
+// deletionUntil returns the 'until' tag of the deletion request, which is NULL if missing or invalid.
+func deletionUntil(event *nostr.Event) sql.NullInt64 {
+	v, ok := events.Find(event.Tags, "until")
+	if !ok {
+		return sql.NullInt64{}
+	}
+	until, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || until <= 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: until, Valid: true}
+}
+
+// addTombstone records the tombstone of the target deleted by the request, replacing any previous one.
+func addTombstone(ctx context.Context, tx *sql.Tx, target string, until sql.NullInt64, requestID string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO tombstones (target, until, request_id, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(target) DO UPDATE SET
+			until = excluded.until,
+			request_id = excluded.request_id,
+			created_at = excluded.created_at`,
+		target, until, requestID, time.Now().UTC().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to add tombstone %q: %w", target, err)
+	}
+	return nil
+}
+
+// extendTombstone records the tombstone of the coordinate up to until, unless an existing one already blocks
+// those versions. Unlike [addTombstone], it never unblocks versions blocked by a previous tombstone.
+func extendTombstone(ctx context.Context, tx *sql.Tx, ref events.AddressableRef, until int64, requestID string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO tombstones (target, until, request_id, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(target) DO UPDATE SET
+			until = excluded.until,
+			request_id = excluded.request_id,
+			created_at = excluded.created_at
+		WHERE tombstones.until < excluded.until`,
+		ref.String(), until, requestID, time.Now().UTC().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to add tombstone %q: %w", ref, err)
+	}
+	return nil
+}
+
+// IsTombstoned returns whether the event has been deleted by the relay operator, either by its ID
+// or, for addressable events, by its coordinate, if it was created up to the 'until' of the tombstone.
+func (s T) IsTombstoned(ctx context.Context, event *nostr.Event) (bool, error) {
+	targets := []any{event.ID}
+	if nostr.IsAddressableKind(event.Kind) {
+		if d, ok := events.Find(event.Tags, "d"); ok {
+			ref := events.AddressableRef{Kind: event.Kind, Pubkey: event.PubKey, DTag: d}
+			targets = append(targets, ref.String())
+		}
+	}
+
+	query := `SELECT EXISTS (
+		SELECT 1 FROM tombstones
+		WHERE target` + inClause(len(targets)) + ` AND (until IS NULL OR ? <= until)
+	)`
+
+	var tombstoned bool
+	args := append(targets, event.CreatedAt)
+	if err := s.DB.QueryRowContext(ctx, query, args...).Scan(&tombstoned); err != nil {
+		return false, fmt.Errorf("failed to check tombstones: %w", err)
+	}
+	return tombstoned, nil
+}
+
+// Tombstones returns all the tombstones, most recent first.
+func (s T) Tombstones(ctx context.Context) ([]Tombstone, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT target, until, request_id, created_at FROM tombstones ORDER BY created_at DESC, target`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	var tombstones []Tombstone
+	for rows.Next() {
+		var t Tombstone
+		var until sql.NullInt64
+		var createdAt int64
+		if err := rows.Scan(&t.Target, &until, &t.RequestID, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %w", err)
+		}
+		t.Until = nostr.Timestamp(until.Int64)
+		t.CreatedAt = time.Unix(createdAt, 0).UTC()
+		tombstones = append(tombstones, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query tombstones: %w", err)
+	}
+	return tombstones, nil
+}
+
+// RemoveTombstone removes the tombstone of the event ID or addressable coordinate, so that it can be
+// published again. It returns whether the tombstone existed.
+func (s T) RemoveTombstone(ctx context.Context, target string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM tombstones WHERE target = ?`, target)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove tombstone: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	return affected > 0, nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestTombstones(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	stored := []nostr.Event{
+		{ID: "post", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindForumPost},
+		{ID: "app", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}}},
+		{ID: "stack", PubKey: "alice", CreatedAt: 1700000000, Kind: events.KindStack, Tags: nostr.Tags{{"d", "favorites"}}},
+	}
+	for _, e := range stored {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	requests := []nostr.Event{
+		{ID: "request1", Kind: nostr.KindDeletion, PubKey: "operator", Tags: nostr.Tags{
+			{"e", "post"},
+			{"a", "32267:alice:com.example"},
+		}},
+		{ID: "request2", Kind: nostr.KindDeletion, PubKey: "operator", Tags: nostr.Tags{
+			{"a", "30267:alice:favorites"},
+			{"until", "1700000100"},
+		}},
+	}
+	for _, r := range requests {
+		if _, err := store.ForceDeleteRequest(ctx, &r); err != nil {
+			t.Fatalf("ForceDeleteRequest %s: %v", r.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		event      nostr.Event
+		tombstoned bool
+	}{
+		{name: "deleted ID", event: stored[0], tombstoned: true},
+		{name: "other ID", event: nostr.Event{ID: "other", PubKey: "alice", Kind: events.KindForumPost}},
+		{name: "deleted coordinate", event: stored[1], tombstoned: true},
+		{
+			name:       "newer version of a coordinate deleted without until",
+			event:      nostr.Event{ID: "app2", PubKey: "alice", CreatedAt: 1800000000, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}}},
+			tombstoned: true,
+		},
+		{
+			name:  "same coordinate of another pubkey",
+			event: nostr.Event{ID: "app3", PubKey: "bob", CreatedAt: 1700000000, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}}},
+		},
+		{
+			name:       "version before until",
+			event:      nostr.Event{ID: "stack2", PubKey: "alice", CreatedAt: 1700000100, Kind: events.KindStack, Tags: nostr.Tags{{"d", "favorites"}}},
+			tombstoned: true,
+		},
+		{
+			name:  "version after until",
+			event: nostr.Event{ID: "stack3", PubKey: "alice", CreatedAt: 1700000101, Kind: events.KindStack, Tags: nostr.Tags{{"d", "favorites"}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tombstoned, err := store.IsTombstoned(ctx, &test.event)
+			if err != nil {
+				t.Fatalf("IsTombstoned: %v", err)
+			}
+			if tombstoned != test.tombstoned {
+				t.Errorf("expected tombstoned %v, got %v", test.tombstoned, tombstoned)
+			}
+		})
+	}
+
+	tombstones, err := store.Tombstones(ctx)
+	if err != nil {
+		t.Fatalf("Tombstones: %v", err)
+	}
+	if len(tombstones) != 3 {
+		t.Fatalf("expected 3 tombstones, got %v", tombstones)
+	}
+
+	removed, err := store.RemoveTombstone(ctx, "post")
+	if err != nil {
+		t.Fatalf("RemoveTombstone: %v", err)
+	}
+	if !removed {
+		t.Error("expected the tombstone to be removed")
+	}
+	if tombstoned, _ := store.IsTombstoned(ctx, &stored[0]); tombstoned {
+		t.Error("expected the event not to be tombstoned anymore")
+	}
+}
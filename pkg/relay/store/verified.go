@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// VerifiedCertificate is an APK certificate whose identity proof (kind 30509) has been verified.
+type VerifiedCertificate struct {
+	CertificateHash string
+	Pubkey          string
+	ProofID         string
+	ExpiresAt       time.Time
+	VerifiedAt      time.Time
+}
+
+// SaveVerifiedCertificate records that the proof verified the certificate for its pubkey.
+// Nothing is recorded if the proof is no longer stored, for example because it has been replaced meanwhile.
+func (s T) SaveVerifiedCertificate(ctx context.Context, v VerifiedCertificate) error {
+	query := `INSERT INTO verified_certificates (certificate_hash, pubkey, proof_id, expires_at, verified_at)
+		SELECT ?, ?, ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM events WHERE id = ?)
+		ON CONFLICT (certificate_hash, pubkey) DO UPDATE SET
+			proof_id = excluded.proof_id,
+			expires_at = excluded.expires_at,
+			verified_at = excluded.verified_at`
+
+	_, err := s.DB.ExecContext(ctx, query,
+		v.CertificateHash, v.Pubkey, v.ProofID, v.ExpiresAt.Unix(), v.VerifiedAt.Unix(), v.ProofID)
+	if err != nil {
+		return fmt.Errorf("failed to save verified certificate: %w", err)
+	}
+	return nil
+}
+
+// UnverifiedProofs returns the identity proofs (kind 30509) that haven't verified their certificate,
+// for example because fetching the certificate failed, or the relay stopped before verifying them.
+// Expired proofs are excluded.
+func (s T) UnverifiedProofs(ctx context.Context) ([]nostr.Event, error) {
+	query := `SELECT e.id FROM events e
+		WHERE e.kind = 30509
+		AND NOT EXISTS (SELECT 1 FROM verified_certificates v WHERE v.proof_id = e.id)
+		AND ` + notExpired
+
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unverified proofs: %w", err)
+	}
+	defer rows.Close()
+
+	var IDs []string
+	for rows.Next() {
+		var ID string
+		if err := rows.Scan(&ID); err != nil {
+			return nil, fmt.Errorf("failed to scan unverified proof: %w", err)
+		}
+		IDs = append(IDs, ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query unverified proofs: %w", err)
+	}
+
+	if len(IDs) == 0 {
+		return nil, nil
+	}
+	return s.Query(ctx, nostr.Filter{IDs: IDs, Limit: len(IDs)})
+}
+
+// IsVerified returns whether the pubkey has a valid identity proof for the certificate.
+func (s T) IsVerified(ctx context.Context, certificateHash, pubkey string) (bool, error) {
+	query := `SELECT EXISTS (
+		SELECT 1 FROM verified_certificates
+		WHERE certificate_hash = ? AND pubkey = ? AND expires_at > unixepoch())`
+
+	var verified bool
+	if err := s.DB.QueryRowContext(ctx, query, certificateHash, pubkey).Scan(&verified); err != nil {
+		return false, fmt.Errorf("failed to query verified certificate: %w", err)
+	}
+	return verified, nil
+}
+
+// VerifiedApps returns which of the given app IDs have been published by a verified developer,
+// meaning a pubkey with a valid identity proof for the certificate of one of its assets.
+func (s T) VerifiedApps(ctx context.Context, appIDs ...string) (map[string]bool, error) {
+	if len(appIDs) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, len(appIDs))
+	for i, id := range appIDs {
+		args[i] = id
+	}
+
+	query := "SELECT DISTINCT app_id FROM verified_apps WHERE app_id" + inClause(len(appIDs))
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query verified apps: %w", err)
+	}
+	defer rows.Close()
+
+	verified := make(map[string]bool)
+	for rows.Next() {
+		var appID string
+		if err := rows.Scan(&appID); err != nil {
+			return nil, fmt.Errorf("failed to scan verified app: %w", err)
+		}
+		verified[appID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query verified apps: %w", err)
+	}
+	return verified, nil
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestVerifiedCertificates(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	saved := []nostr.Event{
+		{ID: "proof1", PubKey: "alice", Kind: events.KindIdentityProof, Tags: nostr.Tags{{"d", "cert1"}}},
+		{ID: "proof2", PubKey: "bob", Kind: events.KindIdentityProof, Tags: nostr.Tags{{"d", "cert2"}}},
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.alice.app"}, {"apk_certificate_hash", "cert1"}}},
+		{ID: "asset2", PubKey: "bob", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.bob.app"}, {"apk_certificate_hash", "cert2"}}},
+		{ID: "asset3", PubKey: "mallory", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.mallory.app"}, {"apk_certificate_hash", "cert1"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	now := time.Now().Truncate(time.Second)
+	verified := []VerifiedCertificate{
+		{CertificateHash: "cert1", Pubkey: "alice", ProofID: "proof1", ExpiresAt: now.Add(time.Hour), VerifiedAt: now},
+		{CertificateHash: "cert2", Pubkey: "bob", ProofID: "proof2", ExpiresAt: now.Add(-time.Hour), VerifiedAt: now},
+		{CertificateHash: "cert3", Pubkey: "carol", ProofID: "missing", ExpiresAt: now.Add(time.Hour), VerifiedAt: now},
+	}
+	for _, v := range verified {
+		if err := store.SaveVerifiedCertificate(ctx, v); err != nil {
+			t.Fatalf("failed to save verified certificate: %v", err)
+		}
+	}
+
+	checks := []struct {
+		hash, pubkey string
+		want         bool
+	}{
+		{"cert1", "alice", true},
+		{"cert1", "mallory", false}, // same certificate, but no proof
+		{"cert2", "bob", false},     // expired proof
+		{"cert3", "carol", false},   // proof not stored
+	}
+	for _, c := range checks {
+		ok, err := store.IsVerified(ctx, c.hash, c.pubkey)
+		if err != nil {
+			t.Fatalf("IsVerified: %v", err)
+		}
+		if ok != c.want {
+			t.Errorf("IsVerified(%s, %s): expected %v, got %v", c.hash, c.pubkey, c.want, ok)
+		}
+	}
+
+	apps, err := store.VerifiedApps(ctx, "com.alice.app", "com.bob.app", "com.mallory.app")
+	if err != nil {
+		t.Fatalf("VerifiedApps: %v", err)
+	}
+	if want := map[string]bool{"com.alice.app": true}; !reflect.DeepEqual(apps, want) {
+		t.Errorf("VerifiedApps: expected %v, got %v", want, apps)
+	}
+
+	unverified, err := store.UnverifiedProofs(ctx)
+	if err != nil {
+		t.Fatalf("UnverifiedProofs: %v", err)
+	}
+	if len(unverified) != 0 {
+		t.Errorf("UnverifiedProofs: expected none, got %v", unverified)
+	}
+
+	// deleting the proof removes the verification
+	if _, err := store.Delete(ctx, nostr.Filter{IDs: []string{"proof1"}}); err != nil {
+		t.Fatalf("failed to delete proof: %v", err)
+	}
+
+	ok, err := store.IsVerified(ctx, "cert1", "alice")
+	if err != nil {
+		t.Fatalf("IsVerified: %v", err)
+	}
+	if ok {
+		t.Error("expected the certificate to be unverified after the proof is deleted")
+	}
+
+	proof3 := &nostr.Event{ID: "proof3", PubKey: "carol", Kind: events.KindIdentityProof, Tags: nostr.Tags{{"d", "cert3"}}}
+	if _, err := store.Save(ctx, proof3); err != nil {
+		t.Fatalf("failed to save event %s: %v", proof3.ID, err)
+	}
+
+	unverified, err = store.UnverifiedProofs(ctx)
+	if err != nil {
+		t.Fatalf("UnverifiedProofs: %v", err)
+	}
+	if len(unverified) != 1 || unverified[0].ID != "proof3" {
+		t.Errorf("UnverifiedProofs: expected proof3, got %v", unverified)
+	}
+}
+
+func TestSearchVerified(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	saved := []nostr.Event{
+		{ID: "app1", PubKey: "alice", CreatedAt: 1700000001, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.alice.notes"}, {"name", "Notes"}}},
+		{ID: "app2", PubKey: "bob", CreatedAt: 1700000002, Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.bob.notes"}, {"name", "Notes"}}},
+		{ID: "proof1", PubKey: "alice", Kind: events.KindIdentityProof, Tags: nostr.Tags{{"d", "cert1"}}},
+		{ID: "asset1", PubKey: "alice", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.alice.notes"}, {"apk_certificate_hash", "cert1"}}},
+		{ID: "asset2", PubKey: "bob", Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.bob.notes"}, {"apk_certificate_hash", "cert2"}}},
+	}
+	for _, e := range saved {
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("failed to save event %s: %v", e.ID, err)
+		}
+	}
+
+	v := VerifiedCertificate{CertificateHash: "cert1", Pubkey: "alice", ProofID: "proof1", ExpiresAt: time.Now().Add(time.Hour), VerifiedAt: time.Now()}
+	if err := store.SaveVerifiedCertificate(ctx, v); err != nil {
+		t.Fatalf("failed to save verified certificate: %v", err)
+	}
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{search: "notes", want: []string{"app1", "app2"}},
+		{search: "notes verified:true", want: []string{"app1"}},
+		{search: "verified:true notes", want: []string{"app1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.search, func(t *testing.T) {
+			results, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: test.search, Limit: 10})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+
+			var IDs []string
+			for _, e := range results {
+				IDs = append(IDs, e.ID)
+			}
+			if !reflect.DeepEqual(IDs, test.want) {
+				t.Errorf("expected %v, got %v", test.want, IDs)
+			}
+		})
+	}
+
+	if _, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindApp}, Search: "verified:true", Limit: 10}); err == nil {
+		t.Error("expected an error for a search with only extensions")
+	}
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// vocabularySeparators are the characters on which the app names are split into the words of the
+// app_vocabulary table. They must match the replacements of the app_name_words view in schema.sql.
+const vocabularySeparators = " \t\n\"\\-_.,:/()&+"
+
+// Length bounds of the words of the app_vocabulary table, as in the app_name_words view.
+const (
+	minVocabularyWord = 4
+	maxVocabularyWord = 32
+)
+
+// backfillVocabulary fills the app_vocabulary table with the names of the apps stored before it existed.
+// It's a no-op if the table is already populated.
+func backfillVocabulary(db *sql.DB) error {
+	var populated bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM app_vocabulary)`).Scan(&populated); err != nil {
+		return fmt.Errorf("failed to check app vocabulary: %w", err)
+	}
+	if populated {
+		return nil
+	}
+
+	query := `INSERT OR IGNORE INTO app_vocabulary (variant, word, event_id)
+		SELECT word, word, event_id FROM app_name_words
+		UNION
+		SELECT substr(w.word, 1, p.value - 1) || substr(w.word, p.value + 1), w.word, w.event_id
+		FROM app_name_words w
+		CROSS JOIN json_each('[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24,25,26,27,28,29,30,31,32]') p
+			ON p.value <= length(w.word)`
+
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to backfill app vocabulary: %w", err)
+	}
+	return nil
+}
+
+// typoVariants returns the variants of the words to look up in the app_vocabulary table: the words themselves,
+// and the words with one character deleted. Words are lowercased and split like the app names.
+func typoVariants(words []string) []string {
+	var variants []string
+	seen := make(map[string]bool)
+	add := func(v string) {
+		if !seen[v] {
+			seen[v] = true
+			variants = append(variants, v)
+		}
+	}
+
+	for _, word := range words {
+		tokens := strings.FieldsFunc(asciiLower(word), func(r rune) bool {
+			return strings.ContainsRune(vocabularySeparators, r)
+		})
+
+		for _, token := range tokens {
+			// tokens one character shorter or longer than the name words can still be one typo away from them
+			runes := []rune(token)
+			if len(runes) < minVocabularyWord-1 || len(runes) > maxVocabularyWord+1 {
+				continue
+			}
+
+			add(token)
+			if len(runes) > minVocabularyWord-1 {
+				for i := range runes {
+					add(string(runes[:i]) + string(runes[i+1:]))
+				}
+			}
+		}
+	}
+	return variants
+}
+
+// asciiLower lowercases the ASCII letters of s, like the SQLite lower() function.
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
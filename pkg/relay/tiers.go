@@ -0,0 +1,123 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/zapstore/defender/pkg/models"
+)
+
+// tiersInterval is the interval at which the publishers allowed by the defender are refreshed in the rate limiter,
+// and the pubkeys it blocks are refreshed in the relay.
+const tiersInterval = 5 * time.Minute
+
+// runTiers refreshes the [rate.Publisher] tier of the rate limiter and the blocked pubkeys at startup and then
+// periodically. The indexer tier is set once by [Setup].
+func (r *T) runTiers(ctx context.Context) {
+	if err := r.refreshPublishers(ctx); err != nil {
+		slog.Error("relay: failed to refresh publishers", "error", err)
+	}
+	if err := r.refreshBlocked(ctx); err != nil {
+		slog.Error("relay: failed to refresh blocked pubkeys", "error", err)
+	}
+
+	ticker := time.NewTicker(tiersInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := r.refreshPublishers(ctx); err != nil {
+				slog.Error("relay: failed to refresh publishers", "error", err)
+			}
+			if err := r.refreshBlocked(ctx); err != nil {
+				slog.Error("relay: failed to refresh blocked pubkeys", "error", err)
+			}
+		}
+	}
+}
+
+// refreshPublishers sets the pubkeys allowed by the defender as the publishers of the rate limiter.
+func (r *T) refreshPublishers(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	policies, err := r.defender.ListPolicies(ctx, models.PlatformNostr, models.StatusAllowed)
+	if err != nil {
+		return fmt.Errorf("failed to list allowed pubkeys: %w", err)
+	}
+
+	pubkeys := make([]string, len(policies))
+	for i, p := range policies {
+		pubkeys[i] = p.Entity.ID
+	}
+	r.limiter.SetPublishers(pubkeys)
+	return nil
+}
+
+// refreshBlocked sets the pubkeys blocked by the defender as the blocked pubkeys of the relay.
+func (r *T) refreshBlocked(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	policies, err := r.defender.ListPolicies(ctx, models.PlatformNostr, models.StatusBlocked)
+	if err != nil {
+		return fmt.Errorf("failed to list blocked pubkeys: %w", err)
+	}
+
+	pubkeys := make([]string, len(policies))
+	for i, p := range policies {
+		pubkeys[i] = p.Entity.ID
+	}
+	r.blocked.Set(pubkeys)
+	return nil
+}
+
+// pubkeySet is a set of pubkeys, safe for concurrent use.
+type pubkeySet struct {
+	mu      sync.RWMutex
+	pubkeys map[string]struct{}
+}
+
+func newPubkeySet() *pubkeySet {
+	return &pubkeySet{pubkeys: make(map[string]struct{})}
+}
+
+// Set replaces the pubkeys in the set.
+func (s *pubkeySet) Set(pubkeys []string) {
+	set := make(map[string]struct{}, len(pubkeys))
+	for _, pk := range pubkeys {
+		set[pk] = struct{}{}
+	}
+
+	s.mu.Lock()
+	s.pubkeys = set
+	s.mu.Unlock()
+}
+
+// Add adds the pubkey to the set.
+func (s *pubkeySet) Add(pubkey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pubkeys[pubkey] = struct{}{}
+}
+
+// Remove removes the pubkey from the set.
+func (s *pubkeySet) Remove(pubkey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pubkeys, pubkey)
+}
+
+// Contains returns whether the pubkey is in the set.
+func (s *pubkeySet) Contains(pubkey string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.pubkeys[pubkey]
+	return ok
+}
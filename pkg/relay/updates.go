@@ -0,0 +1,212 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// UpdatesPath is the path of the HTTP endpoint that checks many installed apps for updates in one round trip,
+// so that clients don't have to send one REQ per installed app.
+const UpdatesPath = "/v1/updates"
+
+const (
+	// maxUpdateChecks is the maximum number of apps that can be checked in a single request.
+	maxUpdateChecks = 500
+
+	// defaultChannel is the release channel used when the client doesn't specify one.
+	defaultChannel = "main"
+)
+
+// installedApp is an app installed on a client, as sent to [UpdatesPath].
+type installedApp struct {
+	AppID           string `json:"app_id"`
+	Pubkey          string `json:"pubkey"`
+	VersionCode     int64  `json:"version_code"`
+	Platform        string `json:"platform"`
+	CertificateHash string `json:"certificate_hash,omitempty"`
+	Channel         string `json:"channel,omitempty"`
+}
+
+// Validate returns an error if the installed app is invalid, and defaults its channel to [defaultChannel].
+func (a *installedApp) Validate() error {
+	if a.AppID == "" {
+		return fmt.Errorf("missing app_id")
+	}
+	if !nostr.IsValidPublicKey(a.Pubkey) {
+		return fmt.Errorf("invalid pubkey for app %q", a.AppID)
+	}
+	if a.Platform == "" {
+		return fmt.Errorf("missing platform for app %q", a.AppID)
+	}
+	if a.VersionCode < 0 {
+		return fmt.Errorf("negative version_code for app %q", a.AppID)
+	}
+	if a.Channel == "" {
+		a.Channel = defaultChannel
+	}
+	return nil
+}
+
+// appUpdate is the latest release of an installed app, with the asset the client should install.
+// Forced is true when the installed version code is below the asset's 'min_allowed_version_code'.
+type appUpdate struct {
+	AppID   string       `json:"app_id"`
+	Pubkey  string       `json:"pubkey"`
+	Forced  bool         `json:"forced"`
+	Release *nostr.Event `json:"release"`
+	Asset   *nostr.Event `json:"asset"`
+}
+
+// serveUpdates serves POST /v1/updates
+//
+// Request body: {"apps": [{"app_id", "pubkey", "version_code", "platform", "certificate_hash", "channel"}, ...]}
+// Response:     {"updates": [{"app_id", "pubkey", "forced", "release", "asset"}, ...]}
+//
+// Only apps with an update are returned. An update is the latest release (kind 30063) on the app's channel
+// (default "main") and platform, whose asset (kind 3063) has a version code higher than the installed one
+// and is signed with the certificate hash, if provided.
+func (r *T) serveUpdates(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the base cost is charged before reading the body, so that rate limited clients can't make the relay parse it
+	ip := rely.GetIP(req).Group()
+	if !r.limiter.Allow(ip, 1) {
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	var body struct {
+		Apps []installedApp `json:"apps"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, r.config.MaxMessageBytes)).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Apps) == 0 {
+		http.Error(w, "apps must not be empty", http.StatusBadRequest)
+		return
+	}
+	if len(body.Apps) > maxUpdateChecks {
+		http.Error(w, fmt.Sprintf("apps must be at most %d", maxUpdateChecks), http.StatusBadRequest)
+		return
+	}
+	for i := range body.Apps {
+		if err := body.Apps[i].Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !r.limiter.Allow(ip, float64(len(body.Apps))/10) {
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	updates, err := r.findUpdates(ctx, body.Apps)
+	if err != nil {
+		slog.Error("relay: failed to check for updates", "error", err, "apps", len(body.Apps))
+		http.Error(w, ErrInternal.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.indexing != nil {
+		for _, app := range body.Apps {
+			r.indexing.RecordReleaseRequest(app.AppID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"updates": updates}); err != nil {
+		slog.Error("relay: failed to encode updates", "error", err)
+	}
+}
+
+// findUpdates returns the updates of the installed apps, in the same order, skipping the apps without one.
+// An update is the latest release for the app's channel and platform, if its asset is newer than the
+// installed one and, when the client provides a certificate hash, signed with that certificate.
+// The latest releases of all the apps are resolved in one query, and their events in another.
+func (r *T) findUpdates(ctx context.Context, apps []installedApp) ([]appUpdate, error) {
+	keys := make([]store.ReleaseKey, len(apps))
+	for i, app := range apps {
+		keys[i] = store.ReleaseKey{AppID: app.AppID, Pubkey: app.Pubkey, Channel: app.Channel, Platform: app.Platform}
+	}
+
+	latest, err := r.store.LatestReleasesOf(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var IDs []string
+	for i, app := range apps {
+		release, ok := latest[keys[i]]
+		if ok && release.VersionCode > app.VersionCode {
+			IDs = append(IDs, release.ReleaseID, release.AssetID)
+		}
+	}
+	if len(IDs) == 0 {
+		return []appUpdate{}, nil
+	}
+
+	fetched, err := r.store.Query(ctx, nostr.Filter{IDs: IDs, Limit: len(IDs)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query releases and assets: %w", err)
+	}
+
+	byID := make(map[string]*nostr.Event, len(fetched))
+	for i := range fetched {
+		byID[fetched[i].ID] = &fetched[i]
+	}
+
+	updates := make([]appUpdate, 0)
+	for i, app := range apps {
+		release, ok := latest[keys[i]]
+		if !ok || release.VersionCode <= app.VersionCode {
+			continue
+		}
+		if update, ok := newUpdate(app, byID[release.ReleaseID], byID[release.AssetID]); ok {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+// newUpdate returns the update of the installed app to the release and asset, unless they are missing
+// or the asset is not signed with the app's certificate hash, when provided.
+func newUpdate(app installedApp, release, asset *nostr.Event) (appUpdate, bool) {
+	if release == nil || asset == nil || asset.Kind != events.KindAsset {
+		return appUpdate{}, false
+	}
+
+	parsed, err := events.ParseAsset(asset)
+	if err != nil {
+		return appUpdate{}, false
+	}
+	if app.CertificateHash != "" && !slices.Contains(parsed.APKCertificateHashes, app.CertificateHash) {
+		// Android refuses to install an update signed with a different certificate
+		return appUpdate{}, false
+	}
+
+	update := appUpdate{AppID: app.AppID, Pubkey: app.Pubkey, Release: release, Asset: asset}
+	minAllowed, err := strconv.ParseInt(parsed.MinAllowedVersionCode, 10, 64)
+	if err == nil && app.VersionCode < minAllowed {
+		update.Forced = true
+	}
+	return update, true
+}